@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
 	"strings"
 	"time"
 
@@ -26,6 +27,28 @@ type DocumentQueryResponse struct {
 	Result         string `json:"result,omitempty"`
 	Error          string `json:"error,omitempty"`
 	ProcessingTime int64  `json:"processingTime,omitempty"`
+	ChunksUsed     int    `json:"chunksUsed"`    // retrieved chunks that fit the prompt
+	ChunksDropped  int    `json:"chunksDropped"` // lowest-ranked chunks left out to stay within the context window
+	PromptTokens   int    `json:"promptTokens,omitempty"`
+
+	Answer    string          `json:"answer,omitempty"`
+	Reasoning string          `json:"reasoning,omitempty"`
+	Channels  []OutputChannel `json:"channels,omitempty"`
+
+	Structured *StructuredOutputResult `json:"structured,omitempty"`
+	Stats      *RunStats               `json:"stats,omitempty"` // performance figures reported by the backend
+
+	StrippedTokens []StrippedControlToken `json:"strippedTokens,omitempty"` // control tokens removed from the question and the retrieved context
+}
+
+// DocumentQueryOutcome carries the details of a document query besides its answer
+type DocumentQueryOutcome struct {
+	Usage          RetrievalContextUsage
+	Reply          string // model reply without the echoed prompt
+	Output         ParsedOutput
+	Structured     *StructuredOutputResult
+	Stats          *RunStats
+	StrippedTokens []StrippedControlToken
 }
 
 // DocumentQueryRequest represents a document query request
@@ -39,6 +62,11 @@ type DocumentQueryRequest struct {
 	DocumentPrompt  string                 `json:"documentPrompt"`
 	PromptType      string                 `json:"promptType"`
 	SearchKeywords  []string               `json:"searchKeywords"`
+
+	SystemPrompt string            `json:"systemPrompt,omitempty"` // replaces the prompt type's system instruction
+	Variables    map[string]string `json:"variables,omitempty"`    // values for {{.Name}} references in the question
+
+	StructuredOutput *StructuredOutputRequest `json:"structuredOutput,omitempty"`
 }
 
 // DocumentAddRequest represents a document add request
@@ -65,6 +93,8 @@ type DocumentAddResponse struct {
 	ProcessingTime int64  `json:"processingTime,omitempty"`
 }
 
+const documentAddedMessage = "Document added successfully"
+
 // DocumentSearchService handles Elasticsearch operations for document search and retrieval
 type DocumentSearchService struct {
 	application *App
@@ -106,6 +136,7 @@ func (app *App) createEventHandler() *EventHandler {
 func (app *App) emitProgressSafely(requestID, status, message string, progress int) {
 	defer app.recoverFromPanic("emitProgress", requestID)
 
+	app.jobs.SetProgress(requestID, progress)
 	eh := app.createEventHandler()
 	if eh != nil {
 		progressData := map[string]interface{}{
@@ -118,21 +149,87 @@ func (app *App) emitProgressSafely(requestID, status, message string, progress i
 	}
 }
 
+// emitTokenSafely streams a generated text batch to the frontend with error handling
+func (app *App) emitTokenSafely(requestID, token string, index int) {
+	defer app.recoverFromPanic("emitToken", requestID)
+
+	eh := app.createEventHandler()
+	if eh != nil {
+		eh.emitInferenceCompletionToken(InferenceCompletionToken{
+			RequestID: requestID,
+			Token:     token,
+			Index:     index,
+		})
+	}
+}
+
+// emitTokenResetSafely tells the frontend to discard the text streamed for a rejected attempt
+func (app *App) emitTokenResetSafely(requestID string) {
+	defer app.recoverFromPanic("emitTokenReset", requestID)
+
+	eh := app.createEventHandler()
+	if eh != nil {
+		eh.emitInferenceCompletionToken(InferenceCompletionToken{RequestID: requestID, Reset: true})
+	}
+}
+
+// emitQueuedProgress tells the frontend that a job is waiting for a scheduler slot, using the
+// progress event of the job's kind and keeping its last reported percentage
+func (app *App) emitQueuedProgress(job *Job, resource string, position int) {
+	defer app.recoverFromPanic("emitQueuedProgress", job.ID())
+
+	message := fmt.Sprintf("Waiting for %s (position %d in queue)...", resourceDisplayName(resource), position)
+	app.log.Info(fmt.Sprintf("Job %s queued: %s", job.ID(), message))
+
+	eh := app.createEventHandler()
+	if eh == nil {
+		return
+	}
+	progress := app.jobs.Progress(job.ID())
+	switch job.Kind() {
+	case JobKindInference:
+		eh.emitProgressUpdate(job.ID(), "queued", message, progress)
+	case JobKindQuery:
+		eh.emitDocumentQueryProgress(DocumentQueryProgress{
+			RequestID: job.ID(),
+			Status:    "queued",
+			Message:   message,
+			Progress:  progress,
+		})
+	case JobKindIngest:
+		app.emitProgressSafely(job.ID(), "queued", message, progress)
+	}
+}
+
+// resourceDisplayName returns the user-facing name of a scheduler resource
+func resourceDisplayName(resource string) string {
+	switch resource {
+	case ResourceLLM:
+		return "the language model"
+	case ResourceEmbed:
+		return "the embedding model"
+	case ResourceOCR:
+		return "OCR"
+	default:
+		return resource
+	}
+}
+
 // Helper function with proper error handling
-func (app *App) generateDocumentAddWithProgressSafe(request DocumentAddRequest) (*string, error) {
+func (app *App) generateDocumentAddWithProgressSafe(ctx context.Context, request DocumentAddRequest) (*string, error) {
 	defer app.recoverFromPanic("generateDocumentAddWithProgressSafe", request.RequestID)
 
-	result := app.generateDocumentAddWithProgress(request)
+	result := app.generateDocumentAddWithProgress(ctx, request)
 	return &result, nil
 }
 
 // generateDocumentAddWithProgress generates document add response with progress updates
-func (app *App) generateDocumentAddWithProgress(request DocumentAddRequest) string {
+func (app *App) generateDocumentAddWithProgress(ctx context.Context, request DocumentAddRequest) string {
 	// Emit progress: processing document
 	app.emitProgressSafely(request.RequestID, "processing", "Processing document...", 20)
 
 	// Check if the operation was canceled
-	if app.isOperationCanceled() {
+	if ctx.Err() != nil {
 		return "Operation cancelled by user"
 	}
 
@@ -140,7 +237,7 @@ func (app *App) generateDocumentAddWithProgress(request DocumentAddRequest) stri
 	app.emitProgressSafely(request.RequestID, "extracting", "Extracting document content...", 40)
 
 	// Check for cancellation
-	if app.isOperationCanceled() {
+	if ctx.Err() != nil {
 		return "Operation cancelled by user"
 	}
 
@@ -148,7 +245,7 @@ func (app *App) generateDocumentAddWithProgress(request DocumentAddRequest) stri
 	app.emitProgressSafely(request.RequestID, "indexing", "Indexing document...", 70)
 
 	// Check for cancellation
-	if app.isOperationCanceled() {
+	if ctx.Err() != nil {
 		return "Operation cancelled by user"
 	}
 
@@ -165,7 +262,8 @@ func (app *App) generateDocumentAddWithProgress(request DocumentAddRequest) stri
 	chunkOverlap := request.ChunkOverlap
 	enableStopWordRemoval := request.EnableStopWordRemoval // Configure as needed
 
-	result := app.AddElasticDocument(
+	result := app.addElasticDocument(
+		ctx,
 		embeddingArgs,
 		embeddingType,
 		indexName,
@@ -202,8 +300,16 @@ func (app *App) handleAddDocumentRequest(request DocumentAddRequest) {
 	// Emit initial progress
 	app.emitProgressSafely(request.RequestID, "starting", "Starting document processing...", 5)
 
+	job, err := app.startJob(request.RequestID, JobKindIngest)
+	if err != nil {
+		eh.emitDocumentAddError(request.RequestID, err.Error())
+		return
+	}
+	request.RequestID = job.ID()
+
 	// Generate document add response with progress tracking
-	result := app.generateDocumentAddWithProgress(request)
+	result := app.generateDocumentAddWithProgress(job.Context(), request)
+	app.jobs.Finish(job, addDocumentError(result))
 
 	// Calculate processing time
 	processingTime := time.Since(processingStartTime).Milliseconds()
@@ -245,8 +351,8 @@ func (app *App) handleAddDocumentRequest(request DocumentAddRequest) {
 	app.log.Info(fmt.Sprintf("Document add request %s completed successfully", request.RequestID))
 }
 
-func (app *App) handleSearchError(err error, searchType string) string {
-	if errors.Is(app.operationCtx.Err(), context.Canceled) {
+func (app *App) handleSearchError(ctx context.Context, err error, searchType string) string {
+	if errors.Is(ctx.Err(), context.Canceled) {
 		app.log.Info("Document analysis was cancelled by user")
 		return "Search cancelled by user"
 	}
@@ -282,9 +388,16 @@ func (app *App) GetDocumentsByFieldsSettings(indexName, metaKeyWords, metaTextDe
 		ResultSize:   20,
 	}
 
-	searchResults, err := elasticClient.SearchDocumentsByFields(app.operationCtx, indexName, searchParameters)
+	job, err := app.startJob("", JobKindSearch)
+	if err != nil {
+		return err.Error()
+	}
+	ctx := job.Context()
+
+	searchResults, err := elasticClient.SearchDocumentsByFields(ctx, indexName, searchParameters)
+	app.jobs.Finish(job, err)
 	if err != nil {
-		if errors.Is(app.ctx.Err(), context.Canceled) {
+		if errors.Is(ctx.Err(), context.Canceled) {
 			app.log.Info("Document search was cancelled by user")
 			return "Search cancelled by user"
 		}
@@ -292,7 +405,7 @@ func (app *App) GetDocumentsByFieldsSettings(indexName, metaKeyWords, metaTextDe
 		return err.Error()
 	}
 
-	if errors.Is(app.ctx.Err(), context.Canceled) {
+	if job.Canceled() {
 		app.log.Info("Document search was cancelled by user")
 		return "Search cancelled by user"
 	}
@@ -309,6 +422,19 @@ func (app *App) GetDocumentsByFieldsSettings(indexName, metaKeyWords, metaTextDe
 func (app *App) AddElasticDocument(embeddingArguments LlamaEmbedArgs, embeddingType, indexName,
 	title, metaTextDesc, metaKeyWords, sourceLocation string,
 	chunkSize, chunkOverlap int, enableStopWordRemoval bool) string {
+	job, err := app.startJob("", JobKindIngest)
+	if err != nil {
+		return err.Error()
+	}
+
+	result := app.addElasticDocument(job.Context(), embeddingArguments, embeddingType, indexName, title, metaTextDesc, metaKeyWords, sourceLocation, chunkSize, chunkOverlap, enableStopWordRemoval)
+	app.jobs.Finish(job, addDocumentError(result))
+	return result
+}
+
+func (app *App) addElasticDocument(ctx context.Context, embeddingArguments LlamaEmbedArgs, embeddingType, indexName,
+	title, metaTextDesc, metaKeyWords, sourceLocation string,
+	chunkSize, chunkOverlap int, enableStopWordRemoval bool) string {
 
 	elasticClient, err := app.createElasticsearchClient(5000)
 	if err != nil {
@@ -321,13 +447,21 @@ func (app *App) AddElasticDocument(embeddingArguments LlamaEmbedArgs, embeddingT
 		return err.Error()
 	}
 
-	err = elasticClient.AddElasticsearchDocument(app.ctx, app.log, *app.appArgs, embeddingArguments, processedDocument, indexName, title, metaTextDesc, metaKeyWords, sourceLocation)
+	err = elasticClient.AddElasticsearchDocument(ctx, app.log, *app.appArgs, embeddingArguments, processedDocument, indexName, title, metaTextDesc, metaKeyWords, sourceLocation)
 	if err != nil {
 		app.log.Error("Failed to add document to Elasticsearch: " + err.Error())
 		return err.Error()
 	}
 
-	return "Document added successfully"
+	return documentAddedMessage
+}
+
+// addDocumentError converts an addElasticDocument result into an error for the job registry
+func addDocumentError(result string) error {
+	if result == documentAddedMessage {
+		return nil
+	}
+	return errors.New(result)
 }
 
 func (app *App) processDocumentByType(embeddingType, sourceLocation string, chunkSize, chunkOverlap int, enableStopWordRemoval bool) ([]Document, error) {
@@ -346,53 +480,95 @@ func (app *App) processDocumentByType(embeddingType, sourceLocation string, chun
 // Legacy methods (consider refactoring these as well in future iterations)
 func (app *App) QueryElasticDocument(llamaCliArgs LlamaCliArgs, llamaEmbedArgs LlamaEmbedArgs,
 	indexID string, documentID, embeddingPrompt string, documentPrompt string, promptType string, searchKeywords []string) string {
+	job, err := app.startJob("", JobKindQuery)
+	if err != nil {
+		return err.Error()
+	}
+
+	result, _ := app.queryElasticDocument(job.Context(), job.ID(), llamaCliArgs, llamaEmbedArgs, indexID, documentID, embeddingPrompt, documentPrompt, promptType, searchKeywords, PromptOptions{}, nil)
+	app.jobs.Finish(job, app.resultError(result))
+	return result
+}
+
+// queryElasticDocument runs the document query under ctx; requestID keys the streamed token events.
+// Besides the result it reports how many retrieved chunks fit the prompt and, when structured is
+// set, the validated JSON reply.
+func (app *App) queryElasticDocument(ctx context.Context, requestID string, llamaCliArgs LlamaCliArgs, llamaEmbedArgs LlamaEmbedArgs,
+	indexID string, documentID, embeddingPrompt string, documentPrompt string, promptType string, searchKeywords []string,
+	promptOptions PromptOptions, structured *StructuredOutputRequest) (string, DocumentQueryOutcome) {
 	processingStartTime := time.Now()
-	app.resetContext()
 
 	select {
-	case <-app.ctx.Done():
+	case <-ctx.Done():
 		app.log.Info("Operation was cancelled before starting")
-		return "Operation cancelled by user"
+		return "Operation cancelled by user", DocumentQueryOutcome{}
 	default:
-		elasticClient, err := app.createElasticsearchClient(150000)
+		rankedChunks, err := app.retrieveDocumentChunks(ctx, llamaEmbedArgs, indexID, documentID, embeddingPrompt, searchKeywords)
 		if err != nil {
-			return err.Error()
+			return err.Error(), DocumentQueryOutcome{}
 		}
 
-		keywordSearchVector, err := GenerateEmbedWithCancel(app.ctx, llamaEmbedArgs, *app.appArgs, strings.Join(searchKeywords, " "))
-		if err != nil {
-			return app.handleEmbeddingError(err, "keywordSearchVector")
-		}
+		completionResult, outcome := app.generateCompletionWithPromptType(ctx, requestID, llamaCliArgs, llamaEmbedArgs, rankedChunks, promptType, documentID, indexID, embeddingPrompt, documentPrompt, searchKeywords, promptOptions, structured)
 
-		promptSearchVector, err := GenerateEmbedWithCancel(app.ctx, llamaEmbedArgs, *app.appArgs, embeddingPrompt)
-		if err != nil {
-			return app.handleEmbeddingError(err, "promptSearchVector")
-		}
+		totalProcessingTime := time.Since(processingStartTime).Milliseconds()
+		app.prepareDocumentQuestionResponse(documentID, indexID, embeddingPrompt, documentPrompt, searchKeywords, promptType, llamaEmbedArgs, llamaCliArgs, outcome, totalProcessingTime)
 
-		keywordSearchResults, err := elasticClient.SearchDocumentByIDWithVector(app.ctx, indexID, documentID, keywordSearchVector, 15)
-		if err != nil {
-			return app.handleSearchError(err, "keywordSearchVector")
-		}
+		return completionResult, outcome
+	}
+}
 
-		promptSearchResults, err := elasticClient.SearchDocumentByIDWithVector(app.ctx, indexID, documentID, promptSearchVector, 15)
-		if err != nil {
-			return app.handleSearchError(err, "promptSearchVector")
-		}
+// retrieveDocumentChunks searches the document for chunks near the keywords and near the embedding
+// prompt and returns both result sets as one ranking. Errors are already logged.
+func (app *App) retrieveDocumentChunks(ctx context.Context, llamaEmbedArgs LlamaEmbedArgs, indexID, documentID, embeddingPrompt string, searchKeywords []string) ([]RetrievedChunk, error) {
+	elasticClient, err := app.createElasticsearchClient(150000)
+	if err != nil {
+		return nil, err
+	}
+	embeddingModel, err := NewEmbeddingModelIdentity(llamaEmbedArgs)
+	if err != nil {
+		return nil, errors.New(app.handleEmbeddingError(ctx, err, "search"))
+	}
+	indexEmbedding, err := elasticClient.GetIndexEmbedding(ctx, indexID)
+	if err != nil {
+		app.log.Error("Failed to read index embedding settings: " + err.Error())
+		return nil, err
+	}
+	if err := indexEmbedding.Check(indexID, embeddingModel); err != nil {
+		app.log.Error(err.Error())
+		return nil, err
+	}
+
+	keywordSearchVector, err := EmbedText(ctx, llamaEmbedArgs, *app.appArgs, strings.Join(searchKeywords, " "))
+	if err != nil {
+		return nil, errors.New(app.handleEmbeddingError(ctx, err, "keywordSearchVector"))
+	}
 
-		deduplicatedKeywordResults, deduplicatedPromptResults := removeDuplicateSearchResults(keywordSearchResults, promptSearchResults)
-		combinedSearchContext := deduplicatedKeywordResults + deduplicatedPromptResults
+	promptSearchVector, err := EmbedText(ctx, llamaEmbedArgs, *app.appArgs, embeddingPrompt)
+	if err != nil {
+		return nil, errors.New(app.handleEmbeddingError(ctx, err, "promptSearchVector"))
+	}
 
-		completionResult := app.generateCompletionWithPromptType(llamaCliArgs, llamaEmbedArgs, combinedSearchContext, promptType, documentID, indexID, embeddingPrompt, documentPrompt, searchKeywords)
+	for _, searchVector := range [][]float32{keywordSearchVector, promptSearchVector} {
+		if err := CheckEmbeddingDims(searchVector, indexEmbedding.Dims); err != nil {
+			return nil, errors.New(app.handleEmbeddingError(ctx, err, "searchVector"))
+		}
+	}
 
-		totalProcessingTime := time.Since(processingStartTime).Milliseconds()
-		app.prepareDocumentQuestionResponse(documentID, indexID, embeddingPrompt, documentPrompt, completionResult, searchKeywords, promptType, llamaEmbedArgs, llamaCliArgs, totalProcessingTime)
+	keywordSearchResults, err := elasticClient.SearchDocumentChunksByIDWithVector(ctx, indexID, documentID, keywordSearchVector, 15)
+	if err != nil {
+		return nil, errors.New(app.handleSearchError(ctx, err, "keywordSearchVector"))
+	}
 
-		return completionResult
+	promptSearchResults, err := elasticClient.SearchDocumentChunksByIDWithVector(ctx, indexID, documentID, promptSearchVector, 15)
+	if err != nil {
+		return nil, errors.New(app.handleSearchError(ctx, err, "promptSearchVector"))
 	}
+
+	return mergeRankedChunks(keywordSearchResults, promptSearchResults), nil
 }
 
-func (app *App) handleEmbeddingError(err error, embeddingType string) string {
-	if errors.Is(app.ctx.Err(), context.Canceled) {
+func (app *App) handleEmbeddingError(ctx context.Context, err error, embeddingType string) string {
+	if errors.Is(ctx.Err(), context.Canceled) {
 		app.log.Info("Document analysis was cancelled by user")
 		return "Operation cancelled by user"
 	}
@@ -400,20 +576,25 @@ func (app *App) handleEmbeddingError(err error, embeddingType string) string {
 	return err.Error()
 }
 
-func (app *App) prepareDocumentQuestionResponse(documentID, indexID, embeddingPrompt, documentPrompt, completionResult string, searchKeywords []string, promptType string, llamaEmbedArgs LlamaEmbedArgs, llamaCliArgs LlamaCliArgs, totalProcessingTime int64) {
+func (app *App) prepareDocumentQuestionResponse(documentID, indexID, embeddingPrompt, documentPrompt string, searchKeywords []string, promptType string, llamaEmbedArgs LlamaEmbedArgs, llamaCliArgs LlamaCliArgs, outcome DocumentQueryOutcome, totalProcessingTime int64) {
 	documentQuestionResponse := DocumentQuestionResponse{
-		ID:          bson.NewObjectID(),
-		DocumentID:  documentID,
-		IndexName:   indexID,
-		EmbedPrompt: embeddingPrompt,
-		DocPrompt:   documentPrompt,
-		Response:    completionResult,
-		Keywords:    searchKeywords,
-		PromptType:  promptType,
-		EmbedArgs:   llamaEmbedArgs,
-		CliState:    llamaCliArgs,
-		CreatedAt:   time.Now(),
-		ProcessTime: totalProcessingTime,
+		ID:            bson.NewObjectID(),
+		DocumentID:    documentID,
+		IndexName:     indexID,
+		EmbedPrompt:   embeddingPrompt,
+		DocPrompt:     documentPrompt,
+		Response:      outcome.Reply,
+		Reasoning:     outcome.Output.Reasoning,
+		Answer:        outcome.Output.Answer,
+		Keywords:      searchKeywords,
+		PromptType:    promptType,
+		EmbedArgs:     llamaEmbedArgs,
+		CliState:      llamaCliArgs,
+		ChunksUsed:    outcome.Usage.ChunksUsed,
+		ChunksDropped: outcome.Usage.ChunksDropped,
+		Stats:         outcome.Stats,
+		CreatedAt:     time.Now(),
+		ProcessTime:   totalProcessingTime,
 	}
 
 	if _, err := SaveDocumentQuestionResponse(app.appArgs, documentQuestionResponse); err != nil {
@@ -421,15 +602,55 @@ func (app *App) prepareDocumentQuestionResponse(documentID, indexID, embeddingPr
 	}
 }
 
-func (app *App) generateCompletionWithPromptType(llamaCliArgs LlamaCliArgs, llamaEmbedArgs LlamaEmbedArgs,
-	combinedSearchContext, promptType, documentID, indexName, embeddingPrompt, documentPrompt string, searchKeywords []string) string {
+// generateCompletionWithPromptType answers documentPrompt from the best-ranked chunks that fit the
+// context window of llamaCliArgs and reports how many chunks were used and dropped along with the
+// reasoning split off the answer. With structured set, the answer is constrained to and validated
+// against its JSON schema. Prompt variables are expanded in documentPrompt, not in the retrieved context.
+func (app *App) generateCompletionWithPromptType(ctx context.Context, requestID string, llamaCliArgs LlamaCliArgs, llamaEmbedArgs LlamaEmbedArgs,
+	rankedChunks []RetrievedChunk, promptType, documentID, indexName, embeddingPrompt, documentPrompt string, searchKeywords []string,
+	promptOptions PromptOptions, structured *StructuredOutputRequest) (string, DocumentQueryOutcome) {
 
 	processingStartTime := time.Now()
 
-	formattedPrompt, err := HandlePromptType(app.log, promptType, documentPrompt+"\nUse only the provided Context:"+combinedSearchContext)
+	var outcome DocumentQueryOutcome
+	defaults := map[string]string{
+		"DocumentID": documentID,
+		"Keywords":   strings.Join(searchKeywords, ", "),
+	}
+	// Reading the title costs an Elasticsearch request; only templated prompts can reference it
+	if _, overridden := promptOptions.Variables["DocumentTitle"]; promptOptions.Variables != nil && !overridden {
+		defaults["DocumentTitle"] = app.documentTitle(ctx, indexName, documentID)
+	}
+	promptOptions = promptOptions.WithDefaults(defaults)
+	question, promptOptions, err := promptOptions.Expand(documentPrompt)
+	if err != nil {
+		app.log.Error("Failed to expand prompt variables: " + err.Error())
+		return err.Error(), outcome
+	}
+
+	// The question and the retrieved chunks are untrusted; neither may carry the prompt type's control tokens
+	processor := NewTemplateProcessor(app.log)
+	question, strippedInput := processor.StripControlTokens(promptType, PromptSourceInput, question)
+	rankedChunks, strippedContext := processor.StripControlTokensFromChunks(promptType, rankedChunks)
+	outcome.StrippedTokens = mergeStrippedTokens(strippedInput, strippedContext)
+
+	buildPrompt := func(chunks []RetrievedChunk) (string, error) {
+		return HandlePromptType(app.log, promptType, llamaCliArgs.ModelFullPathVal, documentContextPrompt(question, chunks), promptOptions)
+	}
+	contextChunks, usage, err := app.fitRetrievedContext(ctx, llamaCliArgs, rankedChunks, buildPrompt)
+	outcome.Usage = usage
+	if err != nil {
+		app.log.Error("Failed to fit document context: " + err.Error())
+		return err.Error(), outcome
+	}
+	app.log.Info(fmt.Sprintf("Document query %s uses %d of %d chunks (%d prompt tokens, budget %d, exact count: %t)",
+		requestID, usage.ChunksUsed, len(rankedChunks), usage.PromptTokens, usage.TokenBudget, usage.ExactTokenCount))
+
+	promptText := documentContextPrompt(question, contextChunks)
+	formattedPrompt, err := HandlePromptType(app.log, promptType, llamaCliArgs.ModelFullPathVal, promptText, promptOptions)
 	if err != nil {
 		app.log.Error("Failed to handle prompt type: " + err.Error())
-		return err.Error()
+		return err.Error(), outcome
 	}
 
 	filename := fmt.Sprintf("docQuery_%s_%s.txt", documentID, time.Now().Format("20060102_150405"))
@@ -438,60 +659,89 @@ func (app *App) generateCompletionWithPromptType(llamaCliArgs LlamaCliArgs, llam
 	}
 
 	llamaCliArgs.PromptText = formattedPrompt
-	cliArgumentsArray := LlamaCliStructToArgs(llamaCliArgs)
-	generatedOutput, err := GenerateSingleCompletionWithCancel(app.ctx, *app.appArgs, cliArgumentsArray)
+	tokenIndex := 0
+	completionRequest := CompletionRequest{
+		RequestID:  requestID,
+		CliArgs:    llamaCliArgs,
+		PromptType: promptType,
+		Prompt:     formattedPrompt,
+		Messages:   BuildChatMessages(promptType, promptText, promptOptions),
+	}
+	onToken := func(token string) {
+		app.emitTokenSafely(requestID, token, tokenIndex)
+		tokenIndex++
+	}
+	onReset := func() {
+		app.emitTokenResetSafely(requestID)
+		tokenIndex = 0
+	}
+
+	generatedOutput, structuredResult, err := app.generateCompletionOrStructured(ctx, completionRequest, structured, onToken, onReset)
+	outcome.Structured = structuredResult
+	outcome.Stats = generatedOutput.Stats
 	if err != nil {
 		app.log.Error("Failed to generate completion: " + err.Error())
-		return err.Error()
+		return err.Error(), outcome
 	}
 
-	completionOutputString := string(generatedOutput)
+	outcome.Reply = strings.TrimSpace(stripEchoedPrompt(generatedOutput.Text, formattedPrompt))
+	outcome.Output = ParseModelOutput(promptType, formattedPrompt, generatedOutput.Text)
 	totalProcessingTime := time.Since(processingStartTime).Milliseconds()
 
-	app.prepareDocumentQuestionResponse(documentID, indexName, embeddingPrompt, documentPrompt, completionOutputString, searchKeywords, promptType, llamaEmbedArgs, llamaCliArgs, totalProcessingTime)
+	app.prepareDocumentQuestionResponse(documentID, indexName, embeddingPrompt, documentPrompt, searchKeywords, promptType, llamaEmbedArgs, llamaCliArgs, outcome, totalProcessingTime)
 
-	return completionOutputString
+	return outcome.Output.Answer, outcome
 }
 
-func removeDuplicateSearchResults(keywordResults, promptResults string) (string, string) {
-	if keywordResults == "" && promptResults == "" {
-		return "", ""
-	}
-
-	if keywordResults == "" {
-		return "", promptResults
+// documentTitle returns the title of the queried document for the DocumentTitle prompt variable, or
+// an empty title when it cannot be read
+func (app *App) documentTitle(ctx context.Context, indexName, documentID string) string {
+	elasticClient, err := app.createElasticsearchClient(5000)
+	if err != nil {
+		app.log.Error("Failed to read document title: " + err.Error())
+		return ""
 	}
-
-	if promptResults == "" {
-		return keywordResults, ""
+	title, err := elasticClient.GetDocumentTitle(ctx, indexName, documentID)
+	if err != nil {
+		app.log.Error("Failed to read document title: " + err.Error())
+		return ""
 	}
+	return title
+}
 
-	if keywordResults == promptResults {
-		return keywordResults, ""
+// documentContextPrompt combines the question with the retrieved chunks, best match first
+func documentContextPrompt(documentPrompt string, chunks []RetrievedChunk) string {
+	chunkTexts := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		chunkTexts[i] = chunk.Text
 	}
+	return documentPrompt + "\nUse only the provided Context:\n" + strings.Join(chunkTexts, "\n\n")
+}
 
-	keywordResultLines := strings.Split(keywordResults, "\n")
-	promptResultLines := strings.Split(promptResults, "\n")
-
-	keywordLinesMap := make(map[string]bool)
-	for _, line := range keywordResultLines {
-		trimmedLine := strings.TrimSpace(line)
-		if trimmedLine != "" {
-			keywordLinesMap[trimmedLine] = true
+// mergeRankedChunks combines the keyword and prompt search results into one ranking by score.
+// A chunk found by both searches is kept once, with its better score.
+func mergeRankedChunks(keywordResults, promptResults []RetrievedChunk) []RetrievedChunk {
+	positions := make(map[string]int)
+	var merged []RetrievedChunk
+	for _, chunk := range append(append([]RetrievedChunk{}, keywordResults...), promptResults...) {
+		key := strings.TrimSpace(chunk.Text)
+		if key == "" {
+			continue
 		}
-	}
-
-	var uniquePromptResultLines []string
-	for _, line := range promptResultLines {
-		trimmedLine := strings.TrimSpace(line)
-		if trimmedLine != "" && !keywordLinesMap[trimmedLine] {
-			uniquePromptResultLines = append(uniquePromptResultLines, line)
+		if position, exists := positions[key]; exists {
+			if chunk.Score > merged[position].Score {
+				merged[position].Score = chunk.Score
+			}
+			continue
 		}
+		positions[key] = len(merged)
+		merged = append(merged, chunk)
 	}
 
-	deduplicatedPromptResults := strings.Join(uniquePromptResultLines, "\n")
-
-	return keywordResults, deduplicatedPromptResults
+	sort.SliceStable(merged, func(i, j int) bool {
+		return merged[i].Score > merged[j].Score
+	})
+	return merged
 }
 
 // convertMapToLlamaCliArgs converts map[string]interface{} to LlamaCliArgs struct (creates a copy)
@@ -567,16 +817,6 @@ func (app *App) safeCreateEventHandler() *EventHandler {
 	return app.createEventHandler()
 }
 
-// safeResetContext safely resets the operation context with nil checks
-func (app *App) safeResetContext() {
-	if app == nil {
-		return
-	}
-
-	defer app.recoverFromPanic("safeResetContext", "")
-	app.resetContext()
-}
-
 // validateQueryRequest validates the incoming request structure
 func (app *App) validateQueryRequest(request DocumentQueryRequest) error {
 	if request.RequestID == "" {
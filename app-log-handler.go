@@ -9,3 +9,8 @@ func (app *App) GetAppLogFile(appLogPath string) string {
 func (app *App) GetModelFiles() []ModelNameFullPath {
 	return GetModelFilesInDirectory(*app.appArgs)
 }
+
+// GetModelDescriptors retrieves the GGUF facts of every model file, including files that were rejected
+func (app *App) GetModelDescriptors() []ModelDescriptor {
+	return GetModelDescriptorsInDirectory(*app.appArgs)
+}
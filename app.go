@@ -14,55 +14,72 @@ import (
 type App struct {
 	ctx            context.Context // Original Wails context
 	wailsCtx       context.Context // Store the original Wails context
-	cancelFunc     context.CancelFunc
-	operationCtx   context.Context // Separate context for operations
 	log            logger.Logger
 	llamaCliArgs   *LlamaCliArgs
 	llamaEmbedArgs *LlamaEmbedArgs
 	appArgs        *DefaultAppArgs
 	database       *mongo.Database
+	llamaServer    *LlamaServerManager
+	backends       *CompletionBackendRegistry
+	promptCache    *PromptCacheManager
+	jobs           *JobRegistry
 }
 
-// CancelProcess Update the method
+// CancelProcess cancels every running job; kept for callers that predate CancelJob
 func (app *App) CancelProcess() string {
-	if app.cancelFunc != nil {
-		app.log.Info("Canceling current process...")
-		app.cancelFunc() // Actually cancel the context
-		return "Process canceled successfully"
-	}
-	return "No active process to cancel"
+	return app.CancelAll()
 }
 
 // Startup Update to store both contexts
 func (app *App) Startup(ctx context.Context) {
 	app.log.Info("App startup called")
 	app.ctx = ctx
+	childProcesses.Configure(app.appArgs.AppLogPath)
+	if err := childProcesses.CleanupOrphans(app.log); err != nil {
+		app.log.Error("Failed to clean up orphaned processes: " + err.Error())
+	}
 	app.log.Info("Setting up event listeners...")
 	app.SetupEventListeners()
+	if err := app.loadPromptTemplates(); err != nil {
+		app.log.Error("Failed to load prompt templates, using the built-in prompt types: " + err.Error())
+	}
+	go app.logLlamaBinaryReport()
 	app.log.Info("Startup complete")
 }
 
-// Reset context for operations only
-func (app *App) resetContext() {
-	// Create a cancelable context from the original Wails context
-	cancelableCtx, cancel := context.WithCancel(app.wailsCtx)
-	app.operationCtx = cancelableCtx
-	app.cancelFunc = cancel
-}
-
 // NewApp creates a new App application struct
 func NewApp(logger logger.Logger, llamaCliArgs *LlamaCliArgs, llamaEmbedArgs *LlamaEmbedArgs, appArgs *DefaultAppArgs, database *mongo.Database) *App {
-	return &App{
+	llamaServer := NewLlamaServerManager(logger)
+	promptCache := NewPromptCacheManager(appArgs, logger)
+	app := &App{
 		log:            logger,
 		llamaCliArgs:   llamaCliArgs,
 		llamaEmbedArgs: llamaEmbedArgs,
 		appArgs:        appArgs,
 		database:       database,
+		llamaServer:    llamaServer,
+		backends:       NewCompletionBackendRegistry(appArgs, llamaServer, promptCache),
+		promptCache:    promptCache,
+		jobs:           NewJobRegistry(),
 	}
+
+	workScheduler.Configure(map[string]int{
+		ResourceLLM:   appArgs.SchedulerLlmConcurrency,
+		ResourceEmbed: appArgs.SchedulerEmbedConcurrency,
+		ResourceOCR:   appArgs.SchedulerOcrConcurrency,
+	})
+	workScheduler.SetQueuedHandler(app.emitQueuedProgress)
+	embeddingCache.Configure(appArgs.EmbeddingCachePath, appArgs.EmbeddingCacheMaxSizeMB)
+	llamaBinaries.SetLogger(logger)
+	return app
 }
 
 func (app *App) Shutdown(ctx context.Context) {
 	app.ctx = ctx
+	app.jobs.CancelAll()
+	if app.llamaServer != nil {
+		app.llamaServer.Stop()
+	}
 }
 
 func (app *App) Domready(ctx context.Context) {
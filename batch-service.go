@@ -0,0 +1,581 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// Columns the batch job adds to every output row
+var batchOutputColumns = []string{"completion", "error", "duration_ms", "generated_tokens", "tokens_per_second"}
+
+// batchOutputPrefix is prepended to an output column whose name is already used by an input column
+const batchOutputPrefix = "output_"
+
+// BatchInferenceRequest runs PromptTemplate over every row of a CSV or JSONL file. The template
+// references columns as {{.column}}, or {{index . "column name"}} for names that are not identifiers.
+type BatchInferenceRequest struct {
+	RequestID      string `json:"requestId,omitempty"`
+	InputPath      string `json:"inputPath"`
+	OutputPath     string `json:"outputPath,omitempty"` // defaults to <input>.output.<ext> next to the input
+	PromptTemplate string `json:"promptTemplate"`
+	PromptType     string `json:"promptType"`
+	Profile        string `json:"profile,omitempty"` // saved cli-settings profile; the current settings when empty
+	Restart        bool   `json:"restart"`           // discard the rows of an existing output file instead of resuming
+}
+
+// BatchInferenceProgress is emitted as batch-inference-progress after every row
+type BatchInferenceProgress struct {
+	RequestID  string `json:"requestId"`
+	Completed  int    `json:"completed"`
+	Failed     int    `json:"failed"`
+	Total      int    `json:"total"`
+	OutputPath string `json:"outputPath"`
+}
+
+// BatchInferenceResponse is emitted as batch-inference-complete when the job ends
+type BatchInferenceResponse struct {
+	RequestID  string `json:"requestId"`
+	Success    bool   `json:"success"`
+	Error      string `json:"error,omitempty"`
+	OutputPath string `json:"outputPath"`
+	Completed  int    `json:"completed"`
+	Resumed    int    `json:"resumed"` // rows taken over from an earlier, unfinished run
+	Failed     int    `json:"failed"`
+	Total      int    `json:"total"`
+}
+
+// batchRow is one input row; raw keeps the JSON values of JSONL rows so they are written back unchanged
+type batchRow struct {
+	values map[string]string
+	raw    map[string]json.RawMessage
+}
+
+// batchFile is a parsed batch input
+type batchFile struct {
+	jsonLines     bool
+	columns       []string
+	outputColumns []string // batchOutputColumns, prefixed where an input column has the same name
+	rows          []batchRow
+}
+
+// batchResult is the outcome of one row
+type batchResult struct {
+	completion string
+	err        string
+	durationMs int64
+	stats      *RunStats
+}
+
+// StartBatchInference validates the request and runs it in the background as a batch job. It returns
+// the job's request ID; progress is emitted as batch-inference-progress events and the result as a
+// batch-inference-complete event.
+func (app *App) StartBatchInference(request BatchInferenceRequest) (string, error) {
+	if strings.TrimSpace(request.PromptTemplate) == "" {
+		return "", fmt.Errorf("a prompt template is required")
+	}
+	input, err := readBatchFile(request.InputPath)
+	if err != nil {
+		app.log.Error("Failed to read batch input: " + err.Error())
+		return "", err
+	}
+	if err := validateBatchColumns(input.columns); err != nil {
+		app.log.Error("Invalid batch input: " + err.Error())
+		return "", err
+	}
+	if request.OutputPath == "" {
+		request.OutputPath = defaultBatchOutputPath(request.InputPath)
+	}
+
+	cliArgs, err := app.batchCliArgs(request.Profile)
+	if err != nil {
+		app.log.Error("Failed to prepare batch: " + err.Error())
+		return "", err
+	}
+
+	job, err := app.startJob(request.RequestID, JobKindBatch)
+	if err != nil {
+		return "", err
+	}
+	request.RequestID = job.ID()
+
+	go func() {
+		response := app.runBatchInference(job.Context(), request, input, cliArgs)
+		var jobErr error
+		if !response.Success {
+			jobErr = errors.New(response.Error)
+		}
+		app.jobs.Finish(job, jobErr)
+		runtime.EventsEmit(app.ctx, "batch-inference-complete", response)
+	}()
+
+	return request.RequestID, nil
+}
+
+// batchCliArgs returns the settings of the named profile, or the current settings
+func (app *App) batchCliArgs(profile string) (LlamaCliArgs, error) {
+	if profile == "" {
+		if app.llamaCliArgs == nil {
+			return LlamaCliArgs{}, fmt.Errorf("no settings profile selected and no current settings")
+		}
+		return *app.llamaCliArgs, nil
+	}
+	saved, err := GetSavedCliSettings(app.appArgs)
+	if err != nil {
+		return LlamaCliArgs{}, fmt.Errorf("failed to load saved settings: %w", err)
+	}
+	return findSavedCliProfile(saved, profile)
+}
+
+// runBatchInference processes the rows the output file does not contain yet, appending each result
+// as soon as it is available so an interrupted batch can resume
+func (app *App) runBatchInference(ctx context.Context, request BatchInferenceRequest, input *batchFile, cliArgs LlamaCliArgs) BatchInferenceResponse {
+	response := BatchInferenceResponse{RequestID: request.RequestID, OutputPath: request.OutputPath, Total: len(input.rows)}
+
+	output, resumed, err := openBatchOutput(request.OutputPath, input, request.Restart)
+	if err != nil {
+		response.Error = err.Error()
+		app.log.Error("Failed to open batch output: " + err.Error())
+		return response
+	}
+	defer func() {
+		if err := output.Close(); err != nil {
+			app.log.Error("Failed to close batch output: " + err.Error())
+		}
+	}()
+	response.Resumed = resumed
+	response.Completed = resumed
+	if resumed > 0 {
+		app.log.Info(fmt.Sprintf("Resuming batch %s at row %d of %d", request.RequestID, resumed+1, len(input.rows)))
+	}
+
+	for _, row := range input.rows[resumed:] {
+		if ctx.Err() != nil {
+			response.Error = "Operation cancelled by user"
+			return response
+		}
+
+		result := app.runBatchRow(ctx, request, cliArgs, row)
+		if ctx.Err() != nil {
+			// The interrupted row is not written so that a resumed batch runs it again
+			response.Error = "Operation cancelled by user"
+			return response
+		}
+		if err := output.Write(input, row, result); err != nil {
+			response.Error = err.Error()
+			app.log.Error("Failed to write batch output: " + err.Error())
+			return response
+		}
+
+		response.Completed++
+		if result.err != "" {
+			response.Failed++
+		}
+		app.jobs.SetProgress(request.RequestID, response.Completed*100/max(len(input.rows), 1))
+		runtime.EventsEmit(app.ctx, "batch-inference-progress", BatchInferenceProgress{
+			RequestID:  request.RequestID,
+			Completed:  response.Completed,
+			Failed:     response.Failed,
+			Total:      len(input.rows),
+			OutputPath: request.OutputPath,
+		})
+	}
+
+	response.Success = true
+	app.log.Info(fmt.Sprintf("Batch %s finished: %d rows, %d failed", request.RequestID, response.Completed, response.Failed))
+	return response
+}
+
+// runBatchRow fills the template with the row's values and runs the completion
+func (app *App) runBatchRow(ctx context.Context, request BatchInferenceRequest, cliArgs LlamaCliArgs, row batchRow) batchResult {
+	startTime := time.Now()
+	result := batchResult{}
+
+	// Row values are data; they must not be able to add turns to the prompt
+	processor := NewTemplateProcessor(app.log)
+	variables := make(map[string]string, len(row.values))
+	for column, value := range row.values {
+		variables[column], _ = processor.StripControlTokens(request.PromptType, PromptSourceContext, value)
+	}
+
+	promptText, options, err := PromptOptions{Variables: variables}.Expand(request.PromptTemplate)
+	if err != nil {
+		result.err = err.Error()
+		return result
+	}
+	prompt, err := HandlePromptType(app.log, request.PromptType, cliArgs.ModelFullPathVal, promptText, options)
+	if err != nil {
+		result.err = err.Error()
+		return result
+	}
+
+	cliArgs.PromptText = prompt
+	output, err := app.generateCompletion(ctx, CompletionRequest{
+		RequestID:  request.RequestID,
+		CliArgs:    cliArgs,
+		PromptType: request.PromptType,
+		Prompt:     prompt,
+		Messages:   BuildChatMessages(request.PromptType, promptText, options),
+	}, nil)
+	result.durationMs = time.Since(startTime).Milliseconds()
+	result.stats = output.Stats
+	if err != nil {
+		result.err = err.Error()
+		return result
+	}
+	result.completion = ParseModelOutput(request.PromptType, prompt, output.Text).Answer
+	return result
+}
+
+// defaultBatchOutputPath places the output next to the input, as data.csv -> data.output.csv
+func defaultBatchOutputPath(inputPath string) string {
+	extension := filepath.Ext(inputPath)
+	return strings.TrimSuffix(inputPath, extension) + ".output" + extension
+}
+
+// isJSONLinesPath reports whether a path names a JSONL file
+func isJSONLinesPath(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jsonl", ".ndjson":
+		return true
+	}
+	return false
+}
+
+// readBatchFile reads a CSV file with a header row, or a JSONL file with one object per line
+func readBatchFile(path string) (*batchFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open batch input: %w", err)
+	}
+	defer func() { _ = file.Close() }()
+
+	var input *batchFile
+	if isJSONLinesPath(path) {
+		input, err = readBatchJSONLines(file)
+	} else {
+		input, err = readBatchCSV(file)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(input.rows) == 0 {
+		return nil, fmt.Errorf("batch input %s has no rows", filepath.Base(path))
+	}
+	input.outputColumns = batchOutputColumnNames(input.columns)
+	return input, nil
+}
+
+// validateBatchColumns rejects columns that would shadow the fields prompt templates fill in, such as
+// Input or SystemPrompt, since every column becomes a template variable
+func validateBatchColumns(columns []string) error {
+	reserved := promptTemplateFieldNames()
+	for _, column := range columns {
+		if reserved[column] {
+			return fmt.Errorf("column %q conflicts with a prompt template field; rename the column", column)
+		}
+	}
+	return nil
+}
+
+// batchOutputColumnNames returns the names of the result columns, prefixing any that an input column
+// already uses so results never overwrite input values
+func batchOutputColumnNames(inputColumns []string) []string {
+	used := make(map[string]bool, len(inputColumns))
+	for _, column := range inputColumns {
+		used[column] = true
+	}
+	names := make([]string, len(batchOutputColumns))
+	for i, name := range batchOutputColumns {
+		for used[name] {
+			name = batchOutputPrefix + name
+		}
+		used[name] = true
+		names[i] = name
+	}
+	return names
+}
+
+func readBatchCSV(reader io.Reader) (*batchFile, error) {
+	csvReader := csv.NewReader(reader)
+	header, err := csvReader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+	if len(header) > 0 {
+		header[0] = strings.TrimPrefix(header[0], "\uFEFF")
+	}
+
+	input := &batchFile{columns: header}
+	for {
+		record, err := csvReader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CSV row %d: %w", len(input.rows)+1, err)
+		}
+		row := batchRow{values: make(map[string]string, len(header))}
+		for i, column := range header {
+			row.values[column] = record[i]
+		}
+		input.rows = append(input.rows, row)
+	}
+	return input, nil
+}
+
+func readBatchJSONLines(reader io.Reader) (*batchFile, error) {
+	input := &batchFile{jsonLines: true}
+	knownColumns := make(map[string]bool)
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		keys, raw, err := decodeOrderedObject(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JSON on line %d: %w", lineNumber, err)
+		}
+
+		row := batchRow{values: make(map[string]string, len(keys)), raw: raw}
+		for _, key := range keys {
+			if !knownColumns[key] {
+				knownColumns[key] = true
+				input.columns = append(input.columns, key)
+			}
+			var text string
+			if json.Unmarshal(raw[key], &text) != nil {
+				text = string(raw[key])
+			}
+			row.values[key] = text
+		}
+		input.rows = append(input.rows, row)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read JSONL input: %w", err)
+	}
+	return input, nil
+}
+
+// decodeOrderedObject decodes a JSON object, returning its keys in document order
+func decodeOrderedObject(data []byte) ([]string, map[string]json.RawMessage, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return nil, nil, fmt.Errorf("expected a JSON object")
+	}
+
+	var keys []string
+	values := make(map[string]json.RawMessage)
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+		key, _ := token.(string)
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return nil, nil, err
+		}
+		if _, exists := values[key]; !exists {
+			keys = append(keys, key)
+		}
+		values[key] = value
+	}
+	if _, err := decoder.Token(); err != nil {
+		return nil, nil, err
+	}
+	return keys, values, nil
+}
+
+// batchOutput appends result rows to the output file
+type batchOutput struct {
+	file      *os.File
+	csvWriter *csv.Writer
+}
+
+// openBatchOutput opens the output file for appending and returns how many input rows it already
+// holds. A row cut off by an interrupted run is removed. Unless restart is set, existing rows must
+// match the input so a batch is never resumed against a different file.
+func openBatchOutput(path string, input *batchFile, restart bool) (*batchOutput, int, error) {
+	completed := 0
+	validLength := int64(0)
+	if !restart {
+		var err error
+		completed, validLength, err = scanBatchOutput(path, input)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to open batch output: %w", err)
+	}
+	if err := file.Truncate(validLength); err != nil {
+		_ = file.Close()
+		return nil, 0, fmt.Errorf("failed to truncate batch output: %w", err)
+	}
+	if _, err := file.Seek(validLength, io.SeekStart); err != nil {
+		_ = file.Close()
+		return nil, 0, fmt.Errorf("failed to seek batch output: %w", err)
+	}
+
+	output := &batchOutput{file: file}
+	if !input.jsonLines {
+		output.csvWriter = csv.NewWriter(file)
+		if validLength == 0 {
+			output.csvWriter.Write(append(append([]string{}, input.columns...), input.outputColumns...))
+			output.csvWriter.Flush()
+			if err := output.csvWriter.Error(); err != nil {
+				_ = file.Close()
+				return nil, 0, fmt.Errorf("failed to write batch output header: %w", err)
+			}
+		}
+	}
+	return output, completed, nil
+}
+
+// scanBatchOutput counts the complete rows of an existing output file and returns the length of the
+// file up to the end of the last of them
+func scanBatchOutput(path string, input *batchFile) (int, int64, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read existing batch output: %w", err)
+	}
+
+	mismatch := func(row int) error {
+		return fmt.Errorf("existing output %s does not match row %d of the input; choose another output file or restart the batch", filepath.Base(path), row)
+	}
+
+	if input.jsonLines {
+		completed, offset := 0, 0
+		for completed < len(input.rows) {
+			newline := bytes.IndexByte(data[offset:], '\n')
+			if newline < 0 {
+				break
+			}
+			keys, raw, err := decodeOrderedObject(data[offset : offset+newline])
+			if err != nil || len(keys) == 0 {
+				break
+			}
+			for column, value := range input.rows[completed].raw {
+				if !bytes.Equal(raw[column], value) {
+					return 0, 0, mismatch(completed + 1)
+				}
+			}
+			completed++
+			offset += newline + 1
+		}
+		return completed, int64(offset), nil
+	}
+
+	expectedHeader := append(append([]string{}, input.columns...), input.outputColumns...)
+	csvReader := csv.NewReader(bytes.NewReader(data))
+	csvReader.FieldsPerRecord = len(expectedHeader)
+	header, err := csvReader.Read()
+	if err != nil || strings.Join(header, "\x00") != strings.Join(expectedHeader, "\x00") {
+		if len(bytes.TrimSpace(data)) == 0 {
+			return 0, 0, nil
+		}
+		return 0, 0, fmt.Errorf("existing output %s has different columns; choose another output file or restart the batch", filepath.Base(path))
+	}
+
+	completed, offset := 0, csvReader.InputOffset()
+	for completed < len(input.rows) {
+		record, err := csvReader.Read()
+		if err != nil {
+			break
+		}
+		// A record is only complete once its line ending has been written
+		if end := csvReader.InputOffset(); end > int64(len(data)) || data[end-1] != '\n' {
+			break
+		}
+		for i, column := range input.columns {
+			if record[i] != input.rows[completed].values[column] {
+				return 0, 0, mismatch(completed + 1)
+			}
+		}
+		completed++
+		offset = csvReader.InputOffset()
+	}
+	return completed, offset, nil
+}
+
+// Write appends the result of a row and flushes it to disk
+func (o *batchOutput) Write(input *batchFile, row batchRow, result batchResult) error {
+	resultValues := []string{result.completion, result.err, strconv.FormatInt(result.durationMs, 10), "", ""}
+	if result.stats != nil {
+		resultValues[3] = strconv.Itoa(result.stats.GeneratedTokens)
+		resultValues[4] = strconv.FormatFloat(result.stats.GenerationTokensPerSecond, 'f', 2, 64)
+	}
+
+	if o.csvWriter != nil {
+		record := make([]string, 0, len(input.columns)+len(resultValues))
+		for _, column := range input.columns {
+			record = append(record, row.values[column])
+		}
+		o.csvWriter.Write(append(record, resultValues...))
+		o.csvWriter.Flush()
+		if err := o.csvWriter.Error(); err != nil {
+			return fmt.Errorf("failed to write batch output: %w", err)
+		}
+		return o.file.Sync()
+	}
+
+	var line bytes.Buffer
+	line.WriteByte('{')
+	writeField := func(key string, value json.RawMessage) {
+		if line.Len() > 1 {
+			line.WriteByte(',')
+		}
+		encodedKey, _ := json.Marshal(key)
+		line.Write(encodedKey)
+		line.WriteByte(':')
+		line.Write(value)
+	}
+	for _, column := range input.columns {
+		if value, exists := row.raw[column]; exists {
+			writeField(column, value)
+		}
+	}
+	for i, column := range input.outputColumns {
+		encodedValue, _ := json.Marshal(resultValues[i])
+		if i >= 2 {
+			// Timing columns are numbers, or null when llama-cli reported no statistics
+			encodedValue = json.RawMessage(resultValues[i])
+			if resultValues[i] == "" {
+				encodedValue = json.RawMessage("null")
+			}
+		}
+		writeField(column, encodedValue)
+	}
+	line.WriteString("}\n")
+
+	if _, err := o.file.Write(line.Bytes()); err != nil {
+		return fmt.Errorf("failed to write batch output: %w", err)
+	}
+	return o.file.Sync()
+}
+
+// Close closes the output file
+func (o *batchOutput) Close() error {
+	return o.file.Close()
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateBatchColumns(t *testing.T) {
+	if err := validateBatchColumns([]string{"question", "Date", "input"}); err != nil {
+		t.Errorf("ordinary columns were rejected: %v", err)
+	}
+	for _, column := range []string{"Input", "SystemPrompt", "UserPrompt", "AssistantPrompt", "UserStart", "UserEnd"} {
+		if err := validateBatchColumns([]string{"question", column}); err == nil {
+			t.Errorf("column %s was accepted", column)
+		}
+	}
+}
+
+func TestBatchOutputColumnNames(t *testing.T) {
+	got := batchOutputColumnNames([]string{"question", "completion", "output_completion", "error"})
+	want := []string{"output_output_completion", "output_error", "duration_ms", "generated_tokens", "tokens_per_second"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestBatchOutputResumesWithCollidingColumns(t *testing.T) {
+	for _, name := range []string{"input.csv", "input.jsonl"} {
+		t.Run(name, func(t *testing.T) {
+			directory := t.TempDir()
+			inputPath := filepath.Join(directory, name)
+			content := "question,completion\nfirst,expected one\nsecond,expected two\n"
+			if strings.HasSuffix(name, ".jsonl") {
+				content = `{"question":"first","completion":"expected one"}` + "\n" + `{"question":"second","completion":"expected two"}` + "\n"
+			}
+			if err := os.WriteFile(inputPath, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			input, err := readBatchFile(inputPath)
+			if err != nil {
+				t.Fatalf("readBatchFile: %v", err)
+			}
+			outputPath := defaultBatchOutputPath(inputPath)
+
+			output, resumed, err := openBatchOutput(outputPath, input, false)
+			if err != nil || resumed != 0 {
+				t.Fatalf("openBatchOutput: resumed %d, %v", resumed, err)
+			}
+			if err := output.Write(input, input.rows[0], batchResult{completion: "generated"}); err != nil {
+				t.Fatalf("Write: %v", err)
+			}
+			if err := output.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			written, err := os.ReadFile(outputPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(written), "expected one") || !strings.Contains(string(written), "output_completion") {
+				t.Errorf("output does not keep the input column next to the result:\n%s", written)
+			}
+
+			output, resumed, err = openBatchOutput(outputPath, input, false)
+			if err != nil {
+				t.Fatalf("reopening the output: %v", err)
+			}
+			_ = output.Close()
+			if resumed != 1 {
+				t.Errorf("resumed %d rows, want 1", resumed)
+			}
+		})
+	}
+}
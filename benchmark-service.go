@@ -0,0 +1,374 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// BenchmarkRequest starts a benchmark. Every model is run with every settings profile, one run after
+// the other and with the same seed. Without models each profile runs its own model; without
+// profiles the current settings are used.
+type BenchmarkRequest struct {
+	RequestID     string                  `json:"requestId,omitempty"`
+	Name          string                  `json:"name"`
+	PromptType    string                  `json:"promptType"`
+	PromptText    string                  `json:"promptText,omitempty"`
+	DocumentQuery *BenchmarkDocumentQuery `json:"documentQuery,omitempty"` // answer a document query instead of PromptText
+	ModelPaths    []string                `json:"modelPaths"`
+	Profiles      []string                `json:"profiles"` // descriptions of saved cli-settings profiles
+	Seed          int                     `json:"seed"`
+}
+
+// BenchmarkProgress is emitted as benchmark-progress after every run
+type BenchmarkProgress struct {
+	RequestID   string       `json:"requestId"`
+	BenchmarkID string       `json:"benchmarkId"`
+	Completed   int          `json:"completed"`
+	Total       int          `json:"total"`
+	Run         BenchmarkRun `json:"run"`
+}
+
+// BenchmarkTable is a benchmark laid out side by side, one column per run
+type BenchmarkTable struct {
+	BenchmarkID string              `json:"benchmarkId"`
+	Name        string              `json:"name"`
+	Columns     []string            `json:"columns"`
+	Rows        []BenchmarkTableRow `json:"rows"`
+}
+
+// BenchmarkTableRow is one metric across all runs of a benchmark
+type BenchmarkTableRow struct {
+	Metric string   `json:"metric"`
+	Values []string `json:"values"`
+}
+
+// benchmarkCase is one model and settings profile combination
+type benchmarkCase struct {
+	profile string
+	cliArgs LlamaCliArgs
+}
+
+// StartBenchmark validates the request, stores the benchmark and runs it in the background as a
+// benchmark job. It returns the benchmark ID; progress is emitted as benchmark-progress events and
+// the finished benchmark as a benchmark-complete event.
+func (app *App) StartBenchmark(request BenchmarkRequest) (string, error) {
+	if request.DocumentQuery == nil && strings.TrimSpace(request.PromptText) == "" {
+		return "", fmt.Errorf("a prompt or a document query is required")
+	}
+	if request.DocumentQuery != nil && strings.TrimSpace(request.DocumentQuery.DocumentPrompt) == "" {
+		return "", fmt.Errorf("the document query needs a question")
+	}
+
+	cases, err := app.benchmarkCases(request)
+	if err != nil {
+		app.log.Error("Failed to prepare benchmark: " + err.Error())
+		return "", err
+	}
+
+	benchmark := Benchmark{
+		Name:          request.Name,
+		PromptType:    request.PromptType,
+		PromptText:    request.PromptText,
+		DocumentQuery: request.DocumentQuery,
+		Seed:          request.Seed,
+		Status:        JobStatusRunning,
+		TotalRuns:     len(cases),
+	}
+	if benchmark.Name == "" {
+		benchmark.Name = "Benchmark " + time.Now().Format("2006-01-02 15:04")
+	}
+	benchmarkID, err := CreateBenchmark(app.appArgs, benchmark)
+	if err != nil {
+		app.log.Error("Failed to create benchmark: " + err.Error())
+		return "", err
+	}
+
+	job, err := app.startJob(request.RequestID, JobKindBenchmark)
+	if err != nil {
+		_ = FinishBenchmark(app.appArgs, benchmarkID, JobStatusFailed, err.Error())
+		return "", err
+	}
+	request.RequestID = job.ID()
+
+	go func() {
+		err := app.runBenchmark(job.Context(), benchmarkID, request, cases)
+		app.jobs.Finish(job, err)
+
+		status, errorMessage := JobStatusCompleted, ""
+		switch {
+		case job.Canceled():
+			status = JobStatusCanceled
+		case err != nil:
+			status, errorMessage = JobStatusFailed, err.Error()
+			app.log.Error(fmt.Sprintf("Benchmark %s failed: %s", benchmarkID, errorMessage))
+		}
+		if err := FinishBenchmark(app.appArgs, benchmarkID, status, errorMessage); err != nil {
+			app.log.Error("Failed to finish benchmark: " + err.Error())
+		}
+
+		finished, err := GetBenchmark(app.appArgs, benchmarkID)
+		if err != nil {
+			app.log.Error("Failed to load finished benchmark: " + err.Error())
+			finished = Benchmark{Status: status, Error: errorMessage}
+		}
+		runtime.EventsEmit(app.ctx, "benchmark-complete", finished)
+	}()
+
+	return benchmarkID, nil
+}
+
+// benchmarkCases expands the models and profiles of a request into the runs to perform
+func (app *App) benchmarkCases(request BenchmarkRequest) ([]benchmarkCase, error) {
+	var profiles []benchmarkCase
+	if len(request.Profiles) == 0 {
+		if app.llamaCliArgs == nil {
+			return nil, fmt.Errorf("no settings profile selected and no current settings")
+		}
+		profiles = append(profiles, benchmarkCase{profile: "Current settings", cliArgs: *app.llamaCliArgs})
+	} else {
+		saved, err := GetSavedCliSettings(app.appArgs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load saved settings: %w", err)
+		}
+		for _, description := range request.Profiles {
+			cliArgs, err := findSavedCliProfile(saved, description)
+			if err != nil {
+				return nil, err
+			}
+			profiles = append(profiles, benchmarkCase{profile: description, cliArgs: cliArgs})
+		}
+	}
+
+	if len(request.ModelPaths) == 0 {
+		return profiles, nil
+	}
+	cases := make([]benchmarkCase, 0, len(request.ModelPaths)*len(profiles))
+	for _, modelPath := range request.ModelPaths {
+		for _, profile := range profiles {
+			profile.cliArgs.ModelFullPathVal = modelPath
+			cases = append(cases, profile)
+		}
+	}
+	return cases, nil
+}
+
+// findSavedCliProfile decodes the saved cli-settings profile with the given description
+func findSavedCliProfile(saved []SettingsDocument, description string) (LlamaCliArgs, error) {
+	for _, document := range saved {
+		if document.Description != description {
+			continue
+		}
+		settingsJSON, err := json.Marshal(document.Settings)
+		if err != nil {
+			return LlamaCliArgs{}, fmt.Errorf("failed to read settings profile %s: %w", description, err)
+		}
+		var cliArgs LlamaCliArgs
+		if err := json.Unmarshal(settingsJSON, &cliArgs); err != nil {
+			return LlamaCliArgs{}, fmt.Errorf("failed to read settings profile %s: %w", description, err)
+		}
+		return cliArgs, nil
+	}
+	return LlamaCliArgs{}, fmt.Errorf("settings profile %s not found", description)
+}
+
+// runBenchmark performs the runs one after the other. A failed run is recorded and the benchmark
+// continues; only cancellation and retrieval failures stop it.
+func (app *App) runBenchmark(ctx context.Context, benchmarkID string, request BenchmarkRequest, cases []benchmarkCase) error {
+	var rankedChunks []RetrievedChunk
+	if query := request.DocumentQuery; query != nil {
+		// Every run answers from the same retrieved context
+		chunks, err := app.retrieveDocumentChunks(ctx, query.LlamaEmbedArgs, query.IndexID, query.DocumentID, query.EmbeddingPrompt, query.SearchKeywords)
+		if err != nil {
+			return err
+		}
+		rankedChunks, _ = NewTemplateProcessor(app.log).StripControlTokensFromChunks(request.PromptType, chunks)
+	}
+
+	for i, benchmarkCase := range cases {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		run := app.runBenchmarkCase(ctx, request, benchmarkCase, rankedChunks)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err := AppendBenchmarkRun(app.appArgs, benchmarkID, run); err != nil {
+			app.log.Error("Failed to save benchmark run: " + err.Error())
+		}
+
+		app.jobs.SetProgress(request.RequestID, (i+1)*100/len(cases))
+		runtime.EventsEmit(app.ctx, "benchmark-progress", BenchmarkProgress{
+			RequestID:   request.RequestID,
+			BenchmarkID: benchmarkID,
+			Completed:   i + 1,
+			Total:       len(cases),
+			Run:         run,
+		})
+	}
+	return nil
+}
+
+// runBenchmarkCase runs llama-cli once with the settings of benchmarkCase. Runs bypass the completion
+// backends and the prompt cache so that every run loads its model and evaluates the full prompt. A run
+// without performance statistics has nothing to compare and is marked as failed.
+func (app *App) runBenchmarkCase(ctx context.Context, request BenchmarkRequest, benchmarkCase benchmarkCase, rankedChunks []RetrievedChunk) BenchmarkRun {
+	startTime := time.Now()
+	cliArgs := benchmarkCase.cliArgs
+	if cliArgs.RandomSeedCmd == "" {
+		cliArgs.RandomSeedCmd = "--seed"
+	}
+	cliArgs.RandomSeedVal = strconv.Itoa(request.Seed)
+	cliArgs.PromptCacheVal = ""
+	cliArgs.PromptText = ""
+	// The timings llama-cli prints are what the benchmark compares
+	cliArgs.NoPerfCmdEnabled = false
+
+	run := BenchmarkRun{
+		ModelPath: cliArgs.ModelFullPathVal,
+		ModelName: filepath.Base(cliArgs.ModelFullPathVal),
+		Profile:   benchmarkCase.profile,
+		Args:      LlamaCliStructToArgs(cliArgs),
+	}
+	app.log.Info(fmt.Sprintf("Benchmark run %s with %s", run.ModelName, run.Profile))
+
+	prompt, usage, err := app.benchmarkPrompt(ctx, request, cliArgs, rankedChunks)
+	run.ChunksUsed = usage.ChunksUsed
+	if err != nil {
+		run.Error = err.Error()
+		run.DurationMs = time.Since(startTime).Milliseconds()
+		return run
+	}
+
+	cliArgs.PromptText = prompt
+	output, stats, err := GenerateStreamingCompletionWithCancel(ctx, *app.appArgs, LlamaCliStructToArgs(cliArgs), nil)
+	run.Stats = stats
+	run.DurationMs = time.Since(startTime).Milliseconds()
+	if err != nil {
+		run.Error = err.Error()
+		return run
+	}
+
+	parsed := ParseModelOutput(request.PromptType, prompt, string(output))
+	run.Answer = parsed.Answer
+	run.Reasoning = parsed.Reasoning
+	if stats == nil {
+		run.Error = "llama-cli reported no performance statistics"
+	}
+	return run
+}
+
+// benchmarkPrompt renders the prompt of a run. A document query fits as many retrieved chunks as the
+// run's context window allows, so runs with smaller contexts may see less of the document.
+func (app *App) benchmarkPrompt(ctx context.Context, request BenchmarkRequest, cliArgs LlamaCliArgs, rankedChunks []RetrievedChunk) (string, RetrievalContextUsage, error) {
+	if request.DocumentQuery == nil {
+		prompt, err := HandlePromptType(app.log, request.PromptType, cliArgs.ModelFullPathVal, request.PromptText, PromptOptions{})
+		return prompt, RetrievalContextUsage{}, err
+	}
+
+	question := request.DocumentQuery.DocumentPrompt
+	buildPrompt := func(chunks []RetrievedChunk) (string, error) {
+		return HandlePromptType(app.log, request.PromptType, cliArgs.ModelFullPathVal, documentContextPrompt(question, chunks), PromptOptions{})
+	}
+	contextChunks, usage, err := app.fitRetrievedContext(ctx, cliArgs, rankedChunks, buildPrompt)
+	if err != nil {
+		return "", usage, err
+	}
+	prompt, err := buildPrompt(contextChunks)
+	return prompt, usage, err
+}
+
+// ListBenchmarks returns all benchmarks without their runs, newest first
+func (app *App) ListBenchmarks() ([]Benchmark, error) {
+	benchmarks, err := GetBenchmarks(app.appArgs)
+	if err != nil {
+		app.log.Error("Failed to list benchmarks: " + err.Error())
+	}
+	return benchmarks, err
+}
+
+// GetBenchmark returns a benchmark with all of its runs
+func (app *App) GetBenchmark(benchmarkID string) (Benchmark, error) {
+	benchmark, err := GetBenchmark(app.appArgs, benchmarkID)
+	if err != nil {
+		app.log.Error("Failed to get benchmark: " + err.Error())
+	}
+	return benchmark, err
+}
+
+// DeleteBenchmark removes a benchmark
+func (app *App) DeleteBenchmark(benchmarkID string) error {
+	if err := DeleteBenchmark(app.appArgs, benchmarkID); err != nil {
+		app.log.Error("Failed to delete benchmark: " + err.Error())
+		return err
+	}
+	return nil
+}
+
+// GetBenchmarkTable returns a benchmark as a side-by-side comparison of its runs
+func (app *App) GetBenchmarkTable(benchmarkID string) (BenchmarkTable, error) {
+	benchmark, err := GetBenchmark(app.appArgs, benchmarkID)
+	if err != nil {
+		app.log.Error("Failed to get benchmark: " + err.Error())
+		return BenchmarkTable{}, err
+	}
+	return NewBenchmarkTable(benchmark), nil
+}
+
+// NewBenchmarkTable lays out the runs of a benchmark as columns with one row per metric
+func NewBenchmarkTable(benchmark Benchmark) BenchmarkTable {
+	table := BenchmarkTable{BenchmarkID: benchmark.ID.Hex(), Name: benchmark.Name}
+	metrics := []struct {
+		name  string
+		value func(run BenchmarkRun) string
+	}{
+		{"Model", func(run BenchmarkRun) string { return run.ModelName }},
+		{"Profile", func(run BenchmarkRun) string { return run.Profile }},
+		{"Load (ms)", statValue(func(stats *RunStats) string { return formatStat(stats.LoadMs) })},
+		{"Prompt tokens", statValue(func(stats *RunStats) string { return strconv.Itoa(stats.PromptTokens) })},
+		{"Prompt tokens/s", statValue(func(stats *RunStats) string { return formatStat(stats.PromptTokensPerSecond) })},
+		{"Generated tokens", statValue(func(stats *RunStats) string { return strconv.Itoa(stats.GeneratedTokens) })},
+		{"Generation tokens/s", statValue(func(stats *RunStats) string { return formatStat(stats.GenerationTokensPerSecond) })},
+		{"Context used", statValue(func(stats *RunStats) string { return strconv.Itoa(stats.ContextUsed) })},
+		{"Chunks used", func(run BenchmarkRun) string { return strconv.Itoa(run.ChunksUsed) }},
+		{"Duration (ms)", func(run BenchmarkRun) string { return strconv.FormatInt(run.DurationMs, 10) }},
+		{"Error", func(run BenchmarkRun) string { return run.Error }},
+		{"Answer", func(run BenchmarkRun) string { return run.Answer }},
+	}
+
+	for _, run := range benchmark.Runs {
+		table.Columns = append(table.Columns, run.ModelName+" / "+run.Profile)
+	}
+	for _, metric := range metrics {
+		if metric.name == "Chunks used" && benchmark.DocumentQuery == nil {
+			continue
+		}
+		row := BenchmarkTableRow{Metric: metric.name, Values: make([]string, len(benchmark.Runs))}
+		for i, run := range benchmark.Runs {
+			row.Values[i] = metric.value(run)
+		}
+		table.Rows = append(table.Rows, row)
+	}
+	return table
+}
+
+// statValue formats a RunStats figure, leaving the cell empty when the run reported no stats
+func statValue(format func(stats *RunStats) string) func(run BenchmarkRun) string {
+	return func(run BenchmarkRun) string {
+		if run.Stats == nil {
+			return ""
+		}
+		return format(run.Stats)
+	}
+}
+
+func formatStat(value float64) string {
+	return strconv.FormatFloat(value, 'f', 2, 64)
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	chatSummaryTokens      = 256 // tokens reserved for, and generated by, the history summary
+	chatTurnOverheadTokens = 8   // control tokens added around every rendered turn
+	chatTitleLength        = 60
+)
+
+const chatSummaryInstruction = "You summarise conversations. Write a concise summary of the conversation below that keeps every fact, decision and open question needed to continue it. Reply with the summary only."
+
+// ChatSessionRequest continues a chat session with a new user message
+type ChatSessionRequest struct {
+	RequestID    string       `json:"requestId,omitempty"`
+	SessionID    string       `json:"sessionId"`
+	Message      string       `json:"message"`
+	PromptType   string       `json:"promptType,omitempty"` // overrides the session's prompt type when set
+	LlamaCliArgs LlamaCliArgs `json:"llamaCliArgs"`
+}
+
+// ChatSessionResponse is the result of continuing a chat session
+type ChatSessionResponse struct {
+	RequestID         string `json:"requestId,omitempty"`
+	SessionID         string `json:"sessionId"`
+	Success           bool   `json:"success"`
+	Result            string `json:"result,omitempty"`
+	Reasoning         string `json:"reasoning,omitempty"`
+	Error             string `json:"error,omitempty"`
+	ProcessingTime    int64  `json:"processingTime"`
+	TruncatedMessages int    `json:"truncatedMessages"` // older messages left out of the prompt
+	Summarized        bool   `json:"summarized"`        // whether a summary of older turns was included
+
+	StrippedTokens []StrippedControlToken `json:"strippedTokens,omitempty"` // control tokens removed from the message
+}
+
+// CreateChatSession starts a new, empty chat session
+func (app *App) CreateChatSession(title string, promptType string) (ChatSession, error) {
+	session := ChatSession{Title: title, PromptType: promptType}
+	sessionID, err := CreateChatSession(app.appArgs, session)
+	if err != nil {
+		app.log.Error("Failed to create chat session: " + err.Error())
+		return ChatSession{}, err
+	}
+	return GetChatSession(app.appArgs, sessionID)
+}
+
+// ListChatSessions returns all chat sessions without their messages
+func (app *App) ListChatSessions() ([]ChatSession, error) {
+	sessions, err := GetChatSessions(app.appArgs)
+	if err != nil {
+		app.log.Error("Failed to list chat sessions: " + err.Error())
+	}
+	return sessions, err
+}
+
+// GetChatSession returns a chat session with its full message history
+func (app *App) GetChatSession(sessionID string) (ChatSession, error) {
+	session, err := GetChatSession(app.appArgs, sessionID)
+	if err != nil {
+		app.log.Error("Failed to get chat session: " + err.Error())
+	}
+	return session, err
+}
+
+// RenameChatSession changes the title of a chat session
+func (app *App) RenameChatSession(sessionID string, title string) error {
+	if err := RenameChatSession(app.appArgs, sessionID, title); err != nil {
+		app.log.Error("Failed to rename chat session: " + err.Error())
+		return err
+	}
+	return nil
+}
+
+// DeleteChatSession removes a chat session
+func (app *App) DeleteChatSession(sessionID string) error {
+	if err := DeleteChatSession(app.appArgs, sessionID); err != nil {
+		app.log.Error("Failed to delete chat session: " + err.Error())
+		return err
+	}
+	return nil
+}
+
+// ContinueChatSession sends a new user message in the context of the session's history and stores
+// both the message and the reply. Tokens are streamed as inference-completion-token events.
+func (app *App) ContinueChatSession(request ChatSessionRequest) ChatSessionResponse {
+	processingStartTime := time.Now()
+	response := ChatSessionResponse{RequestID: request.RequestID, SessionID: request.SessionID}
+
+	job, err := app.startJob(request.RequestID, JobKindInference)
+	if err != nil {
+		response.Error = err.Error()
+		return response
+	}
+	response.RequestID = job.ID()
+
+	result, err := app.continueChatSession(job.Context(), job.ID(), request, &response)
+	app.jobs.Finish(job, err)
+
+	response.ProcessingTime = time.Since(processingStartTime).Milliseconds()
+	if err != nil {
+		if job.Canceled() {
+			response.Error = "Operation cancelled by user"
+		} else {
+			response.Error = err.Error()
+		}
+		app.log.Error(fmt.Sprintf("Chat session %s failed: %s", request.SessionID, response.Error))
+		return response
+	}
+
+	response.Success = true
+	response.Result = result
+	return response
+}
+
+func (app *App) continueChatSession(ctx context.Context, requestID string, request ChatSessionRequest, response *ChatSessionResponse) (string, error) {
+	if strings.TrimSpace(request.Message) == "" {
+		return "", fmt.Errorf("message is required")
+	}
+
+	session, err := GetChatSession(app.appArgs, request.SessionID)
+	if err != nil {
+		return "", err
+	}
+
+	promptType := request.PromptType
+	if promptType == "" {
+		promptType = session.PromptType
+	}
+
+	cliArgs := request.LlamaCliArgs
+	if cliArgs.ModelFullPathVal == "" && app.llamaCliArgs != nil {
+		cliArgs = *app.llamaCliArgs
+	}
+
+	message, strippedTokens := NewTemplateProcessor(app.log).StripControlTokens(promptType, PromptSourceInput, request.Message)
+	response.StrippedTokens = strippedTokens
+	if strings.TrimSpace(message) == "" {
+		return "", fmt.Errorf("message contains nothing but control tokens")
+	}
+
+	userMessage := ChatSessionMessage{Role: "user", Content: message, CreatedAt: time.Now()}
+	history := append(append([]ChatSessionMessage{}, session.Messages...), userMessage)
+
+	messages, truncated, summarized := app.fitChatHistory(ctx, &session, history, promptType, cliArgs)
+	response.TruncatedMessages = truncated
+	response.Summarized = summarized
+
+	prompt := RenderChatPrompt(app.log, promptType, cliArgs.ModelFullPathVal, messages)
+	_ = SaveAsText(app.appArgs.PromptTempPath, generateUniqueFileName("chat"), prompt, app.log)
+
+	cliArgs.PromptText = prompt
+	tokenIndex := 0
+	output, err := app.generateCompletion(ctx, CompletionRequest{
+		RequestID:  requestID,
+		CliArgs:    cliArgs,
+		PromptType: promptType,
+		Prompt:     prompt,
+		Messages:   messages,
+	}, func(token string) {
+		app.emitTokenSafely(requestID, token, tokenIndex)
+		tokenIndex++
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// Only the final answer goes into the history; earlier reasoning would crowd out the conversation
+	parsed := ParseModelOutput(promptType, prompt, output.Text)
+	response.Reasoning = parsed.Reasoning
+	answer := parsed.Answer
+	assistantMessage := ChatSessionMessage{Role: "assistant", Content: answer, CreatedAt: time.Now()}
+	if err := AppendChatSessionMessages(app.appArgs, session.ID.Hex(), userMessage, assistantMessage); err != nil {
+		app.log.Error("Failed to save chat session messages: " + err.Error())
+	}
+	if session.Title == "" {
+		if err := RenameChatSession(app.appArgs, session.ID.Hex(), chatTitleFromMessage(request.Message)); err != nil {
+			app.log.Error("Failed to title chat session: " + err.Error())
+		}
+	}
+
+	return answer, nil
+}
+
+// fitChatHistory selects the history that fits the profile's context window and saves the session
+// summary when older turns were folded into it. It returns the messages to send, how many history
+// messages were left out and whether a summary was included.
+func (app *App) fitChatHistory(ctx context.Context, session *ChatSession, history []ChatSessionMessage, promptType string, cliArgs LlamaCliArgs) ([]ChatMessage, int, bool) {
+	summarizedCount := session.SummarizedCount
+	messages, truncated, summarized, err := fitChatHistory(session, history, SystemInstruction(promptType), promptTokenBudget(cliArgs), estimateTokenCount,
+		func(previousSummary string, turns []ChatSessionMessage, budget int) (string, error) {
+			return app.summarizeChatTurns(ctx, promptType, cliArgs, previousSummary, turns, budget)
+		})
+	if err != nil {
+		app.log.Error("Failed to summarise chat history, dropping older turns: " + err.Error())
+	}
+	if session.SummarizedCount != summarizedCount {
+		if err := UpdateChatSessionSummary(app.appArgs, session.ID.Hex(), session.Summary, session.SummarizedCount); err != nil {
+			app.log.Error("Failed to save chat session summary: " + err.Error())
+		}
+	}
+	return messages, truncated, summarized
+}
+
+// fitChatHistory selects the newest turns that fit promptBudget tokens, as measured by countTokens.
+// Turns that no longer fit are folded into the session summary with summarize, which updates
+// session.Summary and SummarizedCount; if summarising fails they are simply dropped and the error is
+// returned. The latest turn is always sent.
+func fitChatHistory(session *ChatSession, history []ChatSessionMessage, systemInstruction string, promptBudget int, countTokens func(string) int,
+	summarize func(previousSummary string, turns []ChatSessionMessage, budget int) (string, error)) ([]ChatMessage, int, bool, error) {
+
+	budget := promptBudget - countTokens(systemInstruction) - chatTurnOverheadTokens
+	start := len(history)
+	used := 0
+	for start > 0 {
+		cost := countTokens(history[start-1].Content) + chatTurnOverheadTokens
+		if used+cost > budget && start < len(history) {
+			break
+		}
+		used += cost
+		start--
+	}
+
+	// Make room for a summary of what was left out
+	if start > 0 {
+		for start < len(history)-1 && used > budget-chatSummaryTokens {
+			used -= countTokens(history[start].Content) + chatTurnOverheadTokens
+			start++
+		}
+	}
+
+	var summarizeErr error
+	summary := ""
+	if start > 0 {
+		summary = session.Summary
+		if start > session.SummarizedCount {
+			newSummary, err := summarize(session.Summary, history[session.SummarizedCount:start], budget)
+			if err != nil {
+				summarizeErr = err
+			} else {
+				summary = newSummary
+				session.Summary = newSummary
+				session.SummarizedCount = start
+			}
+		}
+	}
+
+	var messages []ChatMessage
+	systemText := systemInstruction
+	if summary != "" {
+		systemText = strings.TrimSpace(systemText + "\nSummary of the earlier conversation:\n" + summary)
+	}
+	if systemText != "" {
+		messages = append(messages, ChatMessage{Role: "system", Content: systemText})
+	}
+	for _, message := range history[start:] {
+		messages = append(messages, ChatMessage{Role: message.Role, Content: message.Content})
+	}
+
+	return messages, start, summary != "", summarizeErr
+}
+
+// summarizeChatTurns asks the model for a summary of turns, extending previousSummary
+func (app *App) summarizeChatTurns(ctx context.Context, promptType string, cliArgs LlamaCliArgs, previousSummary string, turns []ChatSessionMessage, budget int) (string, error) {
+	messages := []ChatMessage{
+		{Role: "system", Content: chatSummaryInstruction},
+		{Role: "user", Content: chatSummaryTranscript(previousSummary, turns, budget)},
+	}
+	prompt := RenderChatPrompt(app.log, promptType, cliArgs.ModelFullPathVal, messages)
+
+	summaryArgs := cliArgs
+	summaryArgs.PredictVal = strconv.Itoa(chatSummaryTokens)
+	summaryArgs.PromptText = prompt
+	output, err := app.generateCompletion(ctx, CompletionRequest{
+		CliArgs:    summaryArgs,
+		PromptType: promptType,
+		Prompt:     prompt,
+		Messages:   messages,
+	}, nil)
+	if err != nil {
+		return "", err
+	}
+
+	summary := ParseModelOutput(promptType, prompt, output.Text).Answer
+	if summary == "" {
+		return "", fmt.Errorf("model returned an empty summary")
+	}
+	return summary, nil
+}
+
+// chatSummaryTranscript writes the previous summary and turns out as the text to summarise. When
+// that is too long to summarise in one pass within budget, its most recent part is kept.
+func chatSummaryTranscript(previousSummary string, turns []ChatSessionMessage, budget int) string {
+	var transcript strings.Builder
+	if previousSummary != "" {
+		transcript.WriteString("Earlier summary:\n" + previousSummary + "\n\n")
+	}
+	for _, turn := range turns {
+		speaker := "User"
+		if turn.Role == "assistant" {
+			speaker = "Assistant"
+		}
+		transcript.WriteString(speaker + ": " + turn.Content + "\n")
+	}
+
+	text := []rune(transcript.String())
+	maxChars := (budget - chatSummaryTokens) * 4
+	if maxChars > 0 && len(text) > maxChars {
+		text = text[len(text)-maxChars:]
+	}
+	return string(text)
+}
+
+// chatTitleFromMessage derives a session title from its first message
+func chatTitleFromMessage(message string) string {
+	title := strings.Join(strings.Fields(message), " ")
+	if utf8.RuneCountInString(title) > chatTitleLength {
+		title = string([]rune(title)[:chatTitleLength]) + "..."
+	}
+	return title
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testChatHistory returns count alternating user and assistant turns of size characters each
+func testChatHistory(count, size int) []ChatSessionMessage {
+	history := make([]ChatSessionMessage, count)
+	for i := range history {
+		role := "user"
+		if i%2 == 1 {
+			role = "assistant"
+		}
+		content := fmt.Sprintf("turn %d ", i)
+		history[i] = ChatSessionMessage{Role: role, Content: content + strings.Repeat("x", size-len(content))}
+	}
+	return history
+}
+
+// countTestTokens counts one token per byte so budgets in the tests are easy to follow
+func countTestTokens(text string) int {
+	return len(text)
+}
+
+func TestFitChatHistory(t *testing.T) {
+	const systemInstruction = "Be brief."
+	summarizeErr := errors.New("backend unavailable")
+
+	tests := []struct {
+		name           string
+		history        []ChatSessionMessage
+		session        ChatSession
+		promptBudget   int
+		summarizeErr   error
+		wantStart      int
+		wantSummarized []int // indexes of the turns passed to summarize, nil when it must not be called
+		wantSummary    string
+	}{
+		{
+			name:         "everything fits",
+			history:      testChatHistory(6, 100),
+			promptBudget: 1000,
+			wantStart:    0,
+		},
+		{
+			// 589 tokens for turns of 108: five fit, then two more make room for the summary
+			name:           "oldest turns are summarised",
+			history:        testChatHistory(6, 100),
+			promptBudget:   600,
+			wantStart:      3,
+			wantSummarized: []int{0, 1, 2},
+			wantSummary:    "summary of 3 turns",
+		},
+		{
+			name:           "summary is extended with the newly left out turns",
+			history:        testChatHistory(6, 100),
+			session:        ChatSession{Summary: "earlier", SummarizedCount: 1},
+			promptBudget:   600,
+			wantStart:      3,
+			wantSummarized: []int{1, 2},
+			wantSummary:    "summary of 2 turns",
+		},
+		{
+			name:         "existing summary is reused",
+			history:      testChatHistory(6, 100),
+			session:      ChatSession{Summary: "earlier", SummarizedCount: 3},
+			promptBudget: 600,
+			wantStart:    3,
+			wantSummary:  "earlier",
+		},
+		{
+			name:           "failed summary drops the oldest turns",
+			history:        testChatHistory(6, 100),
+			promptBudget:   600,
+			summarizeErr:   summarizeErr,
+			wantStart:      3,
+			wantSummarized: []int{0, 1, 2},
+		},
+		{
+			name:           "latest turn is kept even when it is over budget",
+			history:        append(testChatHistory(3, 100), ChatSessionMessage{Role: "user", Content: strings.Repeat("y", 2000)}),
+			promptBudget:   600,
+			wantStart:      3,
+			wantSummarized: []int{0, 1, 2},
+			wantSummary:    "summary of 3 turns",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			session := test.session
+			var summarized []int
+			summarize := func(previousSummary string, turns []ChatSessionMessage, budget int) (string, error) {
+				if previousSummary != test.session.Summary {
+					t.Errorf("summarize got previous summary %q, want %q", previousSummary, test.session.Summary)
+				}
+				for _, turn := range turns {
+					for i := range test.history {
+						if test.history[i].Content == turn.Content {
+							summarized = append(summarized, i)
+						}
+					}
+				}
+				if test.summarizeErr != nil {
+					return "", test.summarizeErr
+				}
+				return fmt.Sprintf("summary of %d turns", len(turns)), nil
+			}
+
+			messages, truncated, hasSummary, err := fitChatHistory(&session, test.history, systemInstruction, test.promptBudget, countTestTokens, summarize)
+			if !errors.Is(err, test.summarizeErr) {
+				t.Fatalf("got error %v, want %v", err, test.summarizeErr)
+			}
+			if truncated != test.wantStart {
+				t.Fatalf("left out %d messages, want %d", truncated, test.wantStart)
+			}
+			if !reflect.DeepEqual(summarized, test.wantSummarized) {
+				t.Fatalf("summarised turns %v, want %v", summarized, test.wantSummarized)
+			}
+
+			wantSystem := systemInstruction
+			if test.wantSummary != "" {
+				wantSystem += "\nSummary of the earlier conversation:\n" + test.wantSummary
+			}
+			if hasSummary != (test.wantSummary != "") {
+				t.Fatalf("got summarized %v, want %v", hasSummary, test.wantSummary != "")
+			}
+			want := []ChatMessage{{Role: "system", Content: wantSystem}}
+			for _, message := range test.history[test.wantStart:] {
+				want = append(want, ChatMessage{Role: message.Role, Content: message.Content})
+			}
+			if !reflect.DeepEqual(messages, want) {
+				t.Fatalf("got messages %+v, want %+v", messages, want)
+			}
+
+			wantSession := test.session
+			if test.wantSummarized != nil && test.summarizeErr == nil {
+				wantSession.Summary, wantSession.SummarizedCount = test.wantSummary, test.wantStart
+			}
+			if session.Summary != wantSession.Summary || session.SummarizedCount != wantSession.SummarizedCount {
+				t.Fatalf("got session summary %q of %d turns, want %q of %d", session.Summary, session.SummarizedCount, wantSession.Summary, wantSession.SummarizedCount)
+			}
+		})
+	}
+}
+
+func TestChatSummaryTranscript(t *testing.T) {
+	turns := []ChatSessionMessage{
+		{Role: "user", Content: "What is the capital of France?"},
+		{Role: "assistant", Content: "Paris."},
+	}
+	got := chatSummaryTranscript("The user is planning a trip.", turns, 4096)
+	want := "Earlier summary:\nThe user is planning a trip.\n\nUser: What is the capital of France?\nAssistant: Paris.\n"
+	if got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	// Ten tokens over the summary reserve leave room for the last 40 characters
+	got = chatSummaryTranscript("The user is planning a trip.", turns, chatSummaryTokens+10)
+	if want := want[len(want)-40:]; got != want {
+		t.Fatalf("got %q, want the most recent %q", got, want)
+	}
+}
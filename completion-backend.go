@@ -0,0 +1,423 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const (
+	BackendLlamaCli         = "llama-cli"
+	BackendLlamaServer      = "llama-server"
+	BackendOpenAICompatible = "openai"
+)
+
+// ChatMessage is a single role-tagged message for chat-style backends
+type ChatMessage struct {
+	Role    string `json:"role" bson:"role"` // system, user or assistant
+	Content string `json:"content" bson:"content"`
+}
+
+// BackendCapabilities describes what a completion backend can do so callers can adapt requests
+type BackendCapabilities struct {
+	Streaming       bool `json:"streaming"`
+	RawPrompt       bool `json:"rawPrompt"`       // accepts a fully templated prompt string
+	ChatMessages    bool `json:"chatMessages"`    // accepts role-tagged messages and applies its own template
+	PersistentModel bool `json:"persistentModel"` // keeps the model loaded between requests
+	Grammar         bool `json:"grammar"`         // supports GBNF grammar constraints
+	JSONSchema      bool `json:"jsonSchema"`      // can constrain generation with a JSON schema
+}
+
+// CompletionRequest carries both the rendered prompt and its role-tagged form so every backend
+// can use whichever representation it supports
+type CompletionRequest struct {
+	RequestID  string
+	CliArgs    LlamaCliArgs
+	PromptType string        // selects the output parser for the reply
+	Prompt     string        // fully templated prompt for raw-prompt backends
+	Messages   []ChatMessage // system/user messages for chat backends
+}
+
+// CompletionResult holds the generated text of a completed request
+type CompletionResult struct {
+	Text  string
+	Stats *RunStats // nil when the backend reports no performance figures
+}
+
+// CompletionBackend is implemented by every inference engine the app can talk to
+type CompletionBackend interface {
+	Name() string
+	Capabilities() BackendCapabilities
+	Complete(ctx context.Context, request CompletionRequest) (CompletionResult, error)
+	Stream(ctx context.Context, request CompletionRequest, onToken TokenCallback) (CompletionResult, error)
+	Cancel(requestID string) bool
+}
+
+// inflightRequests tracks a cancel function per running request so backends can cancel by RequestID
+type inflightRequests struct {
+	mu      sync.Mutex
+	cancels map[string]context.CancelFunc
+}
+
+// begin derives a cancelable context for requestID; the returned func must be called when the request ends
+func (r *inflightRequests) begin(ctx context.Context, requestID string) (context.Context, func()) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	requestCtx, cancel := context.WithCancel(ctx)
+	if requestID == "" {
+		return requestCtx, cancel
+	}
+
+	r.mu.Lock()
+	if r.cancels == nil {
+		r.cancels = make(map[string]context.CancelFunc)
+	}
+	r.cancels[requestID] = cancel
+	r.mu.Unlock()
+
+	return requestCtx, func() {
+		r.mu.Lock()
+		delete(r.cancels, requestID)
+		r.mu.Unlock()
+		cancel()
+	}
+}
+
+// cancel stops the request with the given ID and reports whether it was running
+func (r *inflightRequests) cancel(requestID string) bool {
+	r.mu.Lock()
+	cancel, exists := r.cancels[requestID]
+	r.mu.Unlock()
+	if exists {
+		cancel()
+	}
+	return exists
+}
+
+// LlamaCliBackend spawns llama-cli for every request
+type LlamaCliBackend struct {
+	appArgs     *DefaultAppArgs
+	promptCache *PromptCacheManager
+	inflight    inflightRequests
+}
+
+func NewLlamaCliBackend(appArgs *DefaultAppArgs, promptCache *PromptCacheManager) *LlamaCliBackend {
+	return &LlamaCliBackend{appArgs: appArgs, promptCache: promptCache}
+}
+
+func (b *LlamaCliBackend) Name() string { return BackendLlamaCli }
+
+func (b *LlamaCliBackend) Capabilities() BackendCapabilities {
+	return BackendCapabilities{Streaming: true, RawPrompt: true, Grammar: true, JSONSchema: true}
+}
+
+func (b *LlamaCliBackend) Complete(ctx context.Context, request CompletionRequest) (CompletionResult, error) {
+	return b.Stream(ctx, request, nil)
+}
+
+func (b *LlamaCliBackend) Stream(ctx context.Context, request CompletionRequest, onToken TokenCallback) (CompletionResult, error) {
+	requestCtx, done := b.inflight.begin(ctx, request.RequestID)
+	defer done()
+
+	cliArgs, releaseCache := b.promptCache.Apply(request.CliArgs, request.Prompt, request.Messages)
+	defer releaseCache()
+
+	cliArgs.PromptText = request.Prompt
+	output, stats, err := GenerateStreamingCompletionWithCancel(requestCtx, *b.appArgs, LlamaCliStructToArgs(cliArgs), onToken)
+	return CompletionResult{Text: string(output), Stats: stats}, err
+}
+
+func (b *LlamaCliBackend) Cancel(requestID string) bool { return b.inflight.cancel(requestID) }
+
+// LlamaServerBackend sends requests to the managed llama-server subprocess
+type LlamaServerBackend struct {
+	appArgs  *DefaultAppArgs
+	manager  *LlamaServerManager
+	inflight inflightRequests
+}
+
+func NewLlamaServerBackend(appArgs *DefaultAppArgs, manager *LlamaServerManager) *LlamaServerBackend {
+	return &LlamaServerBackend{appArgs: appArgs, manager: manager}
+}
+
+func (b *LlamaServerBackend) Name() string { return BackendLlamaServer }
+
+func (b *LlamaServerBackend) Capabilities() BackendCapabilities {
+	return BackendCapabilities{Streaming: true, RawPrompt: true, PersistentModel: true, Grammar: true, JSONSchema: true}
+}
+
+func (b *LlamaServerBackend) Complete(ctx context.Context, request CompletionRequest) (CompletionResult, error) {
+	return b.Stream(ctx, request, nil)
+}
+
+func (b *LlamaServerBackend) Stream(ctx context.Context, request CompletionRequest, onToken TokenCallback) (CompletionResult, error) {
+	requestCtx, done := b.inflight.begin(ctx, request.RequestID)
+	defer done()
+
+	output, stats, err := b.manager.Complete(requestCtx, *b.appArgs, request.CliArgs, request.Prompt, onToken)
+	return CompletionResult{Text: string(output), Stats: stats}, err
+}
+
+func (b *LlamaServerBackend) Cancel(requestID string) bool { return b.inflight.cancel(requestID) }
+
+// OpenAICompatibleBackend talks to any server exposing /v1/chat/completions, such as vLLM or Ollama
+type OpenAICompatibleBackend struct {
+	baseURL    string
+	model      string
+	apiKey     string
+	httpClient *http.Client
+	inflight   inflightRequests
+}
+
+func NewOpenAICompatibleBackend(baseURL, model, apiKey string) *OpenAICompatibleBackend {
+	return &OpenAICompatibleBackend{
+		baseURL:    strings.TrimSuffix(strings.TrimSuffix(baseURL, "/"), "/v1"),
+		model:      model,
+		apiKey:     apiKey,
+		httpClient: &http.Client{},
+	}
+}
+
+// openAIChatRequest is the body of a /v1/chat/completions request
+type openAIChatRequest struct {
+	Model            string                `json:"model,omitempty"`
+	Messages         []ChatMessage         `json:"messages"`
+	Stream           bool                  `json:"stream"`
+	MaxTokens        *int                  `json:"max_tokens,omitempty"`
+	Temperature      *float64              `json:"temperature,omitempty"`
+	TopP             *float64              `json:"top_p,omitempty"`
+	Seed             *int                  `json:"seed,omitempty"`
+	PresencePenalty  *float64              `json:"presence_penalty,omitempty"`
+	FrequencyPenalty *float64              `json:"frequency_penalty,omitempty"`
+	Stop             []string              `json:"stop,omitempty"`
+	ResponseFormat   *openAIResponseFormat `json:"response_format,omitempty"`
+}
+
+// openAIResponseFormat asks the server to constrain its reply to a JSON schema
+type openAIResponseFormat struct {
+	Type       string `json:"type"`
+	JSONSchema struct {
+		Name   string          `json:"name"`
+		Schema json.RawMessage `json:"schema"`
+		Strict bool            `json:"strict"`
+	} `json:"json_schema"`
+}
+
+// openAIChatChunk covers both streamed deltas and non-streamed messages
+type openAIChatChunk struct {
+	Choices []struct {
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
+		Message struct {
+			Content string `json:"content"`
+		} `json:"message"`
+		FinishReason *string `json:"finish_reason"`
+	} `json:"choices"`
+}
+
+func (b *OpenAICompatibleBackend) Name() string { return BackendOpenAICompatible }
+
+func (b *OpenAICompatibleBackend) Capabilities() BackendCapabilities {
+	return BackendCapabilities{Streaming: true, ChatMessages: true, PersistentModel: true, JSONSchema: true}
+}
+
+func (b *OpenAICompatibleBackend) Complete(ctx context.Context, request CompletionRequest) (CompletionResult, error) {
+	return b.Stream(ctx, request, nil)
+}
+
+func (b *OpenAICompatibleBackend) Stream(ctx context.Context, request CompletionRequest, onToken TokenCallback) (CompletionResult, error) {
+	requestCtx, done := b.inflight.begin(ctx, request.RequestID)
+	defer done()
+
+	messages := request.Messages
+	if len(messages) == 0 {
+		messages = []ChatMessage{{Role: "user", Content: request.Prompt}}
+	}
+
+	chatRequest := openAIChatRequest{
+		Model:            b.model,
+		Messages:         messages,
+		Stream:           true,
+		MaxTokens:        parseOptionalInt(request.CliArgs.PredictVal),
+		Temperature:      parseOptionalFloat(request.CliArgs.TemperatureVal),
+		TopP:             parseOptionalFloat(request.CliArgs.TopPVal),
+		Seed:             parseOptionalInt(request.CliArgs.RandomSeedVal),
+		PresencePenalty:  parseOptionalFloat(request.CliArgs.PresencePenaltyVal),
+		FrequencyPenalty: parseOptionalFloat(request.CliArgs.FrequencyPenaltyVal),
+	}
+	// llama.cpp uses -1 for "unlimited"; OpenAI servers reject non-positive limits
+	if chatRequest.MaxTokens != nil && *chatRequest.MaxTokens <= 0 {
+		chatRequest.MaxTokens = nil
+	}
+	if request.CliArgs.ReversePromptVal != "" {
+		chatRequest.Stop = []string{request.CliArgs.ReversePromptVal}
+	}
+	if request.CliArgs.JsonSchemaVal != "" && json.Valid([]byte(request.CliArgs.JsonSchemaVal)) {
+		chatRequest.ResponseFormat = &openAIResponseFormat{Type: "json_schema"}
+		chatRequest.ResponseFormat.JSONSchema.Name = "response"
+		chatRequest.ResponseFormat.JSONSchema.Schema = json.RawMessage(request.CliArgs.JsonSchemaVal)
+		chatRequest.ResponseFormat.JSONSchema.Strict = true
+	}
+
+	body, err := json.Marshal(chatRequest)
+	if err != nil {
+		return CompletionResult{}, fmt.Errorf("failed to encode chat completion request: %w", err)
+	}
+
+	httpRequest, err := http.NewRequestWithContext(requestCtx, http.MethodPost, b.baseURL+"/v1/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return CompletionResult{}, fmt.Errorf("failed to create chat completion request: %w", err)
+	}
+	httpRequest.Header.Set("Content-Type", "application/json")
+	httpRequest.Header.Set("Accept", "text/event-stream")
+	if b.apiKey != "" {
+		httpRequest.Header.Set("Authorization", "Bearer "+b.apiKey)
+	}
+
+	httpResponse, err := b.httpClient.Do(httpRequest)
+	if err != nil {
+		if requestCtx.Err() != nil {
+			return CompletionResult{}, requestCtx.Err()
+		}
+		return CompletionResult{}, fmt.Errorf("chat completion request failed: %w", err)
+	}
+	defer func(responseBody io.ReadCloser) {
+		_ = responseBody.Close()
+	}(httpResponse.Body)
+
+	if httpResponse.StatusCode != http.StatusOK {
+		errorBody, _ := io.ReadAll(io.LimitReader(httpResponse.Body, 4096))
+		return CompletionResult{}, fmt.Errorf("chat completion endpoint returned %s: %s", httpResponse.Status, strings.TrimSpace(string(errorBody)))
+	}
+
+	output, err := readOpenAIChatStream(httpResponse.Body, onToken)
+	if requestCtx.Err() != nil {
+		return CompletionResult{}, requestCtx.Err()
+	}
+	return CompletionResult{Text: output}, err
+}
+
+func (b *OpenAICompatibleBackend) Cancel(requestID string) bool { return b.inflight.cancel(requestID) }
+
+// readOpenAIChatStream decodes a streamed chat completion; servers that ignore stream=true and
+// answer with a single JSON document are handled as well
+func readOpenAIChatStream(body io.Reader, onToken TokenCallback) (string, error) {
+	var output strings.Builder
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		payload := line
+		if strings.HasPrefix(line, "data:") {
+			payload = strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		}
+		if payload == "[DONE]" {
+			break
+		}
+
+		var chunk openAIChatChunk
+		if err := json.Unmarshal([]byte(payload), &chunk); err != nil {
+			continue // keep-alive comments and partial non-stream bodies
+		}
+		for _, choice := range chunk.Choices {
+			content := choice.Delta.Content
+			if content == "" {
+				content = choice.Message.Content
+			}
+			if content == "" {
+				continue
+			}
+			output.WriteString(content)
+			if onToken != nil {
+				onToken(content)
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return output.String(), fmt.Errorf("failed to read chat completion stream: %w", err)
+	}
+	return output.String(), nil
+}
+
+// CompletionBackendRegistry resolves the backend selected by a settings profile
+type CompletionBackendRegistry struct {
+	appArgs     *DefaultAppArgs
+	llamaCli    *LlamaCliBackend
+	llamaServer *LlamaServerBackend
+
+	mu     sync.Mutex
+	openAI map[string]*OpenAICompatibleBackend
+}
+
+func NewCompletionBackendRegistry(appArgs *DefaultAppArgs, serverManager *LlamaServerManager, promptCache *PromptCacheManager) *CompletionBackendRegistry {
+	return &CompletionBackendRegistry{
+		appArgs:     appArgs,
+		llamaCli:    NewLlamaCliBackend(appArgs, promptCache),
+		llamaServer: NewLlamaServerBackend(appArgs, serverManager),
+		openAI:      make(map[string]*OpenAICompatibleBackend),
+	}
+}
+
+// Resolve returns the backend named in the profile's BackendVal. Profiles without a backend use
+// llama-server when it is configured and llama-cli otherwise.
+func (r *CompletionBackendRegistry) Resolve(cliArgs LlamaCliArgs) (CompletionBackend, error) {
+	backendName := strings.ToLower(strings.TrimSpace(cliArgs.BackendVal))
+	if backendName == "" {
+		if r.appArgs.LLamaServerPath != "" {
+			backendName = BackendLlamaServer
+		} else {
+			backendName = BackendLlamaCli
+		}
+	}
+
+	switch backendName {
+	case BackendLlamaCli:
+		return r.llamaCli, nil
+	case BackendLlamaServer:
+		if r.appArgs.LLamaServerPath == "" {
+			return nil, fmt.Errorf("profile selects llama-server but LLamaServerPath is not configured")
+		}
+		return r.llamaServer, nil
+	case BackendOpenAICompatible:
+		if cliArgs.BackendUrlVal == "" {
+			return nil, fmt.Errorf("profile selects an OpenAI-compatible backend but BackendUrlVal is empty")
+		}
+		key := cliArgs.BackendUrlVal + "\x00" + cliArgs.BackendModelVal
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		backend, exists := r.openAI[key]
+		if !exists {
+			backend = NewOpenAICompatibleBackend(cliArgs.BackendUrlVal, cliArgs.BackendModelVal, r.appArgs.BackendAPIKey)
+			r.openAI[key] = backend
+		}
+		return backend, nil
+	default:
+		return nil, fmt.Errorf("unknown completion backend: %s", cliArgs.BackendVal)
+	}
+}
+
+// Cancel cancels the request on whichever backend is running it
+func (r *CompletionBackendRegistry) Cancel(requestID string) bool {
+	canceled := r.llamaCli.Cancel(requestID)
+	canceled = r.llamaServer.Cancel(requestID) || canceled
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, backend := range r.openAI {
+		canceled = backend.Cancel(requestID) || canceled
+	}
+	return canceled
+}
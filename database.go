@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
@@ -20,6 +21,9 @@ const (
 	EmbedSettingsCollection      = "embed-settings"
 	DocumentQuestionsCollection  = "document-questions"
 	InferenceQuestionsCollection = "inference-questions"
+	ChatSessionsCollection       = "chat-sessions"
+	PromptTemplatesCollection    = "prompt-templates"
+	BenchmarksCollection         = "benchmarks"
 
 	DefaultTimeout    = 5 * time.Second
 	LongTimeout       = 60 * time.Second
@@ -28,7 +32,10 @@ const (
 
 type QuestionResponse struct {
 	ID        bson.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
-	Response  string        `bson:"response" json:"response"`
+	Response  string        `bson:"response" json:"response"` // reply without the echoed prompt
+	Reasoning string        `bson:"reasoning,omitempty" json:"reasoning,omitempty"`
+	Answer    string        `bson:"answer" json:"answer"`
+	Stats     *RunStats     `bson:"stats,omitempty" json:"stats,omitempty"`
 	Args      string        `bson:"args" json:"args"`
 	Question  string        `bson:"question" json:"question"`
 	CreatedAt time.Time     `bson:"createdAt" json:"createdAt"`
@@ -36,18 +43,125 @@ type QuestionResponse struct {
 
 // DocumentQuestionResponse represents a document question and its response
 type DocumentQuestionResponse struct {
-	ID          bson.ObjectID  `bson:"_id,omitempty" json:"id,omitempty"`
-	DocumentID  string         `bson:"documentId" json:"documentId"`
-	IndexName   string         `bson:"indexName" json:"indexName"`
-	EmbedPrompt string         `bson:"embedPrompt" json:"embedPrompt"`
-	DocPrompt   string         `bson:"docPrompt" json:"docPrompt"`
-	Response    string         `bson:"response" json:"response"`
-	Keywords    []string       `bson:"keywords" json:"keywords"`
-	PromptType  string         `bson:"promptType" json:"promptType"`
-	EmbedArgs   LlamaEmbedArgs `bson:"embedState" json:"embedState"`
-	CliState    LlamaCliArgs   `bson:"cliState" json:"cliState"`
-	CreatedAt   time.Time      `bson:"createdAt" json:"createdAt"`
-	ProcessTime int64          `bson:"processTime" json:"processTime"` // in milliseconds
+	ID            bson.ObjectID  `bson:"_id,omitempty" json:"id,omitempty"`
+	DocumentID    string         `bson:"documentId" json:"documentId"`
+	IndexName     string         `bson:"indexName" json:"indexName"`
+	EmbedPrompt   string         `bson:"embedPrompt" json:"embedPrompt"`
+	DocPrompt     string         `bson:"docPrompt" json:"docPrompt"`
+	Response      string         `bson:"response" json:"response"` // reply without the echoed prompt
+	Reasoning     string         `bson:"reasoning,omitempty" json:"reasoning,omitempty"`
+	Answer        string         `bson:"answer" json:"answer"`
+	Keywords      []string       `bson:"keywords" json:"keywords"`
+	PromptType    string         `bson:"promptType" json:"promptType"`
+	EmbedArgs     LlamaEmbedArgs `bson:"embedState" json:"embedState"`
+	CliState      LlamaCliArgs   `bson:"cliState" json:"cliState"`
+	ChunksUsed    int            `bson:"chunksUsed" json:"chunksUsed"`
+	ChunksDropped int            `bson:"chunksDropped" json:"chunksDropped"`
+	Stats         *RunStats      `bson:"stats,omitempty" json:"stats,omitempty"`
+	CreatedAt     time.Time      `bson:"createdAt" json:"createdAt"`
+	ProcessTime   int64          `bson:"processTime" json:"processTime"` // in milliseconds
+}
+
+// ChatSessionMessage is a single role-tagged turn of a chat session
+type ChatSessionMessage struct {
+	Role      string    `bson:"role" json:"role"` // system, user or assistant
+	Content   string    `bson:"content" json:"content"`
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+}
+
+// ChatSession represents a persisted multi-turn conversation
+type ChatSession struct {
+	ID              bson.ObjectID        `bson:"_id,omitempty" json:"id,omitempty"`
+	Title           string               `bson:"title" json:"title"`
+	PromptType      string               `bson:"promptType" json:"promptType"`
+	Messages        []ChatSessionMessage `bson:"messages" json:"messages"`
+	Summary         string               `bson:"summary,omitempty" json:"summary,omitempty"`       // summary of turns that no longer fit the context
+	SummarizedCount int                  `bson:"summarizedCount" json:"summarizedCount,omitempty"` // number of leading messages covered by Summary
+	CreatedAt       time.Time            `bson:"createdAt" json:"createdAt"`
+	UpdatedAt       time.Time            `bson:"updatedAt" json:"updatedAt"`
+}
+
+// PromptTemplateData holds the values a stored prompt template is executed with. It has the fields of
+// both SystemPrompt and UserPrompt so either kind of built-in template can be stored.
+type PromptTemplateData struct {
+	SystemPrompt    string `bson:"systemPrompt" json:"systemPrompt"`
+	UserPrompt      string `bson:"userPrompt" json:"userPrompt"`
+	AssistantPrompt string `bson:"assistantPrompt" json:"assistantPrompt"`
+	UserStart       string `bson:"userStart" json:"userStart"`
+	UserEnd         string `bson:"userEnd" json:"userEnd"`
+	Input           string `bson:"-" json:"-"`
+}
+
+// SetInput implements the PromptData interface
+func (d *PromptTemplateData) SetInput(input string) {
+	d.Input = input
+}
+
+// PromptTemplateVersion is an earlier version of a prompt template
+type PromptTemplateVersion struct {
+	Version     int                `bson:"version" json:"version"`
+	Description string             `bson:"description" json:"description"`
+	Template    string             `bson:"template" json:"template"`
+	Data        PromptTemplateData `bson:"data" json:"data"`
+	Instruction string             `bson:"instruction" json:"instruction"`
+	UpdatedAt   time.Time          `bson:"updatedAt" json:"updatedAt"`
+}
+
+// PromptTemplate is a prompt type stored in MongoDB. Template is a Go text/template executed with
+// Data, the user's text being available as .Input.
+type PromptTemplate struct {
+	ID          bson.ObjectID           `bson:"_id,omitempty" json:"id,omitempty"`
+	Name        string                  `bson:"name" json:"name"` // the prompt type
+	Description string                  `bson:"description" json:"description"`
+	BaseType    string                  `bson:"baseType" json:"baseType"` // built-in prompt type providing the chat and output formats
+	Template    string                  `bson:"template" json:"template"`
+	Data        PromptTemplateData      `bson:"data" json:"data"`
+	Instruction string                  `bson:"instruction" json:"instruction"` // system message for chat backends and chat sessions
+	BuiltIn     bool                    `bson:"builtIn" json:"builtIn"`
+	Version     int                     `bson:"version" json:"version"`
+	History     []PromptTemplateVersion `bson:"history,omitempty" json:"history,omitempty"` // earlier versions, oldest first
+	CreatedAt   time.Time               `bson:"createdAt" json:"createdAt"`
+	UpdatedAt   time.Time               `bson:"updatedAt" json:"updatedAt"`
+}
+
+// BenchmarkDocumentQuery is the document query a benchmark answers instead of a plain prompt
+type BenchmarkDocumentQuery struct {
+	IndexID         string         `bson:"indexId" json:"indexId"`
+	DocumentID      string         `bson:"documentId" json:"documentId"`
+	EmbeddingPrompt string         `bson:"embeddingPrompt" json:"embeddingPrompt"`
+	DocumentPrompt  string         `bson:"documentPrompt" json:"documentPrompt"`
+	SearchKeywords  []string       `bson:"searchKeywords" json:"searchKeywords"`
+	LlamaEmbedArgs  LlamaEmbedArgs `bson:"llamaEmbedArgs" json:"llamaEmbedArgs"`
+}
+
+// BenchmarkRun is the result of one model and settings profile combination of a benchmark
+type BenchmarkRun struct {
+	ModelPath  string    `bson:"modelPath" json:"modelPath"`
+	ModelName  string    `bson:"modelName" json:"modelName"`
+	Profile    string    `bson:"profile" json:"profile"` // description of the saved cli-settings profile
+	Args       []string  `bson:"args" json:"args"`       // llama-cli arguments without the prompt
+	Answer     string    `bson:"answer" json:"answer"`
+	Reasoning  string    `bson:"reasoning,omitempty" json:"reasoning,omitempty"`
+	Stats      *RunStats `bson:"stats,omitempty" json:"stats,omitempty"`
+	ChunksUsed int       `bson:"chunksUsed,omitempty" json:"chunksUsed,omitempty"`
+	DurationMs int64     `bson:"durationMs" json:"durationMs"`
+	Error      string    `bson:"error,omitempty" json:"error,omitempty"`
+}
+
+// Benchmark runs one prompt, or one document query, across several models and settings profiles
+type Benchmark struct {
+	ID            bson.ObjectID           `bson:"_id,omitempty" json:"id,omitempty"`
+	Name          string                  `bson:"name" json:"name"`
+	PromptType    string                  `bson:"promptType" json:"promptType"`
+	PromptText    string                  `bson:"promptText,omitempty" json:"promptText,omitempty"`
+	DocumentQuery *BenchmarkDocumentQuery `bson:"documentQuery,omitempty" json:"documentQuery,omitempty"`
+	Seed          int                     `bson:"seed" json:"seed"`
+	Status        string                  `bson:"status" json:"status"` // a JobStatus value
+	Error         string                  `bson:"error,omitempty" json:"error,omitempty"`
+	TotalRuns     int                     `bson:"totalRuns" json:"totalRuns"`
+	Runs          []BenchmarkRun          `bson:"runs" json:"runs"`
+	CreatedAt     time.Time               `bson:"createdAt" json:"createdAt"`
+	CompletedAt   *time.Time              `bson:"completedAt,omitempty" json:"completedAt,omitempty"`
 }
 
 // SettingsDocument represents a saved settings document in MongoDB
@@ -290,7 +404,7 @@ func GetDocumentQuestionResponse(appArgs *DefaultAppArgs, documentID string) ([]
 }
 
 // SaveQuestionResponse inserts a new record into the collection
-func SaveQuestionResponse(appArgs *DefaultAppArgs, response string, args string, question string) error {
+func SaveQuestionResponse(appArgs *DefaultAppArgs, response string, reasoning string, answer string, stats *RunStats, args string, question string) error {
 	if err := ensureDatabaseConnection(appArgs); err != nil {
 		return err
 	}
@@ -301,6 +415,9 @@ func SaveQuestionResponse(appArgs *DefaultAppArgs, response string, args string,
 	// Create a new document
 	questionResponseDoc := QuestionResponse{
 		Response:  response,
+		Reasoning: reasoning,
+		Answer:    answer,
+		Stats:     stats,
 		Args:      args,
 		Question:  question,
 		CreatedAt: time.Now(),
@@ -340,6 +457,455 @@ func GetInferenceHistory(appArgs *DefaultAppArgs) ([]QuestionResponse, error) {
 	return inferenceResults, nil
 }
 
+// CreateChatSession inserts a new chat session and returns its ID
+func CreateChatSession(appArgs *DefaultAppArgs, session ChatSession) (string, error) {
+	if err := ensureDatabaseConnection(appArgs); err != nil {
+		return "", fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	ctx, cancel := createContextWithTimeout(DefaultTimeout)
+	defer cancel()
+
+	now := time.Now()
+	session.CreatedAt = now
+	session.UpdatedAt = now
+	if session.Messages == nil {
+		session.Messages = []ChatSessionMessage{}
+	}
+
+	sessionCollection := mongoDatabase.Collection(ChatSessionsCollection)
+	insertResult, err := sessionCollection.InsertOne(ctx, session)
+	if err != nil {
+		return "", fmt.Errorf("failed to create chat session: %w", err)
+	}
+
+	return insertResult.InsertedID.(bson.ObjectID).Hex(), nil
+}
+
+// GetChatSessions retrieves all chat sessions without their messages, most recently used first
+func GetChatSessions(appArgs *DefaultAppArgs) ([]ChatSession, error) {
+	if err := ensureDatabaseConnection(appArgs); err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	ctx, cancel := createContextWithTimeout(DefaultTimeout)
+	defer cancel()
+
+	sessionCollection := mongoDatabase.Collection(ChatSessionsCollection)
+	findOptions := options.Find().
+		SetSort(bson.M{"updatedAt": -1}).
+		SetProjection(bson.M{"messages": 0})
+	sessionCursor, err := sessionCollection.Find(ctx, bson.M{}, findOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve chat sessions: %w", err)
+	}
+	defer closeCursor(sessionCursor, ctx)
+
+	var sessions []ChatSession
+	if err := sessionCursor.All(ctx, &sessions); err != nil {
+		return nil, fmt.Errorf("failed to decode chat sessions: %w", err)
+	}
+
+	return sessions, nil
+}
+
+// GetChatSession retrieves a single chat session including its messages
+func GetChatSession(appArgs *DefaultAppArgs, sessionID string) (ChatSession, error) {
+	if err := ensureDatabaseConnection(appArgs); err != nil {
+		return ChatSession{}, fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	objectID, err := bson.ObjectIDFromHex(sessionID)
+	if err != nil {
+		return ChatSession{}, fmt.Errorf("invalid chat session ID: %w", err)
+	}
+
+	ctx, cancel := createContextWithTimeout(DefaultTimeout)
+	defer cancel()
+
+	var session ChatSession
+	sessionCollection := mongoDatabase.Collection(ChatSessionsCollection)
+	if err := sessionCollection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&session); err != nil {
+		return ChatSession{}, fmt.Errorf("failed to retrieve chat session: %w", err)
+	}
+
+	return session, nil
+}
+
+// RenameChatSession changes the title of a chat session
+func RenameChatSession(appArgs *DefaultAppArgs, sessionID string, title string) error {
+	return updateChatSession(appArgs, sessionID, bson.M{"$set": bson.M{"title": title, "updatedAt": time.Now()}})
+}
+
+// AppendChatSessionMessages adds messages to the end of a chat session
+func AppendChatSessionMessages(appArgs *DefaultAppArgs, sessionID string, messages ...ChatSessionMessage) error {
+	return updateChatSession(appArgs, sessionID, bson.M{
+		"$push": bson.M{"messages": bson.M{"$each": messages}},
+		"$set":  bson.M{"updatedAt": time.Now()},
+	})
+}
+
+// UpdateChatSessionSummary stores the summary of the first summarizedCount messages
+func UpdateChatSessionSummary(appArgs *DefaultAppArgs, sessionID string, summary string, summarizedCount int) error {
+	return updateChatSession(appArgs, sessionID, bson.M{"$set": bson.M{"summary": summary, "summarizedCount": summarizedCount}})
+}
+
+// DeleteChatSession deletes a chat session and its messages
+func DeleteChatSession(appArgs *DefaultAppArgs, sessionID string) error {
+	if err := ensureDatabaseConnection(appArgs); err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	objectID, err := bson.ObjectIDFromHex(sessionID)
+	if err != nil {
+		return fmt.Errorf("invalid chat session ID: %w", err)
+	}
+
+	ctx, cancel := createContextWithTimeout(DefaultTimeout)
+	defer cancel()
+
+	sessionCollection := mongoDatabase.Collection(ChatSessionsCollection)
+	if _, err := sessionCollection.DeleteOne(ctx, bson.M{"_id": objectID}); err != nil {
+		return fmt.Errorf("failed to delete chat session: %w", err)
+	}
+	return nil
+}
+
+// updateChatSession applies an update document to the chat session with the given ID
+func updateChatSession(appArgs *DefaultAppArgs, sessionID string, update bson.M) error {
+	if err := ensureDatabaseConnection(appArgs); err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	objectID, err := bson.ObjectIDFromHex(sessionID)
+	if err != nil {
+		return fmt.Errorf("invalid chat session ID: %w", err)
+	}
+
+	ctx, cancel := createContextWithTimeout(DefaultTimeout)
+	defer cancel()
+
+	sessionCollection := mongoDatabase.Collection(ChatSessionsCollection)
+	result, err := sessionCollection.UpdateOne(ctx, bson.M{"_id": objectID}, update)
+	if err != nil {
+		return fmt.Errorf("failed to update chat session: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("chat session %s not found", sessionID)
+	}
+	return nil
+}
+
+// SeedPromptTemplates creates the unique name index and inserts the templates that do not exist yet
+func SeedPromptTemplates(appArgs *DefaultAppArgs, templates []PromptTemplate) error {
+	if err := ensureDatabaseConnection(appArgs); err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	ctx, cancel := createContextWithTimeout(LongTimeout)
+	defer cancel()
+
+	templateCollection := mongoDatabase.Collection(PromptTemplatesCollection)
+	nameIndex := mongo.IndexModel{Keys: bson.D{{Key: "name", Value: 1}}, Options: options.Index().SetUnique(true)}
+	if _, err := templateCollection.Indexes().CreateOne(ctx, nameIndex); err != nil {
+		return fmt.Errorf("failed to create prompt template index: %w", err)
+	}
+
+	now := time.Now()
+	upsertOptions := options.UpdateOne().SetUpsert(true)
+	for _, template := range templates {
+		template.Version = 1
+		template.CreatedAt = now
+		template.UpdatedAt = now
+		update := bson.M{"$setOnInsert": template}
+		if _, err := templateCollection.UpdateOne(ctx, bson.M{"name": template.Name}, update, upsertOptions); err != nil {
+			return fmt.Errorf("failed to seed prompt template %s: %w", template.Name, err)
+		}
+	}
+	return nil
+}
+
+// GetPromptTemplates retrieves all prompt templates without their version history, sorted by name
+func GetPromptTemplates(appArgs *DefaultAppArgs) ([]PromptTemplate, error) {
+	if err := ensureDatabaseConnection(appArgs); err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	ctx, cancel := createContextWithTimeout(DefaultTimeout)
+	defer cancel()
+
+	templateCollection := mongoDatabase.Collection(PromptTemplatesCollection)
+	findOptions := options.Find().
+		SetSort(bson.M{"name": 1}).
+		SetProjection(bson.M{"history": 0})
+	templateCursor, err := templateCollection.Find(ctx, bson.M{}, findOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve prompt templates: %w", err)
+	}
+	defer closeCursor(templateCursor, ctx)
+
+	var templates []PromptTemplate
+	if err := templateCursor.All(ctx, &templates); err != nil {
+		return nil, fmt.Errorf("failed to decode prompt templates: %w", err)
+	}
+
+	return templates, nil
+}
+
+// GetPromptTemplate retrieves a prompt template including its version history
+func GetPromptTemplate(appArgs *DefaultAppArgs, name string) (PromptTemplate, error) {
+	if err := ensureDatabaseConnection(appArgs); err != nil {
+		return PromptTemplate{}, fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	ctx, cancel := createContextWithTimeout(DefaultTimeout)
+	defer cancel()
+
+	var template PromptTemplate
+	templateCollection := mongoDatabase.Collection(PromptTemplatesCollection)
+	if err := templateCollection.FindOne(ctx, bson.M{"name": name}).Decode(&template); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return PromptTemplate{}, fmt.Errorf("prompt template %s not found", name)
+		}
+		return PromptTemplate{}, fmt.Errorf("failed to retrieve prompt template: %w", err)
+	}
+
+	return template, nil
+}
+
+// CreatePromptTemplate inserts a new prompt template as version 1
+func CreatePromptTemplate(appArgs *DefaultAppArgs, template PromptTemplate) error {
+	if err := ensureDatabaseConnection(appArgs); err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	ctx, cancel := createContextWithTimeout(DefaultTimeout)
+	defer cancel()
+
+	now := time.Now()
+	template.ID = bson.NilObjectID
+	template.Version = 1
+	template.History = nil
+	template.CreatedAt = now
+	template.UpdatedAt = now
+
+	templateCollection := mongoDatabase.Collection(PromptTemplatesCollection)
+	if _, err := templateCollection.InsertOne(ctx, template); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return fmt.Errorf("a prompt template named %s already exists", template.Name)
+		}
+		return fmt.Errorf("failed to create prompt template: %w", err)
+	}
+	return nil
+}
+
+// UpdatePromptTemplate stores new content for the template with the same name, moving the current
+// content into its version history. It fails if the template changed since it was read.
+func UpdatePromptTemplate(appArgs *DefaultAppArgs, template PromptTemplate) error {
+	current, err := GetPromptTemplate(appArgs, template.Name)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := createContextWithTimeout(DefaultTimeout)
+	defer cancel()
+
+	previous := PromptTemplateVersion{
+		Version:     current.Version,
+		Description: current.Description,
+		Template:    current.Template,
+		Data:        current.Data,
+		Instruction: current.Instruction,
+		UpdatedAt:   current.UpdatedAt,
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"description": template.Description,
+			"baseType":    template.BaseType,
+			"template":    template.Template,
+			"data":        template.Data,
+			"instruction": template.Instruction,
+			"version":     current.Version + 1,
+			"updatedAt":   time.Now(),
+		},
+		"$push": bson.M{"history": previous},
+	}
+
+	templateCollection := mongoDatabase.Collection(PromptTemplatesCollection)
+	// template.Version is the version the caller edited; zero skips the check
+	expectedVersion := template.Version
+	if expectedVersion == 0 {
+		expectedVersion = current.Version
+	}
+	result, err := templateCollection.UpdateOne(ctx, bson.M{"name": template.Name, "version": expectedVersion}, update)
+	if err != nil {
+		return fmt.Errorf("failed to update prompt template: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("prompt template %s was changed by someone else, reload it and try again", template.Name)
+	}
+	return nil
+}
+
+// DeletePromptTemplate deletes a prompt template and its version history
+func DeletePromptTemplate(appArgs *DefaultAppArgs, name string) error {
+	if err := ensureDatabaseConnection(appArgs); err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	ctx, cancel := createContextWithTimeout(DefaultTimeout)
+	defer cancel()
+
+	templateCollection := mongoDatabase.Collection(PromptTemplatesCollection)
+	result, err := templateCollection.DeleteOne(ctx, bson.M{"name": name})
+	if err != nil {
+		return fmt.Errorf("failed to delete prompt template: %w", err)
+	}
+	if result.DeletedCount == 0 {
+		return fmt.Errorf("prompt template %s not found", name)
+	}
+	return nil
+}
+
+// CreateBenchmark stores a new benchmark and returns its ID
+func CreateBenchmark(appArgs *DefaultAppArgs, benchmark Benchmark) (string, error) {
+	if err := ensureDatabaseConnection(appArgs); err != nil {
+		return "", fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	ctx, cancel := createContextWithTimeout(DefaultTimeout)
+	defer cancel()
+
+	benchmark.ID = bson.NewObjectID()
+	benchmark.CreatedAt = time.Now()
+	if benchmark.Runs == nil {
+		benchmark.Runs = []BenchmarkRun{}
+	}
+
+	benchmarkCollection := mongoDatabase.Collection(BenchmarksCollection)
+	if _, err := benchmarkCollection.InsertOne(ctx, benchmark); err != nil {
+		return "", fmt.Errorf("failed to create benchmark: %w", err)
+	}
+	return benchmark.ID.Hex(), nil
+}
+
+// AppendBenchmarkRun adds the result of a finished run to a benchmark
+func AppendBenchmarkRun(appArgs *DefaultAppArgs, benchmarkID string, run BenchmarkRun) error {
+	objectID, err := bson.ObjectIDFromHex(benchmarkID)
+	if err != nil {
+		return fmt.Errorf("invalid benchmark ID: %w", err)
+	}
+	if err := ensureDatabaseConnection(appArgs); err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	ctx, cancel := createContextWithTimeout(DefaultTimeout)
+	defer cancel()
+
+	benchmarkCollection := mongoDatabase.Collection(BenchmarksCollection)
+	if _, err := benchmarkCollection.UpdateOne(ctx, bson.M{"_id": objectID}, bson.M{"$push": bson.M{"runs": run}}); err != nil {
+		return fmt.Errorf("failed to save benchmark run: %w", err)
+	}
+	return nil
+}
+
+// FinishBenchmark records the final status of a benchmark
+func FinishBenchmark(appArgs *DefaultAppArgs, benchmarkID string, status string, errorMessage string) error {
+	objectID, err := bson.ObjectIDFromHex(benchmarkID)
+	if err != nil {
+		return fmt.Errorf("invalid benchmark ID: %w", err)
+	}
+	if err := ensureDatabaseConnection(appArgs); err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	ctx, cancel := createContextWithTimeout(DefaultTimeout)
+	defer cancel()
+
+	update := bson.M{"$set": bson.M{"status": status, "error": errorMessage, "completedAt": time.Now()}}
+	benchmarkCollection := mongoDatabase.Collection(BenchmarksCollection)
+	if _, err := benchmarkCollection.UpdateOne(ctx, bson.M{"_id": objectID}, update); err != nil {
+		return fmt.Errorf("failed to finish benchmark: %w", err)
+	}
+	return nil
+}
+
+// GetBenchmarks retrieves all benchmarks without their runs, newest first
+func GetBenchmarks(appArgs *DefaultAppArgs) ([]Benchmark, error) {
+	if err := ensureDatabaseConnection(appArgs); err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	ctx, cancel := createContextWithTimeout(DefaultTimeout)
+	defer cancel()
+
+	benchmarkCollection := mongoDatabase.Collection(BenchmarksCollection)
+	findOptions := options.Find().
+		SetSort(bson.M{"createdAt": -1}).
+		SetProjection(bson.M{"runs": 0})
+	benchmarkCursor, err := benchmarkCollection.Find(ctx, bson.M{}, findOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve benchmarks: %w", err)
+	}
+	defer closeCursor(benchmarkCursor, ctx)
+
+	var benchmarks []Benchmark
+	if err := benchmarkCursor.All(ctx, &benchmarks); err != nil {
+		return nil, fmt.Errorf("failed to decode benchmarks: %w", err)
+	}
+
+	return benchmarks, nil
+}
+
+// GetBenchmark retrieves a benchmark with all of its runs
+func GetBenchmark(appArgs *DefaultAppArgs, benchmarkID string) (Benchmark, error) {
+	objectID, err := bson.ObjectIDFromHex(benchmarkID)
+	if err != nil {
+		return Benchmark{}, fmt.Errorf("invalid benchmark ID: %w", err)
+	}
+	if err := ensureDatabaseConnection(appArgs); err != nil {
+		return Benchmark{}, fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	ctx, cancel := createContextWithTimeout(DefaultTimeout)
+	defer cancel()
+
+	var benchmark Benchmark
+	benchmarkCollection := mongoDatabase.Collection(BenchmarksCollection)
+	if err := benchmarkCollection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&benchmark); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return Benchmark{}, fmt.Errorf("benchmark %s not found", benchmarkID)
+		}
+		return Benchmark{}, fmt.Errorf("failed to retrieve benchmark: %w", err)
+	}
+
+	return benchmark, nil
+}
+
+// DeleteBenchmark deletes a benchmark and its runs
+func DeleteBenchmark(appArgs *DefaultAppArgs, benchmarkID string) error {
+	objectID, err := bson.ObjectIDFromHex(benchmarkID)
+	if err != nil {
+		return fmt.Errorf("invalid benchmark ID: %w", err)
+	}
+	if err := ensureDatabaseConnection(appArgs); err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	ctx, cancel := createContextWithTimeout(DefaultTimeout)
+	defer cancel()
+
+	benchmarkCollection := mongoDatabase.Collection(BenchmarksCollection)
+	result, err := benchmarkCollection.DeleteOne(ctx, bson.M{"_id": objectID})
+	if err != nil {
+		return fmt.Errorf("failed to delete benchmark: %w", err)
+	}
+	if result.DeletedCount == 0 {
+		return fmt.Errorf("benchmark %s not found", benchmarkID)
+	}
+	return nil
+}
+
 // CloseDatabase closes the MongoDB connection
 func CloseDatabase() error {
 	if mongoClient != nil {
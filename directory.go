@@ -90,36 +90,30 @@ func GetAppLogFile(appLogPath string) string {
 	}
 	return string(data)
 }
+
+// GetModelFilesInDirectory lists the valid GGUF models in ModelPath; rejected files are logged with
+// the reason
 func GetModelFilesInDirectory(appArgs DefaultAppArgs) []ModelNameFullPath {
-	dirPath := filepath.Clean(appArgs.ModelPath)
-	filePath, err := os.Open(dirPath)
-	if err != nil {
-		log.Error(err.Error())
-		return nil
-	}
-	defer func(filePath *os.File) {
-		err := filePath.Close()
-		if err != nil {
-			log.Error(err.Error())
+	var modelNameFullPath []ModelNameFullPath
+	for _, descriptor := range GetModelDescriptorsInDirectory(appArgs) {
+		if !descriptor.Valid {
+			log.Warn(fmt.Sprintf("Skipping model %s: %s", descriptor.FileName, descriptor.Error))
+			continue
 		}
-	}(filePath)
-	files, err := filePath.Readdir(-1)
+		modelNameFullPath = append(modelNameFullPath, ModelNameFullPath{
+			FileName: descriptor.FileName,
+			FullPath: descriptor.FullPath,
+		})
+	}
+	return modelNameFullPath
+}
+
+// GetModelDescriptorsInDirectory describes every *.gguf file in ModelPath, including rejected ones
+func GetModelDescriptorsInDirectory(appArgs DefaultAppArgs) []ModelDescriptor {
+	descriptors, err := modelCatalog.List(filepath.Clean(appArgs.ModelPath))
 	if err != nil {
 		log.Error(err.Error())
 		return nil
 	}
-	var modelNameFullPath []ModelNameFullPath
-	for _, entry := range files {
-		if !entry.IsDir() {
-			// Check if the file has a .gguf extension
-			if filepath.Ext(entry.Name()) == ".gguf" {
-				fullPath := filepath.Clean(filepath.Join(dirPath, entry.Name()))
-				modelNameFullPath = append(modelNameFullPath, ModelNameFullPath{
-					FileName: entry.Name(),
-					FullPath: fullPath,
-				})
-			}
-		}
-	}
-	return modelNameFullPath
+	return descriptors
 }
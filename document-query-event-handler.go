@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"time"
@@ -100,11 +101,18 @@ func (p *documentQueryProcessor) processRequest() {
 		return
 	}
 
+	job, err := p.app.startJob(p.request.RequestID, JobKindQuery)
+	if err != nil {
+		p.emitErrorResponse(err.Error())
+		return
+	}
+
 	// Execute query
-	result := p.executeQuery(cliArgs, embedArgs)
+	result, outcome := p.executeQuery(job.Context(), cliArgs, embedArgs)
+	p.app.jobs.Finish(job, p.app.resultError(result))
 
 	// Emit completion and response
-	p.emitCompletion(result)
+	p.emitCompletion(result, outcome)
 }
 
 func (p *documentQueryProcessor) handlePanic() {
@@ -153,7 +161,7 @@ func (p *documentQueryProcessor) convertArguments() (LlamaCliArgs, LlamaEmbedArg
 	return cliArgs, embedArgs, nil
 }
 
-func (p *documentQueryProcessor) executeQuery(cliArgs LlamaCliArgs, embedArgs LlamaEmbedArgs) string {
+func (p *documentQueryProcessor) executeQuery(ctx context.Context, cliArgs LlamaCliArgs, embedArgs LlamaEmbedArgs) (string, DocumentQueryOutcome) {
 	p.eventHandler.emitDocumentQueryProgress(DocumentQueryProgress{
 		RequestID: p.request.RequestID,
 		Status:    "processing",
@@ -163,7 +171,9 @@ func (p *documentQueryProcessor) executeQuery(cliArgs LlamaCliArgs, embedArgs Ll
 
 	p.app.log.Info(fmt.Sprintf("Calling QueryElasticDocument with converted arguments for request: %s", p.request.RequestID))
 
-	return p.app.QueryElasticDocument(
+	return p.app.queryElasticDocument(
+		ctx,
+		p.request.RequestID,
 		cliArgs,
 		embedArgs,
 		p.request.IndexID,
@@ -172,11 +182,22 @@ func (p *documentQueryProcessor) executeQuery(cliArgs LlamaCliArgs, embedArgs Ll
 		p.request.DocumentPrompt,
 		p.request.PromptType,
 		p.request.SearchKeywords,
+		PromptOptions{SystemPrompt: p.request.SystemPrompt, Variables: p.request.Variables},
+		p.request.StructuredOutput,
 	)
 }
 
-func (p *documentQueryProcessor) emitCompletion(result string) {
+func (p *documentQueryProcessor) emitCompletion(result string, outcome DocumentQueryOutcome) {
 	response := p.app.prepareQueryResponse(p.request.RequestID, result, nil, p.processingStartTime)
+	response.ChunksUsed = outcome.Usage.ChunksUsed
+	response.ChunksDropped = outcome.Usage.ChunksDropped
+	response.PromptTokens = outcome.Usage.PromptTokens
+	response.Answer = outcome.Output.Answer
+	response.Reasoning = outcome.Output.Reasoning
+	response.Channels = outcome.Output.Channels
+	response.Structured = outcome.Structured
+	response.StrippedTokens = outcome.StrippedTokens
+	response.Stats = outcome.Stats
 
 	p.eventHandler.emitDocumentQueryProgress(DocumentQueryProgress{
 		RequestID: p.request.RequestID,
@@ -233,6 +254,7 @@ func (eh *EventHandler) emitDocumentQueryError(requestID, errorMsg string) {
 
 // Event emission helper functions
 func (eh *EventHandler) emitDocumentQueryProgress(progress DocumentQueryProgress) {
+	eh.app.jobs.SetProgress(progress.RequestID, progress.Progress)
 	eh.app.log.Info(fmt.Sprintf("Emitting progress: %+v", progress))
 	runtime.EventsEmit(eh.app.ctx, "query-document-progress", progress)
 }
@@ -4,10 +4,12 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"sort"
 	"strings"
 	"time"
 
@@ -19,9 +21,13 @@ import (
 )
 
 /*
-Elasticsearch Index Mapping Structure:
+Elasticsearch Index Mapping Structure (the vector settings come from the IndexProfile):
 {
   "mappings": {
+    "_meta": {
+      "embeddingModel": { "file": "...", "sizeBytes": 0, "dims": 1024, "pooling": "...", "normalize": "..." },
+      "indexProfile": { "dims": 1024, "similarity": "cosine", "quantization": "int8", ... }
+    },
     "properties": {
       "metaKeyWords": {
         "type": "text"
@@ -77,6 +83,12 @@ type DocumentSearchParameters struct {
 	ResultSize   int    // Maximum number of results to return
 }
 
+// RetrievedChunk is a document chunk returned by a vector search together with its similarity score
+type RetrievedChunk struct {
+	Text  string  `json:"text"`
+	Score float64 `json:"score"`
+}
+
 // ElasticsearchRequestLogger handles logging of Elasticsearch requests and responses
 type ElasticsearchRequestLogger struct {
 	LogOutput             io.Writer    // Where to write log output
@@ -109,7 +121,7 @@ func (logger *ElasticsearchRequestLogger) ResponseBodyEnabled() bool {
 }
 
 // InitializeElasticsearchWithIndices creates a new Elasticsearch client and initializes required indices
-func InitializeElasticsearchWithIndices(elasticsearchLogger ElasticsearchRequestLogger, appArgs DefaultAppArgs) (*ElasticsearchClientWrapper, error) {
+func InitializeElasticsearchWithIndices(elasticsearchLogger ElasticsearchRequestLogger, appArgs DefaultAppArgs, llamaEmbedArgs LlamaEmbedArgs) (*ElasticsearchClientWrapper, error) {
 	// Create the Elasticsearch client
 	elasticsearchWrapper, err := NewElasticsearchClient(elasticsearchLogger, appArgs)
 	if err != nil {
@@ -120,7 +132,7 @@ func InitializeElasticsearchWithIndices(elasticsearchLogger ElasticsearchRequest
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	if err := elasticsearchWrapper.InitializeRequiredIndices(ctx); err != nil {
+	if err := elasticsearchWrapper.InitializeRequiredIndices(ctx, &elasticsearchLogger, appArgs, llamaEmbedArgs); err != nil {
 		return nil, fmt.Errorf("failed to initialize required indices: %w", err)
 	}
 
@@ -269,6 +281,14 @@ func (logger *ElasticsearchRequestLogger) LogRoundTrip(httpRequest *http.Request
 	return nil
 }
 
+// LogMessage writes a message that is not tied to a request when the logging level includes it
+func (logger *ElasticsearchRequestLogger) LogMessage(messageLevel LoggingLevel, message string) {
+	if logger.LoggingLevel < messageLevel {
+		return
+	}
+	fmt.Fprintf(logger.LogOutput, "[%s] %s\n", time.Now().Format(time.RFC3339), message)
+}
+
 // IsRequestBodyLoggingEnabled returns whether request body logging is enabled
 func (logger *ElasticsearchRequestLogger) IsRequestBodyLoggingEnabled() bool {
 	return logger.EnableRequestBodyLog
@@ -511,58 +531,79 @@ func (elasticsearchWrapper *ElasticsearchClientWrapper) CreateIndexIfNotExists(c
 	return fmt.Errorf("unexpected response when checking index existence: %s", indexExistsResponse.String())
 }
 
-// InitializeRequiredIndices creates all required indices with their mappings on startup
-func (elasticsearchWrapper *ElasticsearchClientWrapper) InitializeRequiredIndices(ctx context.Context) error {
-	// Define the mapping structure
-	mapping := map[string]interface{}{
-		"properties": map[string]interface{}{
-			"metaKeyWords": map[string]interface{}{
-				"type": "text",
-			},
-			"metaTextDesc": map[string]interface{}{
-				"type": "text",
-			},
-			"docChunks": map[string]interface{}{
-				"type": "nested",
-				"properties": map[string]interface{}{
-					"textChunk": map[string]interface{}{
-						"type": "text",
-					},
-					"vector": map[string]interface{}{
-						"type":       "dense_vector",
-						"dims":       1024,
-						"index":      true,
-						"similarity": "cosine",
-						"index_options": map[string]interface{}{
-							"type":            "int8_hnsw",
-							"m":               24,
-							"ef_construction": 200,
-						},
-					},
-				},
-			},
-			"sourceLocation": map[string]interface{}{
-				"type": "text",
-			},
-			"timestamp": map[string]interface{}{
-				"type": "date",
-			},
-			"title": map[string]interface{}{
-				"type": "text",
-			},
-		},
-	}
-
+// InitializeRequiredIndices creates all required indices with their mappings on startup. When the
+// embedding model cannot be identified yet the default index is created on first use instead.
+func (elasticsearchWrapper *ElasticsearchClientWrapper) InitializeRequiredIndices(ctx context.Context, elasticsearchLogger *ElasticsearchRequestLogger, appArgs DefaultAppArgs, llamaEmbedArgs LlamaEmbedArgs) error {
 	// Create the default index - you can adjust the index name as needed
 	defaultIndexName := "document-meta-index"
 
-	if err := elasticsearchWrapper.CreateIndexIfNotExists(ctx, defaultIndexName, mapping); err != nil {
+	err := elasticsearchWrapper.EnsureDocumentIndex(ctx, defaultIndexName, appArgs, llamaEmbedArgs, DefaultIndexProfile(appArgs))
+	if errors.Is(err, ErrEmbeddingModelUnavailable) {
+		elasticsearchLogger.LogMessage(LoggingLevelWarn, fmt.Sprintf("Index '%s' will be created on first use: %v", defaultIndexName, err))
+		return nil
+	}
+	if err != nil {
 		return fmt.Errorf("failed to create default index '%s': %w", defaultIndexName, err)
 	}
 
 	return nil
 }
 
+// IndexExists reports whether an index or alias with the given name exists
+func (elasticsearchWrapper *ElasticsearchClientWrapper) IndexExists(ctx context.Context, indexName string) (bool, error) {
+	indexExistsResponse, err := elasticsearchWrapper.elasticsearchClient.Indices.Exists([]string{indexName}, elasticsearchWrapper.elasticsearchClient.Indices.Exists.WithContext(ctx))
+	if err != nil {
+		return false, fmt.Errorf("error checking if index exists: %w", err)
+	}
+	defer func(responseBody io.ReadCloser) {
+		err := responseBody.Close()
+		if err != nil {
+			log.Error(err.Error())
+		}
+	}(indexExistsResponse.Body)
+
+	switch indexExistsResponse.StatusCode {
+	case 200:
+		return true, nil
+	case 404:
+		return false, nil
+	}
+	return false, fmt.Errorf("unexpected response when checking index existence: %s", indexExistsResponse.String())
+}
+
+// EnsureDocumentIndex creates a document index for the embedding model when it does not exist yet. The
+// profile's dims are taken from the model when they are not set.
+func (elasticsearchWrapper *ElasticsearchClientWrapper) EnsureDocumentIndex(ctx context.Context, indexName string, appArgs DefaultAppArgs, llamaEmbedArgs LlamaEmbedArgs, profile IndexProfile) error {
+	return elasticsearchWrapper.ensureDocumentIndex(ctx, indexName, appArgs, llamaEmbedArgs, profile, "")
+}
+
+// EnsureMigrationTargetIndex is EnsureDocumentIndex for the target of a migration; the source index is
+// recorded as migratedFrom in _meta so only that migration resumes into or restarts the index
+func (elasticsearchWrapper *ElasticsearchClientWrapper) EnsureMigrationTargetIndex(ctx context.Context, indexName string, sourceIndex string, appArgs DefaultAppArgs, llamaEmbedArgs LlamaEmbedArgs, profile IndexProfile) error {
+	return elasticsearchWrapper.ensureDocumentIndex(ctx, indexName, appArgs, llamaEmbedArgs, profile, sourceIndex)
+}
+
+func (elasticsearchWrapper *ElasticsearchClientWrapper) ensureDocumentIndex(ctx context.Context, indexName string, appArgs DefaultAppArgs, llamaEmbedArgs LlamaEmbedArgs, profile IndexProfile, migratedFrom string) error {
+	exists, err := elasticsearchWrapper.IndexExists(ctx, indexName)
+	if err != nil || exists {
+		return err
+	}
+
+	identity, err := NewEmbeddingModelIdentity(llamaEmbedArgs)
+	if err != nil {
+		return err
+	}
+	profile, err = completeIndexProfile(ctx, profile, &identity, llamaEmbedArgs, appArgs)
+	if err != nil {
+		return fmt.Errorf("invalid index profile for '%s': %w", indexName, err)
+	}
+	mapping := NewDocumentIndexMapping(profile, identity)
+	if migratedFrom != "" {
+		mapping["_meta"].(map[string]interface{})["migratedFrom"] = migratedFrom
+	}
+	return elasticsearchWrapper.CreateIndexIfNotExists(ctx, indexName, mapping)
+}
+
 // AddElasticsearchDocument adds a document with embeddings to Elasticsearch
 func (elasticsearchWrapper *ElasticsearchClientWrapper) AddElasticsearchDocument(documentContext context.Context, log logger.Logger, appArgs DefaultAppArgs, llamaEmbeddingParameters LlamaEmbedArgs, documentChunks []Document, indexName string, documentTitle string, metaTextDesc string, metaKeyWords string, sourceFilePath string) error {
 
@@ -576,13 +617,46 @@ func (elasticsearchWrapper *ElasticsearchClientWrapper) AddElasticsearchDocument
 		DocChunks:      []ElasticDocumentTextChunk{},
 	}
 
-	// Process each document chunk and generate embeddings
-	for _, documentChunk := range documentChunks {
-		chunkEmbedding, err := GenerateEmbedWithCancel(documentContext, llamaEmbeddingParameters, appArgs, documentChunk.Content)
-		if err != nil {
-			log.Info(fmt.Sprintf("Failed to generate embedding for document: %v", err))
+	// Check the index against the embedding model before embedding so a mismatch fails before any work is done
+	embeddingModel, err := NewEmbeddingModelIdentity(llamaEmbeddingParameters)
+	if err != nil {
+		return err
+	}
+	if err := elasticsearchWrapper.EnsureDocumentIndex(documentContext, indexName, appArgs, llamaEmbeddingParameters, DefaultIndexProfile(appArgs)); err != nil {
+		return err
+	}
+	indexEmbedding, err := elasticsearchWrapper.GetIndexEmbedding(documentContext, indexName)
+	if err != nil {
+		return err
+	}
+	if err := indexEmbedding.Check(indexName, embeddingModel); err != nil {
+		return err
+	}
+	if indexEmbedding.Model == nil {
+		// Indices created before the model was recorded adopt the model of their first new document
+		if err := elasticsearchWrapper.SetIndexEmbeddingModel(documentContext, indexName, embeddingModel); err != nil {
+			return err
+		}
+	}
+
+	// Generate the embeddings in batches, one llama-embedding run per batch
+	chunkTexts := make([]string, len(documentChunks))
+	for i, documentChunk := range documentChunks {
+		chunkTexts[i] = documentChunk.Content
+	}
+	chunkEmbeddings, err := EmbedTexts(documentContext, log, llamaEmbeddingParameters, appArgs, chunkTexts, appArgs.EmbeddingBatchSize)
+	if err != nil {
+		return fmt.Errorf("failed to generate embeddings: %w", err)
+	}
+
+	for i, documentChunk := range documentChunks {
+		chunkEmbedding := chunkEmbeddings[i]
+		if chunkEmbedding == nil {
 			continue // Skip this document chunk but continue processing others
 		}
+		if err := CheckEmbeddingDims(chunkEmbedding, indexEmbedding.Dims); err != nil {
+			return fmt.Errorf("chunk %d of %s: %w", i+1, indexName, err)
+		}
 
 		// Create the document chunk with text and vector embedding
 		elasticsearchDocumentChunk := ElasticDocumentTextChunk{
@@ -647,6 +721,408 @@ func (elasticsearchWrapper *ElasticsearchClientWrapper) GetElasticsearchIndexInf
 	return indexInformation, nil
 }
 
+// GetIndexEmbedding reads the dims of the docChunks.vector field and the embedding model recorded in
+// the index mapping. When indexName is an alias every index behind it must agree.
+func (elasticsearchWrapper *ElasticsearchClientWrapper) GetIndexEmbedding(ctx context.Context, indexName string) (IndexEmbedding, error) {
+	mappingResponse, err := elasticsearchWrapper.elasticsearchClient.Indices.GetMapping(
+		elasticsearchWrapper.elasticsearchClient.Indices.GetMapping.WithIndex(indexName),
+		elasticsearchWrapper.elasticsearchClient.Indices.GetMapping.WithContext(ctx),
+	)
+	if err != nil {
+		return IndexEmbedding{}, fmt.Errorf("error getting index mapping: %w", err)
+	}
+	defer func(responseBody io.ReadCloser) {
+		err := responseBody.Close()
+		if err != nil {
+			log.Error(err.Error())
+		}
+	}(mappingResponse.Body)
+
+	if mappingResponse.IsError() {
+		if mappingResponse.StatusCode == 404 {
+			return IndexEmbedding{}, fmt.Errorf("%w: '%s'", ErrIndexNotFound, indexName)
+		}
+		return IndexEmbedding{}, fmt.Errorf("error response from Elasticsearch: %s", mappingResponse.String())
+	}
+
+	var indexMappings map[string]struct {
+		Mappings struct {
+			Meta struct {
+				EmbeddingModel *EmbeddingModelIdentity `json:"embeddingModel"`
+				MigratedFrom   string                  `json:"migratedFrom"`
+			} `json:"_meta"`
+			Properties struct {
+				DocChunks struct {
+					Properties struct {
+						Vector struct {
+							Dims int `json:"dims"`
+						} `json:"vector"`
+					} `json:"properties"`
+				} `json:"docChunks"`
+			} `json:"properties"`
+		} `json:"mappings"`
+	}
+	if err := json.NewDecoder(mappingResponse.Body).Decode(&indexMappings); err != nil {
+		return IndexEmbedding{}, fmt.Errorf("error decoding index mapping: %w", err)
+	}
+
+	var indexEmbedding IndexEmbedding
+	for concreteIndex, indexMapping := range indexMappings {
+		indexDims := indexMapping.Mappings.Properties.DocChunks.Properties.Vector.Dims
+		if indexDims <= 0 {
+			return IndexEmbedding{}, fmt.Errorf("index '%s' has no docChunks.vector dims in its mapping", concreteIndex)
+		}
+		if indexEmbedding.Dims != 0 && indexDims != indexEmbedding.Dims {
+			return IndexEmbedding{}, fmt.Errorf("indices behind '%s' use different vector dims (%d and %d)", indexName, indexEmbedding.Dims, indexDims)
+		}
+		indexEmbedding.Dims = indexDims
+		if migratedFrom := indexMapping.Mappings.Meta.MigratedFrom; migratedFrom != "" {
+			indexEmbedding.MigratedFrom = migratedFrom
+		}
+
+		model := indexMapping.Mappings.Meta.EmbeddingModel
+		if model == nil {
+			continue
+		}
+		if indexEmbedding.Model != nil && !indexEmbedding.Model.Matches(*model) {
+			return IndexEmbedding{}, fmt.Errorf("%w: indices behind '%s' were built with different embedding models", ErrEmbeddingModelMismatch, indexName)
+		}
+		indexEmbedding.Model = model
+	}
+	if indexEmbedding.Dims == 0 {
+		return IndexEmbedding{}, fmt.Errorf("%w: '%s'", ErrIndexNotFound, indexName)
+	}
+	return indexEmbedding, nil
+}
+
+// SetIndexEmbeddingModel records the embedding model in the _meta of the index mapping
+func (elasticsearchWrapper *ElasticsearchClientWrapper) SetIndexEmbeddingModel(ctx context.Context, indexName string, identity EmbeddingModelIdentity) error {
+	mappingUpdate := map[string]interface{}{
+		"_meta": map[string]interface{}{
+			"embeddingModel": identity,
+		},
+	}
+	putMappingResponse, err := elasticsearchWrapper.elasticsearchClient.Indices.PutMapping(
+		[]string{indexName},
+		esutil.NewJSONReader(mappingUpdate),
+		elasticsearchWrapper.elasticsearchClient.Indices.PutMapping.WithContext(ctx),
+	)
+	if err != nil {
+		return fmt.Errorf("error updating index mapping: %w", err)
+	}
+	defer func(responseBody io.ReadCloser) {
+		err := responseBody.Close()
+		if err != nil {
+			log.Error(err.Error())
+		}
+	}(putMappingResponse.Body)
+
+	if putMappingResponse.IsError() {
+		return fmt.Errorf("error response from Elasticsearch when updating index mapping: %s", putMappingResponse.String())
+	}
+	return nil
+}
+
+// ScrollDocuments calls visit with the ID and source of every document in the index, pageSize documents
+// per request. Fields listed in sourceExcludes are left out of the source; visit may stop the scroll by
+// returning an error.
+func (elasticsearchWrapper *ElasticsearchClientWrapper) ScrollDocuments(ctx context.Context, indexName string, pageSize int, sourceExcludes []string, visit func(documentID string, source json.RawMessage) error) error {
+	const scrollKeepAlive = 30 * time.Minute
+
+	searchQuery := map[string]interface{}{
+		"size":    pageSize,
+		"sort":    []string{"_doc"},
+		"_source": map[string]interface{}{"excludes": sourceExcludes},
+	}
+	searchResponse, err := elasticsearchWrapper.elasticsearchClient.Search(
+		elasticsearchWrapper.elasticsearchClient.Search.WithContext(ctx),
+		elasticsearchWrapper.elasticsearchClient.Search.WithIndex(indexName),
+		elasticsearchWrapper.elasticsearchClient.Search.WithBody(esutil.NewJSONReader(searchQuery)),
+		elasticsearchWrapper.elasticsearchClient.Search.WithScroll(scrollKeepAlive),
+	)
+
+	var scrollID string
+	defer func() {
+		if scrollID != "" {
+			clearResponse, err := elasticsearchWrapper.elasticsearchClient.ClearScroll(elasticsearchWrapper.elasticsearchClient.ClearScroll.WithBody(esutil.NewJSONReader(map[string]string{"scroll_id": scrollID})))
+			if err == nil {
+				_ = clearResponse.Body.Close()
+			}
+		}
+	}()
+
+	for {
+		if err != nil {
+			return fmt.Errorf("error scrolling index '%s': %w", indexName, err)
+		}
+		var scrollPage struct {
+			ScrollID string `json:"_scroll_id"`
+			Hits     struct {
+				Hits []struct {
+					ID     string          `json:"_id"`
+					Source json.RawMessage `json:"_source"`
+				} `json:"hits"`
+			} `json:"hits"`
+		}
+		pageErr := func() error {
+			defer func(responseBody io.ReadCloser) {
+				err := responseBody.Close()
+				if err != nil {
+					log.Error(err.Error())
+				}
+			}(searchResponse.Body)
+			if searchResponse.IsError() {
+				if searchResponse.StatusCode == 404 {
+					return fmt.Errorf("%w: '%s'", ErrIndexNotFound, indexName)
+				}
+				return fmt.Errorf("error response from Elasticsearch: %s", searchResponse.String())
+			}
+			return json.NewDecoder(searchResponse.Body).Decode(&scrollPage)
+		}()
+		if pageErr != nil {
+			return pageErr
+		}
+		if scrollPage.ScrollID != "" {
+			scrollID = scrollPage.ScrollID
+		}
+		if len(scrollPage.Hits.Hits) == 0 {
+			return nil
+		}
+		for _, hit := range scrollPage.Hits.Hits {
+			if err := visit(hit.ID, hit.Source); err != nil {
+				return err
+			}
+		}
+
+		searchResponse, err = elasticsearchWrapper.elasticsearchClient.Scroll(
+			elasticsearchWrapper.elasticsearchClient.Scroll.WithContext(ctx),
+			elasticsearchWrapper.elasticsearchClient.Scroll.WithBody(esutil.NewJSONReader(map[string]string{"scroll_id": scrollID})),
+			elasticsearchWrapper.elasticsearchClient.Scroll.WithScroll(scrollKeepAlive),
+		)
+	}
+}
+
+// IndexDocument stores a document under the given ID, replacing any document with that ID
+func (elasticsearchWrapper *ElasticsearchClientWrapper) IndexDocument(ctx context.Context, indexName string, documentID string, document interface{}) error {
+	indexResponse, err := elasticsearchWrapper.elasticsearchClient.Index(indexName, esutil.NewJSONReader(document),
+		elasticsearchWrapper.elasticsearchClient.Index.WithDocumentID(documentID),
+		elasticsearchWrapper.elasticsearchClient.Index.WithContext(ctx),
+	)
+	if err != nil {
+		return fmt.Errorf("error indexing document: %w", err)
+	}
+	defer func(responseBody io.ReadCloser) {
+		err := responseBody.Close()
+		if err != nil {
+			log.Error(err.Error())
+		}
+	}(indexResponse.Body)
+
+	if indexResponse.IsError() {
+		return fmt.Errorf("error response from Elasticsearch: %s", indexResponse.String())
+	}
+	return nil
+}
+
+// DeleteDocument removes the document with the given ID; a missing document is not an error
+func (elasticsearchWrapper *ElasticsearchClientWrapper) DeleteDocument(ctx context.Context, indexName string, documentID string) error {
+	deleteResponse, err := elasticsearchWrapper.elasticsearchClient.Delete(indexName, documentID, elasticsearchWrapper.elasticsearchClient.Delete.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("error deleting document: %w", err)
+	}
+	defer func(responseBody io.ReadCloser) {
+		err := responseBody.Close()
+		if err != nil {
+			log.Error(err.Error())
+		}
+	}(deleteResponse.Body)
+
+	if deleteResponse.IsError() && deleteResponse.StatusCode != 404 {
+		return fmt.Errorf("error response from Elasticsearch when deleting document: %s", deleteResponse.String())
+	}
+	return nil
+}
+
+// GetDocumentTitle returns the title stored with the document with the given ID
+func (elasticsearchWrapper *ElasticsearchClientWrapper) GetDocumentTitle(ctx context.Context, indexName string, documentID string) (string, error) {
+	getResponse, err := elasticsearchWrapper.elasticsearchClient.Get(indexName, documentID,
+		elasticsearchWrapper.elasticsearchClient.Get.WithSourceIncludes("title"),
+		elasticsearchWrapper.elasticsearchClient.Get.WithContext(ctx),
+	)
+	if err != nil {
+		return "", fmt.Errorf("error getting document: %w", err)
+	}
+	defer func(responseBody io.ReadCloser) {
+		err := responseBody.Close()
+		if err != nil {
+			log.Error(err.Error())
+		}
+	}(getResponse.Body)
+
+	if getResponse.IsError() {
+		return "", fmt.Errorf("error response from Elasticsearch when getting document: %s", getResponse.String())
+	}
+
+	var getResponseData struct {
+		Source struct {
+			Title string `json:"title"`
+		} `json:"_source"`
+	}
+	if err := json.NewDecoder(getResponse.Body).Decode(&getResponseData); err != nil {
+		return "", fmt.Errorf("error parsing get response: %w", err)
+	}
+	return getResponseData.Source.Title, nil
+}
+
+// CountDocumentsAndChunks returns the number of documents in the index and the number of docChunks
+// they hold. The index is refreshed first so recently indexed documents are counted.
+func (elasticsearchWrapper *ElasticsearchClientWrapper) CountDocumentsAndChunks(ctx context.Context, indexName string) (int64, int64, error) {
+	refreshResponse, err := elasticsearchWrapper.elasticsearchClient.Indices.Refresh(
+		elasticsearchWrapper.elasticsearchClient.Indices.Refresh.WithIndex(indexName),
+		elasticsearchWrapper.elasticsearchClient.Indices.Refresh.WithContext(ctx),
+	)
+	if err != nil {
+		return 0, 0, fmt.Errorf("error refreshing index: %w", err)
+	}
+	_ = refreshResponse.Body.Close()
+
+	countQuery := map[string]interface{}{
+		"size":             0,
+		"track_total_hits": true,
+		"aggs": map[string]interface{}{
+			"chunks": map[string]interface{}{
+				"nested": map[string]interface{}{"path": "docChunks"},
+			},
+		},
+	}
+	searchResponse, err := elasticsearchWrapper.elasticsearchClient.Search(
+		elasticsearchWrapper.elasticsearchClient.Search.WithContext(ctx),
+		elasticsearchWrapper.elasticsearchClient.Search.WithIndex(indexName),
+		elasticsearchWrapper.elasticsearchClient.Search.WithBody(esutil.NewJSONReader(countQuery)),
+	)
+	if err != nil {
+		return 0, 0, fmt.Errorf("error counting documents: %w", err)
+	}
+	defer func(responseBody io.ReadCloser) {
+		err := responseBody.Close()
+		if err != nil {
+			log.Error(err.Error())
+		}
+	}(searchResponse.Body)
+
+	if searchResponse.IsError() {
+		return 0, 0, fmt.Errorf("error response from Elasticsearch: %s", searchResponse.String())
+	}
+	var countResult struct {
+		Hits struct {
+			Total struct {
+				Value int64 `json:"value"`
+			} `json:"total"`
+		} `json:"hits"`
+		Aggregations struct {
+			Chunks struct {
+				DocCount int64 `json:"doc_count"`
+			} `json:"chunks"`
+		} `json:"aggregations"`
+	}
+	if err := json.NewDecoder(searchResponse.Body).Decode(&countResult); err != nil {
+		return 0, 0, fmt.Errorf("error decoding document count: %w", err)
+	}
+	return countResult.Hits.Total.Value, countResult.Aggregations.Chunks.DocCount, nil
+}
+
+// GetAliasIndices returns the indices an alias points at, or nil when no alias has that name
+func (elasticsearchWrapper *ElasticsearchClientWrapper) GetAliasIndices(ctx context.Context, aliasName string) ([]string, error) {
+	aliasResponse, err := elasticsearchWrapper.elasticsearchClient.Indices.GetAlias(
+		elasticsearchWrapper.elasticsearchClient.Indices.GetAlias.WithName(aliasName),
+		elasticsearchWrapper.elasticsearchClient.Indices.GetAlias.WithContext(ctx),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error getting alias: %w", err)
+	}
+	defer func(responseBody io.ReadCloser) {
+		err := responseBody.Close()
+		if err != nil {
+			log.Error(err.Error())
+		}
+	}(aliasResponse.Body)
+
+	if aliasResponse.StatusCode == 404 {
+		return nil, nil
+	}
+	if aliasResponse.IsError() {
+		return nil, fmt.Errorf("error response from Elasticsearch: %s", aliasResponse.String())
+	}
+	var aliasIndices map[string]json.RawMessage
+	if err := json.NewDecoder(aliasResponse.Body).Decode(&aliasIndices); err != nil {
+		return nil, fmt.Errorf("error decoding alias: %w", err)
+	}
+	indices := make([]string, 0, len(aliasIndices))
+	for indexName := range aliasIndices {
+		indices = append(indices, indexName)
+	}
+	sort.Strings(indices)
+	return indices, nil
+}
+
+// SwapAlias points aliasName at newIndex in a single atomic update, removing it from the indices it
+// pointed at before. When replaceIndex is set that index is deleted in the same update, which lets an
+// alias take over the name of the index it replaces.
+func (elasticsearchWrapper *ElasticsearchClientWrapper) SwapAlias(ctx context.Context, aliasName string, newIndex string, previousIndices []string, replaceIndex string) error {
+	var actions []map[string]interface{}
+	for _, previousIndex := range previousIndices {
+		actions = append(actions, map[string]interface{}{
+			"remove": map[string]interface{}{"index": previousIndex, "alias": aliasName},
+		})
+	}
+	if replaceIndex != "" {
+		actions = append(actions, map[string]interface{}{
+			"remove_index": map[string]interface{}{"index": replaceIndex},
+		})
+	}
+	actions = append(actions, map[string]interface{}{
+		"add": map[string]interface{}{"index": newIndex, "alias": aliasName},
+	})
+
+	aliasResponse, err := elasticsearchWrapper.elasticsearchClient.Indices.UpdateAliases(
+		esutil.NewJSONReader(map[string]interface{}{"actions": actions}),
+		elasticsearchWrapper.elasticsearchClient.Indices.UpdateAliases.WithContext(ctx),
+	)
+	if err != nil {
+		return fmt.Errorf("error updating aliases: %w", err)
+	}
+	defer func(responseBody io.ReadCloser) {
+		err := responseBody.Close()
+		if err != nil {
+			log.Error(err.Error())
+		}
+	}(aliasResponse.Body)
+
+	if aliasResponse.IsError() {
+		return fmt.Errorf("error response from Elasticsearch when updating aliases: %s", aliasResponse.String())
+	}
+	return nil
+}
+
+// DeleteIndex removes an index; a missing index is not an error
+func (elasticsearchWrapper *ElasticsearchClientWrapper) DeleteIndex(ctx context.Context, indexName string) error {
+	deleteResponse, err := elasticsearchWrapper.elasticsearchClient.Indices.Delete([]string{indexName}, elasticsearchWrapper.elasticsearchClient.Indices.Delete.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("error deleting index: %w", err)
+	}
+	defer func(responseBody io.ReadCloser) {
+		err := responseBody.Close()
+		if err != nil {
+			log.Error(err.Error())
+		}
+	}(deleteResponse.Body)
+
+	if deleteResponse.IsError() && deleteResponse.StatusCode != 404 {
+		return fmt.Errorf("error response from Elasticsearch when deleting index: %s", deleteResponse.String())
+	}
+	return nil
+}
+
 // GetAllElasticsearchIndices retrieves a list of all indices in the Elasticsearch cluster
 func (elasticsearchWrapper *ElasticsearchClientWrapper) GetAllElasticsearchIndices() ([]string, error) {
 	// Perform a request to get all indices using the _cat/indices API with a specific pattern
@@ -786,8 +1262,23 @@ func (elasticsearchWrapper *ElasticsearchClientWrapper) SearchWithKNearestNeighb
 }
 
 // SearchDocumentByIDWithVector searches for a specific document by ID and performs a vector similarity search
-// within that document's chunks, returning the matching text chunks as a concatenated string
+// on its chunks, returning the matching chunk texts joined by blank lines
 func (elasticsearchWrapper *ElasticsearchClientWrapper) SearchDocumentByIDWithVector(searchContext context.Context, indexName, targetDocumentID string, searchVector []float32, maximumResults int) (string, error) {
+	rankedChunks, err := elasticsearchWrapper.SearchDocumentChunksByIDWithVector(searchContext, indexName, targetDocumentID, searchVector, maximumResults)
+	if err != nil {
+		return "", err
+	}
+
+	textChunks := make([]string, len(rankedChunks))
+	for chunkIndex, chunk := range rankedChunks {
+		textChunks[chunkIndex] = chunk.Text
+	}
+	return strings.Join(textChunks, "\n\n"), nil
+}
+
+// SearchDocumentChunksByIDWithVector runs the same search as SearchDocumentByIDWithVector but returns the
+// matching chunks with their similarity scores, best match first
+func (elasticsearchWrapper *ElasticsearchClientWrapper) SearchDocumentChunksByIDWithVector(searchContext context.Context, indexName, targetDocumentID string, searchVector []float32, maximumResults int) ([]RetrievedChunk, error) {
 	searchContext, cancelSearch := context.WithCancel(searchContext)
 	defer cancelSearch()
 
@@ -825,7 +1316,7 @@ func (elasticsearchWrapper *ElasticsearchClientWrapper) SearchDocumentByIDWithVe
 		elasticsearchWrapper.elasticsearchClient.Search.WithBody(esutil.NewJSONReader(&combinedSearchQuery)),
 	)
 	if err != nil {
-		return "", fmt.Errorf("error executing document search: %w", err)
+		return nil, fmt.Errorf("error executing document search: %w", err)
 	}
 
 	// Check for errors in the response BEFORE reading the body
@@ -834,9 +1325,9 @@ func (elasticsearchWrapper *ElasticsearchClientWrapper) SearchDocumentByIDWithVe
 		var errorResponseDetails map[string]interface{}
 		if err := json.NewDecoder(documentSearchResponse.Body).Decode(&errorResponseDetails); err != nil {
 			// If we can't decode the error response, return a generic error
-			return "", fmt.Errorf("error response from Elasticsearch (status: %d)", documentSearchResponse.StatusCode)
+			return nil, fmt.Errorf("error response from Elasticsearch (status: %d)", documentSearchResponse.StatusCode)
 		}
-		return "", fmt.Errorf("error response from Elasticsearch: %v", errorResponseDetails)
+		return nil, fmt.Errorf("error response from Elasticsearch: %v", errorResponseDetails)
 	}
 	defer func(responseBody io.ReadCloser) {
 		err := responseBody.Close()
@@ -848,56 +1339,56 @@ func (elasticsearchWrapper *ElasticsearchClientWrapper) SearchDocumentByIDWithVe
 	// Parse the search response JSON
 	var documentSearchResultData map[string]interface{}
 	if err := json.NewDecoder(documentSearchResponse.Body).Decode(&documentSearchResultData); err != nil {
-		return "", fmt.Errorf("error parsing search response: %w", err)
+		return nil, fmt.Errorf("error parsing search response: %w", err)
 	}
 
 	// Extract the hits from the response structure
 	searchHits, ok := documentSearchResultData["hits"].(map[string]interface{})
 	if !ok {
-		return "", fmt.Errorf("unexpected response format: missing hits")
+		return nil, fmt.Errorf("unexpected response format: missing hits")
 	}
 
 	searchHitsArray, ok := searchHits["hits"].([]interface{})
 	if !ok {
-		return "", fmt.Errorf("unexpected response format: missing hits array")
+		return nil, fmt.Errorf("unexpected response format: missing hits array")
 	}
 
 	// Check if we found the target document
 	if len(searchHitsArray) == 0 {
-		return "", fmt.Errorf("document with ID %s not found", targetDocumentID)
+		return nil, fmt.Errorf("document with ID %s not found", targetDocumentID)
 	}
 
 	// Get the first (and should be only) hit
 	primaryHitMap, ok := searchHitsArray[0].(map[string]interface{})
 	if !ok {
-		return "", fmt.Errorf("unexpected hit format")
+		return nil, fmt.Errorf("unexpected hit format")
 	}
 
 	// Extract inner hits containing the matching text chunks
 	innerHitsData, ok := primaryHitMap["inner_hits"].(map[string]interface{})
 	if !ok {
-		return "", fmt.Errorf("no inner hits found in the document")
+		return nil, fmt.Errorf("no inner hits found in the document")
 	}
 
 	// Extract text chunks inner hits
 	documentChunksData, ok := innerHitsData["docChunks"].(map[string]interface{})
 	if !ok {
-		return "", fmt.Errorf("docChunks not found in inner hits")
+		return nil, fmt.Errorf("docChunks not found in inner hits")
 	}
 
 	documentChunksHitsData, ok := documentChunksData["hits"].(map[string]interface{})
 	if !ok {
-		return "", fmt.Errorf("unexpected docChunks format")
+		return nil, fmt.Errorf("unexpected docChunks format")
 	}
 
 	documentChunksHitsArray, ok := documentChunksHitsData["hits"].([]interface{})
 	if !ok {
-		return "", fmt.Errorf("unexpected docChunks hits array format")
+		return nil, fmt.Errorf("unexpected docChunks hits array format")
 	}
 
-	// Build a concatenated string from all the matching text chunks
-	var textChunksResultBuilder strings.Builder
-	for chunkIndex, chunkHitData := range documentChunksHitsArray {
+	// Collect the matching text chunks in the order Elasticsearch ranked them
+	var rankedChunks []RetrievedChunk
+	for _, chunkHitData := range documentChunksHitsArray {
 		chunkHitMap, ok := chunkHitData.(map[string]interface{})
 		if !ok {
 			continue
@@ -933,14 +1424,11 @@ func (elasticsearchWrapper *ElasticsearchClientWrapper) SearchDocumentByIDWithVe
 			continue
 		}
 
-		// Add a newline between chunks except for the first one
-		if chunkIndex > 0 {
-			textChunksResultBuilder.WriteString("\n\n")
-		}
-		textChunksResultBuilder.WriteString(textChunkContent)
+		chunkScore, _ := chunkHitMap["_score"].(float64)
+		rankedChunks = append(rankedChunks, RetrievedChunk{Text: textChunkContent, Score: chunkScore})
 	}
 
-	return textChunksResultBuilder.String(), nil
+	return rankedChunks, nil
 }
 
 // SearchWithKNNAndTextCombined performs a combined vector and text search on documents for hybrid search capabilities
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// newTestElasticsearchServer starts a fake Elasticsearch server served by handler and returns its URL
+func newTestElasticsearchServer(t *testing.T, handler http.HandlerFunc) string {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The client refuses servers that do not identify as Elasticsearch
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		w.Header().Set("Content-Type", "application/json")
+		handler(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+// newTestElasticsearchClient returns a client for a fake Elasticsearch server served by handler
+func newTestElasticsearchClient(t *testing.T, handler http.HandlerFunc) *ElasticsearchClientWrapper {
+	t.Helper()
+	elasticsearchLogger := NewElasticsearchRequestLogger(LoggingLevelError)
+	elasticsearchLogger.SetLogOutput(io.Discard)
+	client, err := NewElasticsearchClient(*elasticsearchLogger, DefaultAppArgs{ElasticsearchServerAddresses: []string{newTestElasticsearchServer(t, handler)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+// fakeElasticsearch keeps indices, documents and aliases in memory and answers the requests of the
+// index, document, scroll, count and alias helpers
+type fakeElasticsearch struct {
+	mu           sync.Mutex
+	indices      map[string]*fakeIndex
+	aliases      map[string][]string
+	scrolls      map[string]*fakeScroll
+	aliasUpdates [][]map[string]map[string]string // the actions of every _aliases request
+	indexed      []string                         // index/id of every stored document
+}
+
+type fakeIndex struct {
+	mappings map[string]interface{}
+	ids      []string
+	docs     map[string]map[string]interface{}
+}
+
+type fakeScroll struct {
+	hits []fakeHit // not returned yet
+	size int
+}
+
+type fakeHit struct {
+	ID     string                 `json:"_id"`
+	Source map[string]interface{} `json:"_source"`
+}
+
+func newFakeElasticsearch() *fakeElasticsearch {
+	return &fakeElasticsearch{
+		indices: make(map[string]*fakeIndex),
+		aliases: make(map[string][]string),
+		scrolls: make(map[string]*fakeScroll),
+	}
+}
+
+// addIndex creates an index with the given mapping and documents
+func (es *fakeElasticsearch) addIndex(name string, mappings map[string]interface{}, docs map[string]map[string]interface{}) {
+	index := &fakeIndex{mappings: mappings, docs: make(map[string]map[string]interface{})}
+	for _, id := range slices.Sorted(maps.Keys(docs)) {
+		index.put(id, docs[id])
+	}
+	es.indices[name] = index
+}
+
+func (index *fakeIndex) put(id string, doc map[string]interface{}) {
+	if _, exists := index.docs[id]; !exists {
+		index.ids = append(index.ids, id)
+	}
+	index.docs[id] = doc
+}
+
+// resolve returns the indices behind an index or alias name
+func (es *fakeElasticsearch) resolve(name string) []string {
+	if indices, ok := es.aliases[name]; ok {
+		return indices
+	}
+	if _, ok := es.indices[name]; ok {
+		return []string{name}
+	}
+	return nil
+}
+
+func (es *fakeElasticsearch) handle(w http.ResponseWriter, r *http.Request) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+
+	var body map[string]interface{}
+	_ = json.NewDecoder(r.Body).Decode(&body)
+	reply := func(status int, value interface{}) {
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(value)
+	}
+	notFound := func() { reply(http.StatusNotFound, map[string]interface{}{"error": "not found", "status": 404}) }
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case parts[0] == "_alias":
+		indices := es.aliases[parts[1]]
+		if indices == nil {
+			notFound()
+			return
+		}
+		result := make(map[string]interface{})
+		for _, index := range indices {
+			result[index] = map[string]interface{}{"aliases": map[string]interface{}{parts[1]: map[string]interface{}{}}}
+		}
+		reply(http.StatusOK, result)
+
+	case parts[0] == "_aliases":
+		var update struct {
+			Actions []map[string]map[string]string `json:"actions"`
+		}
+		encoded, _ := json.Marshal(body)
+		_ = json.Unmarshal(encoded, &update)
+		es.aliasUpdates = append(es.aliasUpdates, update.Actions)
+		for _, action := range update.Actions {
+			for kind, target := range action {
+				switch kind {
+				case "add":
+					es.aliases[target["alias"]] = append(es.aliases[target["alias"]], target["index"])
+				case "remove":
+					es.aliases[target["alias"]] = slices.DeleteFunc(es.aliases[target["alias"]], func(index string) bool { return index == target["index"] })
+				case "remove_index":
+					delete(es.indices, target["index"])
+				}
+			}
+		}
+		reply(http.StatusOK, map[string]interface{}{"acknowledged": true})
+
+	case parts[0] == "_search" && parts[1] == "scroll":
+		if r.Method == http.MethodDelete {
+			reply(http.StatusOK, map[string]interface{}{"succeeded": true})
+			return
+		}
+		es.replyScrollPage(reply, body["scroll_id"].(string))
+
+	case len(parts) == 1:
+		switch r.Method {
+		case http.MethodHead:
+			if es.resolve(parts[0]) == nil {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		case http.MethodPut:
+			mappings, _ := body["mappings"].(map[string]interface{})
+			es.addIndex(parts[0], mappings, nil)
+			reply(http.StatusOK, map[string]interface{}{"acknowledged": true})
+		case http.MethodDelete:
+			if es.indices[parts[0]] == nil {
+				notFound()
+				return
+			}
+			delete(es.indices, parts[0])
+			reply(http.StatusOK, map[string]interface{}{"acknowledged": true})
+		}
+
+	case parts[1] == "_mapping":
+		indices := es.resolve(parts[0])
+		if indices == nil {
+			notFound()
+			return
+		}
+		result := make(map[string]interface{})
+		for _, index := range indices {
+			result[index] = map[string]interface{}{"mappings": es.indices[index].mappings}
+		}
+		reply(http.StatusOK, result)
+
+	case parts[1] == "_refresh":
+		reply(http.StatusOK, map[string]interface{}{})
+
+	case parts[1] == "_search":
+		indices := es.resolve(parts[0])
+		if indices == nil {
+			notFound()
+			return
+		}
+		var hits []fakeHit
+		for _, name := range indices {
+			index := es.indices[name]
+			for _, id := range index.ids {
+				hits = append(hits, fakeHit{ID: id, Source: index.docs[id]})
+			}
+		}
+		if _, counting := body["aggs"]; counting {
+			chunks := 0
+			for _, hit := range hits {
+				if docChunks, ok := hit.Source["docChunks"].([]interface{}); ok {
+					chunks += len(docChunks)
+				}
+			}
+			reply(http.StatusOK, map[string]interface{}{
+				"hits":         map[string]interface{}{"total": map[string]interface{}{"value": len(hits)}},
+				"aggregations": map[string]interface{}{"chunks": map[string]interface{}{"doc_count": chunks}},
+			})
+			return
+		}
+		scrollID := fmt.Sprintf("scroll-%d", len(es.scrolls))
+		es.scrolls[scrollID] = &fakeScroll{hits: hits, size: int(body["size"].(float64))}
+		es.replyScrollPage(reply, scrollID)
+
+	case parts[1] == "_doc":
+		index := es.indices[parts[0]]
+		if index == nil {
+			notFound()
+			return
+		}
+		id := parts[2]
+		switch r.Method {
+		case http.MethodPut, http.MethodPost:
+			index.put(id, body)
+			es.indexed = append(es.indexed, parts[0]+"/"+id)
+			reply(http.StatusOK, map[string]interface{}{"_id": id, "result": "created"})
+		case http.MethodDelete:
+			if _, exists := index.docs[id]; !exists {
+				notFound()
+				return
+			}
+			delete(index.docs, id)
+			index.ids = slices.DeleteFunc(index.ids, func(other string) bool { return other == id })
+			reply(http.StatusOK, map[string]interface{}{"_id": id, "result": "deleted"})
+		default:
+			doc, exists := index.docs[id]
+			if !exists {
+				notFound()
+				return
+			}
+			reply(http.StatusOK, map[string]interface{}{"_id": id, "found": true, "_source": doc})
+		}
+
+	default:
+		http.Error(w, "unexpected request "+r.Method+" "+r.URL.Path, http.StatusBadRequest)
+	}
+}
+
+// replyScrollPage answers with the next page of a scroll
+func (es *fakeElasticsearch) replyScrollPage(reply func(int, interface{}), scrollID string) {
+	scroll := es.scrolls[scrollID]
+	page := scroll.hits[:min(scroll.size, len(scroll.hits))]
+	scroll.hits = scroll.hits[len(page):]
+	reply(http.StatusOK, map[string]interface{}{
+		"_scroll_id": scrollID,
+		"hits":       map[string]interface{}{"hits": page},
+	})
+}
+
+func TestGetDocumentTitle(t *testing.T) {
+	client := newTestElasticsearchClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/documents/_doc/doc-1" {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"_index":"documents","found":false}`)
+			return
+		}
+		if r.URL.Query().Get("_source_includes") != "title" {
+			t.Errorf("requested source %q, want only the title", r.URL.Query().Get("_source_includes"))
+		}
+		fmt.Fprint(w, `{"_index":"documents","_id":"doc-1","found":true,"_source":{"title":"Annual Report"}}`)
+	})
+
+	title, err := client.GetDocumentTitle(context.Background(), "documents", "doc-1")
+	if err != nil || title != "Annual Report" {
+		t.Fatalf("got %q, %v", title, err)
+	}
+	if _, err := client.GetDocumentTitle(context.Background(), "documents", "missing"); err == nil {
+		t.Error("a missing document returned a title")
+	}
+}
+
+func TestScrollDocuments(t *testing.T) {
+	es := newFakeElasticsearch()
+	docs := make(map[string]map[string]interface{})
+	for i := 0; i < 7; i++ {
+		docs[fmt.Sprintf("doc-%d", i)] = map[string]interface{}{"title": fmt.Sprintf("Title %d", i)}
+	}
+	es.addIndex("documents", nil, docs)
+	client := newTestElasticsearchClient(t, es.handle)
+
+	var visited []string
+	err := client.ScrollDocuments(context.Background(), "documents", 3, nil, func(documentID string, _ json.RawMessage) error {
+		visited = append(visited, documentID)
+		return nil
+	})
+	if err != nil || len(visited) != 7 {
+		t.Fatalf("visited %v, %v; want all 7 documents over three pages", visited, err)
+	}
+
+	err = client.ScrollDocuments(context.Background(), "missing", 3, nil, func(string, json.RawMessage) error { return nil })
+	if err == nil {
+		t.Error("scrolling a missing index succeeded")
+	}
+}
+
+func TestCountDocumentsAndChunks(t *testing.T) {
+	es := newFakeElasticsearch()
+	es.addIndex("documents", nil, map[string]map[string]interface{}{
+		"a": {"docChunks": []interface{}{map[string]interface{}{}, map[string]interface{}{}}},
+		"b": {"docChunks": []interface{}{map[string]interface{}{}}},
+	})
+	client := newTestElasticsearchClient(t, es.handle)
+
+	documents, chunks, err := client.CountDocumentsAndChunks(context.Background(), "documents")
+	if err != nil || documents != 2 || chunks != 3 {
+		t.Fatalf("got %d documents and %d chunks, %v; want 2 and 3", documents, chunks, err)
+	}
+}
+
+func TestSwapAlias(t *testing.T) {
+	tests := []struct {
+		name            string
+		previousIndices []string
+		replaceIndex    string
+		want            []map[string]map[string]string
+	}{
+		{
+			name: "new alias",
+			want: []map[string]map[string]string{{"add": {"index": "docs-v2", "alias": "docs"}}},
+		},
+		{
+			name:            "move alias",
+			previousIndices: []string{"docs-v1"},
+			want: []map[string]map[string]string{
+				{"remove": {"index": "docs-v1", "alias": "docs"}},
+				{"add": {"index": "docs-v2", "alias": "docs"}},
+			},
+		},
+		{
+			name:         "replace index",
+			replaceIndex: "docs",
+			want: []map[string]map[string]string{
+				{"remove_index": {"index": "docs"}},
+				{"add": {"index": "docs-v2", "alias": "docs"}},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			es := newFakeElasticsearch()
+			client := newTestElasticsearchClient(t, es.handle)
+			if err := client.SwapAlias(context.Background(), "docs", "docs-v2", test.previousIndices, test.replaceIndex); err != nil {
+				t.Fatalf("SwapAlias: %v", err)
+			}
+			if len(es.aliasUpdates) != 1 {
+				t.Fatalf("sent %d alias updates, want one atomic update", len(es.aliasUpdates))
+			}
+			if !reflect.DeepEqual(es.aliasUpdates[0], test.want) {
+				t.Errorf("got actions %v, want %v", es.aliasUpdates[0], test.want)
+			}
+		})
+	}
+}
@@ -2,100 +2,275 @@ package main
 
 import "C"
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"os/exec"
-	"runtime"
-	"strconv"
+	"math"
 	"strings"
-	"sync"
-	"syscall"
 
 	"github.com/wailsapp/wails/v2/pkg/logger"
 )
 
-var (
-	llamaEmbedMutex sync.Mutex
-)
+// GenerateEmbedWithCancel generates embeddings for a given text, supporting cancellation via context.
+// Takes a context and a string input; returns an embedding slice or an error upon failure.
+// Cancels the operation if the context is done or the configured maximum runtime is reached.
+func GenerateEmbedWithCancel(ctx context.Context, llamaEmbedArgs LlamaEmbedArgs, appArgs DefaultAppArgs, text string) ([]float32, error) {
+	//Create a prompt for embedding. The separator is set explicitly because llama-embedding splits the
+	//prompt on newlines by default, which would turn a multi-line text into several embeddings.
+	llamaEmbedArgs.EmbedPromptCmd = "-p"
+	llamaEmbedArgs.EmbedPromptText = strings.ReplaceAll(text, embeddingBatchSeparator, " ")
+	llamaEmbedArgs.EmbedSeparatorCmd = "--embd-separator"
+	llamaEmbedArgs.EmbedSeparatorVal = embeddingBatchSeparator
+	llamaEmbedArgs.EmbedOutputFormatCmd = "--embd-output-format"
+	llamaEmbedArgs.EmbedOutputFormatVal = "json"
+	args := LlamaEmbedStructToArgs(llamaEmbedArgs)
+	if err := checkLlamaArgs(ctx, LlamaBinaryEmbedding, appArgs.LLamaEmbedCliPath, args); err != nil {
+		return nil, err
+	}
 
-func parseFloatArray(input string) []float32 {
-	// Clean up the input string by removing the outer brackets
-	input = strings.TrimSpace(input)
-	input = strings.TrimPrefix(input, "[[")
-	input = strings.TrimSuffix(input, "]]")
+	// Wait for an embedding slot; ingest yields to interactive queries between chunks. Invalid
+	// arguments are rejected above so they never hold up the queue.
+	release, err := workScheduler.Acquire(ctx, ResourceEmbed)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
 
-	// Split by comma to get individual float values
-	stringValues := strings.Split(input, ",")
+	output, err := RunProcess(ctx, ProcessSpec{
+		Name:       "llama-embedding",
+		Path:       appArgs.LLamaEmbedCliPath,
+		Args:       args,
+		MaxRuntime: maxProcessRuntime(appArgs),
+	})
+	if err != nil {
+		return nil, err
+	}
 
-	// Create the result slice with the appropriate capacity
-	result := make([]float32, 0, len(stringValues))
+	vectors, err := parseEmbeddingJSON(output)
+	if err != nil {
+		return nil, err
+	}
+	if len(vectors) != 1 {
+		return nil, fmt.Errorf("%w: llama-embedding returned %d embeddings for one text", ErrInvalidEmbedding, len(vectors))
+	}
+	return vectors[0], nil
+}
 
-	// Parse each value into a float32
-	for _, strVal := range stringValues {
-		// Remove any leading/trailing spaces
-		strVal = strings.TrimSpace(strVal)
+// embeddingBatchSeparator splits the prompts of a batched llama-embedding run. It is unlikely to
+// occur in document text and is removed from chunks that contain it.
+const embeddingBatchSeparator = "<#sep#>"
 
-		// Convert string to float64 first
-		floatVal, err := strconv.ParseFloat(strVal, 32)
-		if err != nil {
-			// Handle error or skip invalid values
-			continue
-		}
-		// Append as float32
-		result = append(result, float32(floatVal))
+// GenerateEmbedBatchWithCancel embeds several texts with a single llama-embedding run, so the model is
+// loaded once per batch instead of once per text. The vectors are returned in the order of texts.
+func GenerateEmbedBatchWithCancel(ctx context.Context, llamaEmbedArgs LlamaEmbedArgs, appArgs DefaultAppArgs, texts []string) ([][]float32, error) {
+	prompts := make([]string, len(texts))
+	for i, text := range texts {
+		prompts[i] = strings.ReplaceAll(text, embeddingBatchSeparator, " ")
+	}
+	llamaEmbedArgs.EmbedPromptCmd = "-p"
+	llamaEmbedArgs.EmbedPromptText = strings.Join(prompts, embeddingBatchSeparator)
+	llamaEmbedArgs.EmbedSeparatorCmd = "--embd-separator"
+	llamaEmbedArgs.EmbedSeparatorVal = embeddingBatchSeparator
+	llamaEmbedArgs.EmbedOutputFormatCmd = "--embd-output-format"
+	llamaEmbedArgs.EmbedOutputFormatVal = "json"
+	args := LlamaEmbedStructToArgs(llamaEmbedArgs)
+	if err := checkLlamaArgs(ctx, LlamaBinaryEmbedding, appArgs.LLamaEmbedCliPath, args); err != nil {
+		return nil, err
 	}
 
-	return result
-}
+	release, err := workScheduler.Acquire(ctx, ResourceEmbed)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
 
-// GenerateEmbedWithCancel generates embeddings for a given text, supporting cancellation via context.
-// Takes a context and a string input; returns an embedding slice or an error upon failure.
-// Cancels the operation if the context is done or the timeout is reached.
-// Executes an external embedding command asynchronously, gathering the result or handling cancellation.
-func GenerateEmbedWithCancel(ctx context.Context, llamaEmbedArgs LlamaEmbedArgs, appArgs DefaultAppArgs, text string) ([]float32, error) {
-	// Lock to prevent concurrent embed calls
-	llamaEmbedMutex.Lock()
-	defer llamaEmbedMutex.Unlock()
-
-	ctx, cancel := context.WithCancel(ctx)
-	defer cancel()
-	// Create a channel to capture the result
-	result := make(chan struct {
-		output []byte
-		err    error
+	output, err := RunProcess(ctx, ProcessSpec{
+		Name:       "llama-embedding",
+		Path:       appArgs.LLamaEmbedCliPath,
+		Args:       args,
+		MaxRuntime: maxProcessRuntime(appArgs),
 	})
-	//Create a prompt for embedding.
-	llamaEmbedArgs.EmbedPromptCmd = "-p"
-	llamaEmbedArgs.EmbedPromptText = text
-	args := LlamaEmbedStructToArgs(llamaEmbedArgs)
-	// Run the command in a goroutine
-	go func() {
-		cmd := exec.CommandContext(ctx, appArgs.LLamaEmbedCliPath, args...)
-		// Hide the window on Windows
-		if runtime.GOOS == "windows" {
-			cmd.SysProcAttr = &syscall.SysProcAttr{
-				HideWindow: true,
+	if err != nil {
+		return nil, err
+	}
+
+	vectors, err := parseEmbeddingJSON(output)
+	if err != nil {
+		return nil, err
+	}
+	if len(vectors) != len(texts) {
+		return nil, fmt.Errorf("%w: llama-embedding returned %d embeddings for %d texts", ErrInvalidEmbedding, len(vectors), len(texts))
+	}
+	return vectors, nil
+}
+
+// ErrInvalidEmbedding is returned when llama-embedding output is not a complete, well-formed set of vectors
+var ErrInvalidEmbedding = errors.New("invalid embedding")
+
+// embeddingOutput is the document llama-embedding prints with --embd-output-format json
+type embeddingOutput struct {
+	Object string `json:"object"`
+	Data   []struct {
+		Object    string    `json:"object"`
+		Index     int       `json:"index"`
+		Embedding []float64 `json:"embedding"`
+	} `json:"data"`
+}
+
+// parseEmbeddingJSON decodes the JSON document llama-embedding prints with --embd-output-format json.
+// Any line before the document is skipped, but nothing other than whitespace may follow it. Every
+// embedding must be present once, in index order, non-empty, finite and as long as the others.
+func parseEmbeddingJSON(output []byte) ([][]float32, error) {
+	start := -1
+	for offset := 0; offset < len(output); {
+		line := output[offset:]
+		if next := bytes.IndexByte(line, '\n'); next >= 0 {
+			line = line[:next+1]
+		}
+		if bytes.HasPrefix(bytes.TrimLeft(line, " \t\r"), []byte("{")) {
+			start = offset
+			break
+		}
+		offset += len(line)
+	}
+	if start < 0 {
+		return nil, fmt.Errorf("%w: no JSON document in llama-embedding output", ErrInvalidEmbedding)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(output[start:]))
+	var document embeddingOutput
+	if err := decoder.Decode(&document); err != nil {
+		if bytes.Contains(bytes.ToLower(output[start:]), []byte("nan")) {
+			return nil, fmt.Errorf("%w: llama-embedding output contains NaN values", ErrInvalidEmbedding)
+		}
+		return nil, fmt.Errorf("%w: failed to decode llama-embedding output: %v", ErrInvalidEmbedding, err)
+	}
+	if rest := bytes.TrimSpace(output[start+int(decoder.InputOffset()):]); len(rest) > 0 {
+		return nil, fmt.Errorf("%w: unexpected output after the embeddings: %.40q", ErrInvalidEmbedding, rest)
+	}
+	if document.Object != "list" {
+		return nil, fmt.Errorf("%w: llama-embedding output is not an embedding list", ErrInvalidEmbedding)
+	}
+	if len(document.Data) == 0 {
+		return nil, fmt.Errorf("%w: llama-embedding returned no embeddings", ErrInvalidEmbedding)
+	}
+
+	vectors := make([][]float32, len(document.Data))
+	for i, entry := range document.Data {
+		if entry.Index != i {
+			return nil, fmt.Errorf("%w: embedding %d has index %d", ErrInvalidEmbedding, i, entry.Index)
+		}
+		if len(entry.Embedding) == 0 {
+			return nil, fmt.Errorf("%w: embedding %d is empty", ErrInvalidEmbedding, i)
+		}
+		if len(entry.Embedding) != len(document.Data[0].Embedding) {
+			return nil, fmt.Errorf("%w: embedding %d has %d dimensions, expected %d", ErrInvalidEmbedding, i, len(entry.Embedding), len(document.Data[0].Embedding))
+		}
+		vector := make([]float32, len(entry.Embedding))
+		for j, value := range entry.Embedding {
+			vector[j] = float32(value)
+			if math.IsNaN(float64(vector[j])) || math.IsInf(float64(vector[j]), 0) {
+				return nil, fmt.Errorf("%w: embedding %d has a non-finite value at position %d", ErrInvalidEmbedding, i, j)
 			}
 		}
-		out, err := cmd.Output()
-		result <- struct {
-			output []byte
-			err    error
-		}{output: out, err: err}
-		close(result)
-	}()
+		vectors[i] = vector
+	}
+	return vectors, nil
+}
+
+// CheckEmbeddingDims returns an error when vector does not have the number of dimensions the index expects
+func CheckEmbeddingDims(vector []float32, dims int) error {
+	if len(vector) != dims {
+		return fmt.Errorf("%w: embedding has %d dimensions but the index expects %d; check that the embedding model matches the index", ErrInvalidEmbedding, len(vector), dims)
+	}
+	return nil
+}
+
+// EmbedTexts embeds texts in batches of batchSize, taking vectors from the embedding cache where it
+// can. A batch that fails is retried one text at a time so a single problem chunk does not lose the
+// whole batch. The result has a vector, or nil when the text could not be embedded, for every text in
+// order.
+func EmbedTexts(ctx context.Context, log logger.Logger, llamaEmbedArgs LlamaEmbedArgs, appArgs DefaultAppArgs, texts []string, batchSize int) ([][]float32, error) {
+	batchSize = max(batchSize, 1)
+	vectors := make([][]float32, len(texts))
+	cacheKeys := embeddingCache.Keys(llamaEmbedArgs, texts)
+
+	var pending []int
+	for i := range texts {
+		if cacheKeys != nil {
+			vectors[i] = embeddingCache.Get(cacheKeys[i])
+		}
+		if vectors[i] == nil {
+			pending = append(pending, i)
+		}
+	}
+	if cacheKeys != nil && len(pending) < len(texts) {
+		log.Info(fmt.Sprintf("Embedding cache supplied %d of %d chunks", len(texts)-len(pending), len(texts)))
+	}
 
-	select {
-	case res := <-result:
+	store := func(i int, vector []float32) {
+		vectors[i] = vector
+		if cacheKeys == nil {
+			return
+		}
+		if err := embeddingCache.Put(cacheKeys[i], vector); err != nil {
+			log.Warning("Failed to cache embedding: " + err.Error())
+		}
+	}
 
-		output := parseFloatArray(string(res.output))
-		return output, res.err
+	for start := 0; start < len(pending); start += batchSize {
+		batchIndexes := pending[start:min(start+batchSize, len(pending))]
+		if len(batchIndexes) > 1 {
+			batchTexts := make([]string, len(batchIndexes))
+			for j, i := range batchIndexes {
+				batchTexts[j] = texts[i]
+			}
+			batch, err := GenerateEmbedBatchWithCancel(ctx, llamaEmbedArgs, appArgs, batchTexts)
+			if err == nil {
+				for j, i := range batchIndexes {
+					store(i, batch[j])
+				}
+				continue
+			}
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			log.Warning(fmt.Sprintf("Failed to embed %d chunks as a batch, embedding them one at a time: %v", len(batchIndexes), err))
+		}
 
-	case <-ctx.Done():
-		// Context was canceled or timed out
-		return nil, ctx.Err()
+		for _, i := range batchIndexes {
+			vector, err := GenerateEmbedWithCancel(ctx, llamaEmbedArgs, appArgs, texts[i])
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			if err != nil {
+				log.Info(fmt.Sprintf("Failed to generate embedding for chunk %d: %v", i+1, err))
+				continue
+			}
+			store(i, vector)
+		}
 	}
+	return vectors, nil
+}
 
+// EmbedText returns the embedding of a single text, from the embedding cache when it is there
+func EmbedText(ctx context.Context, llamaEmbedArgs LlamaEmbedArgs, appArgs DefaultAppArgs, text string) ([]float32, error) {
+	cacheKeys := embeddingCache.Keys(llamaEmbedArgs, []string{text})
+	if cacheKeys != nil {
+		if vector := embeddingCache.Get(cacheKeys[0]); vector != nil {
+			return vector, nil
+		}
+	}
+	vector, err := GenerateEmbedWithCancel(ctx, llamaEmbedArgs, appArgs, text)
+	if err != nil {
+		return nil, err
+	}
+	if cacheKeys != nil {
+		_ = embeddingCache.Put(cacheKeys[0], vector) // the vector is good even when it cannot be cached
+	}
+	return vector, nil
 }
 
 func IngestTextData(log logger.Logger, appArgs DefaultAppArgs, sourceLocation string, chunkSize int, chunkOverlap int, enableStopWordRemoval bool) ([]Document, error) {
@@ -138,7 +313,7 @@ func IngestCVSData(log logger.Logger, appArgs DefaultAppArgs, sourceLocation str
 func IngestPdfData(log logger.Logger, appArgs DefaultAppArgs, sourceLocation string, chunkSize int, chunkOverlap int, enableStopWordRemoval bool) ([]Document, error) {
 
 	//Load xpdf exe
-	loader := NewPDFToTextLoader(sourceLocation).WithPDFToTextPath(appArgs.PDFToTextPath)
+	loader := NewPDFToTextLoader(sourceLocation).WithPDFToTextPath(appArgs.PDFToTextPath).WithMaxRuntime(maxProcessRuntime(appArgs))
 	//Create docs
 	documents, err := loader.Load(context.Background(), log, appArgs, enableStopWordRemoval)
 	if err != nil {
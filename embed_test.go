@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestGenerateEmbedWithCancelKeepsMultiLineTextTogether(t *testing.T) {
+	appArgs := DefaultAppArgs{LLamaEmbedCliPath: fakeLlamaEmbedding(t), ProcessMaxRuntimeMinutes: 1}
+	text := "first line\nsecond line\n\nlast line"
+
+	vector, err := GenerateEmbedWithCancel(context.Background(), LlamaEmbedArgs{}, appArgs, text)
+	if err != nil {
+		t.Fatalf("GenerateEmbedWithCancel: %v", err)
+	}
+	if len(vector) != 2 || vector[0] != float32(len(text)) {
+		t.Fatalf("got %v, want one embedding of the whole text", vector)
+	}
+}
+
+func TestGenerateEmbedBatchWithCancel(t *testing.T) {
+	appArgs := DefaultAppArgs{LLamaEmbedCliPath: fakeLlamaEmbedding(t), ProcessMaxRuntimeMinutes: 1}
+	texts := []string{"one\ntwo", "three", "four" + embeddingBatchSeparator + "five"}
+
+	vectors, err := GenerateEmbedBatchWithCancel(context.Background(), LlamaEmbedArgs{}, appArgs, texts)
+	if err != nil {
+		t.Fatalf("GenerateEmbedBatchWithCancel: %v", err)
+	}
+	if len(vectors) != len(texts) {
+		t.Fatalf("got %d embeddings for %d texts", len(vectors), len(texts))
+	}
+	for i, vector := range vectors {
+		if vector[1] != float32(i) {
+			t.Errorf("embedding %d has index %v", i, vector[1])
+		}
+	}
+}
+
+func TestGenerateEmbedRejectsBadFlagsWithoutWaitingForASlot(t *testing.T) {
+	appArgs := DefaultAppArgs{LLamaEmbedCliPath: fakeLlamaEmbedding(t), ProcessMaxRuntimeMinutes: 1}
+	embedArgs := LlamaEmbedArgs{EmbedPollCmd: "--poll", EmbedPollVal: "500"}
+
+	// Another run holds the only embedding slot
+	release, err := workScheduler.Acquire(context.Background(), ResourceEmbed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if _, err := GenerateEmbedWithCancel(ctx, embedArgs, appArgs, "text"); !errors.Is(err, ErrBadFlag) {
+		t.Fatalf("GenerateEmbedWithCancel: got %v, want ErrBadFlag", err)
+	}
+	if _, err := GenerateEmbedBatchWithCancel(ctx, embedArgs, appArgs, []string{"text"}); !errors.Is(err, ErrBadFlag) {
+		t.Fatalf("GenerateEmbedBatchWithCancel: got %v, want ErrBadFlag", err)
+	}
+}
+
+func TestParseEmbeddingJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string
+		want    int
+		wantErr bool
+	}{
+		{name: "single", output: `{"object":"list","data":[{"object":"embedding","index":0,"embedding":[0.1,0.2]}]}`, want: 1},
+		{name: "log lines before", output: "load: {not json}\n" + `{"object":"list","data":[{"index":0,"embedding":[1]},{"index":1,"embedding":[2]}]}`, want: 2},
+		{name: "no json", output: "error: model not found", wantErr: true},
+		{name: "empty list", output: `{"object":"list","data":[]}`, wantErr: true},
+		{name: "empty vector", output: `{"object":"list","data":[{"index":0,"embedding":[]}]}`, wantErr: true},
+		{name: "nan", output: `{"object":"list","data":[{"index":0,"embedding":[nan,1]}]}`, wantErr: true},
+		{name: "overflow", output: `{"object":"list","data":[{"index":0,"embedding":[1e300]}]}`, wantErr: true},
+		{name: "mixed lengths", output: `{"object":"list","data":[{"index":0,"embedding":[1,2]},{"index":1,"embedding":[1]}]}`, wantErr: true},
+		{name: "index order", output: `{"object":"list","data":[{"index":1,"embedding":[1]}]}`, wantErr: true},
+		{name: "trailing output", output: `{"object":"list","data":[{"index":0,"embedding":[1]}]}` + "\n[[1]]", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			vectors, err := parseEmbeddingJSON([]byte(test.output))
+			if test.wantErr {
+				if !errors.Is(err, ErrInvalidEmbedding) {
+					t.Fatalf("got %v, %v; want ErrInvalidEmbedding", vectors, err)
+				}
+				return
+			}
+			if err != nil || len(vectors) != test.want {
+				t.Fatalf("got %d vectors, %v; want %d", len(vectors), err, test.want)
+			}
+		})
+	}
+}
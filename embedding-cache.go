@@ -0,0 +1,330 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const embeddingCacheExtension = ".embedding"
+
+// EmbeddingCacheStats reports the size of the embedding cache and how often it has been used since startup
+type EmbeddingCacheStats struct {
+	Path       string `json:"path"`
+	Enabled    bool   `json:"enabled"`
+	Entries    int    `json:"entries"`
+	TotalBytes int64  `json:"totalBytes"`
+	MaxBytes   int64  `json:"maxBytes"`
+	Hits       int64  `json:"hits"`
+	Misses     int64  `json:"misses"`
+	Writes     int64  `json:"writes"`
+	Evictions  int64  `json:"evictions"`
+}
+
+// EmbeddingCache keeps embeddings on disk under EmbeddingCachePath, one file per vector named by the
+// hash of the model file's path, size and modification time, the pooling and normalize settings and
+// the text. Files live in subdirectories named after the first two characters of the hash. The model
+// file's contents are not hashed: replacing, moving or touching the file starts a fresh set of keys,
+// and the vectors stored under the old ones age out. The least recently used files are evicted once
+// the directory grows past EmbeddingCacheMaxSizeMB.
+type EmbeddingCache struct {
+	mu         sync.Mutex
+	path       string
+	maxBytes   int64
+	totalBytes int64 // -1 until the directory has been measured
+	stats      EmbeddingCacheStats
+}
+
+// embeddingCache is shared by the package-level embedding helpers
+var embeddingCache = &EmbeddingCache{totalBytes: -1}
+
+// Configure sets the cache directory and size limit; an empty path disables the cache
+func (c *EmbeddingCache) Configure(path string, maxSizeMB int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.path = path
+	c.maxBytes = int64(maxSizeMB) * 1024 * 1024
+	c.totalBytes = -1
+}
+
+// Enabled reports whether a cache directory is configured
+func (c *EmbeddingCache) Enabled() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.path != ""
+}
+
+// Keys hashes texts together with the path, size and modification time of the embedding model
+// file and the settings that change the vectors. It returns nil when the cache is disabled or the
+// model file cannot be read.
+func (c *EmbeddingCache) Keys(llamaEmbedArgs LlamaEmbedArgs, texts []string) []string {
+	if !c.Enabled() || llamaEmbedArgs.EmbedModelFullPathVal == "" {
+		return nil
+	}
+	modelPath := filepath.Clean(llamaEmbedArgs.EmbedModelFullPathVal)
+	info, err := os.Stat(modelPath)
+	if err != nil {
+		return nil
+	}
+	settings := strings.Join([]string{modelPath, strconv.FormatInt(info.Size(), 10), strconv.FormatInt(info.ModTime().UnixNano(), 10),
+		llamaEmbedArgs.EmbedPoolingVal, llamaEmbedArgs.EmbedNormalizeVal}, "|")
+
+	keys := make([]string, len(texts))
+	for i, text := range texts {
+		sum := sha256.Sum256([]byte(settings + "|" + text))
+		keys[i] = hex.EncodeToString(sum[:])
+	}
+	return keys
+}
+
+// Get returns the cached vector for key, or nil when there is none
+func (c *EmbeddingCache) Get(key string) []float32 {
+	file := c.file(key)
+	if file == "" {
+		return nil
+	}
+	data, err := os.ReadFile(file)
+	if err == nil {
+		if vector := decodeCachedEmbedding(data); vector != nil {
+			// Touch the file so the LRU order reflects reads as well as writes
+			now := time.Now()
+			_ = os.Chtimes(file, now, now)
+			c.count(func(stats *EmbeddingCacheStats) { stats.Hits++ })
+			return vector
+		}
+		_ = os.Remove(file) // truncated or corrupt; embed the text again
+	}
+	c.count(func(stats *EmbeddingCacheStats) { stats.Misses++ })
+	return nil
+}
+
+// Put stores vector under key and evicts old entries when the cache is over its size limit
+func (c *EmbeddingCache) Put(key string, vector []float32) error {
+	file := c.file(key)
+	if file == "" || len(vector) == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return fmt.Errorf("failed to create embedding cache directory: %w", err)
+	}
+
+	// Write to a temporary file first so a reader never sees a partial vector
+	data := encodeCachedEmbedding(vector)
+	temporary, err := os.CreateTemp(filepath.Dir(file), "tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to write embedding cache: %w", err)
+	}
+	_, writeErr := temporary.Write(data)
+	closeErr := temporary.Close()
+	if writeErr == nil {
+		writeErr = closeErr
+	}
+	if writeErr == nil {
+		writeErr = os.Rename(temporary.Name(), file)
+	}
+	if writeErr != nil {
+		_ = os.Remove(temporary.Name())
+		return fmt.Errorf("failed to write embedding cache: %w", writeErr)
+	}
+
+	c.mu.Lock()
+	c.stats.Writes++
+	overLimit := false
+	if c.totalBytes >= 0 {
+		c.totalBytes += int64(len(data))
+		overLimit = c.maxBytes > 0 && c.totalBytes > c.maxBytes
+	}
+	measured := c.totalBytes >= 0
+	c.mu.Unlock()
+
+	if overLimit || !measured {
+		return c.evict()
+	}
+	return nil
+}
+
+// Stats returns the usage counters together with the current size of the cache directory
+func (c *EmbeddingCache) Stats() (EmbeddingCacheStats, error) {
+	c.mu.Lock()
+	stats := c.stats
+	stats.Path = c.path
+	stats.Enabled = c.path != ""
+	stats.MaxBytes = c.maxBytes
+	c.mu.Unlock()
+	if !stats.Enabled {
+		return stats, nil
+	}
+
+	entries, err := c.listEntries()
+	if err != nil {
+		return stats, err
+	}
+	stats.Entries = len(entries)
+	for _, entry := range entries {
+		stats.TotalBytes += entry.size
+	}
+	return stats, nil
+}
+
+// Clear removes every cached embedding and resets the counters
+func (c *EmbeddingCache) Clear() error {
+	entries, err := c.listEntries()
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := os.Remove(entry.file); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove embedding cache file: %w", err)
+		}
+	}
+	c.mu.Lock()
+	c.stats = EmbeddingCacheStats{}
+	c.totalBytes = 0
+	c.mu.Unlock()
+	return nil
+}
+
+// evict measures the cache directory and deletes the least recently used files until it fits the
+// size limit, leaving a tenth of the limit free so the directory is not walked on every write
+func (c *EmbeddingCache) evict() error {
+	entries, err := c.listEntries()
+	if err != nil {
+		return err
+	}
+	var total int64
+	for _, entry := range entries {
+		total += entry.size
+	}
+
+	c.mu.Lock()
+	limit := c.maxBytes
+	c.mu.Unlock()
+
+	var evicted int64
+	if limit > 0 && total > limit {
+		target := limit - limit/10
+		sort.Slice(entries, func(i, j int) bool { return entries[i].lastUsed.Before(entries[j].lastUsed) })
+		for _, entry := range entries {
+			if total <= target {
+				break
+			}
+			if err := os.Remove(entry.file); err != nil && !os.IsNotExist(err) {
+				continue
+			}
+			total -= entry.size
+			evicted++
+		}
+	}
+
+	c.mu.Lock()
+	c.totalBytes = total
+	c.stats.Evictions += evicted
+	c.mu.Unlock()
+	return nil
+}
+
+type embeddingCacheEntry struct {
+	file     string
+	size     int64
+	lastUsed time.Time
+}
+
+func (c *EmbeddingCache) listEntries() ([]embeddingCacheEntry, error) {
+	c.mu.Lock()
+	root := c.path
+	c.mu.Unlock()
+	if root == "" {
+		return nil, nil
+	}
+
+	var entries []embeddingCacheEntry
+	err := filepath.WalkDir(root, func(path string, dirEntry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if dirEntry.IsDir() || !strings.HasSuffix(dirEntry.Name(), embeddingCacheExtension) {
+			return nil
+		}
+		info, err := dirEntry.Info()
+		if err != nil {
+			return nil
+		}
+		entries = append(entries, embeddingCacheEntry{file: path, size: info.Size(), lastUsed: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read embedding cache directory: %w", err)
+	}
+	return entries, nil
+}
+
+func (c *EmbeddingCache) file(key string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.path == "" || len(key) < 2 {
+		return ""
+	}
+	return filepath.Join(c.path, key[:2], key+embeddingCacheExtension)
+}
+
+func (c *EmbeddingCache) count(update func(stats *EmbeddingCacheStats)) {
+	c.mu.Lock()
+	update(&c.stats)
+	c.mu.Unlock()
+}
+
+// encodeCachedEmbedding stores a vector as little-endian float32 values
+func encodeCachedEmbedding(vector []float32) []byte {
+	data := make([]byte, 4*len(vector))
+	for i, value := range vector {
+		binary.LittleEndian.PutUint32(data[4*i:], math.Float32bits(value))
+	}
+	return data
+}
+
+// decodeCachedEmbedding returns nil for data that is not a complete, finite vector
+func decodeCachedEmbedding(data []byte) []float32 {
+	if len(data) == 0 || len(data)%4 != 0 {
+		return nil
+	}
+	vector := make([]float32, len(data)/4)
+	for i := range vector {
+		value := math.Float32frombits(binary.LittleEndian.Uint32(data[4*i:]))
+		if math.IsNaN(float64(value)) || math.IsInf(float64(value), 0) {
+			return nil
+		}
+		vector[i] = value
+	}
+	return vector
+}
+
+// GetEmbeddingCacheStats reports how many embeddings are cached and how often the cache was hit
+func (app *App) GetEmbeddingCacheStats() EmbeddingCacheStats {
+	stats, err := embeddingCache.Stats()
+	if err != nil {
+		app.log.Error("Failed to read embedding cache stats: " + err.Error())
+	}
+	return stats
+}
+
+// ClearEmbeddingCache removes all cached embeddings
+func (app *App) ClearEmbeddingCache() string {
+	if err := embeddingCache.Clear(); err != nil {
+		app.log.Error("Failed to clear embedding cache: " + err.Error())
+		return err.Error()
+	}
+	return "Embedding cache cleared"
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEmbeddingCacheKeys(t *testing.T) {
+	directory := t.TempDir()
+	modelPath := filepath.Join(directory, "model.gguf")
+	if err := os.WriteFile(modelPath, []byte("model"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cache := &EmbeddingCache{totalBytes: -1}
+	cache.Configure(filepath.Join(directory, "cache"), 1)
+	args := LlamaEmbedArgs{EmbedModelFullPathVal: modelPath, EmbedPoolingVal: "mean"}
+
+	keys := cache.Keys(args, []string{"a", "b", "a"})
+	if len(keys) != 3 || keys[0] == keys[1] || keys[0] != keys[2] {
+		t.Fatalf("unexpected keys %v", keys)
+	}
+	if cache.Keys(LlamaEmbedArgs{EmbedModelFullPathVal: modelPath, EmbedPoolingVal: "cls"}, []string{"a"})[0] == keys[0] {
+		t.Error("a different pooling setting kept the key")
+	}
+
+	// Touching the model file starts a fresh set of keys
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(modelPath, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if cache.Keys(args, []string{"a"})[0] == keys[0] {
+		t.Error("a modified model file kept the key")
+	}
+
+	if cache.Keys(LlamaEmbedArgs{EmbedModelFullPathVal: filepath.Join(directory, "missing.gguf")}, []string{"a"}) != nil {
+		t.Error("a missing model file produced keys")
+	}
+}
+
+func TestEmbeddingCachePutGet(t *testing.T) {
+	cache := &EmbeddingCache{totalBytes: -1}
+	cache.Configure(t.TempDir(), 1)
+	key := "ab0123"
+	vector := []float32{0.5, -1, 3}
+
+	if cache.Get(key) != nil {
+		t.Fatal("empty cache returned a vector")
+	}
+	if err := cache.Put(key, vector); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	got := cache.Get(key)
+	if len(got) != len(vector) || got[0] != 0.5 || got[1] != -1 || got[2] != 3 {
+		t.Fatalf("got %v, want %v", got, vector)
+	}
+	stats, err := cache.Stats()
+	if err != nil || stats.Entries != 1 || stats.Hits != 1 || stats.Misses != 1 || stats.Writes != 1 {
+		t.Errorf("unexpected stats %+v, %v", stats, err)
+	}
+}
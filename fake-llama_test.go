@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeLlamaEnv makes the test binary act as a llama.cpp executable when it is set
+const fakeLlamaEnv = "BYTE_VISION_FAKE_LLAMA"
+
+func TestMain(m *testing.M) {
+	switch os.Getenv(fakeLlamaEnv) {
+	case "embedding":
+		os.Exit(runFakeLlamaEmbedding(os.Args[1:]))
+	case "process":
+		os.Exit(runFakeProcess(os.Args[1:]))
+	}
+	os.Exit(m.Run())
+}
+
+// fakeProcess returns the path of an executable that runs the steps given as its arguments, see
+// runFakeProcess
+func fakeProcess(t *testing.T) string {
+	t.Helper()
+	t.Setenv(fakeLlamaEnv, "process")
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return executable
+}
+
+// runFakeProcess runs the steps in args in order:
+//
+//	stdin          copy standard input to stdout
+//	stderr TEXT    print a line to stderr
+//	sleep DURATION wait, e.g. "10s"
+//	spawn FILE     start a child process that appends to FILE until it is killed
+//	tick FILE      append to FILE every few milliseconds until killed
+//	exit CODE      exit with CODE
+func runFakeProcess(args []string) int {
+	for i := 0; i < len(args); i += 2 {
+		step, value := args[i], ""
+		if i+1 < len(args) {
+			value = args[i+1]
+		}
+		switch step {
+		case "stdin":
+			i--
+			if _, err := io.Copy(os.Stdout, os.Stdin); err != nil {
+				return 1
+			}
+		case "stderr":
+			fmt.Fprintln(os.Stderr, value)
+		case "sleep":
+			duration, _ := time.ParseDuration(value)
+			time.Sleep(duration)
+		case "spawn":
+			executable, _ := os.Executable()
+			if err := exec.Command(executable, "tick", value).Start(); err != nil {
+				return 1
+			}
+		case "tick":
+			for {
+				file, err := os.OpenFile(value, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+				if err != nil {
+					return 1
+				}
+				_, _ = file.WriteString(".")
+				_ = file.Close()
+				time.Sleep(10 * time.Millisecond)
+			}
+		case "exit":
+			code, _ := strconv.Atoi(value)
+			return code
+		}
+	}
+	return 0
+}
+
+// fakeLlamaEmbedding returns the path of an executable that behaves like llama-embedding: every
+// prompt, split on --embd-separator or on newlines by default, gets the vector [length, index]
+func fakeLlamaEmbedding(t *testing.T) string {
+	t.Helper()
+	t.Setenv(fakeLlamaEnv, "embedding")
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return executable
+}
+
+func runFakeLlamaEmbedding(args []string) int {
+	prompt, separator := "", "\n"
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--version":
+			fmt.Fprintln(os.Stderr, "version: 5535 (abc1234)")
+			return 0
+		case "--help":
+			for _, flag := range []string{"-m, --model", "-mu, --model-url", "-p, --prompt", "--embd-separator", "--embd-output-format", "--pooling", "--embd-normalize"} {
+				fmt.Println("   " + flag + " VALUE")
+			}
+			return 0
+		case "-p":
+			i++
+			prompt = args[i]
+		case "--embd-separator":
+			i++
+			separator = args[i]
+		}
+	}
+
+	type embedding struct {
+		Object    string    `json:"object"`
+		Index     int       `json:"index"`
+		Embedding []float64 `json:"embedding"`
+	}
+	output := struct {
+		Object string      `json:"object"`
+		Data   []embedding `json:"data"`
+	}{Object: "list"}
+	for i, text := range strings.Split(prompt, separator) {
+		output.Data = append(output.Data, embedding{Object: "embedding", Index: i, Embedding: []float64{float64(len(text)), float64(i)}})
+	}
+	fmt.Fprintln(os.Stderr, "llama_model_loader: loaded meta data")
+	if err := json.NewEncoder(os.Stdout).Encode(output); err != nil {
+		return 1
+	}
+	return 0
+}
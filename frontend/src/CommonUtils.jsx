@@ -1,9 +1,38 @@
 import { Document, Text, View, Page } from "@react-pdf/renderer";
+import { CancelJob, ListJobs } from "../wailsjs/go/main/App.js";
+
+/**
+ * Cancels every running backend job of the given kind (ingest, query, search, inference or ocr)
+ */
+export const cancelJobsOfKind = async (kind) => {
+  const jobs = (await ListJobs()) || [];
+  const running = jobs.filter((job) => job.kind === kind && job.status === "running");
+  return Promise.all(running.map((job) => CancelJob(job.requestId)));
+};
+
+/**
+ * Summarises the GGUF facts of a model for the model pickers
+ */
+export const formatModelFacts = (model) => {
+  if (!model) return "";
+  if (model.invalid) return `Invalid: ${model.error}`;
+  const facts = [
+    model.architecture,
+    model.parameterLabel && `${model.parameterLabel} params`,
+    model.quantizationType,
+    model.contextLength && `${model.contextLength} ctx`,
+    model.embeddingLength && `${model.embeddingLength} embd`,
+    model.tokenizerType && `${model.tokenizerType} tokenizer`,
+    model.chatTemplate && "chat template",
+  ];
+  return facts.filter(Boolean).join(" · ");
+};
 
 /**
  * Prompt types for different AI models
  */
 export const PROMPT_TYPES = [
+  "Auto",
   "Mistral",
   "LLAMA3",
   "Granite",
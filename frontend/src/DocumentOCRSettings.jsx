@@ -1,7 +1,8 @@
 import { useCallback, useState } from "react";
 import { Alert, Button, Card, Form, Spinner } from "react-bootstrap";
 
-import { ChooseFile, OCRFromPDF, CancelProcess } from "../wailsjs/go/main/App.js";
+import { ChooseFile, OCRFromPDF } from "../wailsjs/go/main/App.js";
+import { cancelJobsOfKind } from "./CommonUtils.jsx";
 import { LogError, LogInfo } from "../wailsjs/runtime/runtime.js";
 import "../public/main.css";
 
@@ -69,11 +70,9 @@ export const DocumentOCRSettings = () => {
   const handleCancel = useCallback(() => {
     if (abortController) {
       abortController.abort();
-      CancelProcess((result) => {
-        LogInfo(result);
-      }).catch((error) => {
-        LogError(error);
-      });
+      cancelJobsOfKind("ocr")
+        .then((results) => results.forEach((result) => LogInfo(result)))
+        .catch((error) => LogError(error));
       setAbortController(null);
       LogInfo("OCR processing cancelled by user..");
     }
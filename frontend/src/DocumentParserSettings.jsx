@@ -11,7 +11,7 @@ import {
   Spinner,
 } from "react-bootstrap";
 
-import { ChooseFileOrFolderWithToggle, CancelProcess } from "../wailsjs/go/main/App.js";
+import { ChooseFileOrFolderWithToggle } from "../wailsjs/go/main/App.js";
 import { LogError, LogInfo } from "../wailsjs/runtime/runtime.js";
 import "../public/main.css";
 
@@ -142,11 +142,6 @@ export const DocumentParserSettings = () => {
   const handleCancel = useCallback(() => {
     if (abortController) {
       abortController.abort();
-      CancelProcess((result) => {
-        LogInfo(result);
-      }).catch((error) => {
-        LogError(error);
-      });
       setAbortController(null);
       LogInfo("Document parsing cancelled by user..");
     }
@@ -1,5 +1,5 @@
 import { LogError, LogInfo } from "../wailsjs/runtime/runtime.js";
-import {  CancelProcess } from "../wailsjs/go/main/App.js";
+import { CancelJob } from "../wailsjs/go/main/App.js";
 import { EventsEmit, EventsOff, EventsOn } from "../wailsjs/runtime/runtime.js";
 
 export const createParserState = (set, get) => ({
@@ -140,6 +140,9 @@ export const createParserState = (set, get) => ({
 
         // Handle cancellation
         const handleAbort = () => {
+          CancelJob(requestId)
+              .then((result) => LogInfo(result))
+              .catch((error) => LogError(error));
           setParserError("Operation cancelled by user");
           setProcessingOutput("Operation cancelled by user");
           setProcessingStage("Cancelled");
@@ -182,14 +185,6 @@ export const createParserState = (set, get) => ({
     if (abortController) {
       abortController.abort();
 
-      CancelProcess()
-          .then((result) => {
-            LogInfo(result);
-          })
-          .catch((error) => {
-            LogError(error);
-          });
-
       setProcessing(false);
       setAbortController(null);
       LogInfo("Document processing cancelled by user");
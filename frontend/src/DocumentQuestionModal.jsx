@@ -347,7 +347,7 @@ const DocumentQuestionModal = ({
                     color: "inherit",
                     margin: 0,
                     fontSize: "inherit"
-                }} source={doc.selectedHistoryItem.response} />
+                }} source={doc.selectedHistoryItem.answer || doc.selectedHistoryItem.response} />
               </div>
             </div>
           </div>
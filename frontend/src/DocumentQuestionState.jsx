@@ -8,7 +8,7 @@ import {
     LogError,
     LogInfo,
 } from "../wailsjs/runtime/runtime.js";
-import { CancelProcess, GetDocumentQuestionResponse} from "../wailsjs/go/main/App.js";
+import { CancelAll, CancelJob, GetDocumentQuestionResponse} from "../wailsjs/go/main/App.js";
 
 import { useSettingsState } from "./StoreConfig.jsx";
 import { LEGAL_KEYWORDS, DOC_PROMPTS, PDFReportDocument, PDFExportDocument } from "./CommonUtils.jsx";
@@ -75,6 +75,9 @@ export const useDocumentQuestionState = ({
     const [eventListenersInitialized, setEventListenersInitialized] = useState(false);
 
     const eventListenersRef = useRef(false);
+    // The token event is shared with the inference view, so it is unsubscribed through its own handle
+    // rather than with EventsOff, which would remove the other view's listener as well
+    const tokenListenerOffRef = useRef(null);
 
     // Document history operations - moved up before event handlers
     const loadDocumentHistory = useCallback(async () => {
@@ -221,6 +224,40 @@ export const useDocumentQuestionState = ({
         }
     }, [isProcessing]);
 
+    // Append streamed text to the loading assistant message of the same request
+    const handleDocumentQueryToken = useCallback((tokenData) => {
+        try {
+            const requestId = tokenData?.requestId || null;
+            const token = tokenData?.token || "";
+            const reset = Boolean(tokenData?.reset);
+            // Inference completions stream on the same event; only document requests have a loading message here
+            if (!requestId || (!token && !reset)) return;
+
+            setChatHistory(prevHistory => {
+                const loadingIndex = prevHistory.findIndex(
+                    (msg) => msg.isLoading && msg.requestId === requestId
+                );
+                if (loadingIndex === -1) return prevHistory;
+
+                const newHistory = [...prevHistory];
+                const loadingMessage = newHistory[loadingIndex];
+                // A reset discards the text of a structured-output attempt that failed validation
+                newHistory[loadingIndex] = reset ? {
+                    ...loadingMessage,
+                    content: "Reply did not match the schema, retrying...",
+                    isStreaming: false,
+                } : {
+                    ...loadingMessage,
+                    content: (loadingMessage.isStreaming ? loadingMessage.content : "") + token,
+                    isStreaming: true,
+                };
+                return newHistory;
+            });
+        } catch (err) {
+            LogError(`Error handling document query token: ${err?.message || err}`);
+        }
+    }, []);
+
     // Event handling initialization - now has access to both handlers
     const initializeDocumentQueryListeners = useCallback(() => {
         if (eventListenersRef.current) {
@@ -233,11 +270,12 @@ export const useDocumentQuestionState = ({
 
         EventsOn("query-document-response", handleDocumentQueryResponse);
         EventsOn("query-document-progress", handleDocumentQueryProgress);
+        tokenListenerOffRef.current = EventsOn("inference-completion-token", handleDocumentQueryToken);
 
         eventListenersRef.current = true;
         setEventListenersInitialized(true);
         LogInfo("Document query event listeners initialized");
-    }, [handleDocumentQueryResponse, handleDocumentQueryProgress]);
+    }, [handleDocumentQueryResponse, handleDocumentQueryProgress, handleDocumentQueryToken]);
 
     // Cleanup on unmount
     useEffect(() => {
@@ -245,6 +283,8 @@ export const useDocumentQuestionState = ({
             if (eventListenersRef.current) {
                 EventsOff("query-document-response");
                 EventsOff("query-document-progress");
+                tokenListenerOffRef.current?.();
+                tokenListenerOffRef.current = null;
                 eventListenersRef.current = false;
             }
         };
@@ -397,7 +437,7 @@ export const useDocumentQuestionState = ({
 
         try {
             LogInfo("Cancelling document query");
-            await CancelProcess();
+            LogInfo(currentRequestId ? await CancelJob(currentRequestId) : await CancelAll());
 
             // Update any loading messages
             setChatHistory(prev =>
@@ -416,7 +456,7 @@ export const useDocumentQuestionState = ({
             setLoading(false);
             setCurrentRequestId(null);
         }
-    }, [isProcessing]);
+    }, [isProcessing, currentRequestId]);
 
     // Reset state when modal opens/closes
     useEffect(() => {
@@ -599,7 +639,7 @@ export const useDocumentQuestionState = ({
                 embedPrompt: selectedHistoryItem.embedPrompt,
                 docPrompt: selectedHistoryItem.docPrompt,
                 keywords: selectedHistoryItem.keywords,
-                response: selectedHistoryItem.response,
+                response: selectedHistoryItem.answer || selectedHistoryItem.response,
                 id: selectedHistoryItem._id?.$oid || selectedHistoryItem._id,
             };
 
@@ -11,7 +11,6 @@ import {
 } from "react-bootstrap";
 
 import {
-  CancelProcess,
   GetAllIndices,
   GetDocumentsByFieldsSettings,
 } from "../wailsjs/go/main/App.js";
@@ -25,6 +24,7 @@ import {
   useUIState,
 } from "./StoreConfig.jsx";
 import PDFViewerModal from "./PDFViewerModal.jsx";
+import { cancelJobsOfKind } from "./CommonUtils.jsx";
 
 // Constants for better maintainability
 const INITIAL_FORM_STATE = {
@@ -375,8 +375,8 @@ const DocumentSearchForm = () => {
   const handleCancel = useCallback(() => {
     if (abortControllerRef.current) {
       abortControllerRef.current.abort();
-      CancelProcess()
-        .then((result) => LogInfo(result))
+      cancelJobsOfKind("search")
+        .then((results) => results.forEach((result) => LogInfo(result)))
         .catch((error) => LogError(error));
       setSearching(false);
       setAbortController(null);
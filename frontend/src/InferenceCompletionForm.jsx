@@ -16,11 +16,13 @@ import { LogError } from "../wailsjs/runtime/runtime.js";
 import "../public/main.css";
 import ChatMessage from "./ChatMessageComponent.jsx";
 import { useInferenceState } from "./InferenceCompletionState.jsx";
-import { PROMPT_TYPES, PDFExportDocument } from "./CommonUtils.jsx";
+import { useSettingsState } from "./StoreConfig.jsx";
+import { PDFExportDocument } from "./CommonUtils.jsx";
 
 const InferenceCompletionForm = () => {
     // Use the dedicated inference state hook
     const inference = useInferenceState();
+    const { promptTypes, loadPromptTypes } = useSettingsState();
 
     // Local component state
     const [initError, setInitError] = useState(null);
@@ -49,12 +51,17 @@ const InferenceCompletionForm = () => {
         }
     }, [inference.settingsLoading, inference.isInitialized, inference.initializeInference, inference]);
 
+    // Load the prompt types stored in the database
+    useEffect(() => {
+        loadPromptTypes();
+    }, [loadPromptTypes]);
+
     // Set default prompt type
     useEffect(() => {
-        if (PROMPT_TYPES.length > 0 && !inference.selectedPromptType) {
-            inference.setSelectedPromptType(PROMPT_TYPES[0]);
+        if (promptTypes.length > 0 && !inference.selectedPromptType) {
+            inference.setSelectedPromptType(promptTypes[0]);
         }
-    }, [inference, inference.selectedPromptType, inference.setSelectedPromptType]);
+    }, [inference, promptTypes, inference.selectedPromptType, inference.setSelectedPromptType]);
 
     // Handlers
     const onSubmit = useCallback((e) => {
@@ -313,7 +320,7 @@ const onExportPDF = useCallback(async () => {
                                                 className="theme-form-control"
                                                 size="sm"
                                             >
-                                                {PROMPT_TYPES.map((type) => (
+                                                {promptTypes.map((type) => (
                                                     <option key={type} value={type}>
                                                         {type}
                                                     </option>
@@ -7,7 +7,7 @@ import {
   LogError,
   LogInfo,
 } from "../wailsjs/runtime/runtime.js";
-import { GetInferenceHistory, CancelProcess } from "../wailsjs/go/main/App.js";
+import { GetInferenceHistory, CancelAll, CancelJob } from "../wailsjs/go/main/App.js";
 
 import { useSettingsState } from "./StoreConfig.jsx";
 
@@ -35,7 +35,7 @@ const extractUserQuestion = (questionText) => {
 
 const formatChatData = (chat) => ({
   _id: chat._id || chat.id || `chat_${Date.now()}_${Math.random()}`,
-  response: chat.response || chat.answer || chat.content || "",
+  response: chat.answer || chat.response || chat.content || "",
   args: chat.args || INFERENCE_CLI_ARGS,
   question: chat.question || chat.query || "",
   createdAt: chat.createdAt || chat.timestamp || new Date().toISOString(),
@@ -92,6 +92,7 @@ export const useInferenceState = () => {
   const [eventListenersInitialized, setEventListenersInitialized] = useState(false);
 
   const eventListenersRef = useRef(false);
+  const tokenListenerOffRef = useRef(null);
 
   // Response and progress handlers
     const handleInferenceResponse = useCallback((response) => {
@@ -133,6 +134,7 @@ export const useInferenceState = () => {
                             ...newMessages[loadingIndex],
                             content: result,
                             isLoading: false,
+                            isStreaming: false,
                             processingTime,
                             requestId,
                         };
@@ -175,6 +177,39 @@ export const useInferenceState = () => {
         }
     }, []);
 
+  // Append streamed text to the loading assistant message for the same request
+  const handleInferenceToken = useCallback((tokenData) => {
+    try {
+      const requestId = tokenData?.requestId || null;
+      const token = tokenData?.token || "";
+      const reset = Boolean(tokenData?.reset);
+      if (!token && !reset) return;
+
+      setMessages(prevMessages => {
+        const loadingIndex = prevMessages.findIndex(
+            (m) => m.role === "assistant" && m.isLoading && (!requestId || m.requestId === requestId)
+        );
+        if (loadingIndex === -1) return prevMessages;
+
+        const newMessages = [...prevMessages];
+        const loadingMessage = newMessages[loadingIndex];
+        // A reset discards the text of a structured-output attempt that failed validation
+        newMessages[loadingIndex] = reset ? {
+          ...loadingMessage,
+          content: "Reply did not match the schema, retrying...",
+          isStreaming: false,
+        } : {
+          ...loadingMessage,
+          content: (loadingMessage.isStreaming ? loadingMessage.content : "") + token,
+          isStreaming: true,
+        };
+        return newMessages;
+      });
+    } catch (err) {
+      LogError(`Error handling inference token: ${err?.message || err}`);
+    }
+  }, []);
+
   const handleInferenceProgress = useCallback((progressData) => {
     try {
       const currentProgress = progressData?.progress || 0;
@@ -222,10 +257,12 @@ export const useInferenceState = () => {
 
     EventsOn("inference-completion-response", handleInferenceResponse);
     EventsOn("inference-completion-progress", handleInferenceProgress);
+    // The document question view also listens for tokens, so this listener is removed through its own handle
+    tokenListenerOffRef.current = EventsOn("inference-completion-token", handleInferenceToken);
 
     eventListenersRef.current = true;
     setEventListenersInitialized(true);
-  }, [handleInferenceResponse, handleInferenceProgress]);
+  }, [handleInferenceResponse, handleInferenceProgress, handleInferenceToken]);
 
   // Cleanup on unmount
   useEffect(() => {
@@ -233,6 +270,8 @@ export const useInferenceState = () => {
       if (eventListenersRef.current) {
         EventsOff("inference-completion-response");
         EventsOff("inference-completion-progress");
+        tokenListenerOffRef.current?.();
+        tokenListenerOffRef.current = null;
         eventListenersRef.current = false;
       }
     };
@@ -372,7 +411,7 @@ export const useInferenceState = () => {
       }
 
       // Cancel the process
-      await CancelProcess();
+      LogInfo(currentRequestId ? await CancelJob(currentRequestId) : await CancelAll());
     } catch (err) {
       LogError(`Cancel failed: ${err?.message || err}`);
     } finally {
@@ -381,7 +420,7 @@ export const useInferenceState = () => {
       setCurrentRequestId(null);
       setProgress(null);
     }
-  }, [messages]);
+  }, [messages, currentRequestId]);
 
   // History operations
   const loadSavedChats = useCallback(async () => {
@@ -5,6 +5,7 @@ import { LogError } from "../wailsjs/runtime/runtime.js";
 import "../public/main.css";
 
 import { useSettingsState } from "./StoreConfig.jsx";
+import { formatModelFacts } from "./CommonUtils.jsx";
 
 export const LlamaCliSettingsForm = () => {
     const {
@@ -666,14 +667,66 @@ export const LlamaCliSettingsForm = () => {
                                     >
                                         <option value="">Select a model...</option>
                                         {models.map((model) => (
-                                            <option key={model.id} value={model.FullPath}>
-                                                {model.ModelName} - {model.FullPath}
+                                            <option key={model.id} value={model.FullPath} disabled={model.invalid}>
+                                                {model.ModelName} - {formatModelFacts(model) || model.FullPath}
                                             </option>
                                         ))}
                                     </Form.Select>
+                                    {selectedModel && (
+                                        <Form.Text
+                                            style={{
+                                                color: "var(--text-quaternary)",
+                                                fontSize: "0.75rem",
+                                            }}
+                                        >
+                                            {formatModelFacts(models.find((model) => model.FullPath === selectedModel))}
+                                        </Form.Text>
+                                    )}
                                 </Form.Group>
                             </div>
 
+                            {/* Completion Backend */}
+                            <Card className="theme-nested-card theme-spacing-md">
+                                <Card.Body className="p-3">
+                                    <h6 className="theme-section-title">
+                                        <i className="bi bi-hdd-network me-1"></i>
+                                        Completion Backend
+                                    </h6>
+
+                                    {renderFormField("Backend", "", "BackendVal", {
+                                        value: llamaCliSettings.BackendVal,
+                                        onChange: (value) => handleChange("BackendVal", value),
+                                        options: [
+                                            { value: "", label: "Default" },
+                                            { value: "llama-cli", label: "llama-cli" },
+                                            { value: "llama-server", label: "llama-server" },
+                                            { value: "openai", label: "OpenAI-compatible" },
+                                        ],
+                                        helperText:
+                                            "Default uses llama-server when LLamaServerPath is configured, otherwise llama-cli",
+                                    })}
+
+                                    {llamaCliSettings.BackendVal === "openai" && (
+                                        <>
+                                            {renderFormField("Server URL", "", "BackendUrlVal", {
+                                                type: "text",
+                                                value: llamaCliSettings.BackendUrlVal,
+                                                onChange: (value) => handleChange("BackendUrlVal", value),
+                                                placeholder: "http://localhost:11434",
+                                                helperText: "Base URL of a /v1/chat/completions server (vLLM, Ollama, ...)",
+                                            })}
+
+                                            {renderFormField("Model", "", "BackendModelVal", {
+                                                type: "text",
+                                                value: llamaCliSettings.BackendModelVal,
+                                                onChange: (value) => handleChange("BackendModelVal", value),
+                                                helperText: "Model name sent with each request; set BackendAPIKey in the app config when the server needs a bearer token",
+                                            })}
+                                        </>
+                                    )}
+                                </Card.Body>
+                            </Card>
+
                             {/* Essential Settings */}
                             <Card className="theme-nested-card theme-spacing-md">
                                 <Card.Body className="p-3">
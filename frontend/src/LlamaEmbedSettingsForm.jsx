@@ -5,6 +5,7 @@ import { LogError } from "../wailsjs/runtime/runtime.js";
 import "../public/main.css";
 
 import { useSettingsState } from "./StoreConfig.jsx";
+import { formatModelFacts } from "./CommonUtils.jsx";
 
 export const LlamaEmbedSettingsForm = () => {
     const {
@@ -642,11 +643,21 @@ export const LlamaEmbedSettingsForm = () => {
                                     >
                                         <option value="">Select a model...</option>
                                         {models.map((model) => (
-                                            <option key={model.id} value={model.FullPath}>
-                                                {model.ModelName} - {model.FullPath}
+                                            <option key={model.id} value={model.FullPath} disabled={model.invalid}>
+                                                {model.ModelName} - {formatModelFacts(model) || model.FullPath}
                                             </option>
                                         ))}
                                     </Form.Select>
+                                    {selectedModel && (
+                                        <Form.Text
+                                            style={{
+                                                color: "var(--text-quaternary)",
+                                                fontSize: "0.75rem",
+                                            }}
+                                        >
+                                            {formatModelFacts(models.find((model) => model.FullPath === selectedModel))}
+                                        </Form.Text>
+                                    )}
                                 </Form.Group>
                             </div>
 
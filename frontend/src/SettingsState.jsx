@@ -1,12 +1,14 @@
 import { LogError, LogInfo, LogWarning } from "../wailsjs/runtime/runtime.js";
 import {
   GetDefaultSettings,
-  GetModelFiles,
+  GetModelDescriptors,
   GetSavedCliSettings,
   GetSavedEmbedSettings,
+  ListPromptTemplates,
   SaveCliSettings,
   SaveEmbedSettings,
 } from "../wailsjs/go/main/App.js";
+import { PROMPT_TYPES } from "./CommonUtils.jsx";
 
 export const createSettingsState = (set, get) => ({
   // Settings state
@@ -20,7 +22,8 @@ export const createSettingsState = (set, get) => ({
   settingsError: null,
   initialized: false,
 
-  selectedPromptType: "",
+  selectedPromptType: "Auto",
+  promptTypes: PROMPT_TYPES,
 
   // Models and saved settings state
   models: [],
@@ -51,6 +54,11 @@ export const createSettingsState = (set, get) => ({
       state.selectedPromptType = promptType;
     }),
 
+  setPromptTypes: (promptTypes) =>
+    set((state) => {
+      state.promptTypes = promptTypes;
+    }),
+
   // Basic actions
   setSettings: (newSettings) =>
     set((state) => {
@@ -188,12 +196,25 @@ export const createSettingsState = (set, get) => ({
 
     try {
       setModelsLoading(true);
-      const result = await GetModelFiles();
+      const result = (await GetModelDescriptors()) || [];
+
+      result
+        .filter((item) => !item.valid)
+        .forEach((item) => LogWarning(`Rejected model ${item.FileName}: ${item.error}`));
 
       const formattedModels = result.map((item) => ({
         id: item.FullPath.replace(/\\/g, "/"),
         ModelName: item.FileName,
         FullPath: item.FullPath.replace(/\\/g, "/"),
+        invalid: !item.valid,
+        error: item.error,
+        architecture: item.architecture,
+        parameterLabel: item.parameterLabel,
+        quantizationType: item.quantizationType,
+        contextLength: item.contextLength,
+        embeddingLength: item.embeddingLength,
+        tokenizerType: item.tokenizerType,
+        chatTemplate: item.chatTemplate,
       }));
 
       setModels(formattedModels);
@@ -206,6 +227,22 @@ export const createSettingsState = (set, get) => ({
     }
   },
 
+  // Prompt types stored in the prompt-templates collection; Auto is always offered first
+  loadPromptTypes: async () => {
+    const { setPromptTypes } = get();
+
+    try {
+      const templates = (await ListPromptTemplates()) || [];
+      const promptTypes = ["Auto", ...templates.map((item) => item.name)];
+      setPromptTypes(promptTypes);
+      return promptTypes;
+    } catch (error) {
+      LogError(`Failed to load prompt templates: ${error}`);
+      setPromptTypes(PROMPT_TYPES);
+      return PROMPT_TYPES;
+    }
+  },
+
   // Saved CLI settings
   loadSavedCliSettings: async () => {
     const { setSavedSettingsLoading, setSavedCliSettings } = get();
@@ -448,6 +485,13 @@ export const createSettingsState = (set, get) => ({
       errors.GPULayersCmd = "GPU Layers Command cannot be empty.";
     }
 
+    if (
+      currentSettings.BackendVal === "openai" &&
+      (!currentSettings.BackendUrlVal || currentSettings.BackendUrlVal.trim() === "")
+    ) {
+      errors.BackendUrlVal = "Server URL is required for an OpenAI-compatible backend.";
+    }
+
     return {
       isValid: Object.keys(errors).length === 0,
       errors,
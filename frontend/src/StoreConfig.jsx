@@ -129,6 +129,8 @@ const selectSettingsState = (state) => ({
 
     selectedPromptType: state.selectedPromptType,
     setSelectedPromptType: state.setSelectedPromptType,
+    promptTypes: state.promptTypes,
+    loadPromptTypes: state.loadPromptTypes,
 
     // Models
     models: state.models,
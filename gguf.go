@@ -0,0 +1,573 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	ggufMagic            = "GGUF"
+	ggufDefaultAlignment = 32
+	ggufMaxStringLength  = 64 * 1024 * 1024 // chat templates are a few KB; anything this long is corrupt
+	ggufMaxArrayLength   = 64 * 1024 * 1024
+	ggufMaxKeyValues     = 1 << 16
+	ggufMaxTensors       = 1 << 20
+	ggufMaxDimensions    = 4
+)
+
+// GGUF metadata value types
+const (
+	ggufTypeUint8 uint32 = iota
+	ggufTypeInt8
+	ggufTypeUint16
+	ggufTypeInt16
+	ggufTypeUint32
+	ggufTypeInt32
+	ggufTypeFloat32
+	ggufTypeBool
+	ggufTypeString
+	ggufTypeArray
+	ggufTypeUint64
+	ggufTypeInt64
+	ggufTypeFloat64
+)
+
+// ggufFileTypes names the values of general.file_type (llama_ftype)
+var ggufFileTypes = map[uint64]string{
+	0: "F32", 1: "F16", 2: "Q4_0", 3: "Q4_1", 7: "Q8_0", 8: "Q5_0", 9: "Q5_1",
+	10: "Q2_K", 11: "Q3_K_S", 12: "Q3_K_M", 13: "Q3_K_L", 14: "Q4_K_S", 15: "Q4_K_M",
+	16: "Q5_K_S", 17: "Q5_K_M", 18: "Q6_K", 19: "IQ2_XXS", 20: "IQ2_XS", 21: "Q2_K_S",
+	22: "IQ3_XS", 23: "IQ3_XXS", 24: "IQ1_S", 25: "IQ4_NL", 26: "IQ3_S", 27: "IQ3_M",
+	28: "IQ2_S", 29: "IQ2_M", 30: "IQ4_XS", 31: "IQ1_M", 32: "BF16", 36: "TQ1_0",
+	37: "TQ2_0", 38: "MXFP4_MOE",
+}
+
+// ggmlTensorTypes names the tensor types (ggml_type), used when general.file_type is missing
+var ggmlTensorTypes = map[uint32]string{
+	0: "F32", 1: "F16", 2: "Q4_0", 3: "Q4_1", 6: "Q5_0", 7: "Q5_1", 8: "Q8_0", 9: "Q8_1",
+	10: "Q2_K", 11: "Q3_K", 12: "Q4_K", 13: "Q5_K", 14: "Q6_K", 15: "Q8_K", 16: "IQ2_XXS",
+	17: "IQ2_XS", 18: "IQ3_XXS", 19: "IQ1_S", 20: "IQ4_NL", 21: "IQ3_S", 22: "IQ2_S",
+	23: "IQ4_XS", 24: "I8", 25: "I16", 26: "I32", 27: "I64", 28: "F64", 29: "IQ1_M",
+	30: "BF16", 34: "TQ1_0", 35: "TQ2_0", 39: "MXFP4",
+}
+
+// ModelDescriptor describes a model file and the facts read from its GGUF header. Files that are not
+// valid GGUF models have Valid set to false and the reason in Error.
+type ModelDescriptor struct {
+	FileName   string    `json:"FileName"`
+	FullPath   string    `json:"FullPath"`
+	SizeBytes  int64     `json:"sizeBytes"`
+	ModifiedAt time.Time `json:"modifiedAt"`
+	Valid      bool      `json:"valid"`
+	Error      string    `json:"error,omitempty"`
+
+	GGUFVersion      uint32 `json:"ggufVersion,omitempty"`
+	Name             string `json:"name,omitempty"`
+	Architecture     string `json:"architecture,omitempty"`
+	ParameterCount   uint64 `json:"parameterCount,omitempty"`
+	ParameterLabel   string `json:"parameterLabel,omitempty"` // e.g. 7.24B
+	QuantizationType string `json:"quantizationType,omitempty"`
+	ContextLength    uint64 `json:"contextLength,omitempty"` // context length the model was trained with
+	EmbeddingLength  uint64 `json:"embeddingLength,omitempty"`
+	TokenizerType    string `json:"tokenizerType,omitempty"`
+	ChatTemplate     string `json:"chatTemplate,omitempty"`
+	BOSToken         string `json:"bosToken,omitempty"`
+	EOSToken         string `json:"eosToken,omitempty"`
+	AddBOSToken      bool   `json:"addBosToken"` // llama.cpp prepends BOSToken when tokenizing a prompt
+}
+
+// GGUFMetadata holds the scalar key/value pairs and tensor summary of a GGUF file. Array values are
+// skipped, only their lengths are kept.
+type GGUFMetadata struct {
+	Version        uint32
+	Values         map[string]interface{}
+	ArrayLengths   map[string]uint64
+	TensorCount    uint64
+	ParameterCount uint64
+	TensorTypes    map[uint32]uint64 // elements stored per tensor type
+	BOSToken       string
+	EOSToken       string
+}
+
+// ReadGGUFMetadata parses the header, metadata and tensor infos of the GGUF file at path
+func ReadGGUFMetadata(path string) (GGUFMetadata, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return GGUFMetadata{}, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return GGUFMetadata{}, err
+	}
+
+	reader := &ggufReader{r: bufio.NewReaderSize(file, 1024*1024)}
+	metadata, err := reader.readMetadata()
+	if err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return metadata, fmt.Errorf("file is truncated")
+		}
+		return metadata, err
+	}
+
+	alignment := uint64(ggufDefaultAlignment)
+	if value, ok := ggufUint(metadata.Values["general.alignment"]); ok && value > 0 {
+		alignment = value
+	}
+	dataStart := (reader.offset + alignment - 1) / alignment * alignment
+	if metadata.TensorCount > 0 && dataStart >= uint64(info.Size()) {
+		return metadata, fmt.Errorf("file is truncated: tensor data is missing")
+	}
+	return metadata, nil
+}
+
+type ggufReader struct {
+	r      *bufio.Reader
+	offset uint64
+	tokens []string // the vocabulary, kept only to resolve the BOS and EOS token ids
+}
+
+func (g *ggufReader) readMetadata() (GGUFMetadata, error) {
+	metadata := GGUFMetadata{
+		Values:       make(map[string]interface{}),
+		ArrayLengths: make(map[string]uint64),
+		TensorTypes:  make(map[uint32]uint64),
+	}
+
+	magic := make([]byte, 4)
+	if err := g.read(magic); err != nil {
+		return metadata, fmt.Errorf("not a GGUF file: %w", err)
+	}
+	if string(magic) != ggufMagic {
+		return metadata, fmt.Errorf("not a GGUF file: missing GGUF magic bytes")
+	}
+
+	version, err := g.uint32()
+	if err != nil {
+		return metadata, err
+	}
+	switch {
+	case version == 1:
+		return metadata, fmt.Errorf("GGUF version 1 is no longer supported by llama.cpp")
+	case version > 0xFFFF:
+		return metadata, fmt.Errorf("big-endian GGUF files are not supported")
+	case version > 3:
+		return metadata, fmt.Errorf("unsupported GGUF version %d", version)
+	}
+	metadata.Version = version
+
+	tensorCount, err := g.uint64()
+	if err != nil {
+		return metadata, err
+	}
+	keyValueCount, err := g.uint64()
+	if err != nil {
+		return metadata, err
+	}
+	if tensorCount > ggufMaxTensors {
+		return metadata, fmt.Errorf("invalid GGUF header: %d tensors", tensorCount)
+	}
+	if keyValueCount > ggufMaxKeyValues {
+		return metadata, fmt.Errorf("invalid GGUF header: %d metadata entries", keyValueCount)
+	}
+	metadata.TensorCount = tensorCount
+
+	for i := uint64(0); i < keyValueCount; i++ {
+		key, err := g.string()
+		if err != nil {
+			return metadata, err
+		}
+		valueType, err := g.uint32()
+		if err != nil {
+			return metadata, err
+		}
+		if valueType == ggufTypeArray {
+			length, err := g.skipArray(key == "tokenizer.ggml.tokens")
+			if err != nil {
+				return metadata, fmt.Errorf("invalid metadata %q: %w", key, err)
+			}
+			metadata.ArrayLengths[key] = length
+			continue
+		}
+		value, err := g.value(valueType)
+		if err != nil {
+			return metadata, fmt.Errorf("invalid metadata %q: %w", key, err)
+		}
+		metadata.Values[key] = value
+	}
+	if id, ok := ggufUint(metadata.Values["tokenizer.ggml.bos_token_id"]); ok && id < uint64(len(g.tokens)) {
+		metadata.BOSToken = g.tokens[id]
+	}
+	if id, ok := ggufUint(metadata.Values["tokenizer.ggml.eos_token_id"]); ok && id < uint64(len(g.tokens)) {
+		metadata.EOSToken = g.tokens[id]
+	}
+	g.tokens = nil
+
+	for i := uint64(0); i < tensorCount; i++ {
+		if _, err := g.string(); err != nil {
+			return metadata, err
+		}
+		dimensions, err := g.uint32()
+		if err != nil {
+			return metadata, err
+		}
+		if dimensions == 0 || dimensions > ggufMaxDimensions {
+			return metadata, fmt.Errorf("invalid tensor info: %d dimensions", dimensions)
+		}
+		elements := uint64(1)
+		for d := uint32(0); d < dimensions; d++ {
+			size, err := g.uint64()
+			if err != nil {
+				return metadata, err
+			}
+			if size != 0 && elements > math.MaxUint64/size {
+				return metadata, fmt.Errorf("invalid tensor info: element count overflows")
+			}
+			elements *= size
+		}
+		tensorType, err := g.uint32()
+		if err != nil {
+			return metadata, err
+		}
+		if _, err := g.uint64(); err != nil { // offset into the tensor data
+			return metadata, err
+		}
+		metadata.ParameterCount += elements
+		metadata.TensorTypes[tensorType] += elements
+	}
+	return metadata, nil
+}
+
+func (g *ggufReader) read(buffer []byte) error {
+	n, err := io.ReadFull(g.r, buffer)
+	g.offset += uint64(n)
+	return err
+}
+
+func (g *ggufReader) skip(n uint64) error {
+	discarded, err := io.CopyN(io.Discard, g.r, int64(n))
+	g.offset += uint64(discarded)
+	return err
+}
+
+func (g *ggufReader) uint32() (uint32, error) {
+	var buffer [4]byte
+	if err := g.read(buffer[:]); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(buffer[:]), nil
+}
+
+func (g *ggufReader) uint64() (uint64, error) {
+	var buffer [8]byte
+	if err := g.read(buffer[:]); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(buffer[:]), nil
+}
+
+func (g *ggufReader) string() (string, error) {
+	length, err := g.uint64()
+	if err != nil {
+		return "", err
+	}
+	if length > ggufMaxStringLength {
+		return "", fmt.Errorf("string of %d bytes exceeds the limit", length)
+	}
+	buffer := make([]byte, length)
+	if err := g.read(buffer); err != nil {
+		return "", err
+	}
+	return string(buffer), nil
+}
+
+// value reads a scalar or string value of valueType
+func (g *ggufReader) value(valueType uint32) (interface{}, error) {
+	if valueType == ggufTypeString {
+		return g.string()
+	}
+	size, ok := ggufScalarSize(valueType)
+	if !ok {
+		return nil, fmt.Errorf("unknown value type %d", valueType)
+	}
+	var buffer [8]byte
+	if err := g.read(buffer[:size]); err != nil {
+		return nil, err
+	}
+	switch valueType {
+	case ggufTypeUint8:
+		return uint64(buffer[0]), nil
+	case ggufTypeInt8:
+		return int64(int8(buffer[0])), nil
+	case ggufTypeUint16:
+		return uint64(binary.LittleEndian.Uint16(buffer[:])), nil
+	case ggufTypeInt16:
+		return int64(int16(binary.LittleEndian.Uint16(buffer[:]))), nil
+	case ggufTypeUint32:
+		return uint64(binary.LittleEndian.Uint32(buffer[:])), nil
+	case ggufTypeInt32:
+		return int64(int32(binary.LittleEndian.Uint32(buffer[:]))), nil
+	case ggufTypeFloat32:
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(buffer[:]))), nil
+	case ggufTypeBool:
+		return buffer[0] != 0, nil
+	case ggufTypeUint64:
+		return binary.LittleEndian.Uint64(buffer[:]), nil
+	case ggufTypeInt64:
+		return int64(binary.LittleEndian.Uint64(buffer[:])), nil
+	default:
+		return math.Float64frombits(binary.LittleEndian.Uint64(buffer[:])), nil
+	}
+}
+
+// skipArray reads past an array value and returns its length. With keepTokens set the strings of
+// the array are kept as the vocabulary.
+func (g *ggufReader) skipArray(keepTokens bool) (uint64, error) {
+	elementType, err := g.uint32()
+	if err != nil {
+		return 0, err
+	}
+	length, err := g.uint64()
+	if err != nil {
+		return 0, err
+	}
+	if length > ggufMaxArrayLength {
+		return 0, fmt.Errorf("array of %d elements exceeds the limit", length)
+	}
+	switch elementType {
+	case ggufTypeString:
+		for i := uint64(0); i < length; i++ {
+			stringLength, err := g.uint64()
+			if err != nil {
+				return 0, err
+			}
+			if stringLength > ggufMaxStringLength {
+				return 0, fmt.Errorf("string of %d bytes exceeds the limit", stringLength)
+			}
+			if keepTokens {
+				token := make([]byte, stringLength)
+				if err := g.read(token); err != nil {
+					return 0, err
+				}
+				g.tokens = append(g.tokens, string(token))
+				continue
+			}
+			if err := g.skip(stringLength); err != nil {
+				return 0, err
+			}
+		}
+	case ggufTypeArray:
+		for i := uint64(0); i < length; i++ {
+			if _, err := g.skipArray(false); err != nil {
+				return 0, err
+			}
+		}
+	default:
+		size, ok := ggufScalarSize(elementType)
+		if !ok {
+			return 0, fmt.Errorf("unknown array element type %d", elementType)
+		}
+		if err := g.skip(length * uint64(size)); err != nil {
+			return 0, err
+		}
+	}
+	return length, nil
+}
+
+func ggufScalarSize(valueType uint32) (int, bool) {
+	switch valueType {
+	case ggufTypeUint8, ggufTypeInt8, ggufTypeBool:
+		return 1, true
+	case ggufTypeUint16, ggufTypeInt16:
+		return 2, true
+	case ggufTypeUint32, ggufTypeInt32, ggufTypeFloat32:
+		return 4, true
+	case ggufTypeUint64, ggufTypeInt64, ggufTypeFloat64:
+		return 8, true
+	}
+	return 0, false
+}
+
+// ggufUint returns an integer metadata value as uint64
+func ggufUint(value interface{}) (uint64, bool) {
+	switch v := value.(type) {
+	case uint64:
+		return v, true
+	case int64:
+		if v >= 0 {
+			return uint64(v), true
+		}
+	}
+	return 0, false
+}
+
+func ggufString(value interface{}) string {
+	s, _ := value.(string)
+	return s
+}
+
+// DescribeModelFile reads the GGUF header of path into a ModelDescriptor
+func DescribeModelFile(path string, info os.FileInfo) ModelDescriptor {
+	descriptor := ModelDescriptor{
+		FileName:   info.Name(),
+		FullPath:   path,
+		SizeBytes:  info.Size(),
+		ModifiedAt: info.ModTime(),
+	}
+
+	metadata, err := ReadGGUFMetadata(path)
+	if err != nil {
+		descriptor.Error = err.Error()
+		return descriptor
+	}
+	descriptor.GGUFVersion = metadata.Version
+
+	if split, ok := ggufUint(metadata.Values["split.no"]); ok && split > 0 {
+		descriptor.Error = fmt.Sprintf("part %d of a split model; select the first part instead", split+1)
+		return descriptor
+	}
+
+	descriptor.Architecture = ggufString(metadata.Values["general.architecture"])
+	if descriptor.Architecture == "" {
+		descriptor.Error = "GGUF file has no general.architecture; it is not a model"
+		return descriptor
+	}
+	if metadata.TensorCount == 0 {
+		descriptor.Error = "GGUF file contains no tensors; it is not a model"
+		return descriptor
+	}
+
+	descriptor.Valid = true
+	descriptor.Name = ggufString(metadata.Values["general.name"])
+	descriptor.TokenizerType = ggufString(metadata.Values["tokenizer.ggml.model"])
+	descriptor.ChatTemplate = ggufString(metadata.Values["tokenizer.chat_template"])
+	descriptor.BOSToken = metadata.BOSToken
+	descriptor.EOSToken = metadata.EOSToken
+	descriptor.AddBOSToken = true
+	if addBOS, ok := metadata.Values["tokenizer.ggml.add_bos_token"].(bool); ok {
+		descriptor.AddBOSToken = addBOS
+	}
+	descriptor.ContextLength, _ = ggufUint(metadata.Values[descriptor.Architecture+".context_length"])
+	descriptor.EmbeddingLength, _ = ggufUint(metadata.Values[descriptor.Architecture+".embedding_length"])
+
+	// Split models only list their own tensors, so the label is the better total when present
+	descriptor.ParameterCount = metadata.ParameterCount
+	descriptor.ParameterLabel = ggufString(metadata.Values["general.size_label"])
+	if descriptor.ParameterLabel == "" {
+		descriptor.ParameterLabel = formatParameterCount(metadata.ParameterCount)
+	}
+
+	if fileType, ok := ggufUint(metadata.Values["general.file_type"]); ok {
+		descriptor.QuantizationType = ggufFileTypes[fileType]
+	}
+	if descriptor.QuantizationType == "" {
+		descriptor.QuantizationType = dominantTensorType(metadata.TensorTypes)
+	}
+	return descriptor
+}
+
+// dominantTensorType returns the tensor type that stores the most elements
+func dominantTensorType(tensorTypes map[uint32]uint64) string {
+	var best uint32
+	var bestCount uint64
+	for tensorType, count := range tensorTypes {
+		if count > bestCount || (count == bestCount && tensorType < best) {
+			best, bestCount = tensorType, count
+		}
+	}
+	if bestCount == 0 {
+		return ""
+	}
+	if name, ok := ggmlTensorTypes[best]; ok {
+		return name
+	}
+	return fmt.Sprintf("type %d", best)
+}
+
+// formatParameterCount abbreviates a parameter count, e.g. 7241732096 becomes 7.24B
+func formatParameterCount(count uint64) string {
+	switch {
+	case count >= 1e12:
+		return fmt.Sprintf("%.2fT", float64(count)/1e12)
+	case count >= 1e9:
+		return fmt.Sprintf("%.2fB", float64(count)/1e9)
+	case count >= 1e6:
+		return fmt.Sprintf("%.0fM", float64(count)/1e6)
+	case count >= 1e3:
+		return fmt.Sprintf("%.0fK", float64(count)/1e3)
+	}
+	return fmt.Sprintf("%d", count)
+}
+
+// ModelCatalog caches model descriptors by path, reading a file's header again only when its size
+// or modification time changes
+type ModelCatalog struct {
+	mu      sync.Mutex
+	entries map[string]ModelDescriptor
+}
+
+func NewModelCatalog() *ModelCatalog {
+	return &ModelCatalog{entries: make(map[string]ModelDescriptor)}
+}
+
+var modelCatalog = NewModelCatalog()
+
+// Describe returns the cached descriptor of path, or reads it when the file has changed
+func (c *ModelCatalog) Describe(path string, info os.FileInfo) ModelDescriptor {
+	c.mu.Lock()
+	cached, ok := c.entries[path]
+	c.mu.Unlock()
+	if ok && cached.SizeBytes == info.Size() && cached.ModifiedAt.Equal(info.ModTime()) {
+		return cached
+	}
+
+	descriptor := DescribeModelFile(path, info)
+	c.mu.Lock()
+	c.entries[path] = descriptor
+	c.mu.Unlock()
+	return descriptor
+}
+
+// List describes every *.gguf file in dirPath, sorted by file name, and forgets removed files
+func (c *ModelCatalog) List(dirPath string) ([]ModelDescriptor, error) {
+	dirEntries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var descriptors []ModelDescriptor
+	for _, entry := range dirEntries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".gguf" {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		fullPath := filepath.Clean(filepath.Join(dirPath, entry.Name()))
+		seen[fullPath] = true
+		descriptors = append(descriptors, c.Describe(fullPath, info))
+	}
+
+	c.mu.Lock()
+	for path := range c.entries {
+		if filepath.Dir(path) == dirPath && !seen[path] {
+			delete(c.entries, path)
+		}
+	}
+	c.mu.Unlock()
+
+	sort.Slice(descriptors, func(i, j int) bool { return descriptors[i].FileName < descriptors[j].FileName })
+	return descriptors, nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// ggufBuilder writes a synthetic little-endian GGUF file
+type ggufBuilder struct {
+	bytes.Buffer
+}
+
+func (b *ggufBuilder) uint32(value uint32) { _ = binary.Write(b, binary.LittleEndian, value) }
+
+func (b *ggufBuilder) uint64(value uint64) { _ = binary.Write(b, binary.LittleEndian, value) }
+
+func (b *ggufBuilder) string(value string) {
+	b.uint64(uint64(len(value)))
+	b.WriteString(value)
+}
+
+func (b *ggufBuilder) stringValue(key, value string) {
+	b.string(key)
+	b.uint32(ggufTypeString)
+	b.string(value)
+}
+
+func (b *ggufBuilder) uint32Value(key string, value uint32) {
+	b.string(key)
+	b.uint32(ggufTypeUint32)
+	b.uint32(value)
+}
+
+func (b *ggufBuilder) stringArray(key string, values []string) {
+	b.string(key)
+	b.uint32(ggufTypeArray)
+	b.uint32(ggufTypeString)
+	b.uint64(uint64(len(values)))
+	for _, value := range values {
+		b.string(value)
+	}
+}
+
+const testChatTemplate = "{% for message in messages %}{{ message['content'] }}{% endfor %}"
+
+// syntheticGGUF returns a small model file of the given version and the length of its header
+func syntheticGGUF(version uint32) ([]byte, int) {
+	b := &ggufBuilder{}
+	b.WriteString(ggufMagic)
+	b.uint32(version)
+	b.uint64(2)  // tensors
+	b.uint64(10) // metadata entries
+	b.stringValue("general.architecture", "llama")
+	b.stringValue("general.name", "Tiny Llama")
+	b.uint32Value("general.file_type", 15)
+	b.uint32Value("llama.context_length", 2048)
+	b.uint32Value("llama.embedding_length", 768)
+	b.stringValue("tokenizer.ggml.model", "llama")
+	b.stringArray("tokenizer.ggml.tokens", []string{"<unk>", "<s>", "</s>", "hello"})
+	b.uint32Value("tokenizer.ggml.bos_token_id", 1)
+	b.uint32Value("tokenizer.ggml.eos_token_id", 2)
+	b.stringValue("tokenizer.chat_template", testChatTemplate)
+
+	for _, name := range []string{"token_embd.weight", "output.weight"} {
+		b.string(name)
+		b.uint32(2)
+		b.uint64(768)
+		b.uint64(4)
+		b.uint32(12) // Q4_K
+		b.uint64(0)
+	}
+	headerLength := b.Len()
+	for b.Len()%ggufDefaultAlignment != 0 {
+		b.WriteByte(0)
+	}
+	b.Write(make([]byte, 64)) // tensor data
+	return b.Bytes(), headerLength
+}
+
+func writeTestFile(t *testing.T, data []byte) (string, os.FileInfo) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "model.gguf")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path, info
+}
+
+func TestDescribeModelFile(t *testing.T) {
+	for _, version := range []uint32{2, 3} {
+		data, _ := syntheticGGUF(version)
+		path, info := writeTestFile(t, data)
+
+		descriptor := DescribeModelFile(path, info)
+		if !descriptor.Valid {
+			t.Fatalf("v%d: descriptor is not valid: %s", version, descriptor.Error)
+		}
+		if descriptor.GGUFVersion != version {
+			t.Errorf("v%d: GGUFVersion = %d", version, descriptor.GGUFVersion)
+		}
+		if descriptor.Architecture != "llama" || descriptor.Name != "Tiny Llama" {
+			t.Errorf("v%d: architecture %q, name %q", version, descriptor.Architecture, descriptor.Name)
+		}
+		if descriptor.EmbeddingLength != 768 || descriptor.ContextLength != 2048 {
+			t.Errorf("v%d: embedding length %d, context length %d", version, descriptor.EmbeddingLength, descriptor.ContextLength)
+		}
+		if descriptor.ChatTemplate != testChatTemplate {
+			t.Errorf("v%d: chat template %q", version, descriptor.ChatTemplate)
+		}
+		if descriptor.BOSToken != "<s>" || descriptor.EOSToken != "</s>" {
+			t.Errorf("v%d: BOS %q, EOS %q", version, descriptor.BOSToken, descriptor.EOSToken)
+		}
+		if descriptor.QuantizationType != "Q4_K_M" || descriptor.ParameterCount != 2*768*4 {
+			t.Errorf("v%d: quantization %q, parameters %d", version, descriptor.QuantizationType, descriptor.ParameterCount)
+		}
+	}
+}
+
+func TestReadGGUFMetadataTruncated(t *testing.T) {
+	data, headerLength := syntheticGGUF(3)
+	for length := 0; length <= headerLength; length++ {
+		path, _ := writeTestFile(t, data[:length])
+		if _, err := ReadGGUFMetadata(path); err == nil {
+			t.Fatalf("file truncated to %d of %d bytes was accepted", length, len(data))
+		}
+	}
+}
+
+func TestReadGGUFMetadataCorrupt(t *testing.T) {
+	valid, _ := syntheticGGUF(3)
+	corrupt := func(offset int, patch []byte) []byte {
+		data := append([]byte{}, valid...)
+		copy(data[offset:], patch)
+		return data
+	}
+	huge := binary.LittleEndian.AppendUint64(nil, 1<<62)
+	// The first key starts after the magic, version and the two counts
+	firstKey := 4 + 4 + 8 + 8
+	firstValueType := firstKey + 8 + len("general.architecture")
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "magic", data: corrupt(0, []byte("GGML"))},
+		{name: "version 1", data: corrupt(4, []byte{1, 0, 0, 0})},
+		{name: "version 4", data: corrupt(4, []byte{4, 0, 0, 0})},
+		{name: "big-endian", data: corrupt(4, []byte{0, 0, 0, 3})},
+		{name: "tensor count", data: corrupt(8, huge)},
+		{name: "metadata count", data: corrupt(16, huge)},
+		{name: "key length", data: corrupt(firstKey, huge)},
+		{name: "value type", data: corrupt(firstValueType, []byte{99, 0, 0, 0})},
+		{name: "string length", data: corrupt(firstValueType+4, huge)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path, _ := writeTestFile(t, test.data)
+			if _, err := ReadGGUFMetadata(path); err == nil {
+				t.Fatal("corrupt file was accepted")
+			}
+		})
+	}
+
+	// Random damage may or may not be detected, but must never panic
+	random := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		data := append([]byte{}, valid...)
+		for j := 0; j < 4; j++ {
+			data[4+random.Intn(len(data)-4)] = byte(random.Intn(256))
+		}
+		path, info := writeTestFile(t, data)
+		DescribeModelFile(path, info)
+	}
+}
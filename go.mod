@@ -10,7 +10,6 @@ require (
 	github.com/joho/godotenv v1.5.1
 	github.com/jonathanhecl/chunker v0.0.1
 	github.com/labstack/gommon v0.4.2
-	github.com/tiagomelo/go-ocr v0.1.0
 	github.com/wailsapp/wails/v2 v2.10.2
 	go.mongodb.org/mongo-driver/v2 v2.2.1
 )
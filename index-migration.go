@@ -0,0 +1,379 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// migrationPageSize is how many source documents are read per scroll request
+const migrationPageSize = 20
+
+var migrationIndexNameSanitizer = regexp.MustCompile(`[^a-z0-9_-]+`)
+
+// IndexMigrationRequest re-embeds the chunks stored in SourceIndex with EmbedArgs into a new index and
+// then points Alias at it
+type IndexMigrationRequest struct {
+	RequestID   string         `json:"requestId,omitempty"`
+	SourceIndex string         `json:"sourceIndex"` // index or alias to migrate
+	TargetIndex string         `json:"targetIndex,omitempty"`
+	Alias       string         `json:"alias,omitempty"` // defaults to SourceIndex
+	EmbedArgs   LlamaEmbedArgs `json:"embedArgs"`
+	Profile     IndexProfile   `json:"profile"` // dims are taken from the new model when 0
+	// ReplaceSourceIndex allows deleting SourceIndex when the alias is swapped, which is needed when the
+	// alias takes over the name of a plain index
+	ReplaceSourceIndex bool `json:"replaceSourceIndex"`
+	// Restart deletes the target index instead of resuming into it. Either way an existing target must have
+	// been created by a migration of SourceIndex.
+	Restart bool `json:"restart"`
+}
+
+// IndexMigrationProgress is emitted as index-migration-progress after every document
+type IndexMigrationProgress struct {
+	RequestID   string `json:"requestId"`
+	TargetIndex string `json:"targetIndex"`
+	Migrated    int    `json:"migrated"`
+	Resumed     int    `json:"resumed"`
+	Failed      int    `json:"failed"`
+	Total       int64  `json:"total"`
+}
+
+// IndexMigrationFailure names a document that could not be re-embedded
+type IndexMigrationFailure struct {
+	DocumentID string `json:"documentId"`
+	Title      string `json:"title"`
+	Error      string `json:"error"`
+}
+
+// IndexMigrationReport is emitted as index-migration-complete when the job ends. The alias is only
+// swapped when the target holds as many documents and chunks as the source.
+type IndexMigrationReport struct {
+	RequestID       string                  `json:"requestId"`
+	Success         bool                    `json:"success"`
+	Error           string                  `json:"error,omitempty"`
+	SourceIndex     string                  `json:"sourceIndex"`
+	TargetIndex     string                  `json:"targetIndex"`
+	Alias           string                  `json:"alias"`
+	Migrated        int                     `json:"migrated"`
+	Resumed         int                     `json:"resumed"` // documents taken over from an earlier, unfinished run
+	Removed         int                     `json:"removed"` // documents of an earlier run that the source no longer holds
+	Failed          int                     `json:"failed"`
+	FailedDocuments []IndexMigrationFailure `json:"failedDocuments"`
+	SourceDocuments int64                   `json:"sourceDocuments"`
+	SourceChunks    int64                   `json:"sourceChunks"`
+	TargetDocuments int64                   `json:"targetDocuments"`
+	TargetChunks    int64                   `json:"targetChunks"`
+	Verified        bool                    `json:"verified"`
+	AliasSwapped    bool                    `json:"aliasSwapped"`
+}
+
+// migrationPlan is a validated IndexMigrationRequest
+type migrationPlan struct {
+	request         IndexMigrationRequest
+	model           EmbeddingModelIdentity
+	sourceIndices   []string // concrete indices behind SourceIndex
+	previousIndices []string // indices the alias points at before the swap
+	replaceIndex    string   // plain index deleted by the swap
+}
+
+// StartIndexMigration validates the request and runs it in the background as a migration job. It returns
+// the job's request ID; progress is emitted as index-migration-progress events and the report as an
+// index-migration-complete event.
+func (app *App) StartIndexMigration(request IndexMigrationRequest) (string, error) {
+	elasticClient, err := app.createElasticsearchClient(5000)
+	if err != nil {
+		return "", err
+	}
+	plan, err := app.planIndexMigration(app.ctx, elasticClient, request)
+	if err != nil {
+		app.log.Error("Failed to prepare index migration: " + err.Error())
+		return "", err
+	}
+
+	job, err := app.startJob(request.RequestID, JobKindMigration)
+	if err != nil {
+		return "", err
+	}
+	plan.request.RequestID = job.ID()
+
+	go func() {
+		report := app.runIndexMigration(job.Context(), elasticClient, plan, app.emitIndexMigrationProgress)
+		var jobErr error
+		if !report.Success {
+			jobErr = errors.New(report.Error)
+		}
+		app.jobs.Finish(job, jobErr)
+		runtime.EventsEmit(app.ctx, "index-migration-complete", report)
+	}()
+
+	return plan.request.RequestID, nil
+}
+
+// planIndexMigration resolves the source, target and alias of a migration
+func (app *App) planIndexMigration(ctx context.Context, elasticClient *ElasticsearchClientWrapper, request IndexMigrationRequest) (migrationPlan, error) {
+	plan := migrationPlan{request: request}
+	if request.SourceIndex == "" {
+		return plan, fmt.Errorf("a source index is required")
+	}
+	model, err := NewEmbeddingModelIdentity(request.EmbedArgs)
+	if err != nil {
+		return plan, err
+	}
+	plan.model = model
+
+	aliasIndices, err := elasticClient.GetAliasIndices(ctx, request.SourceIndex)
+	if err != nil {
+		return plan, err
+	}
+	plan.sourceIndices = aliasIndices
+	if plan.sourceIndices == nil {
+		exists, err := elasticClient.IndexExists(ctx, request.SourceIndex)
+		if err != nil {
+			return plan, err
+		}
+		if !exists {
+			return plan, fmt.Errorf("%w: '%s'", ErrIndexNotFound, request.SourceIndex)
+		}
+		plan.sourceIndices = []string{request.SourceIndex}
+	}
+
+	if plan.request.Alias == "" {
+		plan.request.Alias = request.SourceIndex
+	}
+	if aliasIndices == nil && plan.request.Alias == request.SourceIndex {
+		// A plain index and an alias cannot share a name, so the index has to go when the alias is added
+		if !request.ReplaceSourceIndex {
+			return plan, fmt.Errorf("'%s' is an index, not an alias; choose another alias name or allow the source index to be replaced", request.SourceIndex)
+		}
+		plan.replaceIndex = request.SourceIndex
+	}
+	if plan.request.Alias != request.SourceIndex {
+		if plan.previousIndices, err = elasticClient.GetAliasIndices(ctx, plan.request.Alias); err != nil {
+			return plan, err
+		}
+		if plan.previousIndices == nil {
+			exists, err := elasticClient.IndexExists(ctx, plan.request.Alias)
+			if err != nil {
+				return plan, err
+			}
+			if exists {
+				return plan, fmt.Errorf("'%s' is an existing index and cannot become an alias", plan.request.Alias)
+			}
+		}
+	} else {
+		plan.previousIndices = aliasIndices
+	}
+
+	if plan.request.TargetIndex == "" {
+		plan.request.TargetIndex = migrationTargetIndexName(plan.request.Alias, model)
+	}
+	if slices.Contains(plan.sourceIndices, plan.request.TargetIndex) {
+		return plan, fmt.Errorf("'%s' already holds the source documents; choose another target index", plan.request.TargetIndex)
+	}
+
+	// An existing target is resumed into, or deleted on restart, so it must be one this migration created
+	exists, err := elasticClient.IndexExists(ctx, plan.request.TargetIndex)
+	if err != nil {
+		return plan, err
+	}
+	if exists {
+		targetEmbedding, err := elasticClient.GetIndexEmbedding(ctx, plan.request.TargetIndex)
+		if err != nil {
+			return plan, err
+		}
+		if targetEmbedding.MigratedFrom != request.SourceIndex {
+			return plan, fmt.Errorf("'%s' is an existing index that was not created by a migration of '%s'; choose another target index", plan.request.TargetIndex, request.SourceIndex)
+		}
+	}
+	return plan, nil
+}
+
+// migrationTargetIndexName names the new index after the alias and the embedding model, so running the
+// same migration again resumes into the same index
+func migrationTargetIndexName(alias string, model EmbeddingModelIdentity) string {
+	modelName := strings.TrimSuffix(strings.ToLower(model.File[strings.LastIndexAny(model.File, `/\`)+1:]), ".gguf")
+	modelName = strings.Trim(migrationIndexNameSanitizer.ReplaceAllString(modelName, "-"), "-_")
+	name := strings.ToLower(alias) + "-" + modelName
+	if len(name) > 200 {
+		name = name[:200]
+	}
+	return name
+}
+
+// runIndexMigration copies every source document the target does not hold yet, re-embedding its chunks,
+// removes target documents that were deleted from the source since an earlier run, verifies the counts
+// and swaps the alias. onProgress is called with the report so far after every source document.
+func (app *App) runIndexMigration(ctx context.Context, elasticClient *ElasticsearchClientWrapper, plan migrationPlan, onProgress func(IndexMigrationReport)) IndexMigrationReport {
+	request := plan.request
+	report := IndexMigrationReport{
+		RequestID:       request.RequestID,
+		SourceIndex:     request.SourceIndex,
+		TargetIndex:     request.TargetIndex,
+		Alias:           request.Alias,
+		FailedDocuments: []IndexMigrationFailure{},
+	}
+	fail := func(err error) IndexMigrationReport {
+		if errors.Is(ctx.Err(), context.Canceled) {
+			report.Error = "Operation cancelled by user"
+		} else {
+			report.Error = err.Error()
+		}
+		app.log.Error(fmt.Sprintf("Index migration %s stopped: %s", request.RequestID, report.Error))
+		return report
+	}
+
+	var err error
+	if report.SourceDocuments, report.SourceChunks, err = elasticClient.CountDocumentsAndChunks(ctx, request.SourceIndex); err != nil {
+		return fail(err)
+	}
+	if request.Restart {
+		if err := elasticClient.DeleteIndex(ctx, request.TargetIndex); err != nil {
+			return fail(err)
+		}
+	}
+	if err := elasticClient.EnsureMigrationTargetIndex(ctx, request.TargetIndex, request.SourceIndex, *app.appArgs, request.EmbedArgs, request.Profile); err != nil {
+		return fail(err)
+	}
+	targetEmbedding, err := elasticClient.GetIndexEmbedding(ctx, request.TargetIndex)
+	if err != nil {
+		return fail(err)
+	}
+	if err := targetEmbedding.Check(request.TargetIndex, plan.model); err != nil {
+		return fail(err)
+	}
+
+	// Documents keep their IDs, so the ones already in the target are from an earlier run. Each is marked
+	// once the source scroll reaches it; the rest were deleted from the source in the meantime.
+	migrated := make(map[string]bool)
+	err = elasticClient.ScrollDocuments(ctx, request.TargetIndex, 1000, []string{"*"}, func(documentID string, _ json.RawMessage) error {
+		migrated[documentID] = false
+		return nil
+	})
+	if err != nil {
+		return fail(err)
+	}
+
+	err = elasticClient.ScrollDocuments(ctx, request.SourceIndex, migrationPageSize, []string{"docChunks.vector"}, func(documentID string, source json.RawMessage) error {
+		if _, exists := migrated[documentID]; exists {
+			migrated[documentID] = true
+			report.Resumed++
+		} else if failure := app.migrateDocument(ctx, elasticClient, request, targetEmbedding.Dims, documentID, source); failure != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			app.log.Error(fmt.Sprintf("Failed to migrate document %s: %s", documentID, failure.Error))
+			report.Failed++
+			report.FailedDocuments = append(report.FailedDocuments, *failure)
+		} else {
+			report.Migrated++
+		}
+		onProgress(report)
+		return nil
+	})
+	if err != nil {
+		return fail(err)
+	}
+
+	for documentID, inSource := range migrated {
+		if inSource {
+			continue
+		}
+		if err := elasticClient.DeleteDocument(ctx, request.TargetIndex, documentID); err != nil {
+			return fail(err)
+		}
+		report.Removed++
+	}
+	if report.Removed > 0 {
+		app.log.Info(fmt.Sprintf("Index migration %s removed %d documents no longer in '%s'", request.RequestID, report.Removed, request.SourceIndex))
+	}
+
+	if report.TargetDocuments, report.TargetChunks, err = elasticClient.CountDocumentsAndChunks(ctx, request.TargetIndex); err != nil {
+		return fail(err)
+	}
+	report.Verified = report.Failed == 0 && report.TargetDocuments == report.SourceDocuments && report.TargetChunks == report.SourceChunks
+	if !report.Verified {
+		return fail(fmt.Errorf("verification failed: the source has %d documents and %d chunks, the target %d documents and %d chunks; %d documents failed. The alias was not swapped; run the migration again to retry",
+			report.SourceDocuments, report.SourceChunks, report.TargetDocuments, report.TargetChunks, report.Failed))
+	}
+
+	if err := elasticClient.SwapAlias(ctx, request.Alias, request.TargetIndex, plan.previousIndices, plan.replaceIndex); err != nil {
+		return fail(err)
+	}
+	report.AliasSwapped = true
+	report.Success = true
+	app.log.Info(fmt.Sprintf("Index migration %s finished: '%s' now points at '%s'", request.RequestID, request.Alias, request.TargetIndex))
+	return report
+}
+
+// migrateDocument re-embeds the chunks of one source document and stores it in the target index under
+// the same ID. A document is only written when every chunk was embedded.
+func (app *App) migrateDocument(ctx context.Context, elasticClient *ElasticsearchClientWrapper, request IndexMigrationRequest, dims int, documentID string, source json.RawMessage) *IndexMigrationFailure {
+	var document map[string]json.RawMessage
+	if err := json.Unmarshal(source, &document); err != nil {
+		return &IndexMigrationFailure{DocumentID: documentID, Error: "failed to decode document: " + err.Error()}
+	}
+	failure := &IndexMigrationFailure{DocumentID: documentID}
+	_ = json.Unmarshal(document["title"], &failure.Title)
+
+	var chunks []ElasticDocumentTextChunk
+	if rawChunks, ok := document["docChunks"]; ok && string(rawChunks) != "null" {
+		if err := json.Unmarshal(rawChunks, &chunks); err != nil {
+			failure.Error = "failed to decode chunks: " + err.Error()
+			return failure
+		}
+	}
+
+	chunkTexts := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		chunkTexts[i] = chunk.TextChunk
+	}
+	vectors, err := EmbedTexts(ctx, app.log, request.EmbedArgs, *app.appArgs, chunkTexts, app.appArgs.EmbeddingBatchSize)
+	if err != nil {
+		failure.Error = err.Error()
+		return failure
+	}
+	for i := range chunks {
+		if vectors[i] == nil {
+			failure.Error = fmt.Sprintf("chunk %d could not be embedded", i+1)
+			return failure
+		}
+		if err := CheckEmbeddingDims(vectors[i], dims); err != nil {
+			failure.Error = fmt.Sprintf("chunk %d: %s", i+1, err.Error())
+			return failure
+		}
+		chunks[i].Vector = vectors[i]
+	}
+
+	rawChunks, err := json.Marshal(chunks)
+	if err != nil {
+		failure.Error = err.Error()
+		return failure
+	}
+	document["docChunks"] = rawChunks
+	if err := elasticClient.IndexDocument(ctx, request.TargetIndex, documentID, document); err != nil {
+		failure.Error = err.Error()
+		return failure
+	}
+	return nil
+}
+
+func (app *App) emitIndexMigrationProgress(report IndexMigrationReport) {
+	done := report.Migrated + report.Resumed + report.Failed
+	if report.SourceDocuments > 0 {
+		app.jobs.SetProgress(report.RequestID, int(int64(done)*100/report.SourceDocuments))
+	}
+	runtime.EventsEmit(app.ctx, "index-migration-progress", IndexMigrationProgress{
+		RequestID:   report.RequestID,
+		TargetIndex: report.TargetIndex,
+		Migrated:    report.Migrated,
+		Resumed:     report.Resumed,
+		Failed:      report.Failed,
+		Total:       report.SourceDocuments,
+	})
+}
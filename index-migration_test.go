@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/wailsapp/wails/v2/pkg/logger"
+)
+
+// testMigrationEmbedArgs identifies the embedding model by URL so no model file is needed
+var testMigrationEmbedArgs = LlamaEmbedArgs{EmbedModelUrlCmd: "--model-url", EmbedModelUrlVal: "https://models.example/embed.gguf"}
+
+// newMigrationTestApp returns an app that embeds with the fake llama-embedding and a fake Elasticsearch
+// holding a "docs" source index with documents a, b and c
+func newMigrationTestApp(t *testing.T) (*App, *fakeElasticsearch, *ElasticsearchClientWrapper) {
+	t.Helper()
+	app := &App{
+		ctx:     context.Background(),
+		log:     logger.NewDefaultLogger(),
+		appArgs: &DefaultAppArgs{LLamaEmbedCliPath: fakeLlamaEmbedding(t), ProcessMaxRuntimeMinutes: 1, EmbeddingBatchSize: 8},
+		jobs:    NewJobRegistry(),
+	}
+	es := newFakeElasticsearch()
+	es.addIndex("docs", testDocumentMapping(t, ""), map[string]map[string]interface{}{
+		"a": testMigrationDocument("Alpha", "first chunk", "second chunk"),
+		"b": testMigrationDocument("Beta", "only chunk"),
+		"c": testMigrationDocument("Gamma", "last chunk"),
+	})
+	return app, es, newTestElasticsearchClient(t, es.handle)
+}
+
+// testDocumentMapping is the mapping of a document index for the test model, created by a migration of
+// migratedFrom when it is set
+func testDocumentMapping(t *testing.T, migratedFrom string) map[string]interface{} {
+	t.Helper()
+	identity, err := NewEmbeddingModelIdentity(testMigrationEmbedArgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mapping := NewDocumentIndexMapping(IndexProfile{Dims: 2}.withDefaults(), identity)
+	if migratedFrom != "" {
+		mapping["_meta"].(map[string]interface{})["migratedFrom"] = migratedFrom
+	}
+	// Round-trip through JSON so the fake holds the mapping as Elasticsearch would return it
+	encoded, err := json.Marshal(mapping)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	return decoded
+}
+
+func testMigrationDocument(title string, chunks ...string) map[string]interface{} {
+	docChunks := make([]interface{}, len(chunks))
+	for i, chunk := range chunks {
+		docChunks[i] = map[string]interface{}{"textChunk": chunk, "vector": []interface{}{0.0, 0.0}}
+	}
+	return map[string]interface{}{"title": title, "docChunks": docChunks}
+}
+
+func planTestMigration(t *testing.T, app *App, client *ElasticsearchClientWrapper, request IndexMigrationRequest) migrationPlan {
+	t.Helper()
+	request.SourceIndex = "docs"
+	request.EmbedArgs = testMigrationEmbedArgs
+	plan, err := app.planIndexMigration(context.Background(), client, request)
+	if err != nil {
+		t.Fatalf("planIndexMigration: %v", err)
+	}
+	return plan
+}
+
+func TestIndexMigration(t *testing.T) {
+	app, es, client := newMigrationTestApp(t)
+	plan := planTestMigration(t, app, client, IndexMigrationRequest{Alias: "docs-current", TargetIndex: "docs-v2"})
+
+	var progress []IndexMigrationReport
+	report := app.runIndexMigration(context.Background(), client, plan, func(report IndexMigrationReport) {
+		progress = append(progress, report)
+	})
+	if !report.Success || !report.Verified || !report.AliasSwapped {
+		t.Fatalf("migration failed: %+v", report)
+	}
+	if report.Migrated != 3 || report.SourceDocuments != 3 || report.SourceChunks != 4 || report.TargetChunks != 4 {
+		t.Errorf("unexpected report %+v", report)
+	}
+	if len(progress) != 3 {
+		t.Errorf("reported progress %d times, want once per document", len(progress))
+	}
+	if !slices.Equal(es.aliases["docs-current"], []string{"docs-v2"}) {
+		t.Errorf("alias points at %v", es.aliases["docs-current"])
+	}
+	meta := es.indices["docs-v2"].mappings["_meta"].(map[string]interface{})
+	if meta["migratedFrom"] != "docs" {
+		t.Errorf("target _meta %v does not record the source", meta)
+	}
+	// The fake embedder returns [text length, index]; the stored vectors must be the new ones
+	chunk := es.indices["docs-v2"].docs["a"]["docChunks"].([]interface{})[0].(map[string]interface{})
+	if vector := chunk["vector"].([]interface{}); vector[0] != float64(len("first chunk")) {
+		t.Errorf("chunk vector %v was not re-embedded", vector)
+	}
+}
+
+func TestIndexMigrationResumes(t *testing.T) {
+	app, es, client := newMigrationTestApp(t)
+	// An earlier run copied a and b; z has since been deleted from the source
+	es.addIndex("docs-v2", testDocumentMapping(t, "docs"), map[string]map[string]interface{}{
+		"a": testMigrationDocument("Alpha", "first chunk", "second chunk"),
+		"b": testMigrationDocument("Beta", "only chunk"),
+		"z": testMigrationDocument("Deleted", "gone"),
+	})
+	plan := planTestMigration(t, app, client, IndexMigrationRequest{Alias: "docs-current", TargetIndex: "docs-v2"})
+
+	report := app.runIndexMigration(context.Background(), client, plan, func(IndexMigrationReport) {})
+	if !report.Success {
+		t.Fatalf("migration failed: %+v", report)
+	}
+	if report.Resumed != 2 || report.Migrated != 1 || report.Removed != 1 {
+		t.Errorf("resumed %d, migrated %d, removed %d; want 2, 1 and 1", report.Resumed, report.Migrated, report.Removed)
+	}
+	if !slices.Equal(es.indexed, []string{"docs-v2/c"}) {
+		t.Errorf("indexed %v, want only the document the earlier run missed", es.indexed)
+	}
+	if _, exists := es.indices["docs-v2"].docs["z"]; exists {
+		t.Error("the document deleted from the source is still in the target")
+	}
+}
+
+func TestIndexMigrationFailedVerificationKeepsAlias(t *testing.T) {
+	app, es, client := newMigrationTestApp(t)
+	es.aliases["docs-current"] = []string{"docs"}
+	es.indices["docs"].put("broken", map[string]interface{}{"title": "Broken", "docChunks": "not a list"})
+	plan := planTestMigration(t, app, client, IndexMigrationRequest{Alias: "docs-current", TargetIndex: "docs-v2"})
+
+	report := app.runIndexMigration(context.Background(), client, plan, func(IndexMigrationReport) {})
+	if report.Success || report.Verified || report.AliasSwapped {
+		t.Fatalf("migration with a failed document succeeded: %+v", report)
+	}
+	if report.Failed != 1 || report.FailedDocuments[0].DocumentID != "broken" || !strings.Contains(report.Error, "verification failed") {
+		t.Errorf("unexpected report %+v", report)
+	}
+	if len(es.aliasUpdates) != 0 || !slices.Equal(es.aliases["docs-current"], []string{"docs"}) {
+		t.Errorf("alias was changed to %v", es.aliases["docs-current"])
+	}
+}
+
+func TestPlanIndexMigrationRefusesUnrelatedTarget(t *testing.T) {
+	tests := []struct {
+		name         string
+		migratedFrom string
+		restart      bool
+		wantErr      bool
+	}{
+		{name: "resume into an unrelated index", wantErr: true},
+		{name: "restart an unrelated index", restart: true, wantErr: true},
+		{name: "resume into another migration's index", migratedFrom: "other", wantErr: true},
+		{name: "resume into its own index", migratedFrom: "docs"},
+		{name: "restart its own index", migratedFrom: "docs", restart: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			app, es, client := newMigrationTestApp(t)
+			es.addIndex("existing", testDocumentMapping(t, test.migratedFrom), map[string]map[string]interface{}{
+				"unrelated": testMigrationDocument("Unrelated", "keep me"),
+			})
+
+			_, err := app.planIndexMigration(context.Background(), client, IndexMigrationRequest{
+				SourceIndex: "docs",
+				TargetIndex: "existing",
+				Alias:       "docs-current",
+				EmbedArgs:   testMigrationEmbedArgs,
+				Restart:     test.restart,
+			})
+			if test.wantErr && (err == nil || !strings.Contains(err.Error(), "not created by a migration of 'docs'")) {
+				t.Fatalf("got %v, want the target to be refused", err)
+			}
+			if !test.wantErr && err != nil {
+				t.Fatalf("planIndexMigration: %v", err)
+			}
+		})
+	}
+}
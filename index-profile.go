@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// maxIndexVectorDims is the largest dense_vector Elasticsearch can index
+const maxIndexVectorDims = 4096
+
+var (
+	// ErrEmbeddingModelMismatch is returned when an index was built with a different embedding model
+	// or settings than the ones a document is being added or queried with
+	ErrEmbeddingModelMismatch = errors.New("embedding model does not match the index")
+	// ErrEmbeddingModelUnavailable is returned when the embedding model cannot be identified or probed
+	ErrEmbeddingModelUnavailable = errors.New("embedding model unavailable")
+	// ErrIndexNotFound is returned when an index or alias does not exist
+	ErrIndexNotFound = errors.New("index not found")
+)
+
+var indexSimilarities = []string{"cosine", "dot_product", "l2_norm", "max_inner_product"}
+
+// indexQuantizationTypes maps the quantization setting to the dense_vector index_options type
+var indexQuantizationTypes = map[string]string{
+	"none": "hnsw",
+	"int8": "int8_hnsw",
+	"int4": "int4_hnsw",
+	"bbq":  "bbq_hnsw",
+}
+
+// IndexProfile describes how the document vectors of an index are stored and compared
+type IndexProfile struct {
+	Dims               int    `json:"dims"` // 0 takes the size from the embedding model
+	Similarity         string `json:"similarity"`
+	Quantization       string `json:"quantization"` // none, int8, int4 or bbq
+	HnswM              int    `json:"hnswM"`
+	HnswEfConstruction int    `json:"hnswEfConstruction"`
+}
+
+// DefaultIndexProfile returns the profile configured by the Index* settings
+func DefaultIndexProfile(appArgs DefaultAppArgs) IndexProfile {
+	profile := IndexProfile{
+		Dims:               appArgs.IndexVectorDims,
+		Similarity:         appArgs.IndexSimilarity,
+		Quantization:       appArgs.IndexQuantization,
+		HnswM:              appArgs.IndexHnswM,
+		HnswEfConstruction: appArgs.IndexHnswEfConstruction,
+	}
+	return profile.withDefaults()
+}
+
+// withDefaults fills in the settings the indices were created with before profiles existed
+func (p IndexProfile) withDefaults() IndexProfile {
+	if p.Similarity == "" {
+		p.Similarity = "cosine"
+	}
+	if p.Quantization == "" {
+		p.Quantization = "int8"
+	}
+	if p.HnswM == 0 {
+		p.HnswM = 24
+	}
+	if p.HnswEfConstruction == 0 {
+		p.HnswEfConstruction = 200
+	}
+	return p
+}
+
+// Validate returns an error when Elasticsearch would reject the profile
+func (p IndexProfile) Validate() error {
+	if p.Dims < 1 || p.Dims > maxIndexVectorDims {
+		return fmt.Errorf("vector dims must be between 1 and %d, got %d", maxIndexVectorDims, p.Dims)
+	}
+	if !slices.Contains(indexSimilarities, p.Similarity) {
+		return fmt.Errorf("unknown similarity %q, expected one of %v", p.Similarity, indexSimilarities)
+	}
+	if _, ok := indexQuantizationTypes[p.Quantization]; !ok {
+		return fmt.Errorf("unknown quantization %q, expected none, int8, int4 or bbq", p.Quantization)
+	}
+	if p.Quantization == "int4" && p.Dims%2 != 0 {
+		return fmt.Errorf("int4 quantization needs an even number of dims, got %d", p.Dims)
+	}
+	if p.Quantization == "bbq" && p.Dims < 64 {
+		return fmt.Errorf("bbq quantization needs at least 64 dims, got %d", p.Dims)
+	}
+	if p.HnswM < 2 || p.HnswM > 512 {
+		return fmt.Errorf("HNSW m must be between 2 and 512, got %d", p.HnswM)
+	}
+	if p.HnswEfConstruction < 1 || p.HnswEfConstruction > 3200 {
+		return fmt.Errorf("HNSW ef_construction must be between 1 and 3200, got %d", p.HnswEfConstruction)
+	}
+	return nil
+}
+
+// vectorMapping returns the dense_vector mapping of docChunks.vector for the profile
+func (p IndexProfile) vectorMapping() map[string]interface{} {
+	return map[string]interface{}{
+		"type":       "dense_vector",
+		"dims":       p.Dims,
+		"index":      true,
+		"similarity": p.Similarity,
+		"index_options": map[string]interface{}{
+			"type":            indexQuantizationTypes[p.Quantization],
+			"m":               p.HnswM,
+			"ef_construction": p.HnswEfConstruction,
+		},
+	}
+}
+
+// EmbeddingModelIdentity identifies the embedding model and the settings that shape its vectors. It is
+// stored in the _meta of every index so documents and queries embedded differently are rejected.
+type EmbeddingModelIdentity struct {
+	File         string `json:"file"`
+	SizeBytes    int64  `json:"sizeBytes,omitempty"`
+	Name         string `json:"name,omitempty"`
+	Architecture string `json:"architecture,omitempty"`
+	Dims         int    `json:"dims,omitempty"`
+	Pooling      string `json:"pooling,omitempty"`
+	Normalize    string `json:"normalize,omitempty"`
+}
+
+// NewEmbeddingModelIdentity describes the model llamaEmbedArgs points at. The file name rather than the
+// full path is kept so an index stays usable when the models folder moves.
+func NewEmbeddingModelIdentity(llamaEmbedArgs LlamaEmbedArgs) (EmbeddingModelIdentity, error) {
+	identity := EmbeddingModelIdentity{Pooling: llamaEmbedArgs.EmbedPoolingVal, Normalize: llamaEmbedArgs.EmbedNormalizeVal}
+	if llamaEmbedArgs.EmbedModelFullPathVal == "" {
+		if llamaEmbedArgs.EmbedModelUrlVal == "" {
+			return identity, fmt.Errorf("%w: no embedding model is configured", ErrEmbeddingModelUnavailable)
+		}
+		identity.File = llamaEmbedArgs.EmbedModelUrlVal
+		return identity, nil
+	}
+
+	info, err := os.Stat(llamaEmbedArgs.EmbedModelFullPathVal)
+	if err != nil {
+		return identity, fmt.Errorf("%w: %w", ErrEmbeddingModelUnavailable, err)
+	}
+	descriptor := modelCatalog.Describe(llamaEmbedArgs.EmbedModelFullPathVal, info)
+	if !descriptor.Valid {
+		return identity, fmt.Errorf("%w: %s: %s", ErrEmbeddingModelUnavailable, info.Name(), descriptor.Error)
+	}
+	identity.File = filepath.Base(llamaEmbedArgs.EmbedModelFullPathVal)
+	identity.SizeBytes = descriptor.SizeBytes
+	identity.Name = descriptor.Name
+	identity.Architecture = descriptor.Architecture
+	identity.Dims = int(descriptor.EmbeddingLength)
+	return identity, nil
+}
+
+// Matches reports whether two identities describe the same model with the same settings. Dims are
+// left to the vector size check since the GGUF header does not always record them.
+func (id EmbeddingModelIdentity) Matches(other EmbeddingModelIdentity) bool {
+	return id.File == other.File && id.SizeBytes == other.SizeBytes && id.Pooling == other.Pooling && id.Normalize == other.Normalize
+}
+
+func (id EmbeddingModelIdentity) String() string {
+	description := id.File
+	if id.Pooling != "" {
+		description += ", pooling " + id.Pooling
+	}
+	if id.Normalize != "" {
+		description += ", normalize " + id.Normalize
+	}
+	return description
+}
+
+// IndexEmbedding is what an index mapping records about its vectors. Model is nil for indices created
+// before the model identity was stored.
+type IndexEmbedding struct {
+	Dims         int                     `json:"dims"`
+	Model        *EmbeddingModelIdentity `json:"model,omitempty"`
+	MigratedFrom string                  `json:"migratedFrom,omitempty"` // source of the migration that created the index
+}
+
+// Check returns an error when vectors from model cannot be stored in or compared against the index
+func (e IndexEmbedding) Check(indexName string, model EmbeddingModelIdentity) error {
+	if e.Model != nil && !e.Model.Matches(model) {
+		return fmt.Errorf("%w: index '%s' was built with %s, not %s", ErrEmbeddingModelMismatch, indexName, e.Model, model)
+	}
+	if model.Dims > 0 && model.Dims != e.Dims {
+		return fmt.Errorf("%w: index '%s' holds %d-dimension vectors but %s produces %d", ErrEmbeddingModelMismatch, indexName, e.Dims, model.File, model.Dims)
+	}
+	return nil
+}
+
+// completeIndexProfile fills in the vector size of profile from the embedding model when it is not set,
+// probing the model with a short embedding when its GGUF header does not record the size
+func completeIndexProfile(ctx context.Context, profile IndexProfile, identity *EmbeddingModelIdentity, llamaEmbedArgs LlamaEmbedArgs, appArgs DefaultAppArgs) (IndexProfile, error) {
+	profile = profile.withDefaults()
+	if identity.Dims == 0 {
+		vector, err := GenerateEmbedWithCancel(ctx, llamaEmbedArgs, appArgs, "dimension probe")
+		if err != nil {
+			return profile, fmt.Errorf("%w: failed to detect the embedding size: %w", ErrEmbeddingModelUnavailable, err)
+		}
+		identity.Dims = len(vector)
+	}
+	if profile.Dims == 0 {
+		profile.Dims = identity.Dims
+	}
+	if profile.Dims != identity.Dims {
+		return profile, fmt.Errorf("%s produces %d-dimension vectors but the profile sets %d", identity.File, identity.Dims, profile.Dims)
+	}
+	return profile, profile.Validate()
+}
+
+// NewDocumentIndexMapping returns the mapping of a document index, recording the profile and the
+// embedding model in _meta
+func NewDocumentIndexMapping(profile IndexProfile, identity EmbeddingModelIdentity) map[string]interface{} {
+	return map[string]interface{}{
+		"_meta": map[string]interface{}{
+			"embeddingModel": identity,
+			"indexProfile":   profile,
+		},
+		"properties": map[string]interface{}{
+			"metaKeyWords": map[string]interface{}{
+				"type": "text",
+			},
+			"metaTextDesc": map[string]interface{}{
+				"type": "text",
+			},
+			"docChunks": map[string]interface{}{
+				"type": "nested",
+				"properties": map[string]interface{}{
+					"textChunk": map[string]interface{}{
+						"type": "text",
+					},
+					"vector": profile.vectorMapping(),
+				},
+			},
+			"sourceLocation": map[string]interface{}{
+				"type": "text",
+			},
+			"timestamp": map[string]interface{}{
+				"type": "date",
+			},
+			"title": map[string]interface{}{
+				"type": "text",
+			},
+		},
+	}
+}
+
+// GetDefaultIndexProfile returns the configured index profile with the size of the current embedding
+// model filled in when it can be read from the model file
+func (app *App) GetDefaultIndexProfile() IndexProfile {
+	profile := DefaultIndexProfile(*app.appArgs)
+	if profile.Dims == 0 {
+		if identity, err := NewEmbeddingModelIdentity(*app.llamaEmbedArgs); err == nil {
+			profile.Dims = identity.Dims
+		}
+	}
+	return profile
+}
+
+// CreateElasticIndex creates a document index for the current embedding model with the given profile
+func (app *App) CreateElasticIndex(indexName string, profile IndexProfile) string {
+	elasticClient, err := app.createElasticsearchClient(5000)
+	if err != nil {
+		return err.Error()
+	}
+	// The index may need an embedding to learn the vector size; a limit of 0 means no limit
+	ctx := app.ctx
+	if maxRuntime := maxProcessRuntime(*app.appArgs); maxRuntime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(app.ctx, maxRuntime)
+		defer cancel()
+	}
+
+	exists, err := elasticClient.IndexExists(ctx, indexName)
+	if err != nil {
+		app.log.Error("Failed to check index: " + err.Error())
+		return err.Error()
+	}
+	if exists {
+		return fmt.Sprintf("Index '%s' already exists", indexName)
+	}
+	if err := elasticClient.EnsureDocumentIndex(ctx, indexName, *app.appArgs, *app.llamaEmbedArgs, profile); err != nil {
+		app.log.Error("Failed to create index: " + err.Error())
+		return err.Error()
+	}
+	return fmt.Sprintf("Index '%s' created", indexName)
+}
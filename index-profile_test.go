@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/wailsapp/wails/v2/pkg/logger"
+)
+
+func TestCreateElasticIndexWithoutRuntimeLimit(t *testing.T) {
+	es := newFakeElasticsearch()
+	embedArgs := testMigrationEmbedArgs
+	app := &App{
+		ctx: context.Background(),
+		log: logger.NewDefaultLogger(),
+		appArgs: &DefaultAppArgs{
+			LLamaEmbedCliPath:            fakeLlamaEmbedding(t),
+			ElasticsearchServerAddresses: []string{newTestElasticsearchServer(t, es.handle)},
+			ProcessMaxRuntimeMinutes:     0, // no limit
+		},
+		llamaEmbedArgs: &embedArgs,
+	}
+
+	if result := app.CreateElasticIndex("notes", IndexProfile{}); result != "Index 'notes' created" {
+		t.Fatalf("CreateElasticIndex: %s", result)
+	}
+	if es.indices["notes"] == nil {
+		t.Fatal("the index was not created")
+	}
+}
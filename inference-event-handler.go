@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/wailsapp/wails/v2/pkg/runtime"
@@ -105,13 +106,27 @@ func (eh *EventHandler) handleInferenceCompletionRequest(request InferenceComple
 	}
 
 	processingStartTime := time.Now()
+	job, err := eh.app.startJob(request.RequestID, JobKindInference)
+	if err != nil {
+		eh.emitInferenceCompletionResponse(InferenceCompletionResponse{
+			RequestID: request.RequestID,
+			Success:   false,
+			Error:     err.Error(),
+		})
+		return
+	}
+	request.RequestID = job.ID()
+
 	eh.emitProgressUpdate(request.RequestID, "starting", "Starting inference completion...", 5)
 	eh.emitProgressUpdate(request.RequestID, "processing", "Processing inference completion...", 10)
 
 	eh.app.log.Info(fmt.Sprintf("Calling inference completion with converted arguments for request: %s", request.RequestID))
 
-	result := eh.generateInferenceCompletionWithProgress(request)
-	response := eh.createCompletionResponse(request.RequestID, result, processingStartTime)
+	response := InferenceCompletionResponse{RequestID: request.RequestID, Success: true}
+	result, err := eh.generateInferenceCompletionWithProgress(job.Context(), request, &response)
+	eh.app.jobs.Finish(job, err)
+	response.Result = result
+	response.ProcessingTime = time.Since(processingStartTime).Milliseconds()
 
 	eh.finalizeInferenceCompletion(request.RequestID, response, processingStartTime)
 }
@@ -126,41 +141,60 @@ func (eh *EventHandler) finalizeInferenceCompletion(requestID string, response I
 	eh.app.log.Info(fmt.Sprintf("Inference completion request %s completed in %dms", requestID, processingTime))
 }
 
-func (eh *EventHandler) generateInferenceCompletionWithProgress(request InferenceCompletionRequest) string {
+// generateInferenceCompletionWithProgress returns the answer, or an error message, and fills in the
+// reasoning, channels and structured result of response
+func (eh *EventHandler) generateInferenceCompletionWithProgress(ctx context.Context, request InferenceCompletionRequest, response *InferenceCompletionResponse) (string, error) {
 	eh.emitProgressUpdate(request.RequestID, "processing", "Processing prompt...", 20)
 
-	processedPrompt, err := eh.processPromptIfProvided(request)
+	promptText, options, err := PromptOptions{SystemPrompt: request.SystemPrompt, Variables: request.Variables}.Expand(request.LlamaCliArgs.PromptText)
+	if err != nil {
+		eh.app.log.Error("Failed to expand prompt variables: " + err.Error())
+		return "Error: " + err.Error(), err
+	}
+	promptText, response.StrippedTokens = NewTemplateProcessor(eh.app.log).StripControlTokens(request.PromptType, PromptSourceInput, promptText)
+	request.LlamaCliArgs.PromptText = promptText
+
+	messages := BuildChatMessages(request.PromptType, promptText, options)
+	processedPrompt, err := eh.processPromptIfProvided(request, options)
 	if err != nil {
-		return "Error: " + err.Error()
+		return "Error: " + err.Error(), err
 	}
 	request.LlamaCliArgs.PromptText = processedPrompt
 
 	eh.emitProgressUpdate(request.RequestID, "generating", "Generating completion...", 50)
 
-	completionResult, err := eh.executeCompletion(request)
+	onToken, onReset := eh.newTokenStreamer(request.RequestID, request.LlamaCliArgs.PredictVal)
+	completionResult, structured, err := eh.executeCompletion(ctx, request, messages, onToken, onReset)
+	response.Structured = structured
+	response.Stats = completionResult.Stats
 	if err != nil {
-		return eh.handleCompletionError(err)
+		return eh.handleCompletionError(ctx, err), err
 	}
 
+	output := ParseModelOutput(request.PromptType, request.LlamaCliArgs.PromptText, completionResult.Text)
+	response.Answer = output.Answer
+	response.Reasoning = output.Reasoning
+	response.Channels = output.Channels
+
 	eh.emitProgressUpdate(request.RequestID, "saving", "Saving completion...", 80)
 
-	if eh.app.isOperationCanceled() {
-		return "Operation cancelled by user"
+	if ctx.Err() != nil {
+		return "Operation cancelled by user", ctx.Err()
 	}
 
-	eh.saveCompletionToDatabase(request.LlamaCliArgs, completionResult, request.LlamaCliArgs.PromptText)
+	eh.saveCompletionToDatabase(request.LlamaCliArgs, completionResult, output, request.LlamaCliArgs.PromptText)
 	eh.emitProgressUpdate(request.RequestID, "finalizing", "Finalizing response...", 95)
 
-	return string(completionResult)
+	return output.Answer, nil
 }
 
-func (eh *EventHandler) processPromptIfProvided(request InferenceCompletionRequest) (string, error) {
+func (eh *EventHandler) processPromptIfProvided(request InferenceCompletionRequest, options PromptOptions) (string, error) {
 	originalPromptText := request.LlamaCliArgs.PromptText
 	if len(originalPromptText) == 0 {
 		return originalPromptText, nil
 	}
 
-	processedPrompt, err := HandlePromptType(eh.app.log, request.PromptType, originalPromptText)
+	processedPrompt, err := HandlePromptType(eh.app.log, request.PromptType, request.LlamaCliArgs.ModelFullPathVal, originalPromptText, options)
 	if err != nil {
 		eh.app.log.Error("Failed to handle prompt type: " + err.Error())
 		return "", err
@@ -172,13 +206,54 @@ func (eh *EventHandler) processPromptIfProvided(request InferenceCompletionReque
 	return processedPrompt, nil
 }
 
-func (eh *EventHandler) executeCompletion(request InferenceCompletionRequest) ([]byte, error) {
-	completionArgs := LlamaCliStructToArgs(request.LlamaCliArgs)
-	return GenerateSingleCompletionWithCancel(eh.app.operationCtx, *eh.app.appArgs, completionArgs)
+func (eh *EventHandler) executeCompletion(ctx context.Context, request InferenceCompletionRequest, messages []ChatMessage, onToken TokenCallback, onReset func()) (CompletionResult, *StructuredOutputResult, error) {
+	return eh.app.generateCompletionOrStructured(ctx, CompletionRequest{
+		RequestID:  request.RequestID,
+		CliArgs:    request.LlamaCliArgs,
+		PromptType: request.PromptType,
+		Prompt:     request.LlamaCliArgs.PromptText,
+		Messages:   messages,
+	}, request.StructuredOutput, onToken, onReset)
+}
+
+// newTokenStreamer returns a callback that forwards generated text to the frontend as
+// inference-completion-token events and advances the progress bar from 50 to 80 percent
+// based on how many batches have arrived relative to the requested prediction length. The
+// second callback tells the frontend to discard the streamed text before a structured-output retry.
+func (eh *EventHandler) newTokenStreamer(requestID, predictVal string) (TokenCallback, func()) {
+	predictLimit, _ := strconv.Atoi(predictVal)
+	tokenIndex := 0
+	lastProgress := 50
+
+	onReset := func() {
+		eh.emitInferenceCompletionToken(InferenceCompletionToken{RequestID: requestID, Reset: true})
+		tokenIndex = 0
+	}
+	onToken := func(token string) {
+		eh.emitInferenceCompletionToken(InferenceCompletionToken{
+			RequestID: requestID,
+			Token:     token,
+			Index:     tokenIndex,
+		})
+		tokenIndex++
+
+		if predictLimit <= 0 {
+			return
+		}
+		progress := 50 + (tokenIndex*30)/predictLimit
+		if progress > 79 {
+			progress = 79
+		}
+		if progress > lastProgress {
+			lastProgress = progress
+			eh.emitProgressUpdate(requestID, "generating", fmt.Sprintf("Generated %d tokens...", tokenIndex), progress)
+		}
+	}
+	return onToken, onReset
 }
 
-func (eh *EventHandler) handleCompletionError(err error) string {
-	if errors.Is(eh.app.operationCtx.Err(), context.Canceled) {
+func (eh *EventHandler) handleCompletionError(ctx context.Context, err error) string {
+	if errors.Is(ctx.Err(), context.Canceled) {
 		eh.app.log.Info("Completion generation was cancelled by user")
 		return "Operation cancelled by user"
 	}
@@ -186,8 +261,8 @@ func (eh *EventHandler) handleCompletionError(err error) string {
 	return "Error: " + err.Error()
 }
 
-func (eh *EventHandler) saveCompletionToDatabase(llamaArgs LlamaCliArgs, output []byte, originalPrompt string) {
-	if err := eh.app.saveQuestionResponse(llamaArgs, output, originalPrompt); err != nil {
+func (eh *EventHandler) saveCompletionToDatabase(llamaArgs LlamaCliArgs, completion CompletionResult, output ParsedOutput, originalPrompt string) {
+	if err := eh.app.saveQuestionResponse(llamaArgs, []byte(completion.Text), output, completion.Stats, originalPrompt); err != nil {
 		eh.app.log.Error("Failed to save completion: " + err.Error())
 	}
 }
@@ -199,20 +274,16 @@ func (eh *EventHandler) emitProgressUpdate(requestID, status, message string, pr
 		Message:   message,
 		Progress:  progress,
 	}
+	eh.app.jobs.SetProgress(requestID, progress)
 	eh.app.log.Info(fmt.Sprintf("Emitting inference progress: %+v", progressData))
 	runtime.EventsEmit(eh.app.ctx, "inference-completion-progress", progressData)
 }
 
+func (eh *EventHandler) emitInferenceCompletionToken(token InferenceCompletionToken) {
+	runtime.EventsEmit(eh.app.ctx, "inference-completion-token", token)
+}
+
 func (eh *EventHandler) emitInferenceCompletionResponse(response InferenceCompletionResponse) {
 	eh.app.log.Info(fmt.Sprintf("Emitting inference response: %+v", response))
 	runtime.EventsEmit(eh.app.ctx, "inference-completion-response", response)
 }
-
-func (eh *EventHandler) createCompletionResponse(requestID, result string, processingStartTime time.Time) InferenceCompletionResponse {
-	return InferenceCompletionResponse{
-		RequestID:      requestID,
-		Success:        true,
-		Result:         result,
-		ProcessingTime: time.Since(processingStartTime).Milliseconds(),
-	}
-}
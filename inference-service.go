@@ -1,16 +1,14 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"math/rand"
-	"os"
-	"os/exec"
-	"runtime"
-	"sync"
-	"syscall"
+	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 // InferenceCompletionRequest represents the structure for inference completion requests
@@ -19,6 +17,11 @@ type InferenceCompletionRequest struct {
 	PromptText   string       `json:"promptText"`
 	PromptType   string       `json:"promptType"`
 	RequestID    string       `json:"requestId,omitempty"`
+
+	SystemPrompt string            `json:"systemPrompt,omitempty"` // replaces the prompt type's system instruction
+	Variables    map[string]string `json:"variables,omitempty"`    // values for {{.Name}} references in the prompt
+
+	StructuredOutput *StructuredOutputRequest `json:"structuredOutput,omitempty"`
 }
 
 // InferenceCompletionResponse represents the response structure
@@ -28,6 +31,23 @@ type InferenceCompletionResponse struct {
 	Result         string `json:"result"`
 	Error          string `json:"error,omitempty"`
 	ProcessingTime int64  `json:"processingTime"`
+
+	Answer    string          `json:"answer,omitempty"`    // final answer, also returned as Result
+	Reasoning string          `json:"reasoning,omitempty"` // <think> blocks or the harmony analysis channel
+	Channels  []OutputChannel `json:"channels,omitempty"`  // harmony channel messages in order
+
+	Structured *StructuredOutputResult `json:"structured,omitempty"` // parsed and validated reply in structured-output mode
+	Stats      *RunStats               `json:"stats,omitempty"`      // performance figures reported by the backend
+
+	StrippedTokens []StrippedControlToken `json:"strippedTokens,omitempty"` // control tokens removed from the prompt text
+}
+
+// InferenceCompletionToken carries a batch of generated text streamed while the model is running
+type InferenceCompletionToken struct {
+	RequestID string `json:"requestId,omitempty"`
+	Token     string `json:"token"`
+	Index     int    `json:"index"`           // sequence number of this batch within the attempt
+	Reset     bool   `json:"reset,omitempty"` // the batches streamed so far were discarded and a retry follows
 }
 
 // InferenceCompletionProgress represents progress updates
@@ -38,24 +58,8 @@ type InferenceCompletionProgress struct {
 	Progress  int    `json:"progress"` // 0-100
 }
 
-// isOperationCanceled checks if the current operation was canceled
-func (app *App) isOperationCanceled() bool {
-	if app.operationCtx == nil {
-		app.log.Error("operationCtx is nil - this indicates improper initialization")
-		return false // or return true if you want to treat nil context as cancelled
-	}
-
-	select {
-	case <-app.operationCtx.Done():
-		app.log.Info("Operation was cancelled by user")
-		return true
-	default:
-		return false
-	}
-}
-
-// saveQuestionResponse saves the generated completion to the database
-func (app *App) saveQuestionResponse(llamaCliArgs LlamaCliArgs, completionOutput []byte, originalPromptText string) error {
+// saveQuestionResponse saves the generated completion, its reasoning and its answer to the database
+func (app *App) saveQuestionResponse(llamaCliArgs LlamaCliArgs, completionOutput []byte, output ParsedOutput, stats *RunStats, originalPromptText string) error {
 	jsonArgs, err := json.Marshal(llamaCliArgs)
 	if err != nil {
 		return fmt.Errorf("failed to convert arguments to JSON: %w", err)
@@ -64,7 +68,8 @@ func (app *App) saveQuestionResponse(llamaCliArgs LlamaCliArgs, completionOutput
 	// Convert JSON args to string and remove brackets
 	jsonArgsStr := app.removeJSONBrackets(string(jsonArgs))
 
-	return SaveQuestionResponse(app.appArgs, string(completionOutput), jsonArgsStr, originalPromptText)
+	response := strings.TrimSpace(stripEchoedPrompt(string(completionOutput), llamaCliArgs.PromptText))
+	return SaveQuestionResponse(app.appArgs, response, output.Reasoning, output.Answer, stats, jsonArgsStr, originalPromptText)
 }
 
 // removeJSONBrackets removes the first and last characters (brackets) from JSON string
@@ -82,80 +87,113 @@ func generateUniqueFileName(baseName string) string {
 	return fmt.Sprintf("%s_%s_%04d.txt", baseName, timestamp, randomSuffix)
 }
 
-var (
-	llamaCliMutex sync.Mutex
-)
+// TokenCallback receives each decoded batch of text as llama-cli writes it to stdout
+type TokenCallback func(token string)
+
+// generateCompletion runs request on the backend selected by its settings profile
+func (app *App) generateCompletion(ctx context.Context, request CompletionRequest, onToken TokenCallback) (CompletionResult, error) {
+	backend, err := app.backends.Resolve(request.CliArgs)
+	if err != nil {
+		return CompletionResult{}, err
+	}
+	app.log.Info(fmt.Sprintf("Running completion %s on %s backend", request.RequestID, backend.Name()))
 
-func GenerateSingleCompletionWithCancel(ctx context.Context, appArgs DefaultAppArgs, args []string) ([]byte, error) {
-	// Lock to prevent concurrent CLI calls
+	var result CompletionResult
+	if onToken != nil && backend.Capabilities().Streaming {
+		result, err = backend.Stream(ctx, request, onToken)
+	} else {
+		result, err = backend.Complete(ctx, request)
+	}
+	if result.Stats != nil {
+		app.log.Info(fmt.Sprintf("Completion %s: %d prompt tokens at %.2f tokens/s, %d generated tokens at %.2f tokens/s",
+			request.RequestID, result.Stats.PromptTokens, result.Stats.PromptTokensPerSecond, result.Stats.GeneratedTokens, result.Stats.GenerationTokensPerSecond))
+	}
+	return result, err
+}
+
+// GenerateSingleCompletionWithCancel runs llama-cli and returns the complete output, and the
+// performance figures llama-cli reported, once the process exits
+func GenerateSingleCompletionWithCancel(ctx context.Context, appArgs DefaultAppArgs, args []string) ([]byte, *RunStats, error) {
+	return GenerateStreamingCompletionWithCancel(ctx, appArgs, args, nil)
+}
+
+// GenerateStreamingCompletionWithCancel runs llama-cli and reads stdout incrementally as it is written.
+// Each decoded batch is passed to onToken as soon as it is available; the full output is still
+// returned when the process exits so callers can persist the complete completion. The performance
+// report llama-cli prints to stderr is returned as RunStats, or nil when there was none.
+func GenerateStreamingCompletionWithCancel(ctx context.Context, appArgs DefaultAppArgs, args []string, onToken TokenCallback) ([]byte, *RunStats, error) {
 	if ctx == nil {
 		ctx = context.Background()
 	}
+	if err := checkLlamaArgs(ctx, LlamaBinaryCli, appArgs.LLamaCliPath, args); err != nil {
+		return nil, nil, err
+	}
 
-	llamaCliMutex.Lock()
-	defer llamaCliMutex.Unlock()
+	// Wait for an LLM slot; interactive requests are served ahead of background jobs
+	release, err := workScheduler.Acquire(ctx, ResourceLLM)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer release()
+
+	statsWriter := &RunStatsWriter{}
+	stream := &tokenStreamWriter{onToken: onToken}
+	_, err = RunProcess(ctx, ProcessSpec{
+		Name:       "llama-cli",
+		Path:       appArgs.LLamaCliPath,
+		Args:       args,
+		Stdout:     stream,
+		Stderr:     statsWriter,
+		MaxRuntime: maxProcessRuntime(appArgs),
+	})
+	stream.Flush()
+	if ctx.Err() != nil {
+		return nil, nil, ctx.Err()
+	}
+	return stream.output.Bytes(), statsWriter.Stats(), err
+}
 
-	// Create the command with context
-	cmd := exec.CommandContext(ctx, appArgs.LLamaCliPath, args...)
+// tokenStreamWriter collects llama-cli's stdout and forwards it to onToken as it arrives, holding
+// back the bytes of a multibyte rune until the rune is complete
+type tokenStreamWriter struct {
+	onToken TokenCallback
+	output  bytes.Buffer
+	pending []byte
+}
 
-	// Set up process attributes for proper termination
-	if runtime.GOOS == "windows" {
-		cmd.SysProcAttr = &syscall.SysProcAttr{
-			HideWindow:    true,
-			CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP,
-		}
-	} else {
-		// On Unix-like systems, use process group settings
-		cmd.SysProcAttr = &syscall.SysProcAttr{}
+func (w *tokenStreamWriter) Write(p []byte) (int, error) {
+	w.output.Write(p)
+	if w.onToken == nil {
+		return len(p), nil
+	}
+	w.pending = append(w.pending, p...)
+	if complete := completeUTF8Prefix(w.pending); complete > 0 {
+		w.onToken(string(w.pending[:complete]))
+		w.pending = append(w.pending[:0], w.pending[complete:]...)
 	}
+	return len(p), nil
+}
 
-	// Create a channel to capture the result
-	result := make(chan struct {
-		output []byte
-		err    error
-	})
+// Flush forwards any trailing bytes that never formed a complete rune
+func (w *tokenStreamWriter) Flush() {
+	if len(w.pending) > 0 && w.onToken != nil {
+		w.onToken(string(w.pending))
+	}
+	w.pending = nil
+}
 
-	// Run the command in a goroutine
-	go func() {
-		defer close(result)
-		out, err := cmd.Output()
-
-		select {
-		case result <- struct {
-			output []byte
-			err    error
-		}{output: out, err: err}:
-		case <-ctx.Done():
-			// Context canceled, don't send result
+// completeUTF8Prefix returns the length of the longest prefix of data that does not end in a
+// partially written multibyte rune, so token batches never split a character in half
+func completeUTF8Prefix(data []byte) int {
+	// A UTF-8 sequence is at most 4 bytes, so only the tail needs inspecting
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if !utf8.RuneStart(data[i]) {
+			continue
 		}
-	}()
-
-	select {
-	case res := <-result:
-		// Command completed normally
-		return res.output, res.err
-	case <-ctx.Done():
-		// Context was canceled - ensure process is terminated
-		if cmd.Process != nil {
-			// Use the standard Process.Kill() method which works cross-platform
-			if err := cmd.Process.Kill(); err != nil {
-				// If Kill() fails, try using Signal on Unix
-				if runtime.GOOS != "windows" {
-					cmd.Process.Signal(os.Kill)
-				}
-			}
+		if utf8.FullRune(data[i:]) {
+			return len(data)
 		}
-
-		// Wait for the process to actually terminate
-		if cmd.ProcessState == nil {
-			go func() {
-				err := cmd.Wait()
-				if err != nil {
-					return
-				} // Clean up the process
-			}()
-		}
-
-		return nil, ctx.Err()
+		return i
 	}
+	return len(data)
 }
@@ -0,0 +1,2570 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// This file implements the subset of Jinja2 used by the chat templates embedded in GGUF models
+// (tokenizer.chat_template). Templates are rendered the way Hugging Face renders them: with
+// trim_blocks and lstrip_blocks enabled, lenient undefined values and the raise_exception,
+// namespace, range and strftime_now globals.
+
+// jinjaUndefined is the value of a missing variable, attribute or key
+type jinjaUndefined struct{}
+
+// jinjaFunc is a callable template value: a global function, a bound method or a macro
+type jinjaFunc func(args []interface{}, kwargs map[string]interface{}) (interface{}, error)
+
+var (
+	errJinjaBreak    = errors.New("break outside of a loop")
+	errJinjaContinue = errors.New("continue outside of a loop")
+)
+
+// jinjaTemplateCache keeps parsed templates keyed by their source
+var jinjaTemplateCache sync.Map
+
+// JinjaTemplate is a parsed chat template
+type JinjaTemplate struct {
+	body []jinjaNode
+}
+
+// ParseJinjaTemplate parses source into a template that can be rendered many times
+func ParseJinjaTemplate(source string) (*JinjaTemplate, error) {
+	if cached, ok := jinjaTemplateCache.Load(source); ok {
+		return cached.(*JinjaTemplate), nil
+	}
+	tokens, err := lexJinja(source)
+	if err != nil {
+		return nil, err
+	}
+	parser := &jinjaParser{tokens: tokens}
+	body, err := parser.parseBody()
+	if err != nil {
+		return nil, err
+	}
+	if parser.pos < len(parser.tokens) {
+		return nil, fmt.Errorf("unexpected {%% %s %%}", parser.tokens[parser.pos].text)
+	}
+	template := &JinjaTemplate{body: body}
+	jinjaTemplateCache.Store(source, template)
+	return template, nil
+}
+
+// Render executes the template with the given variables
+func (t *JinjaTemplate) Render(variables map[string]interface{}) (string, error) {
+	globals := map[string]interface{}{
+		"raise_exception": jinjaFunc(jinjaRaiseException),
+		"namespace":       jinjaFunc(jinjaNamespace),
+		"range":           jinjaFunc(jinjaRange),
+		"dict":            jinjaFunc(jinjaDict),
+		"strftime_now":    jinjaFunc(jinjaStrftimeNow),
+	}
+	for name, value := range variables {
+		globals[name] = value
+	}
+	root := &jinjaScope{vars: globals}
+
+	var builder strings.Builder
+	if err := renderJinjaNodes(t.body, root, &builder); err != nil {
+		return "", err
+	}
+	return builder.String(), nil
+}
+
+// ---------------------------------------------------------------------------------------------
+// Lexer
+
+type jinjaTokenKind int
+
+const (
+	jinjaText jinjaTokenKind = iota
+	jinjaOutput
+	jinjaStatement
+)
+
+type jinjaToken struct {
+	kind jinjaTokenKind
+	text string
+}
+
+// lexJinja splits source into text, {{ output }} and {% statement %} tokens, applying the
+// whitespace control markers and trim_blocks/lstrip_blocks. Comments are dropped.
+func lexJinja(source string) ([]jinjaToken, error) {
+	var tokens []jinjaToken
+	trimAllAfter := false     // a "-" marker strips all whitespace after the tag
+	trimNewlineAfter := false // trim_blocks strips the first newline after a block tag
+	for position := 0; position <= len(source); {
+		start := len(source)
+		for _, opener := range []string{"{{", "{%", "{#"} {
+			if index := strings.Index(source[position:], opener); index >= 0 && position+index < start {
+				start = position + index
+			}
+		}
+
+		text := source[position:start]
+		// Whether the text begins a line of the template, before any trimming
+		lineStart := position == 0 || source[position-1] == '\n'
+		if trimAllAfter {
+			text = strings.TrimLeft(text, " \t\r\n")
+		} else if trimNewlineAfter && (strings.HasPrefix(text, "\n") || strings.HasPrefix(text, "\r\n")) {
+			text = text[strings.Index(text, "\n")+1:]
+			lineStart = true
+		}
+		if start == len(source) {
+			if text != "" {
+				tokens = append(tokens, jinjaToken{kind: jinjaText, text: text})
+			}
+			break
+		}
+
+		opener := source[start+1]
+		closer := map[byte]string{'{': "}}", '%': "%}", '#': "#}"}[opener]
+		end := strings.Index(source[start+2:], closer)
+		if end < 0 {
+			return nil, fmt.Errorf("unclosed %q tag", source[start:start+2])
+		}
+		inner := source[start+2 : start+2+end]
+		position = start + 2 + end + 2
+
+		stripBefore := strings.HasPrefix(inner, "-")
+		stripAfter := strings.HasSuffix(inner, "-")
+		keepBefore := strings.HasPrefix(inner, "+")
+		inner = strings.Trim(inner, "-+")
+
+		if stripBefore {
+			text = strings.TrimRight(text, " \t\r\n")
+		} else if opener != '{' && !keepBefore {
+			// lstrip_blocks: drop the indentation before a block tag that starts its line
+			indentStart := strings.LastIndex(text, "\n") + 1
+			if strings.TrimLeft(text[indentStart:], " \t") == "" && (indentStart > 0 || lineStart) {
+				text = text[:indentStart]
+			}
+		}
+		if text != "" {
+			tokens = append(tokens, jinjaToken{kind: jinjaText, text: text})
+		}
+
+		trimAllAfter = stripAfter
+		trimNewlineAfter = opener != '{'
+		switch opener {
+		case '{':
+			tokens = append(tokens, jinjaToken{kind: jinjaOutput, text: strings.TrimSpace(inner)})
+		case '%':
+			tokens = append(tokens, jinjaToken{kind: jinjaStatement, text: strings.TrimSpace(inner)})
+		}
+	}
+	return tokens, nil
+}
+
+type jinjaExprTokenKind int
+
+const (
+	exprName jinjaExprTokenKind = iota
+	exprString
+	exprInt
+	exprFloat
+	exprOperator
+	exprEnd
+)
+
+type jinjaExprToken struct {
+	kind  jinjaExprTokenKind
+	text  string
+	value interface{}
+}
+
+var jinjaOperators = []string{"==", "!=", "<=", ">=", "//", "**", "<", ">", "+", "-", "*", "/", "%", "~", "=", "(", ")", "[", "]", "{", "}", ",", ":", ".", "|"}
+
+// lexJinjaExpression splits the inside of a tag into names, literals and operators
+func lexJinjaExpression(source string) ([]jinjaExprToken, error) {
+	var tokens []jinjaExprToken
+	for i := 0; i < len(source); {
+		c := source[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '"' || c == '\'':
+			value, length, err := unquoteJinjaString(source[i:])
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, jinjaExprToken{kind: exprString, value: value})
+			i += length
+		case c >= '0' && c <= '9':
+			j := i
+			isFloat := false
+			for j < len(source) && (source[j] >= '0' && source[j] <= '9' || source[j] == '_' || (source[j] == '.' && !isFloat && j+1 < len(source) && source[j+1] >= '0' && source[j+1] <= '9')) {
+				if source[j] == '.' {
+					isFloat = true
+				}
+				j++
+			}
+			literal := strings.ReplaceAll(source[i:j], "_", "")
+			if isFloat {
+				value, _ := strconv.ParseFloat(literal, 64)
+				tokens = append(tokens, jinjaExprToken{kind: exprFloat, value: value})
+			} else {
+				value, _ := strconv.ParseInt(literal, 10, 64)
+				tokens = append(tokens, jinjaExprToken{kind: exprInt, value: value})
+			}
+			i = j
+		case c == '_' || unicode.IsLetter(rune(c)):
+			j := i
+			for j < len(source) && (source[j] == '_' || unicode.IsLetter(rune(source[j])) || unicode.IsDigit(rune(source[j]))) {
+				j++
+			}
+			tokens = append(tokens, jinjaExprToken{kind: exprName, text: source[i:j]})
+			i = j
+		default:
+			matched := false
+			for _, operator := range jinjaOperators {
+				if strings.HasPrefix(source[i:], operator) {
+					tokens = append(tokens, jinjaExprToken{kind: exprOperator, text: operator})
+					i += len(operator)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected character %q in %q", c, source)
+			}
+		}
+	}
+	return append(tokens, jinjaExprToken{kind: exprEnd}), nil
+}
+
+// unquoteJinjaString reads a quoted string literal and returns its value and length in source
+func unquoteJinjaString(source string) (string, int, error) {
+	quote := source[0]
+	var builder strings.Builder
+	for i := 1; i < len(source); i++ {
+		c := source[i]
+		if c == quote {
+			return builder.String(), i + 1, nil
+		}
+		if c != '\\' || i+1 >= len(source) {
+			builder.WriteByte(c)
+			continue
+		}
+		i++
+		switch source[i] {
+		case 'n':
+			builder.WriteByte('\n')
+		case 't':
+			builder.WriteByte('\t')
+		case 'r':
+			builder.WriteByte('\r')
+		case 'u':
+			if i+4 < len(source) {
+				if code, err := strconv.ParseUint(source[i+1:i+5], 16, 32); err == nil {
+					builder.WriteRune(rune(code))
+					i += 4
+					continue
+				}
+			}
+			builder.WriteString(`\u`)
+		case '\\', '\'', '"':
+			builder.WriteByte(source[i])
+		default:
+			builder.WriteByte('\\')
+			builder.WriteByte(source[i])
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated string literal")
+}
+
+// ---------------------------------------------------------------------------------------------
+// Statement parser
+
+type jinjaNode interface{}
+
+type jinjaTextNode struct{ text string }
+
+type jinjaOutputNode struct{ expr jinjaExpr }
+
+type jinjaIfBranch struct {
+	cond jinjaExpr
+	body []jinjaNode
+}
+
+type jinjaIfNode struct {
+	branches []jinjaIfBranch
+	elseBody []jinjaNode
+}
+
+type jinjaForNode struct {
+	targets  []string
+	iter     jinjaExpr
+	filter   jinjaExpr
+	body     []jinjaNode
+	elseBody []jinjaNode
+}
+
+type jinjaSetNode struct {
+	targets   []string
+	attribute string // set ns.attribute = value
+	expr      jinjaExpr
+	body      []jinjaNode // block form: {% set name %}...{% endset %}
+}
+
+type jinjaMacroNode struct {
+	name     string
+	params   []string
+	defaults map[string]jinjaExpr
+	body     []jinjaNode
+}
+
+type jinjaLoopControlNode struct{ err error }
+
+type jinjaParser struct {
+	tokens []jinjaToken
+	pos    int
+}
+
+// parseBody parses nodes until an end, else or elif statement, which is left for the caller
+func (p *jinjaParser) parseBody() ([]jinjaNode, error) {
+	var nodes []jinjaNode
+	for p.pos < len(p.tokens) {
+		token := p.tokens[p.pos]
+		switch token.kind {
+		case jinjaText:
+			nodes = append(nodes, jinjaTextNode{text: token.text})
+			p.pos++
+		case jinjaOutput:
+			expr, err := parseJinjaExpression(token.text)
+			if err != nil {
+				return nil, err
+			}
+			nodes = append(nodes, jinjaOutputNode{expr: expr})
+			p.pos++
+		case jinjaStatement:
+			keyword := strings.Fields(token.text + " ")[0]
+			if strings.HasPrefix(keyword, "end") || keyword == "else" || keyword == "elif" {
+				return nodes, nil
+			}
+			node, err := p.parseStatement(keyword, strings.TrimSpace(strings.TrimPrefix(token.text, keyword)))
+			if err != nil {
+				return nil, err
+			}
+			if node != nil {
+				nodes = append(nodes, node)
+			}
+		}
+	}
+	return nodes, nil
+}
+
+// expectEnd consumes the {% name %} statement that closes a block
+func (p *jinjaParser) expectEnd(names ...string) (string, string, error) {
+	if p.pos >= len(p.tokens) {
+		return "", "", fmt.Errorf("missing {%% %s %%}", names[len(names)-1])
+	}
+	text := p.tokens[p.pos].text
+	keyword := strings.Fields(text + " ")[0]
+	for _, name := range names {
+		if keyword == name {
+			p.pos++
+			return keyword, strings.TrimSpace(strings.TrimPrefix(text, keyword)), nil
+		}
+	}
+	return "", "", fmt.Errorf("expected {%% %s %%}, found {%% %s %%}", names[len(names)-1], text)
+}
+
+func (p *jinjaParser) parseStatement(keyword, rest string) (jinjaNode, error) {
+	p.pos++
+	switch keyword {
+	case "if":
+		node := jinjaIfNode{}
+		cond := rest
+		for {
+			expr, err := parseJinjaExpression(cond)
+			if err != nil {
+				return nil, err
+			}
+			body, err := p.parseBody()
+			if err != nil {
+				return nil, err
+			}
+			node.branches = append(node.branches, jinjaIfBranch{cond: expr, body: body})
+			end, elifCondition, err := p.expectEnd("elif", "else", "endif")
+			if err != nil {
+				return nil, err
+			}
+			switch end {
+			case "elif":
+				cond = elifCondition
+				continue
+			case "else":
+				if node.elseBody, err = p.parseBody(); err != nil {
+					return nil, err
+				}
+				if _, _, err := p.expectEnd("endif"); err != nil {
+					return nil, err
+				}
+			}
+			return node, nil
+		}
+	case "for":
+		return p.parseFor(rest)
+	case "set":
+		return p.parseSet(rest)
+	case "macro":
+		return p.parseMacro(rest)
+	case "generation":
+		// Hugging Face marks the assistant output of training templates; it renders as its body
+		body, err := p.parseBody()
+		if err != nil {
+			return nil, err
+		}
+		if _, _, err := p.expectEnd("endgeneration"); err != nil {
+			return nil, err
+		}
+		return jinjaIfNode{branches: []jinjaIfBranch{{cond: jinjaLiteral{value: true}, body: body}}}, nil
+	case "break":
+		return jinjaLoopControlNode{err: errJinjaBreak}, nil
+	case "continue":
+		return jinjaLoopControlNode{err: errJinjaContinue}, nil
+	}
+	return nil, fmt.Errorf("unsupported tag {%% %s %%}", keyword)
+}
+
+func (p *jinjaParser) parseFor(rest string) (jinjaNode, error) {
+	tokens, err := lexJinjaExpression(rest)
+	if err != nil {
+		return nil, err
+	}
+	expr := &jinjaExprParser{tokens: tokens}
+	node := jinjaForNode{}
+	for {
+		name := expr.next()
+		if name.kind != exprName {
+			return nil, fmt.Errorf("invalid for loop target in %q", rest)
+		}
+		node.targets = append(node.targets, name.text)
+		if !expr.acceptOperator(",") {
+			break
+		}
+	}
+	if !expr.acceptName("in") {
+		return nil, fmt.Errorf("missing 'in' in for loop %q", rest)
+	}
+	// The iterable may not contain a conditional expression, so "if" starts the loop filter
+	if node.iter, err = expr.parseOr(); err != nil {
+		return nil, err
+	}
+	if expr.acceptName("if") {
+		if node.filter, err = expr.parseOr(); err != nil {
+			return nil, err
+		}
+	}
+	if expr.acceptName("recursive") {
+		return nil, fmt.Errorf("recursive loops are not supported")
+	}
+	if err := expr.expectEnd(); err != nil {
+		return nil, err
+	}
+
+	if node.body, err = p.parseBody(); err != nil {
+		return nil, err
+	}
+	end, _, err := p.expectEnd("else", "endfor")
+	if err != nil {
+		return nil, err
+	}
+	if end == "else" {
+		if node.elseBody, err = p.parseBody(); err != nil {
+			return nil, err
+		}
+		if _, _, err := p.expectEnd("endfor"); err != nil {
+			return nil, err
+		}
+	}
+	return node, nil
+}
+
+func (p *jinjaParser) parseSet(rest string) (jinjaNode, error) {
+	tokens, err := lexJinjaExpression(rest)
+	if err != nil {
+		return nil, err
+	}
+	expr := &jinjaExprParser{tokens: tokens}
+	node := jinjaSetNode{}
+	for {
+		name := expr.next()
+		if name.kind != exprName {
+			return nil, fmt.Errorf("invalid set target in %q", rest)
+		}
+		node.targets = append(node.targets, name.text)
+		if len(node.targets) == 1 && expr.acceptOperator(".") {
+			attribute := expr.next()
+			if attribute.kind != exprName {
+				return nil, fmt.Errorf("invalid set target in %q", rest)
+			}
+			node.attribute = attribute.text
+		}
+		if !expr.acceptOperator(",") {
+			break
+		}
+	}
+
+	if expr.peek().kind == exprEnd {
+		if node.body, err = p.parseBody(); err != nil {
+			return nil, err
+		}
+		_, _, err := p.expectEnd("endset")
+		return node, err
+	}
+	if !expr.acceptOperator("=") {
+		return nil, fmt.Errorf("missing '=' in set %q", rest)
+	}
+	if node.expr, err = expr.parseTuple(); err != nil {
+		return nil, err
+	}
+	return node, expr.expectEnd()
+}
+
+func (p *jinjaParser) parseMacro(rest string) (jinjaNode, error) {
+	tokens, err := lexJinjaExpression(rest)
+	if err != nil {
+		return nil, err
+	}
+	expr := &jinjaExprParser{tokens: tokens}
+	name := expr.next()
+	if name.kind != exprName || !expr.acceptOperator("(") {
+		return nil, fmt.Errorf("invalid macro %q", rest)
+	}
+	node := jinjaMacroNode{name: name.text, defaults: make(map[string]jinjaExpr)}
+	for !expr.acceptOperator(")") {
+		param := expr.next()
+		if param.kind != exprName {
+			return nil, fmt.Errorf("invalid macro parameters %q", rest)
+		}
+		node.params = append(node.params, param.text)
+		if expr.acceptOperator("=") {
+			if node.defaults[param.text], err = expr.parseExpression(); err != nil {
+				return nil, err
+			}
+		}
+		if !expr.acceptOperator(",") && expr.peek().text != ")" {
+			return nil, fmt.Errorf("invalid macro parameters %q", rest)
+		}
+	}
+	if err := expr.expectEnd(); err != nil {
+		return nil, err
+	}
+	if node.body, err = p.parseBody(); err != nil {
+		return nil, err
+	}
+	_, _, err = p.expectEnd("endmacro")
+	return node, err
+}
+
+// ---------------------------------------------------------------------------------------------
+// Expression parser
+
+type jinjaExpr interface{}
+
+type jinjaLiteral struct{ value interface{} }
+
+type jinjaName struct{ name string }
+
+type jinjaListExpr struct{ items []jinjaExpr }
+
+type jinjaDictExpr struct{ keys, values []jinjaExpr }
+
+type jinjaAttribute struct {
+	object jinjaExpr
+	name   string
+}
+
+type jinjaIndex struct {
+	object jinjaExpr
+	index  jinjaExpr
+}
+
+type jinjaSlice struct {
+	object            jinjaExpr
+	start, stop, step jinjaExpr
+}
+
+type jinjaCall struct {
+	function jinjaExpr
+	args     []jinjaExpr
+	kwargs   map[string]jinjaExpr
+}
+
+type jinjaFilter struct {
+	value  jinjaExpr
+	name   string
+	args   []jinjaExpr
+	kwargs map[string]jinjaExpr
+}
+
+type jinjaTest struct {
+	value  jinjaExpr
+	name   string
+	args   []jinjaExpr
+	negate bool
+}
+
+type jinjaUnary struct {
+	operator string
+	operand  jinjaExpr
+}
+
+type jinjaBinary struct {
+	operator    string
+	left, right jinjaExpr
+}
+
+type jinjaConditional struct {
+	cond, then, otherwise jinjaExpr
+}
+
+type jinjaExprParser struct {
+	tokens []jinjaExprToken
+	pos    int
+}
+
+func parseJinjaExpression(source string) (jinjaExpr, error) {
+	tokens, err := lexJinjaExpression(source)
+	if err != nil {
+		return nil, err
+	}
+	parser := &jinjaExprParser{tokens: tokens}
+	expr, err := parser.parseTuple()
+	if err != nil {
+		return nil, err
+	}
+	return expr, parser.expectEnd()
+}
+
+func (p *jinjaExprParser) peek() jinjaExprToken { return p.tokens[p.pos] }
+
+func (p *jinjaExprParser) next() jinjaExprToken {
+	token := p.tokens[p.pos]
+	if token.kind != exprEnd {
+		p.pos++
+	}
+	return token
+}
+
+func (p *jinjaExprParser) acceptOperator(operator string) bool {
+	if token := p.peek(); token.kind == exprOperator && token.text == operator {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *jinjaExprParser) acceptName(name string) bool {
+	if token := p.peek(); token.kind == exprName && token.text == name {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *jinjaExprParser) expectOperator(operator string) error {
+	if !p.acceptOperator(operator) {
+		return fmt.Errorf("expected %q, found %q", operator, p.peek().text)
+	}
+	return nil
+}
+
+func (p *jinjaExprParser) expectEnd() error {
+	if token := p.peek(); token.kind != exprEnd {
+		return fmt.Errorf("unexpected %q", token.text)
+	}
+	return nil
+}
+
+// parseTuple parses an expression, or a tuple when several are separated by commas
+func (p *jinjaExprParser) parseTuple() (jinjaExpr, error) {
+	first, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().text != "," || p.peek().kind != exprOperator {
+		return first, nil
+	}
+	items := []jinjaExpr{first}
+	for p.acceptOperator(",") {
+		if token := p.peek(); token.kind == exprEnd {
+			break
+		}
+		item, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return jinjaListExpr{items: items}, nil
+}
+
+func (p *jinjaExprParser) parseExpression() (jinjaExpr, error) {
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if !p.acceptName("if") {
+		return expr, nil
+	}
+	cond, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	conditional := jinjaConditional{cond: cond, then: expr, otherwise: jinjaLiteral{value: jinjaUndefined{}}}
+	if p.acceptName("else") {
+		if conditional.otherwise, err = p.parseExpression(); err != nil {
+			return nil, err
+		}
+	}
+	return conditional, nil
+}
+
+func (p *jinjaExprParser) parseOr() (jinjaExpr, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.acceptName("or") {
+		var right jinjaExpr
+		right, err = p.parseAnd()
+		left = jinjaBinary{operator: "or", left: left, right: right}
+	}
+	return left, err
+}
+
+func (p *jinjaExprParser) parseAnd() (jinjaExpr, error) {
+	left, err := p.parseNot()
+	for err == nil && p.acceptName("and") {
+		var right jinjaExpr
+		right, err = p.parseNot()
+		left = jinjaBinary{operator: "and", left: left, right: right}
+	}
+	return left, err
+}
+
+func (p *jinjaExprParser) parseNot() (jinjaExpr, error) {
+	if p.acceptName("not") {
+		operand, err := p.parseNot()
+		return jinjaUnary{operator: "not", operand: operand}, err
+	}
+	return p.parseCompare()
+}
+
+func (p *jinjaExprParser) parseCompare() (jinjaExpr, error) {
+	left, err := p.parseMath1()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		token := p.peek()
+		var operator string
+		switch {
+		case token.kind == exprOperator && (token.text == "==" || token.text == "!=" || token.text == "<" || token.text == ">" || token.text == "<=" || token.text == ">="):
+			operator = token.text
+			p.pos++
+		case token.kind == exprName && token.text == "in":
+			operator = "in"
+			p.pos++
+		case token.kind == exprName && token.text == "not" && p.tokens[p.pos+1].kind == exprName && p.tokens[p.pos+1].text == "in":
+			operator = "not in"
+			p.pos += 2
+		default:
+			return left, nil
+		}
+		right, err := p.parseMath1()
+		if err != nil {
+			return nil, err
+		}
+		left = jinjaBinary{operator: operator, left: left, right: right}
+	}
+}
+
+func (p *jinjaExprParser) parseMath1() (jinjaExpr, error) {
+	left, err := p.parseConcat()
+	for err == nil {
+		token := p.peek()
+		if token.kind != exprOperator || (token.text != "+" && token.text != "-") {
+			break
+		}
+		p.pos++
+		var right jinjaExpr
+		right, err = p.parseConcat()
+		left = jinjaBinary{operator: token.text, left: left, right: right}
+	}
+	return left, err
+}
+
+func (p *jinjaExprParser) parseConcat() (jinjaExpr, error) {
+	left, err := p.parseMath2()
+	for err == nil && p.acceptOperator("~") {
+		var right jinjaExpr
+		right, err = p.parseMath2()
+		left = jinjaBinary{operator: "~", left: left, right: right}
+	}
+	return left, err
+}
+
+func (p *jinjaExprParser) parseMath2() (jinjaExpr, error) {
+	left, err := p.parsePow()
+	for err == nil {
+		token := p.peek()
+		if token.kind != exprOperator || (token.text != "*" && token.text != "/" && token.text != "//" && token.text != "%") {
+			break
+		}
+		p.pos++
+		var right jinjaExpr
+		right, err = p.parsePow()
+		left = jinjaBinary{operator: token.text, left: left, right: right}
+	}
+	return left, err
+}
+
+func (p *jinjaExprParser) parsePow() (jinjaExpr, error) {
+	left, err := p.parseUnary()
+	for err == nil && p.acceptOperator("**") {
+		var right jinjaExpr
+		right, err = p.parseUnary()
+		left = jinjaBinary{operator: "**", left: left, right: right}
+	}
+	return left, err
+}
+
+func (p *jinjaExprParser) parseUnary() (jinjaExpr, error) {
+	if p.acceptOperator("-") {
+		operand, err := p.parseUnary()
+		return jinjaUnary{operator: "-", operand: operand}, err
+	}
+	if p.acceptOperator("+") {
+		return p.parseUnary()
+	}
+	expr, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	if expr, err = p.parsePostfix(expr); err != nil {
+		return nil, err
+	}
+	return p.parseFilters(expr)
+}
+
+func (p *jinjaExprParser) parsePrimary() (jinjaExpr, error) {
+	token := p.next()
+	switch token.kind {
+	case exprString:
+		value := token.value.(string)
+		// Adjacent string literals are concatenated
+		for p.peek().kind == exprString {
+			value += p.next().value.(string)
+		}
+		return jinjaLiteral{value: value}, nil
+	case exprInt, exprFloat:
+		return jinjaLiteral{value: token.value}, nil
+	case exprName:
+		switch token.text {
+		case "true", "True":
+			return jinjaLiteral{value: true}, nil
+		case "false", "False":
+			return jinjaLiteral{value: false}, nil
+		case "none", "None":
+			return jinjaLiteral{value: nil}, nil
+		}
+		return jinjaName{name: token.text}, nil
+	case exprOperator:
+		switch token.text {
+		case "(":
+			if p.acceptOperator(")") {
+				return jinjaListExpr{}, nil
+			}
+			expr, err := p.parseTuple()
+			if err != nil {
+				return nil, err
+			}
+			return expr, p.expectOperator(")")
+		case "[":
+			list := jinjaListExpr{}
+			for !p.acceptOperator("]") {
+				item, err := p.parseExpression()
+				if err != nil {
+					return nil, err
+				}
+				list.items = append(list.items, item)
+				if !p.acceptOperator(",") && p.peek().text != "]" {
+					return nil, fmt.Errorf("expected ',' or ']' in list")
+				}
+			}
+			return list, nil
+		case "{":
+			dict := jinjaDictExpr{}
+			for !p.acceptOperator("}") {
+				key, err := p.parseExpression()
+				if err != nil {
+					return nil, err
+				}
+				if err := p.expectOperator(":"); err != nil {
+					return nil, err
+				}
+				value, err := p.parseExpression()
+				if err != nil {
+					return nil, err
+				}
+				dict.keys = append(dict.keys, key)
+				dict.values = append(dict.values, value)
+				if !p.acceptOperator(",") && p.peek().text != "}" {
+					return nil, fmt.Errorf("expected ',' or '}' in dict")
+				}
+			}
+			return dict, nil
+		}
+	}
+	if token.kind == exprEnd {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	return nil, fmt.Errorf("unexpected %q", token.text)
+}
+
+func (p *jinjaExprParser) parsePostfix(expr jinjaExpr) (jinjaExpr, error) {
+	for {
+		switch {
+		case p.acceptOperator("."):
+			name := p.next()
+			if name.kind != exprName && name.kind != exprInt {
+				return nil, fmt.Errorf("invalid attribute %q", name.text)
+			}
+			if name.kind == exprInt {
+				expr = jinjaIndex{object: expr, index: jinjaLiteral{value: name.value}}
+			} else {
+				expr = jinjaAttribute{object: expr, name: name.text}
+			}
+		case p.acceptOperator("["):
+			var parts [3]jinjaExpr
+			part := 0
+			isSlice := false
+			for {
+				if token := p.peek(); !(token.kind == exprOperator && (token.text == ":" || token.text == "]")) {
+					value, err := p.parseExpression()
+					if err != nil {
+						return nil, err
+					}
+					parts[part] = value
+				}
+				if p.acceptOperator(":") {
+					isSlice = true
+					part++
+					if part > 2 {
+						return nil, fmt.Errorf("invalid slice")
+					}
+					continue
+				}
+				if err := p.expectOperator("]"); err != nil {
+					return nil, err
+				}
+				break
+			}
+			if isSlice {
+				expr = jinjaSlice{object: expr, start: parts[0], stop: parts[1], step: parts[2]}
+			} else {
+				expr = jinjaIndex{object: expr, index: parts[0]}
+			}
+		case p.acceptOperator("("):
+			args, kwargs, err := p.parseArguments()
+			if err != nil {
+				return nil, err
+			}
+			expr = jinjaCall{function: expr, args: args, kwargs: kwargs}
+		default:
+			return expr, nil
+		}
+	}
+}
+
+// parseArguments parses call arguments up to and including the closing parenthesis
+func (p *jinjaExprParser) parseArguments() ([]jinjaExpr, map[string]jinjaExpr, error) {
+	var args []jinjaExpr
+	kwargs := make(map[string]jinjaExpr)
+	for !p.acceptOperator(")") {
+		if token := p.peek(); token.kind == exprName && p.tokens[p.pos+1].kind == exprOperator && p.tokens[p.pos+1].text == "=" {
+			p.pos += 2
+			value, err := p.parseExpression()
+			if err != nil {
+				return nil, nil, err
+			}
+			kwargs[token.text] = value
+		} else {
+			value, err := p.parseExpression()
+			if err != nil {
+				return nil, nil, err
+			}
+			args = append(args, value)
+		}
+		if !p.acceptOperator(",") && p.peek().text != ")" {
+			return nil, nil, fmt.Errorf("expected ',' or ')' in arguments")
+		}
+	}
+	return args, kwargs, nil
+}
+
+func (p *jinjaExprParser) parseFilters(expr jinjaExpr) (jinjaExpr, error) {
+	for {
+		switch {
+		case p.acceptOperator("|"):
+			name := p.next()
+			if name.kind != exprName {
+				return nil, fmt.Errorf("invalid filter %q", name.text)
+			}
+			filter := jinjaFilter{value: expr, name: name.text}
+			if p.acceptOperator("(") {
+				var err error
+				if filter.args, filter.kwargs, err = p.parseArguments(); err != nil {
+					return nil, err
+				}
+			}
+			expr = filter
+		case p.acceptName("is"):
+			test := jinjaTest{value: expr, negate: p.acceptName("not")}
+			name := p.next()
+			if name.kind != exprName {
+				return nil, fmt.Errorf("invalid test %q", name.text)
+			}
+			test.name = name.text
+			if name.text == "None" || name.text == "True" || name.text == "False" {
+				test.name = strings.ToLower(name.text)
+			}
+			if p.acceptOperator("(") {
+				args, _, err := p.parseArguments()
+				if err != nil {
+					return nil, err
+				}
+				test.args = args
+			} else if token := p.peek(); token.kind == exprString || token.kind == exprInt || token.kind == exprFloat ||
+				(token.kind == exprName && token.text != "and" && token.text != "or" && token.text != "else" && token.text != "if" && token.text != "in" && token.text != "not" && token.text != "is") {
+				arg, err := p.parsePrimary()
+				if err != nil {
+					return nil, err
+				}
+				test.args = []jinjaExpr{arg}
+			}
+			expr = test
+		default:
+			return expr, nil
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------------------------
+// Evaluation
+
+type jinjaScope struct {
+	vars   map[string]interface{}
+	parent *jinjaScope
+}
+
+func (s *jinjaScope) lookup(name string) interface{} {
+	for scope := s; scope != nil; scope = scope.parent {
+		if value, ok := scope.vars[name]; ok {
+			return value
+		}
+	}
+	return jinjaUndefined{}
+}
+
+func (s *jinjaScope) root() *jinjaScope {
+	scope := s
+	for scope.parent != nil {
+		scope = scope.parent
+	}
+	return scope
+}
+
+func (s *jinjaScope) child() *jinjaScope {
+	return &jinjaScope{vars: make(map[string]interface{}), parent: s}
+}
+
+func renderJinjaNodes(nodes []jinjaNode, scope *jinjaScope, out *strings.Builder) error {
+	for _, node := range nodes {
+		if err := renderJinjaNode(node, scope, out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func renderJinjaNode(node jinjaNode, scope *jinjaScope, out *strings.Builder) error {
+	switch n := node.(type) {
+	case jinjaTextNode:
+		out.WriteString(n.text)
+	case jinjaOutputNode:
+		value, err := evalJinja(n.expr, scope)
+		if err != nil {
+			return err
+		}
+		out.WriteString(jinjaToString(value))
+	case jinjaIfNode:
+		for _, branch := range n.branches {
+			cond, err := evalJinja(branch.cond, scope)
+			if err != nil {
+				return err
+			}
+			if jinjaTruthy(cond) {
+				return renderJinjaNodes(branch.body, scope, out)
+			}
+		}
+		return renderJinjaNodes(n.elseBody, scope, out)
+	case jinjaForNode:
+		return renderJinjaFor(n, scope, out)
+	case jinjaSetNode:
+		return executeJinjaSet(n, scope)
+	case jinjaMacroNode:
+		scope.vars[n.name] = jinjaMacro(n, scope)
+	case jinjaLoopControlNode:
+		return n.err
+	}
+	return nil
+}
+
+func renderJinjaFor(n jinjaForNode, scope *jinjaScope, out *strings.Builder) error {
+	iterable, err := evalJinja(n.iter, scope)
+	if err != nil {
+		return err
+	}
+	items, err := jinjaIterate(iterable)
+	if err != nil {
+		return err
+	}
+
+	bodyScope := scope.child()
+	if n.filter != nil {
+		var filtered []interface{}
+		for _, item := range items {
+			if err := assignJinjaTargets(bodyScope, n.targets, item); err != nil {
+				return err
+			}
+			keep, err := evalJinja(n.filter, bodyScope)
+			if err != nil {
+				return err
+			}
+			if jinjaTruthy(keep) {
+				filtered = append(filtered, item)
+			}
+		}
+		items = filtered
+	}
+	if len(items) == 0 {
+		return renderJinjaNodes(n.elseBody, scope, out)
+	}
+
+	for i, item := range items {
+		bodyScope = scope.child()
+		loop := map[string]interface{}{
+			"index":     int64(i + 1),
+			"index0":    int64(i),
+			"revindex":  int64(len(items) - i),
+			"revindex0": int64(len(items) - i - 1),
+			"first":     i == 0,
+			"last":      i == len(items)-1,
+			"length":    int64(len(items)),
+			"previtem":  interface{}(jinjaUndefined{}),
+			"nextitem":  interface{}(jinjaUndefined{}),
+		}
+		if i > 0 {
+			loop["previtem"] = items[i-1]
+		}
+		if i < len(items)-1 {
+			loop["nextitem"] = items[i+1]
+		}
+		bodyScope.vars["loop"] = loop
+		if err := assignJinjaTargets(bodyScope, n.targets, item); err != nil {
+			return err
+		}
+		err := renderJinjaNodes(n.body, bodyScope, out)
+		if errors.Is(err, errJinjaBreak) {
+			break
+		}
+		if err != nil && !errors.Is(err, errJinjaContinue) {
+			return err
+		}
+	}
+	return nil
+}
+
+func assignJinjaTargets(scope *jinjaScope, targets []string, value interface{}) error {
+	if len(targets) == 1 {
+		scope.vars[targets[0]] = value
+		return nil
+	}
+	items, ok := value.([]interface{})
+	if !ok || len(items) != len(targets) {
+		return fmt.Errorf("cannot unpack %s into %d values", jinjaTypeName(value), len(targets))
+	}
+	for i, target := range targets {
+		scope.vars[target] = items[i]
+	}
+	return nil
+}
+
+func executeJinjaSet(n jinjaSetNode, scope *jinjaScope) error {
+	var value interface{}
+	if n.body != nil {
+		var builder strings.Builder
+		if err := renderJinjaNodes(n.body, scope, &builder); err != nil {
+			return err
+		}
+		value = builder.String()
+	} else {
+		var err error
+		if value, err = evalJinja(n.expr, scope); err != nil {
+			return err
+		}
+	}
+
+	if n.attribute != "" {
+		namespace, ok := scope.lookup(n.targets[0]).(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("cannot set attribute %s on %s", n.attribute, n.targets[0])
+		}
+		namespace[n.attribute] = value
+		return nil
+	}
+	return assignJinjaTargets(scope, n.targets, value)
+}
+
+// jinjaMacro turns a macro definition into a callable that renders its body
+func jinjaMacro(n jinjaMacroNode, definedIn *jinjaScope) jinjaFunc {
+	return func(args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+		scope := definedIn.child()
+		for i, param := range n.params {
+			switch value, ok := kwargs[param]; {
+			case i < len(args):
+				scope.vars[param] = args[i]
+			case ok:
+				scope.vars[param] = value
+			case n.defaults[param] != nil:
+				defaultValue, err := evalJinja(n.defaults[param], definedIn)
+				if err != nil {
+					return nil, err
+				}
+				scope.vars[param] = defaultValue
+			default:
+				scope.vars[param] = jinjaUndefined{}
+			}
+		}
+		var builder strings.Builder
+		if err := renderJinjaNodes(n.body, scope, &builder); err != nil {
+			return nil, err
+		}
+		return builder.String(), nil
+	}
+}
+
+func evalJinja(expr jinjaExpr, scope *jinjaScope) (interface{}, error) {
+	switch e := expr.(type) {
+	case jinjaLiteral:
+		return e.value, nil
+	case jinjaName:
+		return scope.lookup(e.name), nil
+	case jinjaListExpr:
+		items := make([]interface{}, 0, len(e.items))
+		for _, item := range e.items {
+			value, err := evalJinja(item, scope)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, value)
+		}
+		return items, nil
+	case jinjaDictExpr:
+		dict := make(map[string]interface{}, len(e.keys))
+		for i := range e.keys {
+			key, err := evalJinja(e.keys[i], scope)
+			if err != nil {
+				return nil, err
+			}
+			value, err := evalJinja(e.values[i], scope)
+			if err != nil {
+				return nil, err
+			}
+			dict[jinjaToString(key)] = value
+		}
+		return dict, nil
+	case jinjaAttribute:
+		object, err := evalJinja(e.object, scope)
+		if err != nil {
+			return nil, err
+		}
+		return jinjaGetAttribute(object, e.name), nil
+	case jinjaIndex:
+		object, err := evalJinja(e.object, scope)
+		if err != nil {
+			return nil, err
+		}
+		index, err := evalJinja(e.index, scope)
+		if err != nil {
+			return nil, err
+		}
+		return jinjaGetItem(object, index), nil
+	case jinjaSlice:
+		return evalJinjaSlice(e, scope)
+	case jinjaCall:
+		function, err := evalJinja(e.function, scope)
+		if err != nil {
+			return nil, err
+		}
+		callable, ok := function.(jinjaFunc)
+		if !ok {
+			return nil, fmt.Errorf("%s is not callable", describeJinjaExpr(e.function))
+		}
+		args, kwargs, err := evalJinjaArguments(e.args, e.kwargs, scope)
+		if err != nil {
+			return nil, err
+		}
+		return callable(args, kwargs)
+	case jinjaFilter:
+		value, err := evalJinja(e.value, scope)
+		if err != nil {
+			return nil, err
+		}
+		args, kwargs, err := evalJinjaArguments(e.args, e.kwargs, scope)
+		if err != nil {
+			return nil, err
+		}
+		return applyJinjaFilter(e.name, value, args, kwargs)
+	case jinjaTest:
+		value, err := evalJinja(e.value, scope)
+		if err != nil {
+			return nil, err
+		}
+		args, _, err := evalJinjaArguments(e.args, nil, scope)
+		if err != nil {
+			return nil, err
+		}
+		result, err := applyJinjaTest(e.name, value, args)
+		return result != e.negate, err
+	case jinjaUnary:
+		operand, err := evalJinja(e.operand, scope)
+		if err != nil {
+			return nil, err
+		}
+		if e.operator == "not" {
+			return !jinjaTruthy(operand), nil
+		}
+		return jinjaArithmetic("-", int64(0), operand)
+	case jinjaBinary:
+		left, err := evalJinja(e.left, scope)
+		if err != nil {
+			return nil, err
+		}
+		switch e.operator {
+		case "and":
+			if !jinjaTruthy(left) {
+				return left, nil
+			}
+			return evalJinja(e.right, scope)
+		case "or":
+			if jinjaTruthy(left) {
+				return left, nil
+			}
+			return evalJinja(e.right, scope)
+		}
+		right, err := evalJinja(e.right, scope)
+		if err != nil {
+			return nil, err
+		}
+		return evalJinjaBinary(e.operator, left, right)
+	case jinjaConditional:
+		cond, err := evalJinja(e.cond, scope)
+		if err != nil {
+			return nil, err
+		}
+		if jinjaTruthy(cond) {
+			return evalJinja(e.then, scope)
+		}
+		return evalJinja(e.otherwise, scope)
+	}
+	return nil, fmt.Errorf("unsupported expression %T", expr)
+}
+
+func describeJinjaExpr(expr jinjaExpr) string {
+	switch e := expr.(type) {
+	case jinjaName:
+		return e.name
+	case jinjaAttribute:
+		return describeJinjaExpr(e.object) + "." + e.name
+	}
+	return "expression"
+}
+
+func evalJinjaArguments(argExprs []jinjaExpr, kwargExprs map[string]jinjaExpr, scope *jinjaScope) ([]interface{}, map[string]interface{}, error) {
+	args := make([]interface{}, 0, len(argExprs))
+	for _, argExpr := range argExprs {
+		value, err := evalJinja(argExpr, scope)
+		if err != nil {
+			return nil, nil, err
+		}
+		args = append(args, value)
+	}
+	kwargs := make(map[string]interface{}, len(kwargExprs))
+	for name, kwargExpr := range kwargExprs {
+		value, err := evalJinja(kwargExpr, scope)
+		if err != nil {
+			return nil, nil, err
+		}
+		kwargs[name] = value
+	}
+	return args, kwargs, nil
+}
+
+func evalJinjaSlice(e jinjaSlice, scope *jinjaScope) (interface{}, error) {
+	object, err := evalJinja(e.object, scope)
+	if err != nil {
+		return nil, err
+	}
+	bounds := make([]*int64, 3)
+	for i, boundExpr := range []jinjaExpr{e.start, e.stop, e.step} {
+		if boundExpr == nil {
+			continue
+		}
+		value, err := evalJinja(boundExpr, scope)
+		if err != nil {
+			return nil, err
+		}
+		if value == nil {
+			continue
+		}
+		number, ok := value.(int64)
+		if !ok {
+			return nil, fmt.Errorf("slice indices must be integers")
+		}
+		bounds[i] = &number
+	}
+
+	var items []interface{}
+	text, isString := object.(string)
+	if isString {
+		for _, r := range text {
+			items = append(items, string(r))
+		}
+	} else if list, ok := object.([]interface{}); ok {
+		items = list
+	} else {
+		return nil, fmt.Errorf("cannot slice %s", jinjaTypeName(object))
+	}
+
+	step := int64(1)
+	if bounds[2] != nil {
+		step = *bounds[2]
+	}
+	if step == 0 {
+		return nil, fmt.Errorf("slice step cannot be zero")
+	}
+	length := int64(len(items))
+	clamp := func(bound *int64, defaultValue int64) int64 {
+		if bound == nil {
+			return defaultValue
+		}
+		index := *bound
+		if index < 0 {
+			index += length
+		}
+		if step > 0 {
+			return max(0, min(index, length))
+		}
+		return max(-1, min(index, length-1))
+	}
+
+	var result []interface{}
+	if step > 0 {
+		for i := clamp(bounds[0], 0); i < clamp(bounds[1], length); i += step {
+			result = append(result, items[i])
+		}
+	} else {
+		for i := clamp(bounds[0], length-1); i > clamp(bounds[1], -1); i += step {
+			result = append(result, items[i])
+		}
+	}
+
+	if isString {
+		var builder strings.Builder
+		for _, item := range result {
+			builder.WriteString(item.(string))
+		}
+		return builder.String(), nil
+	}
+	if result == nil {
+		result = []interface{}{}
+	}
+	return result, nil
+}
+
+func evalJinjaBinary(operator string, left, right interface{}) (interface{}, error) {
+	switch operator {
+	case "==":
+		return jinjaEqual(left, right), nil
+	case "!=":
+		return !jinjaEqual(left, right), nil
+	case "<", ">", "<=", ">=":
+		comparison, err := jinjaCompare(left, right)
+		if err != nil {
+			return nil, err
+		}
+		switch operator {
+		case "<":
+			return comparison < 0, nil
+		case ">":
+			return comparison > 0, nil
+		case "<=":
+			return comparison <= 0, nil
+		}
+		return comparison >= 0, nil
+	case "in", "not in":
+		contained, err := jinjaContains(right, left)
+		if err != nil {
+			return nil, err
+		}
+		return contained == (operator == "in"), nil
+	case "~":
+		return jinjaToString(left) + jinjaToString(right), nil
+	case "+":
+		if leftString, ok := left.(string); ok {
+			if rightString, ok := right.(string); ok {
+				return leftString + rightString, nil
+			}
+		}
+		if leftList, ok := left.([]interface{}); ok {
+			if rightList, ok := right.([]interface{}); ok {
+				return append(append([]interface{}{}, leftList...), rightList...), nil
+			}
+		}
+	case "*":
+		if text, ok := left.(string); ok {
+			if count, ok := right.(int64); ok {
+				return strings.Repeat(text, int(max(count, 0))), nil
+			}
+		}
+	}
+	return jinjaArithmetic(operator, left, right)
+}
+
+func jinjaArithmetic(operator string, left, right interface{}) (interface{}, error) {
+	leftInt, leftIsInt := jinjaInt(left)
+	rightInt, rightIsInt := jinjaInt(right)
+	if leftIsInt && rightIsInt {
+		switch operator {
+		case "+":
+			return leftInt + rightInt, nil
+		case "-":
+			return leftInt - rightInt, nil
+		case "*":
+			return leftInt * rightInt, nil
+		case "//", "%":
+			if rightInt == 0 {
+				return nil, fmt.Errorf("division by zero")
+			}
+			quotient := leftInt / rightInt
+			if (leftInt%rightInt != 0) && ((leftInt < 0) != (rightInt < 0)) {
+				quotient--
+			}
+			if operator == "//" {
+				return quotient, nil
+			}
+			return leftInt - quotient*rightInt, nil
+		case "**":
+			if rightInt >= 0 {
+				result := int64(1)
+				for i := int64(0); i < rightInt; i++ {
+					result *= leftInt
+				}
+				return result, nil
+			}
+		}
+	}
+
+	leftFloat, leftOk := jinjaFloat(left)
+	rightFloat, rightOk := jinjaFloat(right)
+	if !leftOk || !rightOk {
+		return nil, fmt.Errorf("unsupported operand types for %s: %s and %s", operator, jinjaTypeName(left), jinjaTypeName(right))
+	}
+	switch operator {
+	case "+":
+		return leftFloat + rightFloat, nil
+	case "-":
+		return leftFloat - rightFloat, nil
+	case "*":
+		return leftFloat * rightFloat, nil
+	case "/":
+		if rightFloat == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return leftFloat / rightFloat, nil
+	case "//":
+		if rightFloat == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return math.Floor(leftFloat / rightFloat), nil
+	case "%":
+		if rightFloat == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return leftFloat - math.Floor(leftFloat/rightFloat)*rightFloat, nil
+	case "**":
+		return math.Pow(leftFloat, rightFloat), nil
+	}
+	return nil, fmt.Errorf("unsupported operator %s", operator)
+}
+
+func jinjaInt(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int64:
+		return v, true
+	case int:
+		return int64(v), true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+func jinjaFloat(value interface{}) (float64, bool) {
+	if v, ok := value.(float64); ok {
+		return v, true
+	}
+	if v, ok := jinjaInt(value); ok {
+		return float64(v), true
+	}
+	return 0, false
+}
+
+func jinjaEqual(left, right interface{}) bool {
+	if leftFloat, ok := jinjaFloat(left); ok {
+		if rightFloat, ok := jinjaFloat(right); ok {
+			_, leftBool := left.(bool)
+			_, rightBool := right.(bool)
+			if leftBool == rightBool {
+				return leftFloat == rightFloat
+			}
+		}
+	}
+	return reflect.DeepEqual(left, right)
+}
+
+func jinjaCompare(left, right interface{}) (int, error) {
+	if leftString, ok := left.(string); ok {
+		if rightString, ok := right.(string); ok {
+			return strings.Compare(leftString, rightString), nil
+		}
+	}
+	leftFloat, leftOk := jinjaFloat(left)
+	rightFloat, rightOk := jinjaFloat(right)
+	if !leftOk || !rightOk {
+		return 0, fmt.Errorf("cannot compare %s and %s", jinjaTypeName(left), jinjaTypeName(right))
+	}
+	switch {
+	case leftFloat < rightFloat:
+		return -1, nil
+	case leftFloat > rightFloat:
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func jinjaContains(container, item interface{}) (bool, error) {
+	switch c := container.(type) {
+	case string:
+		return strings.Contains(c, jinjaToString(item)), nil
+	case []interface{}:
+		for _, element := range c {
+			if jinjaEqual(element, item) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case map[string]interface{}:
+		key, ok := item.(string)
+		if !ok {
+			return false, nil
+		}
+		_, exists := c[key]
+		return exists, nil
+	case jinjaUndefined, nil:
+		return false, nil
+	}
+	return false, fmt.Errorf("argument of type %s is not iterable", jinjaTypeName(container))
+}
+
+func jinjaTruthy(value interface{}) bool {
+	switch v := value.(type) {
+	case nil, jinjaUndefined:
+		return false
+	case bool:
+		return v
+	case int64:
+		return v != 0
+	case int:
+		return v != 0
+	case float64:
+		return v != 0
+	case string:
+		return v != ""
+	case []interface{}:
+		return len(v) > 0
+	case map[string]interface{}:
+		return len(v) > 0
+	}
+	return true
+}
+
+func jinjaTypeName(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "none"
+	case jinjaUndefined:
+		return "undefined"
+	case bool:
+		return "bool"
+	case int64, int:
+		return "int"
+	case float64:
+		return "float"
+	case string:
+		return "string"
+	case []interface{}:
+		return "list"
+	case map[string]interface{}:
+		return "dict"
+	case jinjaFunc:
+		return "function"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// jinjaToString converts a value to text the way Python's str() does
+func jinjaToString(value interface{}) string {
+	switch v := value.(type) {
+	case jinjaUndefined:
+		return ""
+	case string:
+		return v
+	}
+	return jinjaRepr(value, false)
+}
+
+func jinjaRepr(value interface{}, quoteStrings bool) string {
+	switch v := value.(type) {
+	case nil:
+		return "None"
+	case jinjaUndefined:
+		return ""
+	case bool:
+		if v {
+			return "True"
+		}
+		return "False"
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case int:
+		return strconv.Itoa(v)
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1e16 {
+			return strconv.FormatFloat(v, 'f', 1, 64)
+		}
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case string:
+		if quoteStrings {
+			return "'" + strings.ReplaceAll(strings.ReplaceAll(v, `\`, `\\`), "'", `\'`) + "'"
+		}
+		return v
+	case []interface{}:
+		parts := make([]string, len(v))
+		for i, item := range v {
+			parts[i] = jinjaRepr(item, true)
+		}
+		return "[" + strings.Join(parts, ", ") + "]"
+	case map[string]interface{}:
+		keys := sortedJinjaKeys(v)
+		parts := make([]string, len(keys))
+		for i, key := range keys {
+			parts[i] = jinjaRepr(key, true) + ": " + jinjaRepr(v[key], true)
+		}
+		return "{" + strings.Join(parts, ", ") + "}"
+	}
+	return fmt.Sprint(value)
+}
+
+func sortedJinjaKeys(dict map[string]interface{}) []string {
+	keys := make([]string, 0, len(dict))
+	for key := range dict {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// jinjaIterate returns the items of a list, the characters of a string or the keys of a dict
+func jinjaIterate(value interface{}) ([]interface{}, error) {
+	switch v := value.(type) {
+	case []interface{}:
+		return v, nil
+	case string:
+		items := make([]interface{}, 0, utf8.RuneCountInString(v))
+		for _, r := range v {
+			items = append(items, string(r))
+		}
+		return items, nil
+	case map[string]interface{}:
+		keys := sortedJinjaKeys(v)
+		items := make([]interface{}, len(keys))
+		for i, key := range keys {
+			items[i] = key
+		}
+		return items, nil
+	case nil, jinjaUndefined:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("%s is not iterable", jinjaTypeName(value))
+}
+
+func jinjaGetItem(object, index interface{}) interface{} {
+	switch o := object.(type) {
+	case map[string]interface{}:
+		if key, ok := index.(string); ok {
+			if value, exists := o[key]; exists {
+				return value
+			}
+		}
+	case []interface{}:
+		if i, ok := index.(int64); ok {
+			if i < 0 {
+				i += int64(len(o))
+			}
+			if i >= 0 && i < int64(len(o)) {
+				return o[i]
+			}
+		}
+	case string:
+		if i, ok := index.(int64); ok {
+			runes := []rune(o)
+			if i < 0 {
+				i += int64(len(runes))
+			}
+			if i >= 0 && i < int64(len(runes)) {
+				return string(runes[i])
+			}
+		}
+	}
+	if name, ok := index.(string); ok {
+		return jinjaMethod(object, name)
+	}
+	return jinjaUndefined{}
+}
+
+func jinjaGetAttribute(object interface{}, name string) interface{} {
+	if dict, ok := object.(map[string]interface{}); ok {
+		if value, exists := dict[name]; exists {
+			return value
+		}
+	}
+	return jinjaMethod(object, name)
+}
+
+// jinjaMethod returns the bound Python method name of object, or undefined
+func jinjaMethod(object interface{}, name string) interface{} {
+	switch o := object.(type) {
+	case string:
+		return jinjaStringMethod(o, name)
+	case map[string]interface{}:
+		switch name {
+		case "items":
+			return jinjaFunc(func([]interface{}, map[string]interface{}) (interface{}, error) {
+				keys := sortedJinjaKeys(o)
+				items := make([]interface{}, len(keys))
+				for i, key := range keys {
+					items[i] = []interface{}{key, o[key]}
+				}
+				return items, nil
+			})
+		case "keys":
+			return jinjaFunc(func([]interface{}, map[string]interface{}) (interface{}, error) {
+				return jinjaIterate(o)
+			})
+		case "values":
+			return jinjaFunc(func([]interface{}, map[string]interface{}) (interface{}, error) {
+				keys := sortedJinjaKeys(o)
+				values := make([]interface{}, len(keys))
+				for i, key := range keys {
+					values[i] = o[key]
+				}
+				return values, nil
+			})
+		case "get":
+			return jinjaFunc(func(args []interface{}, _ map[string]interface{}) (interface{}, error) {
+				if len(args) == 0 {
+					return nil, fmt.Errorf("get() takes a key")
+				}
+				if key, ok := args[0].(string); ok {
+					if value, exists := o[key]; exists {
+						return value, nil
+					}
+				}
+				if len(args) > 1 {
+					return args[1], nil
+				}
+				return nil, nil
+			})
+		}
+	}
+	return jinjaUndefined{}
+}
+
+func jinjaStringMethod(s, name string) interface{} {
+	stringArg := func(args []interface{}, i int) (string, bool) {
+		if i < len(args) {
+			if value, ok := args[i].(string); ok {
+				return value, true
+			}
+		}
+		return "", false
+	}
+	method := func(f func(args []interface{}) (interface{}, error)) jinjaFunc {
+		return func(args []interface{}, _ map[string]interface{}) (interface{}, error) { return f(args) }
+	}
+
+	switch name {
+	case "strip", "lstrip", "rstrip":
+		return method(func(args []interface{}) (interface{}, error) {
+			chars, ok := stringArg(args, 0)
+			if !ok {
+				chars = " \t\n\r\v\f"
+			}
+			switch name {
+			case "lstrip":
+				return strings.TrimLeft(s, chars), nil
+			case "rstrip":
+				return strings.TrimRight(s, chars), nil
+			}
+			return strings.Trim(s, chars), nil
+		})
+	case "startswith", "endswith":
+		return method(func(args []interface{}) (interface{}, error) {
+			candidates := args[:min(len(args), 1)]
+			if len(args) > 0 {
+				if list, ok := args[0].([]interface{}); ok {
+					candidates = list
+				}
+			}
+			for _, candidate := range candidates {
+				text, _ := candidate.(string)
+				if (name == "startswith" && strings.HasPrefix(s, text)) || (name == "endswith" && strings.HasSuffix(s, text)) {
+					return true, nil
+				}
+			}
+			return false, nil
+		})
+	case "split", "rsplit":
+		return method(func(args []interface{}) (interface{}, error) {
+			var parts []string
+			separator, hasSeparator := stringArg(args, 0)
+			limit := -1
+			if len(args) > 1 {
+				if n, ok := args[1].(int64); ok && n >= 0 {
+					limit = int(n) + 1
+				}
+			}
+			switch {
+			case !hasSeparator:
+				parts = strings.Fields(s)
+			case name == "rsplit" && limit > 0:
+				parts = strings.Split(s, separator)
+				if len(parts) > limit {
+					head := strings.Join(parts[:len(parts)-limit+1], separator)
+					parts = append([]string{head}, parts[len(parts)-limit+1:]...)
+				}
+			default:
+				parts = strings.SplitN(s, separator, limit)
+			}
+			items := make([]interface{}, len(parts))
+			for i, part := range parts {
+				items[i] = part
+			}
+			return items, nil
+		})
+	case "upper":
+		return method(func([]interface{}) (interface{}, error) { return strings.ToUpper(s), nil })
+	case "lower":
+		return method(func([]interface{}) (interface{}, error) { return strings.ToLower(s), nil })
+	case "title":
+		return method(func([]interface{}) (interface{}, error) { return jinjaTitle(s), nil })
+	case "capitalize":
+		return method(func([]interface{}) (interface{}, error) { return jinjaCapitalize(s), nil })
+	case "replace":
+		return method(func(args []interface{}) (interface{}, error) {
+			old, _ := stringArg(args, 0)
+			replacement, _ := stringArg(args, 1)
+			count := -1
+			if len(args) > 2 {
+				if n, ok := args[2].(int64); ok {
+					count = int(n)
+				}
+			}
+			return strings.Replace(s, old, replacement, count), nil
+		})
+	case "find":
+		return method(func(args []interface{}) (interface{}, error) {
+			sub, _ := stringArg(args, 0)
+			index := strings.Index(s, sub)
+			if index < 0 {
+				return int64(-1), nil
+			}
+			return int64(utf8.RuneCountInString(s[:index])), nil
+		})
+	case "count":
+		return method(func(args []interface{}) (interface{}, error) {
+			sub, _ := stringArg(args, 0)
+			return int64(strings.Count(s, sub)), nil
+		})
+	case "join":
+		return method(func(args []interface{}) (interface{}, error) {
+			if len(args) == 0 {
+				return nil, fmt.Errorf("join() takes an iterable")
+			}
+			return applyJinjaFilter("join", args[0], []interface{}{s}, nil)
+		})
+	case "format":
+		return method(func(args []interface{}) (interface{}, error) {
+			result := s
+			for _, arg := range args {
+				result = strings.Replace(result, "{}", jinjaToString(arg), 1)
+			}
+			return result, nil
+		})
+	}
+	return jinjaUndefined{}
+}
+
+func jinjaTitle(s string) string {
+	runes := []rune(strings.ToLower(s))
+	for i := range runes {
+		if i == 0 || !unicode.IsLetter(runes[i-1]) {
+			runes[i] = unicode.ToUpper(runes[i])
+		}
+	}
+	return string(runes)
+}
+
+func jinjaCapitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	r, size := utf8.DecodeRuneInString(s)
+	return string(unicode.ToUpper(r)) + strings.ToLower(s[size:])
+}
+
+func applyJinjaFilter(name string, value interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	argument := func(i int, keyword string) (interface{}, bool) {
+		if i < len(args) {
+			return args[i], true
+		}
+		if value, ok := kwargs[keyword]; ok {
+			return value, true
+		}
+		return nil, false
+	}
+
+	switch name {
+	case "safe", "e", "escape", "forceescape":
+		return value, nil
+	case "trim":
+		return strings.TrimSpace(jinjaToString(value)), nil
+	case "upper":
+		return strings.ToUpper(jinjaToString(value)), nil
+	case "lower":
+		return strings.ToLower(jinjaToString(value)), nil
+	case "title":
+		return jinjaTitle(jinjaToString(value)), nil
+	case "capitalize":
+		return jinjaCapitalize(jinjaToString(value)), nil
+	case "string":
+		return jinjaToString(value), nil
+	case "length", "count":
+		switch v := value.(type) {
+		case string:
+			return int64(utf8.RuneCountInString(v)), nil
+		case []interface{}:
+			return int64(len(v)), nil
+		case map[string]interface{}:
+			return int64(len(v)), nil
+		case nil, jinjaUndefined:
+			return int64(0), nil
+		}
+		return nil, fmt.Errorf("object of type %s has no length", jinjaTypeName(value))
+	case "default", "d":
+		fallback, _ := argument(0, "default_value")
+		boolean, _ := argument(1, "boolean")
+		if _, undefined := value.(jinjaUndefined); undefined || (jinjaTruthy(boolean) && !jinjaTruthy(value)) {
+			if fallback == nil {
+				return "", nil
+			}
+			return fallback, nil
+		}
+		return value, nil
+	case "first", "last":
+		items, err := jinjaIterate(value)
+		if err != nil || len(items) == 0 {
+			return jinjaUndefined{}, err
+		}
+		if name == "first" {
+			return items[0], nil
+		}
+		return items[len(items)-1], nil
+	case "reverse":
+		if text, ok := value.(string); ok {
+			runes := []rune(text)
+			for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+				runes[i], runes[j] = runes[j], runes[i]
+			}
+			return string(runes), nil
+		}
+		items, err := jinjaIterate(value)
+		if err != nil {
+			return nil, err
+		}
+		reversed := make([]interface{}, len(items))
+		for i, item := range items {
+			reversed[len(items)-1-i] = item
+		}
+		return reversed, nil
+	case "list":
+		items, err := jinjaIterate(value)
+		if items == nil {
+			items = []interface{}{}
+		}
+		return items, err
+	case "join":
+		items, err := jinjaIterate(value)
+		if err != nil {
+			return nil, err
+		}
+		separator, hasSeparator := argument(0, "d")
+		if !hasSeparator {
+			separator = ""
+		}
+		attribute, hasAttribute := argument(1, "attribute")
+		parts := make([]string, len(items))
+		for i, item := range items {
+			if hasAttribute {
+				item = jinjaGetItem(item, attribute)
+			}
+			parts[i] = jinjaToString(item)
+		}
+		return strings.Join(parts, jinjaToString(separator)), nil
+	case "replace":
+		old, _ := argument(0, "old")
+		replacement, _ := argument(1, "new")
+		return strings.ReplaceAll(jinjaToString(value), jinjaToString(old), jinjaToString(replacement)), nil
+	case "int":
+		if number, ok := jinjaFloat(value); ok {
+			return int64(number), nil
+		}
+		number, err := strconv.ParseFloat(strings.TrimSpace(jinjaToString(value)), 64)
+		if err != nil {
+			return int64(0), nil
+		}
+		return int64(number), nil
+	case "float":
+		if number, ok := jinjaFloat(value); ok {
+			return number, nil
+		}
+		number, _ := strconv.ParseFloat(strings.TrimSpace(jinjaToString(value)), 64)
+		return number, nil
+	case "abs":
+		if number, ok := value.(int64); ok {
+			if number < 0 {
+				return -number, nil
+			}
+			return number, nil
+		}
+		number, _ := jinjaFloat(value)
+		return math.Abs(number), nil
+	case "tojson":
+		indent := -1
+		if value, ok := argument(0, "indent"); ok {
+			if n, ok := value.(int64); ok {
+				indent = int(n)
+			}
+		}
+		var builder strings.Builder
+		if err := writeJinjaJSON(&builder, value, indent, 0); err != nil {
+			return nil, err
+		}
+		return builder.String(), nil
+	case "items":
+		return jinjaGetAttribute(value, "items").(jinjaFunc)(nil, nil)
+	case "dictsort":
+		dict, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("dictsort expects a dict")
+		}
+		return jinjaGetAttribute(dict, "items").(jinjaFunc)(nil, nil)
+	case "selectattr", "rejectattr":
+		items, err := jinjaIterate(value)
+		if err != nil {
+			return nil, err
+		}
+		if len(args) == 0 {
+			return nil, fmt.Errorf("%s requires an attribute name", name)
+		}
+		attribute := jinjaToString(args[0])
+		var selected []interface{}
+		for _, item := range items {
+			attributeValue := jinjaGetItem(item, attribute)
+			keep := jinjaTruthy(attributeValue)
+			if len(args) > 1 {
+				if keep, err = applyJinjaTest(jinjaToString(args[1]), attributeValue, args[2:]); err != nil {
+					return nil, err
+				}
+			}
+			if keep == (name == "selectattr") {
+				selected = append(selected, item)
+			}
+		}
+		if selected == nil {
+			selected = []interface{}{}
+		}
+		return selected, nil
+	case "select", "reject":
+		items, err := jinjaIterate(value)
+		if err != nil {
+			return nil, err
+		}
+		var selected []interface{}
+		for _, item := range items {
+			keep := jinjaTruthy(item)
+			if len(args) > 0 {
+				if keep, err = applyJinjaTest(jinjaToString(args[0]), item, args[1:]); err != nil {
+					return nil, err
+				}
+			}
+			if keep == (name == "select") {
+				selected = append(selected, item)
+			}
+		}
+		if selected == nil {
+			selected = []interface{}{}
+		}
+		return selected, nil
+	case "map":
+		items, err := jinjaIterate(value)
+		if err != nil {
+			return nil, err
+		}
+		mapped := make([]interface{}, len(items))
+		for i, item := range items {
+			if attribute, ok := kwargs["attribute"]; ok {
+				mapped[i] = jinjaGetItem(item, attribute)
+				if _, undefined := mapped[i].(jinjaUndefined); undefined && kwargs["default"] != nil {
+					mapped[i] = kwargs["default"]
+				}
+			} else if len(args) > 0 {
+				if mapped[i], err = applyJinjaFilter(jinjaToString(args[0]), item, args[1:], nil); err != nil {
+					return nil, err
+				}
+			} else {
+				mapped[i] = item
+			}
+		}
+		return mapped, nil
+	case "unique":
+		items, err := jinjaIterate(value)
+		if err != nil {
+			return nil, err
+		}
+		var unique []interface{}
+		for _, item := range items {
+			if contained, _ := jinjaContains(unique, item); !contained {
+				unique = append(unique, item)
+			}
+		}
+		return unique, nil
+	case "indent":
+		width := int64(4)
+		if value, ok := argument(0, "width"); ok {
+			if n, ok := value.(int64); ok {
+				width = n
+			} else if s, ok := value.(string); ok {
+				return strings.ReplaceAll(jinjaToString(value), "\n", "\n"+s), nil
+			}
+		}
+		first, _ := argument(1, "first")
+		padding := strings.Repeat(" ", int(width))
+		text := strings.ReplaceAll(jinjaToString(value), "\n", "\n"+padding)
+		if jinjaTruthy(first) {
+			text = padding + text
+		}
+		return text, nil
+	}
+	return nil, fmt.Errorf("unsupported filter %q", name)
+}
+
+func applyJinjaTest(name string, value interface{}, args []interface{}) (bool, error) {
+	switch name {
+	case "defined":
+		_, undefined := value.(jinjaUndefined)
+		return !undefined, nil
+	case "undefined":
+		_, undefined := value.(jinjaUndefined)
+		return undefined, nil
+	case "none":
+		return value == nil, nil
+	case "string":
+		_, ok := value.(string)
+		return ok, nil
+	case "number":
+		_, isBool := value.(bool)
+		_, ok := jinjaFloat(value)
+		return ok && !isBool, nil
+	case "integer":
+		_, ok := value.(int64)
+		return ok, nil
+	case "float":
+		_, ok := value.(float64)
+		return ok, nil
+	case "boolean":
+		_, ok := value.(bool)
+		return ok, nil
+	case "true":
+		return value == true, nil
+	case "false":
+		return value == false, nil
+	case "mapping":
+		_, ok := value.(map[string]interface{})
+		return ok, nil
+	case "sequence", "iterable":
+		switch value.(type) {
+		case string, []interface{}, map[string]interface{}:
+			return true, nil
+		}
+		return false, nil
+	case "callable":
+		_, ok := value.(jinjaFunc)
+		return ok, nil
+	case "even", "odd":
+		number, ok := value.(int64)
+		return ok && (number%2 == 0) == (name == "even"), nil
+	case "divisibleby":
+		number, ok := value.(int64)
+		if !ok || len(args) == 0 {
+			return false, nil
+		}
+		divisor, ok := args[0].(int64)
+		return ok && divisor != 0 && number%divisor == 0, nil
+	case "equalto", "eq", "==", "sameas":
+		return len(args) > 0 && jinjaEqual(value, args[0]), nil
+	case "ne", "!=":
+		return len(args) > 0 && !jinjaEqual(value, args[0]), nil
+	case "in":
+		if len(args) == 0 {
+			return false, nil
+		}
+		return jinjaContains(args[0], value)
+	case "lower":
+		s, ok := value.(string)
+		return ok && s == strings.ToLower(s), nil
+	case "upper":
+		s, ok := value.(string)
+		return ok && s == strings.ToUpper(s), nil
+	}
+	return false, fmt.Errorf("unsupported test %q", name)
+}
+
+// writeJinjaJSON serialises value like Python's json.dumps with sorted keys and non-ASCII text kept
+func writeJinjaJSON(builder *strings.Builder, value interface{}, indent, depth int) error {
+	newline := func(level int) {
+		if indent >= 0 {
+			builder.WriteString("\n" + strings.Repeat(" ", indent*level))
+		}
+	}
+	separator := ", "
+	if indent >= 0 {
+		separator = ","
+	}
+
+	switch v := value.(type) {
+	case nil, jinjaUndefined:
+		builder.WriteString("null")
+	case bool:
+		builder.WriteString(strconv.FormatBool(v))
+	case int64, int, float64:
+		builder.WriteString(jinjaRepr(v, false))
+	case string:
+		builder.WriteByte('"')
+		for _, r := range v {
+			switch r {
+			case '"':
+				builder.WriteString(`\"`)
+			case '\\':
+				builder.WriteString(`\\`)
+			case '\n':
+				builder.WriteString(`\n`)
+			case '\r':
+				builder.WriteString(`\r`)
+			case '\t':
+				builder.WriteString(`\t`)
+			default:
+				if r < 0x20 {
+					builder.WriteString(fmt.Sprintf(`\u%04x`, r))
+				} else {
+					builder.WriteRune(r)
+				}
+			}
+		}
+		builder.WriteByte('"')
+	case []interface{}:
+		if len(v) == 0 {
+			builder.WriteString("[]")
+			return nil
+		}
+		builder.WriteByte('[')
+		for i, item := range v {
+			if i > 0 {
+				builder.WriteString(separator)
+			}
+			newline(depth + 1)
+			if err := writeJinjaJSON(builder, item, indent, depth+1); err != nil {
+				return err
+			}
+		}
+		newline(depth)
+		builder.WriteByte(']')
+	case map[string]interface{}:
+		if len(v) == 0 {
+			builder.WriteString("{}")
+			return nil
+		}
+		builder.WriteByte('{')
+		for i, key := range sortedJinjaKeys(v) {
+			if i > 0 {
+				builder.WriteString(separator)
+			}
+			newline(depth + 1)
+			_ = writeJinjaJSON(builder, key, indent, depth+1)
+			builder.WriteString(": ")
+			if err := writeJinjaJSON(builder, v[key], indent, depth+1); err != nil {
+				return err
+			}
+		}
+		newline(depth)
+		builder.WriteByte('}')
+	default:
+		return fmt.Errorf("object of type %s is not JSON serializable", jinjaTypeName(value))
+	}
+	return nil
+}
+
+// ---------------------------------------------------------------------------------------------
+// Globals
+
+func jinjaRaiseException(args []interface{}, _ map[string]interface{}) (interface{}, error) {
+	message := "raise_exception called"
+	if len(args) > 0 {
+		message = jinjaToString(args[0])
+	}
+	return nil, fmt.Errorf("template error: %s", message)
+}
+
+func jinjaNamespace(args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	namespace := make(map[string]interface{}, len(kwargs))
+	if len(args) > 0 {
+		if initial, ok := args[0].(map[string]interface{}); ok {
+			for key, value := range initial {
+				namespace[key] = value
+			}
+		}
+	}
+	for key, value := range kwargs {
+		namespace[key] = value
+	}
+	return namespace, nil
+}
+
+func jinjaDict(_ []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	return jinjaNamespace(nil, kwargs)
+}
+
+func jinjaRange(args []interface{}, _ map[string]interface{}) (interface{}, error) {
+	bounds := make([]int64, len(args))
+	for i, arg := range args {
+		number, ok := jinjaInt(arg)
+		if !ok {
+			return nil, fmt.Errorf("range() arguments must be integers")
+		}
+		bounds[i] = number
+	}
+	start, stop, step := int64(0), int64(0), int64(1)
+	switch len(bounds) {
+	case 1:
+		stop = bounds[0]
+	case 2:
+		start, stop = bounds[0], bounds[1]
+	case 3:
+		start, stop, step = bounds[0], bounds[1], bounds[2]
+	default:
+		return nil, fmt.Errorf("range() takes 1 to 3 arguments")
+	}
+	if step == 0 {
+		return nil, fmt.Errorf("range() step cannot be zero")
+	}
+	items := []interface{}{}
+	for i := start; (step > 0 && i < stop) || (step < 0 && i > stop); i += step {
+		items = append(items, i)
+	}
+	return items, nil
+}
+
+// strftimeDirectives maps Python strftime directives to Go layouts
+var strftimeDirectives = map[byte]string{
+	'Y': "2006", 'y': "06", 'm': "01", 'd': "02", 'B': "January", 'b': "Jan", 'A': "Monday",
+	'a': "Mon", 'H': "15", 'I': "03", 'M': "04", 'S': "05", 'p': "PM", 'Z': "MST", 'z': "-0700",
+}
+
+func jinjaStrftimeNow(args []interface{}, _ map[string]interface{}) (interface{}, error) {
+	format := "%Y-%m-%d"
+	if len(args) > 0 {
+		format = jinjaToString(args[0])
+	}
+	now := time.Now()
+	var builder strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i+1 >= len(format) {
+			builder.WriteByte(format[i])
+			continue
+		}
+		i++
+		switch directive := format[i]; directive {
+		case '%':
+			builder.WriteByte('%')
+		case 'e':
+			builder.WriteString(fmt.Sprintf("%2d", now.Day()))
+		case 'j':
+			builder.WriteString(fmt.Sprintf("%03d", now.YearDay()))
+		default:
+			if layout, ok := strftimeDirectives[directive]; ok {
+				builder.WriteString(now.Format(layout))
+			} else {
+				builder.WriteByte('%')
+				builder.WriteByte(directive)
+			}
+		}
+	}
+	return builder.String(), nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const (
+	chatMLTemplate = `{% for message in messages %}{{'<|im_start|>' + message['role'] + '\n' + message['content'] + '<|im_end|>' + '\n'}}{% endfor %}{% if add_generation_prompt %}{{ '<|im_start|>assistant\n' }}{% endif %}`
+
+	llama3Template = `{% set loop_messages = messages %}{% for message in loop_messages %}{% set content = '<|start_header_id|>' + message['role'] + '<|end_header_id|>\n\n'+ message['content'] | trim + '<|eot_id|>' %}{% if loop.index0 == 0 %}{% set content = bos_token + content %}{% endif %}{{ content }}{% endfor %}{% if add_generation_prompt %}{{ '<|start_header_id|>assistant<|end_header_id|>\n\n' }}{% endif %}`
+
+	mistralTemplate = `{{ bos_token }}{% for message in messages %}{% if (message['role'] == 'user') != (loop.index0 % 2 == 0) %}{{ raise_exception('Conversation roles must alternate user/assistant/user/assistant/...') }}{% endif %}{% if message['role'] == 'user' %}{{ '[INST] ' + message['content'] + ' [/INST]' }}{% elif message['role'] == 'assistant' %}{{ message['content'] + eos_token}}{% else %}{{ raise_exception('Only user and assistant roles are supported!') }}{% endif %}{% endfor %}`
+
+	zephyrTemplate = `{% for message in messages %}
+{% if message['role'] == 'user' %}
+{{ '<|user|>\n' + message['content'] + eos_token }}
+{% elif message['role'] == 'system' %}
+{{ '<|system|>\n' + message['content'] + eos_token }}
+{% elif message['role'] == 'assistant' %}
+{{ '<|assistant|>\n'  + message['content'] + eos_token }}
+{% endif %}
+{% if loop.last and add_generation_prompt %}
+{{ '<|assistant|>' }}
+{% endif %}
+{% endfor %}`
+)
+
+func testChatMessages(roles ...string) []interface{} {
+	contents := map[string]string{"system": "Be brief.", "user": " What is Go? ", "assistant": "A language."}
+	messages := make([]interface{}, len(roles))
+	for i, role := range roles {
+		messages[i] = map[string]interface{}{"role": role, "content": contents[role]}
+	}
+	return messages
+}
+
+func TestJinjaChatTemplates(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		bosToken string
+		messages []interface{}
+		want     string
+	}{
+		{
+			name:     "chatml",
+			template: chatMLTemplate,
+			messages: testChatMessages("system", "user"),
+			want:     "<|im_start|>system\nBe brief.<|im_end|>\n<|im_start|>user\n What is Go? <|im_end|>\n<|im_start|>assistant\n",
+		},
+		{
+			name:     "llama 3",
+			template: llama3Template,
+			bosToken: "<|begin_of_text|>",
+			messages: testChatMessages("system", "user"),
+			want: "<|begin_of_text|><|start_header_id|>system<|end_header_id|>\n\nBe brief.<|eot_id|>" +
+				"<|start_header_id|>user<|end_header_id|>\n\nWhat is Go?<|eot_id|>" +
+				"<|start_header_id|>assistant<|end_header_id|>\n\n",
+		},
+		{
+			name:     "mistral",
+			template: mistralTemplate,
+			bosToken: "<s>",
+			messages: testChatMessages("user", "assistant", "user"),
+			want:     "<s>[INST]  What is Go?  [/INST]A language.</s>[INST]  What is Go?  [/INST]",
+		},
+		{
+			name:     "zephyr trim blocks",
+			template: zephyrTemplate,
+			messages: testChatMessages("system", "user"),
+			want:     "<|system|>\nBe brief.</s>\n<|user|>\n What is Go? </s>\n<|assistant|>\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			template, err := ParseJinjaTemplate(test.template)
+			if err != nil {
+				t.Fatalf("ParseJinjaTemplate: %v", err)
+			}
+			got, err := template.Render(map[string]interface{}{
+				"messages":              test.messages,
+				"add_generation_prompt": true,
+				"bos_token":             test.bosToken,
+				"eos_token":             "</s>",
+			})
+			if err != nil {
+				t.Fatalf("Render: %v", err)
+			}
+			if got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestJinjaRaiseException(t *testing.T) {
+	template, err := ParseJinjaTemplate(mistralTemplate)
+	if err != nil {
+		t.Fatalf("ParseJinjaTemplate: %v", err)
+	}
+	_, err = template.Render(map[string]interface{}{"messages": testChatMessages("system", "user")})
+	if err == nil || !strings.Contains(err.Error(), "Conversation roles must alternate") {
+		t.Fatalf("got %v, want the raise_exception message", err)
+	}
+}
+
+func TestJinjaRender(t *testing.T) {
+	variables := map[string]interface{}{
+		"name":  "  Ada  ",
+		"items": []interface{}{"a", "b", "c"},
+		"user":  map[string]interface{}{"role": "user", "tags": []interface{}{"x", "y"}},
+		"count": 3,
+	}
+	tests := []struct {
+		name     string
+		template string
+		want     string
+	}{
+		{name: "trim filter", template: `[{{ name | trim }}]`, want: "[Ada]"},
+		{name: "chained filters", template: `{{ name | trim | upper | replace('A', 'E') }}`, want: "EDE"},
+		{name: "length and join", template: `{{ items | length }}:{{ items | join(',') }}`, want: "3:a,b,c"},
+		{name: "default", template: `{{ missing | default('none') }}`, want: "none"},
+		{name: "tojson", template: `{{ user.tags | tojson }}`, want: `["x", "y"]`},
+		{name: "first and last", template: `{{ items | first }}{{ items | last }}`, want: "ac"},
+		{name: "loop variables", template: `{% for item in items %}{{ loop.index }}{{ item }}{% if not loop.last %},{% endif %}{% endfor %}`, want: "1a,2b,3c"},
+		{name: "loop else", template: `{% for item in [] %}{{ item }}{% else %}empty{% endfor %}`, want: "empty"},
+		{name: "loop filter", template: `{% for item in items if item != 'b' %}{{ item }}{% endfor %}`, want: "ac"},
+		{name: "break", template: `{% for item in items %}{% if loop.index > 2 %}{% break %}{% endif %}{{ item }}{% endfor %}`, want: "ab"},
+		{name: "dict items", template: `{% for key, value in {'k': 'v'}.items() %}{{ key }}={{ value }}{% endfor %}`, want: "k=v"},
+		{name: "namespace", template: `{% set ns = namespace(total=0) %}{% for i in range(count) %}{% set ns.total = ns.total + i %}{% endfor %}{{ ns.total }}`, want: "3"},
+		{name: "slice", template: `{{ items[1:] | join }}{{ items[-1] }}`, want: "bcc"},
+		{name: "string methods", template: `{{ name.strip().startswith('A') }}`, want: "True"},
+		{name: "tests", template: `{{ missing is defined }}{{ items is iterable }}{{ 'x' is string }}`, want: "FalseTrueTrue"},
+		{name: "conditional expression", template: `{{ 'yes' if count > 2 else 'no' }}`, want: "yes"},
+		{name: "macro", template: `{% macro greet(who='you') %}hi {{ who }}{% endmacro %}{{ greet() }}/{{ greet('Ada') }}`, want: "hi you/hi Ada"},
+		{name: "comment", template: `a{# ignored #}b`, want: "ab"},
+		{name: "strip markers", template: "a  {%- if true -%}  \n b  {%- endif -%}  c", want: "abc"},
+		{name: "strip output markers", template: "a\n  {{- 'b' -}}\n  c", want: "abc"},
+		{name: "trim blocks", template: "{% if true %}\nline\n{% endif %}\nend", want: "line\nend"},
+		{name: "lstrip blocks", template: "  {% if true %}\n  indented\n  {% endif %}\n", want: "  indented\n"},
+		{name: "plus marker keeps indent", template: "  {%+ if true %}x{% endif %}", want: "  x"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			template, err := ParseJinjaTemplate(test.template)
+			if err != nil {
+				t.Fatalf("ParseJinjaTemplate: %v", err)
+			}
+			got, err := template.Render(variables)
+			if err != nil {
+				t.Fatalf("Render: %v", err)
+			}
+			if got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestJinjaParseErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+	}{
+		{name: "unclosed output", template: `{{ name`},
+		{name: "unclosed statement", template: `{% if true`},
+		{name: "missing endif", template: `{% if true %}x`},
+		{name: "missing endfor", template: `{% for x in items %}{{ x }}`},
+		{name: "stray endfor", template: `x{% endfor %}`},
+		{name: "unknown statement", template: `{% frobnicate %}`},
+		{name: "bad expression", template: `{{ 1 + }}`},
+		{name: "unbalanced parenthesis", template: `{{ (1 + 2 }}`},
+		{name: "unterminated string", template: `{{ 'abc }}`},
+		{name: "bad for target", template: `{% for in items %}{% endfor %}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := ParseJinjaTemplate(test.template); err == nil {
+				t.Fatalf("ParseJinjaTemplate(%q) succeeded, want an error", test.template)
+			}
+		})
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	JobKindIngest    = "ingest"
+	JobKindQuery     = "query"
+	JobKindSearch    = "search"
+	JobKindInference = "inference"
+	JobKindOCR       = "ocr"
+	JobKindBenchmark = "benchmark"
+	JobKindBatch     = "batch"
+	JobKindMigration = "migration"
+)
+
+const (
+	JobStatusRunning   = "running"
+	JobStatusCompleted = "completed"
+	JobStatusFailed    = "failed"
+	JobStatusCanceled  = "canceled"
+)
+
+// maxFinishedJobs bounds how many finished jobs are kept for ListJobs
+const maxFinishedJobs = 50
+
+// JobInfo is the frontend view of a job
+type JobInfo struct {
+	RequestID  string     `json:"requestId"`
+	Kind       string     `json:"kind"`
+	Status     string     `json:"status"`
+	Progress   int        `json:"progress"` // last progress percentage reported for the job
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// Job is a running ingest, query, inference or OCR operation with its own cancelable context
+type Job struct {
+	info   JobInfo
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// ID returns the RequestID the job is registered under
+func (j *Job) ID() string { return j.info.RequestID }
+
+// Context returns the job's context; it is canceled by CancelJob, CancelAll or Finish
+func (j *Job) Context() context.Context { return j.ctx }
+
+// Kind returns the job kind, such as JobKindIngest
+func (j *Job) Kind() string { return j.info.Kind }
+
+// Canceled reports whether the job has been canceled
+func (j *Job) Canceled() bool { return errors.Is(j.ctx.Err(), context.Canceled) }
+
+type jobContextKey struct{}
+
+// JobFromContext returns the job a context belongs to, or nil for work started outside a job
+func JobFromContext(ctx context.Context) *Job {
+	if ctx == nil {
+		return nil
+	}
+	job, _ := ctx.Value(jobContextKey{}).(*Job)
+	return job
+}
+
+// JobRegistry tracks every job by RequestID
+type JobRegistry struct {
+	mu       sync.Mutex
+	jobs     map[string]*Job
+	finished []string // finished job IDs, oldest first
+}
+
+func NewJobRegistry() *JobRegistry {
+	return &JobRegistry{jobs: make(map[string]*Job)}
+}
+
+// Start registers a new running job. An empty requestID gets a generated one.
+func (r *JobRegistry) Start(requestID, kind string) (*Job, error) {
+	if requestID == "" {
+		requestID = uuid.New().String()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if existing, exists := r.jobs[requestID]; exists {
+		if existing.info.Status == JobStatusRunning {
+			return nil, fmt.Errorf("job %s is already running", requestID)
+		}
+		r.forgetFinished(requestID)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &Job{
+		info: JobInfo{
+			RequestID: requestID,
+			Kind:      kind,
+			Status:    JobStatusRunning,
+			StartedAt: time.Now(),
+		},
+		cancel: cancel,
+	}
+	job.ctx = context.WithValue(ctx, jobContextKey{}, job)
+	r.jobs[requestID] = job
+	return job, nil
+}
+
+// Finish records the outcome of a job and releases its context
+func (r *JobRegistry) Finish(job *Job, err error) {
+	if job == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	job.info.FinishedAt = &now
+	switch {
+	case job.Canceled():
+		job.info.Status = JobStatusCanceled
+	case err != nil:
+		job.info.Status = JobStatusFailed
+		job.info.Error = err.Error()
+	default:
+		job.info.Status = JobStatusCompleted
+	}
+	job.cancel()
+
+	r.finished = append(r.finished, job.info.RequestID)
+	for len(r.finished) > maxFinishedJobs {
+		delete(r.jobs, r.finished[0])
+		r.finished = r.finished[1:]
+	}
+}
+
+// Cancel cancels the running job with the given RequestID and reports whether one was found
+func (r *JobRegistry) Cancel(requestID string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	job, exists := r.jobs[requestID]
+	if !exists || job.info.Status != JobStatusRunning {
+		return false
+	}
+	job.cancel()
+	return true
+}
+
+// SetProgress records the last progress percentage emitted for a job
+func (r *JobRegistry) SetProgress(requestID string, progress int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if job, exists := r.jobs[requestID]; exists && progress >= 0 {
+		job.info.Progress = progress
+	}
+}
+
+// Progress returns the last progress percentage recorded for a job
+func (r *JobRegistry) Progress(requestID string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if job, exists := r.jobs[requestID]; exists {
+		return job.info.Progress
+	}
+	return 0
+}
+
+// CancelAll cancels every running job and returns how many were canceled
+func (r *JobRegistry) CancelAll() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	count := 0
+	for _, job := range r.jobs {
+		if job.info.Status == JobStatusRunning {
+			job.cancel()
+			count++
+		}
+	}
+	return count
+}
+
+// List returns running and recently finished jobs, newest first
+func (r *JobRegistry) List() []JobInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	jobs := make([]JobInfo, 0, len(r.jobs))
+	for _, job := range r.jobs {
+		jobs = append(jobs, job.info)
+	}
+	sort.Slice(jobs, func(i, k int) bool {
+		return jobs[i].StartedAt.After(jobs[k].StartedAt)
+	})
+	return jobs
+}
+
+// forgetFinished removes requestID from the finished list so a new job can reuse the ID
+func (r *JobRegistry) forgetFinished(requestID string) {
+	for i, id := range r.finished {
+		if id == requestID {
+			r.finished = append(r.finished[:i], r.finished[i+1:]...)
+			return
+		}
+	}
+}
+
+// ListJobs returns running and recently finished jobs
+func (app *App) ListJobs() []JobInfo {
+	return app.jobs.List()
+}
+
+// CancelJob cancels the job with the given RequestID
+func (app *App) CancelJob(requestID string) string {
+	if !app.jobs.Cancel(requestID) {
+		return fmt.Sprintf("No running job with ID %s", requestID)
+	}
+	app.log.Info("Canceling job " + requestID)
+	return fmt.Sprintf("Job %s canceled", requestID)
+}
+
+// CancelAll cancels every running job
+func (app *App) CancelAll() string {
+	count := app.jobs.CancelAll()
+	if count == 0 {
+		return "No active jobs to cancel"
+	}
+	app.log.Info(fmt.Sprintf("Canceling %d running jobs", count))
+	return fmt.Sprintf("Canceled %d jobs", count)
+}
+
+// resultError turns the string result of a query or search into an error for Finish
+func (app *App) resultError(result string) error {
+	if app.isErrorResult(result) {
+		return errors.New(app.extractErrorMessage(result))
+	}
+	return nil
+}
+
+// startJob registers a job and logs registration failures
+func (app *App) startJob(requestID, kind string) (*Job, error) {
+	job, err := app.jobs.Start(requestID, kind)
+	if err != nil {
+		app.log.Error("Failed to start job: " + err.Error())
+		return nil, err
+	}
+	app.log.Info(fmt.Sprintf("Started %s job %s", kind, job.ID()))
+	return job, nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/wailsapp/wails/v2/pkg/logger"
+)
+
+func newJobsTestApp() *App {
+	return &App{log: logger.NewDefaultLogger(), jobs: NewJobRegistry()}
+}
+
+// jobStatus returns the status List reports for requestID
+func jobStatus(t *testing.T, registry *JobRegistry, requestID string) string {
+	t.Helper()
+	for _, info := range registry.List() {
+		if info.RequestID == requestID {
+			return info.Status
+		}
+	}
+	t.Fatalf("job %s is not listed", requestID)
+	return ""
+}
+
+func TestStartJobRejectsDuplicateIDs(t *testing.T) {
+	app := newJobsTestApp()
+	first, err := app.startJob("request-1", JobKindQuery)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if JobFromContext(first.Context()) != first {
+		t.Fatal("the job context does not carry its job")
+	}
+
+	if job, err := app.startJob("request-1", JobKindInference); err == nil || job != nil {
+		t.Fatalf("got %v, %v, want the running ID to be rejected", job, err)
+	}
+	if first.Context().Err() != nil || jobStatus(t, app.jobs, "request-1") != JobStatusRunning {
+		t.Fatal("the rejected duplicate affected the running job")
+	}
+
+	// A finished job's ID can be reused
+	app.jobs.Finish(first, nil)
+	second, err := app.startJob("request-1", JobKindInference)
+	if err != nil || second == first || second.Kind() != JobKindInference {
+		t.Fatalf("got %v, %v, want a new job under the finished ID", second, err)
+	}
+	if jobs := app.jobs.List(); len(jobs) != 1 {
+		t.Fatalf("listed %d jobs, want the reused ID once", len(jobs))
+	}
+
+	// Jobs without an ID get distinct generated ones
+	generated1, err1 := app.startJob("", JobKindSearch)
+	generated2, err2 := app.startJob("", JobKindSearch)
+	if err1 != nil || err2 != nil || generated1.ID() == "" || generated1.ID() == generated2.ID() {
+		t.Fatalf("got IDs %q and %q (%v, %v)", generated1.ID(), generated2.ID(), err1, err2)
+	}
+}
+
+func TestCancelJobCancelsOnlyThatJob(t *testing.T) {
+	app := newJobsTestApp()
+	jobs := make(map[string]*Job)
+	for _, id := range []string{"ingest", "query", "ocr"} {
+		job, err := app.startJob(id, JobKindIngest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		jobs[id] = job
+	}
+
+	if got, want := app.CancelJob("query"), "Job query canceled"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	if !jobs["query"].Canceled() || !errors.Is(jobs["query"].Context().Err(), context.Canceled) {
+		t.Fatal("the canceled job's context is still live")
+	}
+	for _, id := range []string{"ingest", "ocr"} {
+		if jobs[id].Context().Err() != nil {
+			t.Fatalf("canceling query also canceled %s", id)
+		}
+	}
+
+	app.jobs.Finish(jobs["query"], errors.New("canceled while running"))
+	if status := jobStatus(t, app.jobs, "query"); status != JobStatusCanceled {
+		t.Fatalf("got status %s, want %s", status, JobStatusCanceled)
+	}
+	for _, id := range []string{"query", "missing"} {
+		if got, want := app.CancelJob(id), "No running job with ID "+id; got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestCancelAllCancelsOnlyRunningJobs(t *testing.T) {
+	app := newJobsTestApp()
+	if got, want := app.CancelAll(), "No active jobs to cancel"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	running1, _ := app.startJob("running-1", JobKindBatch)
+	running2, _ := app.startJob("running-2", JobKindMigration)
+	completed, _ := app.startJob("completed", JobKindQuery)
+	failed, _ := app.startJob("failed", JobKindQuery)
+	app.jobs.Finish(completed, nil)
+	app.jobs.Finish(failed, errors.New("model not found"))
+
+	if got, want := app.CancelAll(), "Canceled 2 jobs"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	if !running1.Canceled() || !running2.Canceled() {
+		t.Fatal("a running job was not canceled")
+	}
+	if status := jobStatus(t, app.jobs, "completed"); status != JobStatusCompleted {
+		t.Fatalf("completed job has status %s", status)
+	}
+	if status := jobStatus(t, app.jobs, "failed"); status != JobStatusFailed {
+		t.Fatalf("failed job has status %s", status)
+	}
+
+	// Jobs started afterwards are not affected
+	later, err := app.startJob("later", JobKindQuery)
+	if err != nil || later.Context().Err() != nil {
+		t.Fatalf("got %v, %v, want a live job", later, err)
+	}
+}
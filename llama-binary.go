@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/wailsapp/wails/v2/pkg/logger"
+)
+
+// llamaDetectTimeout bounds the --version and --help runs used to identify a llama.cpp executable
+const llamaDetectTimeout = 30 * time.Second
+
+var (
+	llamaVersionPattern  = regexp.MustCompile(`version:\s*(\d+)\s*\(([0-9a-fA-F]+)\)`)
+	llamaHelpFlagsLine   = regexp.MustCompile(`(?m)^\s{0,8}(-{1,2}[A-Za-z0-9][\w.+-]*(?:,\s*-{1,2}[A-Za-z0-9][\w.+-]*)*)`)
+	llamaHelpFlagPattern = regexp.MustCompile(`-{1,2}[A-Za-z0-9][\w.+-]*`)
+)
+
+// LlamaBinaryInfo identifies an installed llama.cpp executable
+type LlamaBinaryInfo struct {
+	Binary  LlamaBinary     `json:"binary"`
+	Path    string          `json:"path"`
+	Build   int             `json:"build,omitempty"` // llama.cpp build number, as in release b5535
+	Commit  string          `json:"commit,omitempty"`
+	Flags   map[string]bool `json:"-"` // flags listed by --help
+	modTime time.Time
+}
+
+// Version returns the release name of the build, e.g. "b5535"
+func (info *LlamaBinaryInfo) Version() string {
+	if info.Build == 0 {
+		return ""
+	}
+	return "b" + strconv.Itoa(info.Build)
+}
+
+func (info *LlamaBinaryInfo) describe() string {
+	if version := info.Version(); version != "" {
+		return fmt.Sprintf("%s (%s)", info.Binary, version)
+	}
+	return string(info.Binary)
+}
+
+// Supports reports whether the executable's --help lists the flag under its name or one of the
+// registered aliases. Without a parsed --help every flag is assumed to be supported.
+func (info *LlamaBinaryInfo) Supports(name string, flag LlamaFlag) bool {
+	if len(info.Flags) == 0 || info.Flags[name] {
+		return true
+	}
+	if flag.Name == "" {
+		return false
+	}
+	if info.Flags[flag.Name] {
+		return true
+	}
+	for _, alias := range flag.Aliases {
+		if info.Flags[alias] {
+			return true
+		}
+	}
+	return false
+}
+
+// LlamaBinaryDetector caches what was learned about each executable, and each failed detection,
+// until the file changes
+type LlamaBinaryDetector struct {
+	mu       sync.Mutex
+	byPath   map[string]*LlamaBinaryInfo
+	failures map[string]llamaDetectFailure
+	log      logger.Logger
+}
+
+// llamaDetectFailure is a detection that failed for the executable as it was at modTime
+type llamaDetectFailure struct {
+	err     error
+	modTime time.Time
+}
+
+// llamaBinaries holds the detected llama.cpp executables
+var llamaBinaries = &LlamaBinaryDetector{
+	byPath:   make(map[string]*LlamaBinaryInfo),
+	failures: make(map[string]llamaDetectFailure),
+}
+
+// SetLogger sets the logger failed detections are reported to
+func (d *LlamaBinaryDetector) SetLogger(log logger.Logger) {
+	d.mu.Lock()
+	d.log = log
+	d.mu.Unlock()
+}
+
+// Cached returns the detected executable at path, or nil when it has not been detected or has
+// changed since
+func (d *LlamaBinaryDetector) Cached(path string) *LlamaBinaryInfo {
+	d.mu.Lock()
+	info := d.byPath[path]
+	d.mu.Unlock()
+	if info == nil {
+		return nil
+	}
+	if stat, err := os.Stat(path); err != nil || !stat.ModTime().Equal(info.modTime) {
+		return nil
+	}
+	return info
+}
+
+// Detect runs the executable with --version and --help, unless it was already detected, and
+// returns its build and the flags it accepts
+func (d *LlamaBinaryDetector) Detect(ctx context.Context, binary LlamaBinary, path string) (*LlamaBinaryInfo, error) {
+	if path == "" {
+		return nil, fmt.Errorf("%s path is not configured", binary)
+	}
+	if info := d.Cached(path); info != nil {
+		return info, nil
+	}
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s not found: %w", binary, err)
+	}
+	d.mu.Lock()
+	failure, failed := d.failures[path]
+	d.mu.Unlock()
+	if failed && failure.modTime.Equal(stat.ModTime()) {
+		return nil, failure.err
+	}
+
+	info := &LlamaBinaryInfo{Binary: binary, Path: path, modTime: stat.ModTime()}
+	versionOutput, versionErr := runLlamaForOutput(ctx, binary, path, "--version")
+	if match := llamaVersionPattern.FindStringSubmatch(versionOutput); match != nil {
+		info.Build, _ = strconv.Atoi(match[1])
+		info.Commit = match[2]
+	}
+	helpOutput, helpErr := runLlamaForOutput(ctx, binary, path, "--help")
+	info.Flags = parseLlamaHelpFlags(helpOutput)
+
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if len(info.Flags) == 0 {
+		// A build that cannot print its help is not probed again until the file changes
+		err := fmt.Errorf("failed to detect %s: --help listed no flags", binary)
+		for _, runErr := range []error{helpErr, versionErr} {
+			if runErr != nil {
+				err = fmt.Errorf("failed to detect %s: %w", binary, runErr)
+				break
+			}
+		}
+		d.recordFailure(path, stat.ModTime(), err)
+		return nil, err
+	}
+
+	d.mu.Lock()
+	d.byPath[path] = info
+	delete(d.failures, path)
+	d.mu.Unlock()
+	return info, nil
+}
+
+// recordFailure caches a failed detection and logs it once
+func (d *LlamaBinaryDetector) recordFailure(path string, modTime time.Time, err error) {
+	d.mu.Lock()
+	d.failures[path] = llamaDetectFailure{err: err, modTime: modTime}
+	log := d.log
+	d.mu.Unlock()
+	if log != nil {
+		log.Warning(err.Error() + "; only the flag registry is checked until " + path + " changes")
+	}
+}
+
+// runLlamaForOutput runs a llama.cpp executable and returns stdout and stderr together, since
+// builds differ in which of the two they print version and usage information to. The streams are
+// copied concurrently, so they are collected separately and joined afterwards.
+func runLlamaForOutput(ctx context.Context, binary LlamaBinary, path string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	_, err := RunProcess(ctx, ProcessSpec{
+		Name:       string(binary),
+		Path:       path,
+		Args:       args,
+		Stdout:     &stdout,
+		Stderr:     &stderr,
+		MaxRuntime: llamaDetectTimeout,
+	})
+	return stdout.String() + "\n" + stderr.String(), err
+}
+
+// parseLlamaHelpFlags collects the flags at the start of each line of llama.cpp --help output
+func parseLlamaHelpFlags(help string) map[string]bool {
+	flags := make(map[string]bool)
+	for _, line := range llamaHelpFlagsLine.FindAllStringSubmatch(help, -1) {
+		for _, flag := range llamaHelpFlagPattern.FindAllString(line[1], -1) {
+			flags[flag] = true
+		}
+	}
+	return flags
+}
+
+// checkLlamaArgs validates a command line before the executable at path is launched. The installed
+// executable is detected on first use; when that fails only the registry is checked, and the
+// failure is remembered so the executable is not probed again on every call.
+func checkLlamaArgs(ctx context.Context, binary LlamaBinary, path string, args []string) error {
+	installed, _ := llamaBinaries.Detect(ctx, binary, path)
+	if problems := ValidateLlamaArgs(binary, args, installed); len(problems) > 0 {
+		return &FlagValidationError{Binary: binary, Problems: problems}
+	}
+	return nil
+}
+
+// LlamaBinaryReport describes an installed executable and the configured flags it would reject
+type LlamaBinaryReport struct {
+	Binary           LlamaBinary   `json:"binary"`
+	Path             string        `json:"path"`
+	Version          string        `json:"version,omitempty"`
+	Commit           string        `json:"commit,omitempty"`
+	Error            string        `json:"error,omitempty"`
+	UnsupportedFlags []string      `json:"unsupportedFlags"`
+	Problems         []FlagProblem `json:"problems"`
+}
+
+// GetLlamaBinaryReport detects the configured llama.cpp executables and checks the current settings
+// against them
+func (app *App) GetLlamaBinaryReport() []LlamaBinaryReport {
+	ctx, cancel := context.WithTimeout(app.ctx, 2*llamaDetectTimeout)
+	defer cancel()
+
+	var reports []LlamaBinaryReport
+	reports = append(reports, newLlamaBinaryReport(ctx, LlamaBinaryCli, app.appArgs.LLamaCliPath, LlamaCliStructToArgs(*app.llamaCliArgs)))
+	reports = append(reports, newLlamaBinaryReport(ctx, LlamaBinaryEmbedding, app.appArgs.LLamaEmbedCliPath, LlamaEmbedStructToArgs(*app.llamaEmbedArgs)))
+	if app.appArgs.LLamaServerPath != "" {
+		reports = append(reports, newLlamaBinaryReport(ctx, LlamaBinaryServer, app.appArgs.LLamaServerPath, LlamaServerLoadArgs(*app.llamaCliArgs)))
+	}
+	return reports
+}
+
+func newLlamaBinaryReport(ctx context.Context, binary LlamaBinary, path string, args []string) LlamaBinaryReport {
+	report := LlamaBinaryReport{Binary: binary, Path: path, UnsupportedFlags: []string{}}
+	installed, err := llamaBinaries.Detect(ctx, binary, path)
+	if err != nil {
+		report.Error = err.Error()
+	} else {
+		report.Version = installed.Version()
+		report.Commit = installed.Commit
+	}
+
+	report.Problems = ValidateLlamaArgs(binary, args, installed)
+	if report.Problems == nil {
+		report.Problems = []FlagProblem{}
+	}
+	for _, value := range ParseLlamaArgs(args) {
+		flag, _ := LookupLlamaFlag(value.Flag)
+		if installed != nil && !installed.Supports(value.Flag, flag) {
+			report.UnsupportedFlags = append(report.UnsupportedFlags, value.Flag)
+		}
+	}
+	return report
+}
+
+// logLlamaBinaryReport logs the detected llama.cpp builds and any problems with the current settings
+func (app *App) logLlamaBinaryReport() {
+	for _, report := range app.GetLlamaBinaryReport() {
+		if report.Error != "" {
+			app.log.Warning(fmt.Sprintf("Could not detect %s at %s: %s", report.Binary, report.Path, report.Error))
+			continue
+		}
+		app.log.Info(fmt.Sprintf("Detected %s %s (%s) at %s", report.Binary, report.Version, report.Commit, filepath.Base(report.Path)))
+		for _, problem := range report.Problems {
+			app.log.Warning(fmt.Sprintf("%s settings: %s", report.Binary, problem.String()))
+		}
+	}
+}
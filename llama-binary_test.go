@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLlamaBinaryDetectCachesFailure(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script as the executable")
+	}
+	directory := t.TempDir()
+	runs := filepath.Join(directory, "runs")
+	path := filepath.Join(directory, "llama-cli")
+	// A build that prints neither a version nor any flags
+	script := "#!/bin/sh\necho run >> '" + runs + "'\n"
+	if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	countRuns := func() int {
+		data, _ := os.ReadFile(runs)
+		return strings.Count(string(data), "run")
+	}
+
+	detector := &LlamaBinaryDetector{byPath: make(map[string]*LlamaBinaryInfo), failures: make(map[string]llamaDetectFailure)}
+	for i := 0; i < 3; i++ {
+		if _, err := detector.Detect(context.Background(), LlamaBinaryCli, path); err == nil {
+			t.Fatal("a build without flags was detected")
+		}
+	}
+	if countRuns() != 2 {
+		t.Errorf("ran the executable %d times, want --version and --help once", countRuns())
+	}
+
+	// Replacing the executable probes it again
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := detector.Detect(context.Background(), LlamaBinaryCli, path); err == nil {
+		t.Fatal("a build without flags was detected")
+	}
+	if countRuns() != 4 {
+		t.Errorf("ran the executable %d times after it changed, want 4", countRuns())
+	}
+}
+
+// llamaHelpExcerpt is part of the --help output of llama-cli b5535
+const llamaHelpExcerpt = `----- common params -----
+
+-h,    --help, --usage                  print usage and exit
+--version                               show version and build info
+--verbose-prompt                        print a verbose prompt before generation (default: false)
+-t,    --threads N                      number of threads to use during generation (default: -1)
+                                        (env: LLAMA_ARG_THREADS)
+-c,    --ctx-size N                     size of the prompt context (default: 4096, 0 = loaded from model)
+                                        (env: LLAMA_ARG_CTX_SIZE)
+-n,    --predict, --n-predict N         number of tokens to predict (default: -1, -1 = infinity, -2 = until
+                                        context filled)
+-fa,   --flash-attn                     enable Flash Attention (default: disabled)
+-ngl,  --gpu-layers, --n-gpu-layers N   number of layers to store in VRAM
+                                        (env: LLAMA_ARG_N_GPU_LAYERS)
+-hf,   -hfr, --hf-repo <user>/<model>[:quant]
+                                        Hugging Face model repository; quant is optional, case-insensitive,
+                                        default to Q4_K_M, or falls back to the first file in the repo if
+                                        Q4_K_M doesn't exist.
+                                        mmproj is also downloaded automatically if available. to disable, add
+                                        --no-mmproj
+-m,    --model FNAME                    model path (default: ` + "`models/$filename`" + ` with filename from ` + "`--hf-file`" + `
+                                        or ` + "`--model-url`" + ` if set, otherwise models/7B/ggml-model-f16.gguf)
+
+
+----- sampling params -----
+
+--temp N                                temperature (default: 0.8)
+--top-p N                               top-p sampling (default: 0.9, 1.0 = disabled)
+
+
+----- example-specific params -----
+
+-no-cnv, --no-conversation              force disable conversation mode (default: false)
+-sys,  --system-prompt PROMPT           system prompt to use with model (if applicable, depending on chat
+                                        template)
+`
+
+func TestParseLlamaHelpFlags(t *testing.T) {
+	got := parseLlamaHelpFlags(llamaHelpExcerpt)
+	want := []string{
+		"-h", "--help", "--usage", "--version", "--verbose-prompt", "-t", "--threads", "-c", "--ctx-size",
+		"-n", "--predict", "--n-predict", "-fa", "--flash-attn", "-ngl", "--gpu-layers", "--n-gpu-layers",
+		"-hf", "-hfr", "--hf-repo", "-m", "--model", "--temp", "--top-p", "-no-cnv", "--no-conversation",
+		"-sys", "--system-prompt",
+	}
+	for _, flag := range want {
+		if !got[flag] {
+			t.Errorf("%s was not found", flag)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("found %d flags, want %d: %v", len(got), len(want), got)
+	}
+	// Flags mentioned in descriptions are not flags of this build
+	for _, flag := range []string{"--no-mmproj", "--hf-file", "--model-url", "-1", "-2"} {
+		if got[flag] {
+			t.Errorf("%s was taken from a description", flag)
+		}
+	}
+}
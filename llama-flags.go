@@ -0,0 +1,459 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// LlamaBinary names a llama.cpp executable the app launches
+type LlamaBinary string
+
+const (
+	LlamaBinaryCli       LlamaBinary = "llama-cli"
+	LlamaBinaryEmbedding LlamaBinary = "llama-embedding"
+	LlamaBinaryServer    LlamaBinary = "llama-server"
+)
+
+// FlagType is the kind of value a llama.cpp flag takes
+type FlagType string
+
+const (
+	FlagSwitch FlagType = "switch" // takes no value
+	FlagInt    FlagType = "int"
+	FlagFloat  FlagType = "float"
+	FlagChoice FlagType = "choice" // one of Choices
+	FlagString FlagType = "string"
+	FlagFile   FlagType = "file" // path of an existing file
+	FlagJSON   FlagType = "json"
+)
+
+// LlamaFlag describes one llama.cpp command-line flag
+type LlamaFlag struct {
+	Name     string        `json:"name"`
+	Aliases  []string      `json:"aliases,omitempty"`
+	Type     FlagType      `json:"type"`
+	Min      *float64      `json:"min,omitempty"`
+	Max      *float64      `json:"max,omitempty"`
+	Choices  []string      `json:"choices,omitempty"` // values of a choice flag, or words a numeric flag also accepts
+	Default  string        `json:"default,omitempty"`
+	Binaries []LlamaBinary `json:"binaries,omitempty"` // empty when every binary accepts the flag
+}
+
+func newFlag(flagType FlagType, name string, aliases ...string) LlamaFlag {
+	return LlamaFlag{Name: name, Aliases: aliases, Type: flagType}
+}
+
+func switchFlag(name string, aliases ...string) LlamaFlag {
+	return newFlag(FlagSwitch, name, aliases...)
+}
+
+func intFlag(name string, aliases ...string) LlamaFlag {
+	return newFlag(FlagInt, name, aliases...)
+}
+
+func floatFlag(name string, aliases ...string) LlamaFlag {
+	return newFlag(FlagFloat, name, aliases...)
+}
+
+func stringFlag(name string, aliases ...string) LlamaFlag {
+	return newFlag(FlagString, name, aliases...)
+}
+
+func fileFlag(name string, aliases ...string) LlamaFlag {
+	return newFlag(FlagFile, name, aliases...)
+}
+
+func jsonFlag(name string, aliases ...string) LlamaFlag {
+	return newFlag(FlagJSON, name, aliases...)
+}
+
+func choiceFlag(name string, choices []string, aliases ...string) LlamaFlag {
+	flag := newFlag(FlagChoice, name, aliases...)
+	flag.Choices = choices
+	return flag
+}
+
+func (f LlamaFlag) atLeast(min float64) LlamaFlag {
+	f.Min = &min
+	return f
+}
+
+func (f LlamaFlag) between(min, max float64) LlamaFlag {
+	f.Min, f.Max = &min, &max
+	return f
+}
+
+func (f LlamaFlag) or(words ...string) LlamaFlag {
+	f.Choices = words
+	return f
+}
+
+func (f LlamaFlag) def(value string) LlamaFlag {
+	f.Default = value
+	return f
+}
+
+func (f LlamaFlag) only(binaries ...LlamaBinary) LlamaFlag {
+	f.Binaries = binaries
+	return f
+}
+
+var (
+	cacheTypes = []string{"f32", "f16", "bf16", "q8_0", "q4_0", "q4_1", "iq4_nl", "q5_0", "q5_1"}
+
+	cliOnly        = []LlamaBinary{LlamaBinaryCli}
+	cliAndServer   = []LlamaBinary{LlamaBinaryCli, LlamaBinaryServer}
+	notServer      = []LlamaBinary{LlamaBinaryCli, LlamaBinaryEmbedding}
+	embeddingOnly  = []LlamaBinary{LlamaBinaryEmbedding}
+	embeddingServe = []LlamaBinary{LlamaBinaryEmbedding, LlamaBinaryServer}
+)
+
+// llamaFlags lists the flags LlamaCliArgs, LlamaEmbedArgs and the llama-server settings can produce,
+// with the ranges and defaults documented by llama.cpp
+var llamaFlags = []LlamaFlag{
+	// ----- common params -----
+	switchFlag("--verbose-prompt"),
+	intFlag("--threads", "-t").atLeast(-1).def("-1"),
+	intFlag("--threads-batch", "-tb").atLeast(-1),
+	stringFlag("--cpu-mask", "-C"),
+	stringFlag("--cpu-range", "-Cr"),
+	intFlag("--cpu-strict").between(0, 1).def("0"),
+	intFlag("--prio").between(-1, 3).def("0"),
+	intFlag("--poll").between(0, 100).def("50"),
+	stringFlag("--cpu-mask-batch", "-Cb"),
+	stringFlag("--cpu-range-batch", "-Crb"),
+	intFlag("--cpu-strict-batch").between(0, 1),
+	intFlag("--prio-batch").between(-1, 3),
+	intFlag("--poll-batch").between(0, 100),
+	intFlag("--ctx-size", "-c").atLeast(0).def("4096"),
+	intFlag("--n-predict", "-n", "--predict").atLeast(-2).def("-1"),
+	intFlag("--batch-size", "-b").atLeast(1).def("2048"),
+	intFlag("--ubatch-size", "-ub").atLeast(1).def("512"),
+	intFlag("--keep").atLeast(-1).def("0"),
+	switchFlag("--flash-attn", "-fa"),
+	stringFlag("--prompt", "-p").only(notServer...),
+	switchFlag("--no-perf"),
+	fileFlag("--file", "-f").only(notServer...),
+	fileFlag("--binary-file", "-bf").only(notServer...),
+	switchFlag("--escape", "-e"),
+	switchFlag("--no-escape"),
+	choiceFlag("--rope-scaling", []string{"none", "linear", "yarn"}),
+	floatFlag("--rope-scale").atLeast(0),
+	floatFlag("--rope-freq-base").atLeast(0),
+	floatFlag("--rope-freq-scale").atLeast(0),
+	intFlag("--yarn-orig-ctx").atLeast(0).def("0"),
+	floatFlag("--yarn-ext-factor").atLeast(-1).def("-1.0"),
+	floatFlag("--yarn-attn-factor").atLeast(0).def("1.0"),
+	floatFlag("--yarn-beta-slow").atLeast(0).def("1.0"),
+	floatFlag("--yarn-beta-fast").atLeast(0).def("32.0"),
+	switchFlag("--dump-kv-cache", "-dkvc"),
+	switchFlag("--no-kv-offload", "-nkvo"),
+	choiceFlag("--cache-type-k", cacheTypes, "-ctk").def("f16"),
+	choiceFlag("--cache-type-v", cacheTypes, "-ctv").def("f16"),
+	floatFlag("--defrag-thold", "-dt").def("0.1"),
+	intFlag("--parallel", "-np").atLeast(1).def("1"),
+	stringFlag("--rpc"),
+	switchFlag("--mlock"),
+	switchFlag("--no-mmap"),
+	choiceFlag("--numa", []string{"distribute", "isolate", "numactl"}),
+	stringFlag("--device", "-dev"),
+	switchFlag("--list-devices"),
+	stringFlag("--override-tensor", "-ot"),
+	intFlag("--n-gpu-layers", "-ngl", "--gpu-layers").atLeast(-1).or("auto", "all"),
+	choiceFlag("--split-mode", []string{"none", "layer", "row"}, "-sm").def("layer"),
+	stringFlag("--tensor-split", "-ts"),
+	intFlag("--main-gpu", "-mg").atLeast(0).def("0"),
+	switchFlag("--check-tensors"),
+	stringFlag("--override-kv"),
+	fileFlag("--lora"),
+	stringFlag("--lora-scaled"),
+	fileFlag("--control-vector"),
+	stringFlag("--control-vector-scaled"),
+	stringFlag("--control-vector-layer-range"),
+	fileFlag("--model", "-m"),
+	stringFlag("--model-url", "-mu"),
+	stringFlag("--hf-repo", "-hf", "-hfr"),
+	stringFlag("--hf-repo-draft", "-hfd", "-hfrd"),
+	stringFlag("--hf-file", "-hff"),
+	stringFlag("--hf-repo-v", "-hfv", "-hfrv"),
+	stringFlag("--hf-file-v", "-hffv"),
+	stringFlag("--hf-token", "-hft"),
+	switchFlag("--log-disable"),
+	stringFlag("--log-file"),
+	switchFlag("--log-colors"),
+	switchFlag("--log-verbose", "-v", "--verbose"),
+	intFlag("--log-verbosity", "-lv").atLeast(0),
+	switchFlag("--log-prefix"),
+	switchFlag("--log-timestamps"),
+	switchFlag("--no-warmup"),
+
+	// ----- sampling params -----
+	stringFlag("--samplers"),
+	intFlag("--seed", "-s").between(-1, 4294967295).def("-1"),
+	stringFlag("--sampling-seq", "--sampler-seq"),
+	switchFlag("--ignore-eos"),
+	floatFlag("--temp").atLeast(0).def("0.8"),
+	intFlag("--top-k").atLeast(0).def("40"),
+	floatFlag("--top-p").between(0, 1).def("0.9"),
+	floatFlag("--min-p").between(0, 1).def("0.1"),
+	floatFlag("--top-nsigma", "--top-n-sigma").def("-1.0"),
+	floatFlag("--xtc-probability").between(0, 1).def("0.0"),
+	floatFlag("--xtc-threshold").between(0, 1).def("0.1"),
+	floatFlag("--typical").between(0, 1).def("1.0"),
+	intFlag("--repeat-last-n").atLeast(-1).def("64"),
+	floatFlag("--repeat-penalty").atLeast(0).def("1.0"),
+	floatFlag("--presence-penalty").between(-2, 2).def("0.0"),
+	floatFlag("--frequency-penalty").between(-2, 2).def("0.0"),
+	floatFlag("--dry-multiplier").atLeast(0).def("0.0"),
+	floatFlag("--dry-base").atLeast(0).def("1.75"),
+	intFlag("--dry-allowed-length").atLeast(0).def("2"),
+	intFlag("--dry-penalty-last-n").atLeast(-1).def("-1"),
+	stringFlag("--dry-sequence-breaker"),
+	floatFlag("--dynatemp-range").atLeast(0).def("0.0"),
+	floatFlag("--dynatemp-exp").atLeast(0).def("1.0"),
+	intFlag("--mirostat").between(0, 2).def("0"),
+	floatFlag("--mirostat-lr").atLeast(0).def("0.1"),
+	floatFlag("--mirostat-ent").atLeast(0).def("5.0"),
+	stringFlag("--logit-bias", "-l"),
+	stringFlag("--grammar"),
+	fileFlag("--grammar-file"),
+	jsonFlag("--json-schema", "-j"),
+	fileFlag("--json-schema-file", "-jf"),
+
+	// ----- llama-cli params -----
+	switchFlag("--no-display-prompt").only(cliOnly...),
+	switchFlag("--color", "-co").only(cliOnly...),
+	switchFlag("--no-context-shift").only(cliAndServer...),
+	stringFlag("--system-prompt", "-sys").only(cliOnly...),
+	fileFlag("--system-prompt-file", "-sysf").only(cliOnly...),
+	intFlag("--print-token-count", "-ptc").atLeast(-1).only(cliOnly...),
+	stringFlag("--prompt-cache").only(cliOnly...),
+	switchFlag("--prompt-cache-all").only(cliOnly...),
+	switchFlag("--prompt-cache-ro").only(cliOnly...),
+	stringFlag("--reverse-prompt", "-r").only(cliOnly...),
+	switchFlag("--special", "-sp").only(cliAndServer...),
+	switchFlag("--conversation", "-cnv").only(cliOnly...),
+	switchFlag("--no-conversation", "-no-cnv").only(cliOnly...),
+	switchFlag("--single-turn", "-st").only(cliOnly...),
+	switchFlag("--interactive", "-i").only(cliOnly...),
+	switchFlag("--interactive-first", "-if").only(cliOnly...),
+	switchFlag("--multiline-input", "-mli").only(cliOnly...),
+	switchFlag("--in-prefix-bos").only(cliOnly...),
+	stringFlag("--in-prefix").only(cliOnly...),
+	stringFlag("--in-suffix").only(cliOnly...),
+	intFlag("--grp-attn-n", "-gan").atLeast(1).def("1").only(cliOnly...),
+	intFlag("--grp-attn-w", "-gaw").atLeast(1).def("512").only(cliOnly...),
+	switchFlag("--jinja").only(cliAndServer...),
+	choiceFlag("--reasoning-format", []string{"none", "deepseek", "deepseek-legacy", "auto"}).only(cliAndServer...),
+	stringFlag("--chat-template").only(cliAndServer...),
+	fileFlag("--chat-template-file").only(cliAndServer...),
+	switchFlag("--simple-io").only(cliOnly...),
+
+	// ----- llama-embedding params -----
+	choiceFlag("--pooling", []string{"none", "mean", "cls", "last", "rank"}).only(embeddingServe...),
+	choiceFlag("--attention", []string{"causal", "non-causal"}).only(embeddingOnly...),
+	intFlag("--embd-normalize").atLeast(-1).def("2").only(embeddingOnly...),
+	choiceFlag("--embd-output-format", []string{"array", "json", "json+", "raw"}).only(embeddingOnly...),
+	stringFlag("--embd-separator").only(embeddingOnly...),
+	switchFlag("--embd-bge-small-en-default").only(embeddingServe...),
+	switchFlag("--embd-e5-small-en-default").only(embeddingServe...),
+	switchFlag("--embd-gte-small-default").only(embeddingServe...),
+
+	// ----- llama-server params -----
+	stringFlag("--host").only(LlamaBinaryServer),
+	intFlag("--port").between(0, 65535).def("8080").only(LlamaBinaryServer),
+}
+
+// llamaFlagIndex finds a flag by its name or any of its aliases
+var llamaFlagIndex = func() map[string]LlamaFlag {
+	index := make(map[string]LlamaFlag)
+	for _, flag := range llamaFlags {
+		index[flag.Name] = flag
+		for _, alias := range flag.Aliases {
+			index[alias] = flag
+		}
+	}
+	return index
+}()
+
+// LookupLlamaFlag returns the registered flag with the given name or alias
+func LookupLlamaFlag(name string) (LlamaFlag, bool) {
+	flag, exists := llamaFlagIndex[name]
+	return flag, exists
+}
+
+// LlamaFlags returns the flag registry, e.g. for a settings form
+func (app *App) LlamaFlags() []LlamaFlag {
+	return llamaFlags
+}
+
+// FlagValue is one flag on a command line, with its value unless it is a switch
+type FlagValue struct {
+	Flag   string `json:"flag"`
+	Value  string `json:"value,omitempty"`
+	Switch bool   `json:"switch"`
+}
+
+// FlagProblem is a flag that would make llama.cpp fail or behave unexpectedly
+type FlagProblem struct {
+	Flag    string `json:"flag"`
+	Value   string `json:"value,omitempty"`
+	Message string `json:"message"`
+}
+
+func (p FlagProblem) String() string {
+	if p.Value == "" {
+		return p.Flag + " " + p.Message
+	}
+	return fmt.Sprintf("%s %q %s", p.Flag, abbreviateFlagValue(p.Value), p.Message)
+}
+
+// FlagValidationError lists every problem found in a command line. It matches ErrBadFlag.
+type FlagValidationError struct {
+	Binary   LlamaBinary
+	Problems []FlagProblem
+}
+
+func (e *FlagValidationError) Error() string {
+	messages := make([]string, len(e.Problems))
+	for i, problem := range e.Problems {
+		messages[i] = problem.String()
+	}
+	return fmt.Sprintf("invalid %s arguments: %s", e.Binary, strings.Join(messages, "; "))
+}
+
+func (e *FlagValidationError) Unwrap() error { return ErrBadFlag }
+
+// ParseLlamaArgs splits a command line into flags and values. Registered flags take a value unless they
+// are switches; an unknown flag is assumed to take the next argument when that is not a flag itself.
+func ParseLlamaArgs(args []string) []FlagValue {
+	var values []FlagValue
+	for i := 0; i < len(args); i++ {
+		value := FlagValue{Flag: args[i], Switch: true}
+		flag, known := LookupLlamaFlag(args[i])
+		takesValue := known && flag.Type != FlagSwitch
+		if !known && i+1 < len(args) && !looksLikeFlag(args[i+1]) {
+			takesValue = true
+		}
+		if takesValue && i+1 < len(args) {
+			value.Value = args[i+1]
+			value.Switch = false
+			i++
+		}
+		values = append(values, value)
+	}
+	return values
+}
+
+// looksLikeFlag reports whether an argument is a flag rather than a (possibly negative) value
+func looksLikeFlag(arg string) bool {
+	if !strings.HasPrefix(arg, "-") || len(arg) < 2 {
+		return false
+	}
+	_, err := strconv.ParseFloat(arg, 64)
+	return err != nil
+}
+
+// ValidateLlamaArgs checks a command line for binary against the registry and, when installed is not
+// nil, against the flags the installed executable lists in its --help output
+func ValidateLlamaArgs(binary LlamaBinary, args []string, installed *LlamaBinaryInfo) []FlagProblem {
+	var problems []FlagProblem
+	for _, value := range ParseLlamaArgs(args) {
+		flag, known := LookupLlamaFlag(value.Flag)
+		if installed != nil && !installed.Supports(value.Flag, flag) {
+			problems = append(problems, FlagProblem{Flag: value.Flag, Message: "is not supported by the installed " + installed.describe()})
+			continue
+		}
+		if !known {
+			continue
+		}
+		if len(flag.Binaries) > 0 && !slices.Contains(flag.Binaries, binary) {
+			problems = append(problems, FlagProblem{Flag: value.Flag, Message: "is not accepted by " + string(binary)})
+			continue
+		}
+		if message := flag.check(value); message != "" {
+			problems = append(problems, FlagProblem{Flag: value.Flag, Value: value.Value, Message: message})
+		}
+	}
+	return problems
+}
+
+// check returns why value is not acceptable for the flag, or an empty string
+func (f LlamaFlag) check(value FlagValue) string {
+	if f.Type == FlagSwitch {
+		return ""
+	}
+	if value.Switch {
+		return "needs a value"
+	}
+	if f.Type != FlagChoice && slices.Contains(f.Choices, value.Value) {
+		return ""
+	}
+
+	switch f.Type {
+	case FlagInt:
+		number, err := strconv.ParseInt(strings.TrimSpace(value.Value), 10, 64)
+		if err != nil {
+			return "is not a whole number"
+		}
+		return f.checkRange(float64(number))
+	case FlagFloat:
+		number, err := strconv.ParseFloat(strings.TrimSpace(value.Value), 64)
+		if err != nil {
+			return "is not a number"
+		}
+		return f.checkRange(number)
+	case FlagChoice:
+		if !slices.Contains(f.Choices, value.Value) {
+			return "must be one of " + strings.Join(f.Choices, ", ")
+		}
+	case FlagFile:
+		info, err := os.Stat(value.Value)
+		if err != nil {
+			return "does not exist"
+		}
+		if info.IsDir() {
+			return "is a directory, not a file"
+		}
+	case FlagJSON:
+		if !json.Valid([]byte(value.Value)) {
+			return "is not valid JSON"
+		}
+	}
+	return ""
+}
+
+func (f LlamaFlag) checkRange(number float64) string {
+	switch {
+	case f.Min != nil && f.Max != nil && (number < *f.Min || number > *f.Max):
+		return fmt.Sprintf("must be between %g and %g", *f.Min, *f.Max)
+	case f.Min != nil && number < *f.Min:
+		return fmt.Sprintf("must be at least %g", *f.Min)
+	case f.Max != nil && number > *f.Max:
+		return fmt.Sprintf("must be at most %g", *f.Max)
+	}
+	return ""
+}
+
+// abbreviateFlagValue shortens long values such as prompts for error messages
+func abbreviateFlagValue(value string) string {
+	const limit = 40
+	if runes := []rune(value); len(runes) > limit {
+		return string(runes[:limit]) + "..."
+	}
+	return value
+}
+
+// ValidateLlamaCliArgs returns the problems in a llama-cli settings profile
+func (app *App) ValidateLlamaCliArgs(args LlamaCliArgs) []FlagProblem {
+	return ValidateLlamaArgs(LlamaBinaryCli, LlamaCliStructToArgs(args), llamaBinaries.Cached(app.appArgs.LLamaCliPath))
+}
+
+// ValidateLlamaEmbedArgs returns the problems in a llama-embedding settings profile
+func (app *App) ValidateLlamaEmbedArgs(args LlamaEmbedArgs) []FlagProblem {
+	return ValidateLlamaArgs(LlamaBinaryEmbedding, LlamaEmbedStructToArgs(args), llamaBinaries.Cached(app.appArgs.LLamaEmbedCliPath))
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseLlamaArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want []FlagValue
+	}{
+		{
+			name: "values and switches",
+			args: []string{"-m", "model.gguf", "--flash-attn", "-c", "2048"},
+			want: []FlagValue{{Flag: "-m", Value: "model.gguf"}, {Flag: "--flash-attn", Switch: true}, {Flag: "-c", Value: "2048"}},
+		},
+		{
+			name: "negative value of a known flag",
+			args: []string{"--seed", "-1", "-ngl", "-1"},
+			want: []FlagValue{{Flag: "--seed", Value: "-1"}, {Flag: "-ngl", Value: "-1"}},
+		},
+		{
+			name: "known flag takes a value that starts with a dash",
+			args: []string{"-p", "-list the steps"},
+			want: []FlagValue{{Flag: "-p", Value: "-list the steps"}},
+		},
+		{
+			name: "unknown flag with a negative number",
+			args: []string{"--new-offset", "-0.5", "--temp", "0.7"},
+			want: []FlagValue{{Flag: "--new-offset", Value: "-0.5"}, {Flag: "--temp", Value: "0.7"}},
+		},
+		{
+			name: "unknown flag followed by a flag is a switch",
+			args: []string{"--new-switch", "--temp", "0.7"},
+			want: []FlagValue{{Flag: "--new-switch", Switch: true}, {Flag: "--temp", Value: "0.7"}},
+		},
+		{
+			name: "missing value at the end",
+			args: []string{"--flash-attn", "--temp"},
+			want: []FlagValue{{Flag: "--flash-attn", Switch: true}, {Flag: "--temp", Switch: true}},
+		},
+		{
+			name: "no arguments",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := ParseLlamaArgs(test.args); !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestLooksLikeFlag(t *testing.T) {
+	tests := map[string]bool{
+		"-m":      true,
+		"--temp":  true,
+		"-no-cnv": true,
+		"-1":      false,
+		"-0.5":    false,
+		"-1e3":    false,
+		"-":       false,
+		"value":   false,
+		"":        false,
+	}
+	for arg, want := range tests {
+		if got := looksLikeFlag(arg); got != want {
+			t.Errorf("looksLikeFlag(%q) = %v, want %v", arg, got, want)
+		}
+	}
+}
+
+func TestLlamaFlagCheck(t *testing.T) {
+	tests := []struct {
+		name  string
+		flag  LlamaFlag
+		value FlagValue
+		want  string
+	}{
+		{name: "switch", flag: switchFlag("--mlock"), value: FlagValue{Flag: "--mlock", Switch: true}},
+		{name: "missing value", flag: intFlag("--keep"), value: FlagValue{Flag: "--keep", Switch: true}, want: "needs a value"},
+		{name: "int in range", flag: intFlag("--mirostat").between(0, 2), value: FlagValue{Value: "2"}},
+		{name: "int below range", flag: intFlag("--mirostat").between(0, 2), value: FlagValue{Value: "-1"}, want: "must be between 0 and 2"},
+		{name: "int above range", flag: intFlag("--mirostat").between(0, 2), value: FlagValue{Value: "3"}, want: "must be between 0 and 2"},
+		{name: "int minimum", flag: intFlag("--batch-size").atLeast(1), value: FlagValue{Value: "0"}, want: "must be at least 1"},
+		{name: "int with spaces", flag: intFlag("--batch-size").atLeast(1), value: FlagValue{Value: " 512 "}},
+		{name: "int fraction", flag: intFlag("--batch-size"), value: FlagValue{Value: "1.5"}, want: "is not a whole number"},
+		{name: "int word", flag: intFlag("-ngl").atLeast(-1).or("auto", "all"), value: FlagValue{Value: "all"}},
+		{name: "int unknown word", flag: intFlag("-ngl").atLeast(-1).or("auto", "all"), value: FlagValue{Value: "most"}, want: "is not a whole number"},
+		{name: "float in range", flag: floatFlag("--top-p").between(0, 1), value: FlagValue{Value: "0.95"}},
+		{name: "float out of range", flag: floatFlag("--top-p").between(0, 1), value: FlagValue{Value: "1.5"}, want: "must be between 0 and 1"},
+		{name: "float text", flag: floatFlag("--temp"), value: FlagValue{Value: "warm"}, want: "is not a number"},
+		{name: "choice", flag: choiceFlag("--rope-scaling", []string{"none", "linear", "yarn"}), value: FlagValue{Value: "yarn"}},
+		{name: "unknown choice", flag: choiceFlag("--rope-scaling", []string{"none", "linear", "yarn"}), value: FlagValue{Value: "cubic"}, want: "must be one of none, linear, yarn"},
+		{name: "json", flag: jsonFlag("--json-schema"), value: FlagValue{Value: `{"type":"object"}`}},
+		{name: "invalid json", flag: jsonFlag("--json-schema"), value: FlagValue{Value: `{"type":`}, want: "is not valid JSON"},
+		{name: "string", flag: stringFlag("--prompt"), value: FlagValue{Value: ""}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.flag.check(test.value); got != test.want {
+				t.Fatalf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestLlamaFlagCheckFile(t *testing.T) {
+	directory := t.TempDir()
+	model := filepath.Join(directory, "model.gguf")
+	if err := os.WriteFile(model, []byte("GGUF"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	flag := fileFlag("--model", "-m")
+	tests := map[string]string{
+		model:                               "",
+		filepath.Join(directory, "missing"): "does not exist",
+		directory:                           "is a directory, not a file",
+	}
+	for path, want := range tests {
+		if got := flag.check(FlagValue{Flag: "-m", Value: path}); got != want {
+			t.Errorf("check(%s) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestValidateLlamaArgs(t *testing.T) {
+	model := filepath.Join(t.TempDir(), "model.gguf")
+	if err := os.WriteFile(model, []byte("GGUF"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	installed := &LlamaBinaryInfo{
+		Binary: LlamaBinaryCli,
+		Build:  5535,
+		Flags:  map[string]bool{"-m": true, "-c": true, "--temp": true, "--top-p": true},
+	}
+
+	tests := []struct {
+		name      string
+		binary    LlamaBinary
+		args      []string
+		installed *LlamaBinaryInfo
+		want      []FlagProblem
+	}{
+		{
+			name:   "valid",
+			binary: LlamaBinaryCli,
+			args:   []string{"-m", model, "--temp", "0.7", "--seed", "-1", "-ngl", "auto"},
+		},
+		{
+			name:   "unknown flags are left to the executable",
+			binary: LlamaBinaryCli,
+			args:   []string{"--brand-new", "-3"},
+		},
+		{
+			name:   "flag of another binary",
+			binary: LlamaBinaryEmbedding,
+			args:   []string{"--interactive", "--pooling", "mean"},
+			want:   []FlagProblem{{Flag: "--interactive", Message: "is not accepted by llama-embedding"}},
+		},
+		{
+			name:   "every problem is reported",
+			binary: LlamaBinaryCli,
+			args:   []string{"--top-p", "1.5", "--threads", "two", "--rope-scaling", "cubic", "--temp"},
+			want: []FlagProblem{
+				{Flag: "--top-p", Value: "1.5", Message: "must be between 0 and 1"},
+				{Flag: "--threads", Value: "two", Message: "is not a whole number"},
+				{Flag: "--rope-scaling", Value: "cubic", Message: "must be one of none, linear, yarn"},
+				{Flag: "--temp", Message: "needs a value"},
+			},
+		},
+		{
+			name:      "installed build lists an alias",
+			binary:    LlamaBinaryCli,
+			args:      []string{"--model", model, "--ctx-size", "2048"},
+			installed: installed,
+		},
+		{
+			name:      "installed build lacks a flag",
+			binary:    LlamaBinaryCli,
+			args:      []string{"-m", model, "--brand-new", "x", "--top-p", "2"},
+			installed: installed,
+			want: []FlagProblem{
+				{Flag: "--brand-new", Message: "is not supported by the installed llama-cli (b5535)"},
+				{Flag: "--top-p", Value: "2", Message: "must be between 0 and 1"},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := ValidateLlamaArgs(test.binary, test.args, test.installed)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestFlagValidationErrorIsBadFlag(t *testing.T) {
+	err := error(&FlagValidationError{Binary: LlamaBinaryCli, Problems: []FlagProblem{{Flag: "--temp", Message: "needs a value"}}})
+	if !errors.Is(err, ErrBadFlag) {
+		t.Fatal("a validation error does not match ErrBadFlag")
+	}
+	if want := "invalid llama-cli arguments: --temp needs a value"; err.Error() != want {
+		t.Fatalf("got %q, want %q", err.Error(), want)
+	}
+}
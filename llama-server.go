@@ -0,0 +1,578 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wailsapp/wails/v2/pkg/logger"
+)
+
+const (
+	llamaServerDefaultHost     = "127.0.0.1"
+	llamaServerStartupTimeout  = 10 * time.Minute // large GGUF files can take minutes to load
+	llamaServerHealthInterval  = 500 * time.Millisecond
+	llamaServerShutdownTimeout = 10 * time.Second
+	llamaServerLogFileName     = "llama-server.log"
+)
+
+// LlamaServerManager owns a single llama-server subprocess and keeps the model loaded between requests.
+// The process is restarted whenever the model or any load-time flag changes, once the requests still
+// using it have finished.
+type LlamaServerManager struct {
+	mu         sync.Mutex
+	log        logger.Logger
+	httpClient *http.Client
+
+	cmd       *exec.Cmd
+	exited    chan struct{}
+	logFile   *os.File
+	baseURL   string
+	configKey string
+
+	active int           // requests using the running server
+	idle   chan struct{} // closed when active drops back to 0
+}
+
+// LlamaServerCompletionRequest is the body sent to llama-server's /completion endpoint
+type LlamaServerCompletionRequest struct {
+	Prompt           string          `json:"prompt"`
+	Stream           bool            `json:"stream"`
+	CachePrompt      bool            `json:"cache_prompt"`
+	NPredict         *int            `json:"n_predict,omitempty"`
+	NKeep            *int            `json:"n_keep,omitempty"`
+	Seed             *int            `json:"seed,omitempty"`
+	Temperature      *float64        `json:"temperature,omitempty"`
+	TopK             *int            `json:"top_k,omitempty"`
+	TopP             *float64        `json:"top_p,omitempty"`
+	MinP             *float64        `json:"min_p,omitempty"`
+	TypicalP         *float64        `json:"typical_p,omitempty"`
+	RepeatLastN      *int            `json:"repeat_last_n,omitempty"`
+	RepeatPenalty    *float64        `json:"repeat_penalty,omitempty"`
+	PresencePenalty  *float64        `json:"presence_penalty,omitempty"`
+	FrequencyPenalty *float64        `json:"frequency_penalty,omitempty"`
+	DryMultiplier    *float64        `json:"dry_multiplier,omitempty"`
+	DryBase          *float64        `json:"dry_base,omitempty"`
+	DryAllowedLength *int            `json:"dry_allowed_length,omitempty"`
+	DryPenaltyLastN  *int            `json:"dry_penalty_last_n,omitempty"`
+	XtcProbability   *float64        `json:"xtc_probability,omitempty"`
+	XtcThreshold     *float64        `json:"xtc_threshold,omitempty"`
+	Mirostat         *int            `json:"mirostat,omitempty"`
+	MirostatTau      *float64        `json:"mirostat_tau,omitempty"`
+	MirostatEta      *float64        `json:"mirostat_eta,omitempty"`
+	IgnoreEos        bool            `json:"ignore_eos,omitempty"`
+	Grammar          string          `json:"grammar,omitempty"`
+	JsonSchema       json.RawMessage `json:"json_schema,omitempty"`
+	Stop             []string        `json:"stop,omitempty"`
+}
+
+// llamaServerStreamChunk is a single server-sent event emitted by /completion when stream is true
+type llamaServerStreamChunk struct {
+	Content string `json:"content"`
+	Stop    bool   `json:"stop"`
+
+	// Sent with the final chunk
+	Timings *struct {
+		PromptN            int     `json:"prompt_n"`
+		PromptMs           float64 `json:"prompt_ms"`
+		PromptPerSecond    float64 `json:"prompt_per_second"`
+		PredictedN         int     `json:"predicted_n"`
+		PredictedMs        float64 `json:"predicted_ms"`
+		PredictedPerSecond float64 `json:"predicted_per_second"`
+	} `json:"timings,omitempty"`
+	TokensEvaluated    int `json:"tokens_evaluated"` // prompt tokens including those served from the cache
+	GenerationSettings *struct {
+		NCtx int `json:"n_ctx"`
+	} `json:"generation_settings,omitempty"`
+}
+
+// runStats converts the timings of the final chunk
+func (chunk llamaServerStreamChunk) runStats() *RunStats {
+	if chunk.Timings == nil {
+		return nil
+	}
+	stats := &RunStats{
+		PromptTokens:              chunk.Timings.PromptN,
+		PromptMs:                  chunk.Timings.PromptMs,
+		PromptTokensPerSecond:     chunk.Timings.PromptPerSecond,
+		GeneratedTokens:           chunk.Timings.PredictedN,
+		GenerationMs:              chunk.Timings.PredictedMs,
+		GenerationTokensPerSecond: chunk.Timings.PredictedPerSecond,
+		TotalMs:                   chunk.Timings.PromptMs + chunk.Timings.PredictedMs,
+		ContextUsed:               max(chunk.TokensEvaluated, chunk.Timings.PromptN) + chunk.Timings.PredictedN,
+	}
+	if chunk.GenerationSettings != nil {
+		stats.ContextSize = chunk.GenerationSettings.NCtx
+	}
+	return stats
+}
+
+// NewLlamaServerManager creates a manager; the subprocess is started lazily on the first completion
+func NewLlamaServerManager(log logger.Logger) *LlamaServerManager {
+	return &LlamaServerManager{
+		log: log,
+		// No overall timeout: generations can legitimately stream for minutes and are bounded by ctx
+		httpClient: &http.Client{},
+	}
+}
+
+// LlamaServerLoadArgs returns the llama-server arguments that are fixed when the model is loaded.
+// Sampling parameters are deliberately excluded; they are sent per request instead.
+func LlamaServerLoadArgs(args LlamaCliArgs) []string {
+	var result []string
+	// Helper function for command-value pairs
+	addCmdValPair := func(cmd string, val string) {
+		if len(cmd) != 0 && len(val) != 0 {
+			result = append(result, cmd, val)
+		}
+	}
+	// Helper function for boolean commands
+	addCmdBoolPair := func(cmd string, val bool) {
+		if len(cmd) != 0 && val {
+			result = append(result, cmd)
+		}
+	}
+
+	addCmdValPair(args.ModelCmd, args.ModelFullPathVal)
+	addCmdValPair(args.ThreadsCmd, args.ThreadsVal)
+	addCmdValPair(args.ThreadsBatchCmd, args.ThreadsBatchVal)
+	addCmdValPair(args.CpuMaskCmd, args.CpuMaskVal)
+	addCmdValPair(args.CpuRangeCmd, args.CpuRangeVal)
+	addCmdValPair(args.CpuStrictCmd, args.CpuStrictVal)
+	addCmdValPair(args.PrioCmd, args.PrioVal)
+	addCmdValPair(args.PollCmd, args.PollVal)
+	addCmdValPair(args.CtxSizeCmd, args.CtxSizeVal)
+	addCmdValPair(args.BatchCmd, args.BatchCmdVal)
+	addCmdValPair(args.UBatchCmd, args.UBatchCmdVal)
+	addCmdBoolPair(args.FlashAttentionCmd, args.FlashAttentionCmdEnabled)
+	addCmdValPair(args.RopeScalingCmd, args.RopeScalingVal)
+	addCmdValPair(args.RopeScaleCmd, args.RopeScaleVal)
+	addCmdValPair(args.RopeFreqBaseCmd, args.RopeFreqBaseVal)
+	addCmdValPair(args.RopeFreqScaleCmd, args.RopeFreqScaleVal)
+	addCmdValPair(args.YarnOrigContextCmd, args.YarnOrigContextCmdVal)
+	addCmdValPair(args.YarnExtFactorCmd, args.YarnExtFactorVal)
+	addCmdValPair(args.YarnAttnFactorCmd, args.YarnAttnFactorVal)
+	addCmdValPair(args.YarnBetaSlowCmd, args.YarnBetaSlowVal)
+	addCmdValPair(args.YarnBetaFastCmd, args.YarnBetaFastVal)
+	addCmdBoolPair(args.NoKvOffloadCmd, args.NoKvOffloadCmdEnabled)
+	addCmdValPair(args.CacheTypeKCmd, args.CacheTypeKVal)
+	addCmdValPair(args.CacheTypeVCmd, args.CacheTypeVVal)
+	addCmdValPair(args.DefragTholdCmd, args.DefragTholdVal)
+	addCmdValPair(args.ParallelCmd, args.ParallelVal)
+	addCmdValPair(args.RpcCmd, args.RpcVal)
+	addCmdBoolPair(args.MemLockCmd, args.MemLockCmdEnabled)
+	addCmdBoolPair(args.NoMmapCmd, args.NoMmapCmdEnabled)
+	addCmdValPair(args.NumaCmd, args.NumaVal)
+	addCmdValPair(args.DeviceCmd, args.DeviceVal)
+	addCmdValPair(args.OverrideTensorCmd, args.OverrideTensorVal)
+	addCmdValPair(args.GPULayersCmd, args.GPULayersVal)
+	addCmdValPair(args.SplitModeCmd, args.SplitModeCmdVal)
+	addCmdValPair(args.TensorSplitCmd, args.TensorSplitVal)
+	addCmdValPair(args.MainGPUCmd, args.MainGPUVal)
+	addCmdBoolPair(args.CheckTensorsCmd, args.CheckTensorsCmdEnabled)
+	addCmdValPair(args.OverrideKvCmd, args.OverrideKvVal)
+	addCmdValPair(args.LoraCmd, args.LoraVal)
+	addCmdValPair(args.LoraScaledCmd, args.LoraScaledVal)
+	addCmdValPair(args.ControlVectorCmd, args.ControlVectorVal)
+	addCmdValPair(args.ControlVectorScaledCmd, args.ControlVectorScaledVal)
+	addCmdValPair(args.ControlVectorLayerRangeCmd, args.ControlVectorLayerRangeVal)
+	addCmdBoolPair(args.JinjaCmd, args.JinjaCmdEnabled)
+	addCmdValPair(args.ChatTemplateCmd, args.ChatTemplateVal)
+	addCmdValPair(args.ChatTemplateFileCmd, args.ChatTemplateFileVal)
+	addCmdValPair(args.ReasoningFormatCmd, args.ReasoningFormatVal)
+
+	return result
+}
+
+// NewLlamaServerCompletionRequest maps the per-request sampling settings of a profile onto a /completion body
+func NewLlamaServerCompletionRequest(args LlamaCliArgs, prompt string, stream bool) LlamaServerCompletionRequest {
+	request := LlamaServerCompletionRequest{
+		Prompt:           prompt,
+		Stream:           stream,
+		CachePrompt:      true,
+		NPredict:         parseOptionalInt(args.PredictVal),
+		NKeep:            parseOptionalInt(args.KeepVal),
+		Seed:             parseOptionalInt(args.RandomSeedVal),
+		Temperature:      parseOptionalFloat(args.TemperatureVal),
+		TopK:             parseOptionalInt(args.TopKVal),
+		TopP:             parseOptionalFloat(args.TopPVal),
+		MinP:             parseOptionalFloat(args.MinPVal),
+		TypicalP:         parseOptionalFloat(args.TypicalVal),
+		RepeatLastN:      parseOptionalInt(args.RepeatLastPenaltyVal),
+		RepeatPenalty:    parseOptionalFloat(args.RepeatPenaltyVal),
+		PresencePenalty:  parseOptionalFloat(args.PresencePenaltyVal),
+		FrequencyPenalty: parseOptionalFloat(args.FrequencyPenaltyVal),
+		DryMultiplier:    parseOptionalFloat(args.DryMultiplierVal),
+		DryBase:          parseOptionalFloat(args.DryBaseVal),
+		DryAllowedLength: parseOptionalInt(args.DryAllowedLengthVal),
+		DryPenaltyLastN:  parseOptionalInt(args.DryPenaltyLastNVal),
+		XtcProbability:   parseOptionalFloat(args.XtcProbabilityVal),
+		XtcThreshold:     parseOptionalFloat(args.XtcThresholdVal),
+		Mirostat:         parseOptionalInt(args.MirostatVal),
+		MirostatTau:      parseOptionalFloat(args.MirostatEntVal),
+		MirostatEta:      parseOptionalFloat(args.MirostatLrVal),
+		IgnoreEos:        args.IgnoreEosCmdEnabled,
+		Grammar:          args.GrammarVal,
+	}
+	if args.ReversePromptVal != "" {
+		request.Stop = []string{args.ReversePromptVal}
+	}
+	if args.JsonSchemaVal != "" && json.Valid([]byte(args.JsonSchemaVal)) {
+		request.JsonSchema = json.RawMessage(args.JsonSchemaVal)
+	}
+	return request
+}
+
+// EnsureRunning starts llama-server if needed and returns its base URL along with a release function
+// the caller must call once it no longer uses the server. A running server is restarted when the
+// executable, model or load-time flags differ from the ones it was started with; the restart waits
+// until every request using the server has released it.
+func (m *LlamaServerManager) EnsureRunning(ctx context.Context, appArgs DefaultAppArgs, cliArgs LlamaCliArgs) (string, func(), error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if appArgs.LLamaServerPath == "" {
+		return "", nil, fmt.Errorf("llama-server path is not configured")
+	}
+	if cliArgs.ModelFullPathVal == "" {
+		return "", nil, fmt.Errorf("no model selected for llama-server")
+	}
+
+	loadArgs := LlamaServerLoadArgs(cliArgs)
+	configKey := appArgs.LLamaServerPath + "\x00" + strings.Join(loadArgs, "\x00")
+
+	for m.isRunningLocked() {
+		if m.configKey == configKey {
+			return m.baseURL, m.useServerLocked(), nil
+		}
+		if m.active == 0 {
+			m.log.Info("llama-server configuration changed, restarting")
+			m.stopLocked()
+			break
+		}
+
+		// Another model is still answering; restart once its requests are done
+		idle := m.idle
+		m.log.Info(fmt.Sprintf("llama-server configuration changed, waiting for %d running requests before restarting", m.active))
+		m.mu.Unlock()
+		select {
+		case <-idle:
+			m.mu.Lock()
+		case <-ctx.Done():
+			m.mu.Lock()
+			return "", nil, ctx.Err()
+		}
+	}
+
+	host := appArgs.LLamaServerHost
+	if host == "" {
+		host = llamaServerDefaultHost
+	}
+	port := appArgs.LLamaServerPort
+	if port == "" {
+		freePort, err := findFreePort(host)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to find a free port for llama-server: %w", err)
+		}
+		port = strconv.Itoa(freePort)
+	}
+
+	serverArgs := append(loadArgs, "--host", host, "--port", port)
+	if err := checkLlamaArgs(ctx, LlamaBinaryServer, appArgs.LLamaServerPath, serverArgs); err != nil {
+		return "", nil, err
+	}
+	cmd := exec.Command(appArgs.LLamaServerPath, serverArgs...)
+	setProcessAttributes(cmd)
+
+	// Keep the server's own log next to the model logs so load failures can be diagnosed
+	if appArgs.ModelLogPath != "" {
+		logFile, err := os.OpenFile(filepath.Join(appArgs.ModelLogPath, llamaServerLogFileName), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+		if err != nil {
+			m.log.Error("Failed to open llama-server log file: " + err.Error())
+		} else {
+			cmd.Stdout = logFile
+			cmd.Stderr = logFile
+			m.logFile = logFile
+		}
+	}
+
+	m.log.Info(fmt.Sprintf("Starting llama-server on %s:%s with model %s", host, port, cliArgs.ModelFullPathVal))
+	if err := cmd.Start(); err != nil {
+		m.closeLogFileLocked()
+		return "", nil, fmt.Errorf("failed to start llama-server: %w", err)
+	}
+
+	pid := cmd.Process.Pid
+	childProcesses.Add(pid, appArgs.LLamaServerPath)
+
+	exited := make(chan struct{})
+	go func() {
+		_ = cmd.Wait()
+		reapProcessGroup(pid)
+		childProcesses.Remove(pid)
+		close(exited)
+	}()
+
+	m.cmd = cmd
+	m.exited = exited
+	m.baseURL = "http://" + net.JoinHostPort(host, port)
+	m.configKey = configKey
+
+	if err := m.waitForHealthy(ctx, m.baseURL, exited); err != nil {
+		m.stopLocked()
+		return "", nil, err
+	}
+
+	m.log.Info("llama-server is ready at " + m.baseURL)
+	return m.baseURL, m.useServerLocked(), nil
+}
+
+// useServerLocked counts a request against the running server and returns the function that ends it
+func (m *LlamaServerManager) useServerLocked() func() {
+	if m.active == 0 {
+		m.idle = make(chan struct{})
+	}
+	m.active++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			m.active--
+			if m.active == 0 {
+				close(m.idle)
+				m.idle = nil
+			}
+		})
+	}
+}
+
+// Complete sends a prompt to llama-server and streams the generated text through onToken. The
+// timings of the final chunk are returned as RunStats.
+func (m *LlamaServerManager) Complete(ctx context.Context, appArgs DefaultAppArgs, cliArgs LlamaCliArgs, prompt string, onToken TokenCallback) ([]byte, *RunStats, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	release, err := workScheduler.Acquire(ctx, ResourceLLM)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer release()
+
+	baseURL, releaseServer, err := m.EnsureRunning(ctx, appArgs, cliArgs)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer releaseServer()
+
+	body, err := json.Marshal(NewLlamaServerCompletionRequest(cliArgs, prompt, true))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode llama-server request: %w", err)
+	}
+
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, baseURL+"/completion", bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create llama-server request: %w", err)
+	}
+	httpRequest.Header.Set("Content-Type", "application/json")
+	httpRequest.Header.Set("Accept", "text/event-stream")
+
+	httpResponse, err := m.httpClient.Do(httpRequest)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		return nil, nil, fmt.Errorf("llama-server request failed: %w", err)
+	}
+	defer func(responseBody io.ReadCloser) {
+		err := responseBody.Close()
+		if err != nil {
+			m.log.Error(err.Error())
+		}
+	}(httpResponse.Body)
+
+	if httpResponse.StatusCode != http.StatusOK {
+		errorBody, _ := io.ReadAll(io.LimitReader(httpResponse.Body, 4096))
+		return nil, nil, fmt.Errorf("llama-server returned %s: %s", httpResponse.Status, strings.TrimSpace(string(errorBody)))
+	}
+
+	output, stats, err := readLlamaServerStream(httpResponse.Body, onToken)
+	if ctx.Err() != nil {
+		return nil, nil, ctx.Err()
+	}
+	return output, stats, err
+}
+
+// Stop terminates the managed llama-server, if any
+func (m *LlamaServerManager) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stopLocked()
+}
+
+func (m *LlamaServerManager) isRunningLocked() bool {
+	if m.cmd == nil || m.exited == nil {
+		return false
+	}
+	select {
+	case <-m.exited:
+		return false
+	default:
+		return true
+	}
+}
+
+func (m *LlamaServerManager) stopLocked() {
+	if m.cmd != nil && m.cmd.Process != nil && m.isRunningLocked() {
+		m.log.Info("Stopping llama-server...")
+		pid := m.cmd.Process.Pid
+		if err := terminateProcessTree(pid); err != nil {
+			m.log.Error("Failed to stop llama-server: " + err.Error())
+		}
+		select {
+		case <-m.exited:
+		case <-time.After(processGracePeriod):
+			m.log.Info("llama-server did not exit in time, killing it")
+			if err := killProcessTree(pid); err != nil {
+				m.log.Error("Failed to kill llama-server: " + err.Error())
+			}
+			select {
+			case <-m.exited:
+			case <-time.After(llamaServerShutdownTimeout):
+				m.log.Error("Timed out waiting for llama-server to exit")
+			}
+		}
+	}
+	m.closeLogFileLocked()
+	m.cmd = nil
+	m.exited = nil
+	m.baseURL = ""
+	m.configKey = ""
+}
+
+func (m *LlamaServerManager) closeLogFileLocked() {
+	if m.logFile != nil {
+		if err := m.logFile.Close(); err != nil {
+			m.log.Error("Failed to close llama-server log file: " + err.Error())
+		}
+		m.logFile = nil
+	}
+}
+
+// waitForHealthy polls /health until the model is loaded. llama-server answers 503 while loading.
+func (m *LlamaServerManager) waitForHealthy(ctx context.Context, baseURL string, exited <-chan struct{}) error {
+	deadline := time.NewTimer(llamaServerStartupTimeout)
+	defer deadline.Stop()
+	ticker := time.NewTicker(llamaServerHealthInterval)
+	defer ticker.Stop()
+
+	for {
+		healthCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+		healthRequest, err := http.NewRequestWithContext(healthCtx, http.MethodGet, baseURL+"/health", nil)
+		if err == nil {
+			healthResponse, err := m.httpClient.Do(healthRequest)
+			if err == nil {
+				_, _ = io.Copy(io.Discard, healthResponse.Body)
+				_ = healthResponse.Body.Close()
+				if healthResponse.StatusCode == http.StatusOK {
+					cancel()
+					return nil
+				}
+			}
+		}
+		cancel()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-exited:
+			return fmt.Errorf("llama-server exited during startup, see %s for details", llamaServerLogFileName)
+		case <-deadline.C:
+			return fmt.Errorf("llama-server did not become healthy within %s", llamaServerStartupTimeout)
+		case <-ticker.C:
+		}
+	}
+}
+
+// readLlamaServerStream decodes the server-sent events from /completion, forwarding each content delta
+func readLlamaServerStream(body io.Reader, onToken TokenCallback) ([]byte, *RunStats, error) {
+	var output bytes.Buffer
+	var stats *RunStats
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		payload := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if payload == "" || payload == "[DONE]" {
+			continue
+		}
+
+		var chunk llamaServerStreamChunk
+		if err := json.Unmarshal([]byte(payload), &chunk); err != nil {
+			return output.Bytes(), nil, fmt.Errorf("failed to decode llama-server stream: %w", err)
+		}
+		if chunk.Content != "" {
+			output.WriteString(chunk.Content)
+			if onToken != nil {
+				onToken(chunk.Content)
+			}
+		}
+		if chunk.Stop {
+			stats = chunk.runStats()
+			break
+		}
+	}
+
+	if err := scanner.Err(); err != nil && !errors.Is(err, context.Canceled) {
+		return output.Bytes(), stats, fmt.Errorf("failed to read llama-server stream: %w", err)
+	}
+	return output.Bytes(), stats, nil
+}
+
+// findFreePort asks the OS for an unused TCP port on host
+func findFreePort(host string) (int, error) {
+	listener, err := net.Listen("tcp", net.JoinHostPort(host, "0"))
+	if err != nil {
+		return 0, err
+	}
+	defer func(listener net.Listener) {
+		_ = listener.Close()
+	}(listener)
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
+
+// parseOptionalInt returns nil for empty or non-numeric settings so they are omitted from the request
+func parseOptionalInt(value string) *int {
+	parsed, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return nil
+	}
+	return &parsed
+}
+
+// parseOptionalFloat returns nil for empty or non-numeric settings so they are omitted from the request
+func parseOptionalFloat(value string) *float64 {
+	parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		return nil
+	}
+	return &parsed
+}
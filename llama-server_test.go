@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/wailsapp/wails/v2/pkg/logger"
+)
+
+// newTestLlamaServer returns a manager that treats server as its already running llama-server
+func newTestLlamaServer(t *testing.T, handler http.HandlerFunc) (*LlamaServerManager, DefaultAppArgs, LlamaCliArgs) {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	appArgs := DefaultAppArgs{LLamaServerPath: "llama-server"}
+	cliArgs := LlamaCliArgs{ModelCmd: "-m", ModelFullPathVal: "model.gguf"}
+	manager := NewLlamaServerManager(logger.NewDefaultLogger())
+	manager.cmd = &exec.Cmd{}
+	manager.exited = make(chan struct{})
+	manager.baseURL = server.URL
+	manager.configKey = appArgs.LLamaServerPath + "\x00" + strings.Join(LlamaServerLoadArgs(cliArgs), "\x00")
+	return manager, appArgs, cliArgs
+}
+
+func TestLlamaServerCompleteStreamsTokens(t *testing.T) {
+	manager, appArgs, cliArgs := newTestLlamaServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/completion" {
+			http.NotFound(w, r)
+			return
+		}
+		var request LlamaServerCompletionRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || !request.Stream || request.Prompt != "Say hi" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, content := range []string{"Hel", "lo", " there"} {
+			fmt.Fprintf(w, "data: {\"content\":%q,\"stop\":false}\n\n", content)
+			w.(http.Flusher).Flush()
+		}
+		fmt.Fprint(w, `data: {"content":"","stop":true,"tokens_evaluated":12,`+
+			`"timings":{"prompt_n":4,"prompt_ms":20,"prompt_per_second":200,"predicted_n":3,"predicted_ms":30,"predicted_per_second":100},`+
+			`"generation_settings":{"n_ctx":4096}}`+"\n\n")
+	})
+
+	var tokens []string
+	output, stats, err := manager.Complete(context.Background(), appArgs, cliArgs, "Say hi", func(token string) {
+		tokens = append(tokens, token)
+	})
+	if err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	if string(output) != "Hello there" || strings.Join(tokens, "|") != "Hel|lo| there" {
+		t.Errorf("got output %q and tokens %q", output, tokens)
+	}
+	if stats == nil {
+		t.Fatal("no stats from the final chunk")
+	}
+	if stats.PromptTokens != 4 || stats.GeneratedTokens != 3 || stats.ContextUsed != 15 || stats.ContextSize != 4096 || stats.TotalMs != 50 {
+		t.Errorf("unexpected stats %+v", *stats)
+	}
+}
+
+func TestLlamaServerCompleteErrorStatus(t *testing.T) {
+	manager, appArgs, cliArgs := newTestLlamaServer(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":{"message":"the context is full"}}`, http.StatusInternalServerError)
+	})
+
+	_, _, err := manager.Complete(context.Background(), appArgs, cliArgs, "Say hi", nil)
+	if err == nil || !strings.Contains(err.Error(), "500") || !strings.Contains(err.Error(), "the context is full") {
+		t.Fatalf("got %v, want the status and body of the error response", err)
+	}
+}
+
+func TestLlamaServerWaitForHealthy(t *testing.T) {
+	var healthChecks atomic.Int32
+	manager, _, _ := newTestLlamaServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			http.NotFound(w, r)
+			return
+		}
+		// llama-server answers 503 while the model is loading
+		if healthChecks.Add(1) < 3 {
+			http.Error(w, `{"error":{"message":"Loading model"}}`, http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, `{"status":"ok"}`)
+	})
+
+	if err := manager.waitForHealthy(context.Background(), manager.baseURL, manager.exited); err != nil {
+		t.Fatalf("waitForHealthy: %v", err)
+	}
+	if healthChecks.Load() != 3 {
+		t.Errorf("polled /health %d times, want 3", healthChecks.Load())
+	}
+}
+
+func TestLlamaServerWaitForHealthyStops(t *testing.T) {
+	manager, _, _ := newTestLlamaServer(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "loading", http.StatusServiceUnavailable)
+	})
+
+	exited := make(chan struct{})
+	close(exited)
+	err := manager.waitForHealthy(context.Background(), manager.baseURL, exited)
+	if err == nil || !strings.Contains(err.Error(), "exited during startup") {
+		t.Errorf("got %v, want an error for a server that exited", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := manager.waitForHealthy(ctx, manager.baseURL, manager.exited); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want the context error", err)
+	}
+}
+
+func TestLlamaServerRestartWaitsForRunningRequests(t *testing.T) {
+	unblock := make(chan struct{})
+	var unblockOnce sync.Once
+	release := func() { unblockOnce.Do(func() { close(unblock) }) }
+	manager, appArgs, cliArgs := newTestLlamaServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"content\":\"Hel\",\"stop\":false}\n\n")
+		w.(http.Flusher).Flush()
+		<-unblock
+		fmt.Fprint(w, "data: {\"content\":\"lo\",\"stop\":true}\n\n")
+	})
+
+	streaming := make(chan struct{})
+	type result struct {
+		output []byte
+		err    error
+	}
+	completed := make(chan result, 1)
+	go func() {
+		var once sync.Once
+		output, _, err := manager.Complete(context.Background(), appArgs, cliArgs, "Say hi", func(string) {
+			once.Do(func() { close(streaming) })
+		})
+		completed <- result{output, err}
+	}()
+	// Cleanups run last first, so the handler is released before the server is closed
+	t.Cleanup(release)
+	<-streaming
+
+	// A request for another model has to wait instead of killing the running one
+	otherArgs := cliArgs
+	otherArgs.ModelFullPathVal = "other.gguf"
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, _, err := manager.EnsureRunning(ctx, appArgs, otherArgs); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want the restart to wait for the running request", err)
+	}
+
+	restarted := make(chan struct{})
+	go func() {
+		// The fake has no executable to start, so the restart itself fails once it is allowed
+		_, _, _ = manager.EnsureRunning(context.Background(), appArgs, otherArgs)
+		close(restarted)
+	}()
+	select {
+	case <-restarted:
+		t.Fatal("the server was restarted while a request was streaming")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	release()
+	if got := <-completed; got.err != nil || string(got.output) != "Hello" {
+		t.Fatalf("the running request got %q, %v", got.output, got.err)
+	}
+	select {
+	case <-restarted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the restart did not go ahead once the request finished")
+	}
+}
@@ -165,7 +165,7 @@ func main() {
 	}
 	// Initialize the Elasticsearch client and create indices
 	elasticsearchLogger := NewElasticsearchRequestLogger(LoggingLevelInfo)
-	_, err = InitializeElasticsearchWithIndices(*elasticsearchLogger, *container.AppArgs)
+	_, err = InitializeElasticsearchWithIndices(*elasticsearchLogger, *container.AppArgs, *container.LlamaEmbedArgs)
 	if err != nil {
 		container.Logger.Fatal(fmt.Sprintf("Failed to initialize Elasticsearch: %v", err))
 	}
@@ -3,19 +3,30 @@ package main
 import (
 	"context"
 	"fmt"
-
-	"github.com/tiagomelo/go-ocr/ocr"
 )
 
+// defaultTesseractPath is used when TesseractPath is not configured
+const defaultTesseractPath = "tesseract"
+
 // OCRDocument performs OCR on an image file and returns the extracted text
 func (app *App) OCRDocument(imagePath string) string {
-	t, err := ocr.New(ocr.TesseractPath(app.appArgs.TesseractPath))
+	job, err := app.startJob("", JobKindOCR)
+	if err != nil {
+		return "Error: " + err.Error()
+	}
+	result := app.ocrDocument(job.Context(), imagePath)
+	app.jobs.Finish(job, app.resultError(result))
+	return result
+}
+
+func (app *App) ocrDocument(ctx context.Context, imagePath string) string {
+	release, err := workScheduler.Acquire(ctx, ResourceOCR)
 	if err != nil {
-		app.log.Error("Failed to initialize OCR: " + err.Error())
-		return "Error: Failed to initialize OCR - " + err.Error()
+		return "Operation cancelled by user"
 	}
+	defer release()
 
-	extractedText, err := t.TextFromImageFile(imagePath)
+	extractedText, err := app.extractTextFromImage(ctx, imagePath)
 	if err != nil {
 		app.log.Error("Failed to extract text from image: " + err.Error())
 		return "Error: Failed to extract text from image - " + err.Error()
@@ -25,16 +36,41 @@ func (app *App) OCRDocument(imagePath string) string {
 	return extractedText
 }
 
+// extractTextFromImage runs tesseract on an image file and returns the recognised text
+func (app *App) extractTextFromImage(ctx context.Context, imagePath string) (string, error) {
+	tesseractPath := app.appArgs.TesseractPath
+	if tesseractPath == "" {
+		tesseractPath = defaultTesseractPath
+	}
+	output, err := RunProcess(ctx, ProcessSpec{
+		Name:       "tesseract",
+		Path:       tesseractPath,
+		Args:       []string{imagePath, "stdout"},
+		MaxRuntime: maxProcessRuntime(*app.appArgs),
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(output), nil
+}
+
 // PDFToImages converts a PDF file to JPEG images and returns the paths of created images
 func (app *App) PDFToImages(pdfPath string) []string {
+	job, err := app.startJob("", JobKindOCR)
+	if err != nil {
+		return []string{"Error: " + err.Error()}
+	}
+	defer app.jobs.Finish(job, nil)
+
 	// Create PDF images loader with JPEG format
 	loader := NewPDFImagesLoader(pdfPath).
 		WithFormat("jpg").
 		WithPDFImagesPath(app.appArgs.PdfToImagesPath).
-		WithOutputDir(app.appArgs.DocumentPath)
+		WithOutputDir(app.appArgs.DocumentPath).
+		WithMaxRuntime(maxProcessRuntime(*app.appArgs))
 
 	// Convert PDF to images
-	imageDocuments, err := loader.Load(context.Background())
+	imageDocuments, err := loader.Load(job.Context())
 	if err != nil {
 		app.log.Error("Failed to convert PDF to images: " + err.Error())
 		return []string{"Error: Failed to convert PDF to images - " + err.Error()}
@@ -58,13 +94,24 @@ func (app *App) PDFToImages(pdfPath string) []string {
 
 // OCRFromPDF converts a PDF to images and then performs OCR on all pages
 func (app *App) OCRFromPDF(pdfPath string) string {
+	job, err := app.startJob("", JobKindOCR)
+	if err != nil {
+		return "Error: " + err.Error()
+	}
+	result := app.ocrFromPDF(job.Context(), pdfPath)
+	app.jobs.Finish(job, app.resultError(result))
+	return result
+}
+
+func (app *App) ocrFromPDF(ctx context.Context, pdfPath string) string {
 	// Create PDF images loader with JPEG format
 	loader := NewPDFImagesLoader(pdfPath).
 		WithFormat("jpg").
-		WithPDFImagesPath(app.appArgs.PdfToImagesPath)
+		WithPDFImagesPath(app.appArgs.PdfToImagesPath).
+		WithMaxRuntime(maxProcessRuntime(*app.appArgs))
 
 	// Convert PDF to images
-	imageDocuments, err := loader.Load(context.Background())
+	imageDocuments, err := loader.Load(ctx)
 	if err != nil {
 		app.log.Error("Failed to convert PDF to images: " + err.Error())
 		return "Error: Failed to convert PDF to images - " + err.Error()
@@ -75,17 +122,6 @@ func (app *App) OCRFromPDF(pdfPath string) string {
 		return "Error: No images created from PDF"
 	}
 
-	// Initialize OCR
-	t, err := ocr.New(ocr.TesseractPath(app.appArgs.TesseractPath))
-	if err != nil {
-		app.log.Error("Failed to initialize OCR: " + err.Error())
-		// Clean up images before returning an error
-		err := loader.CleanupImages(imageDocuments)
-		if err != nil {
-			return err.Error()
-		}
-	}
-
 	var allText string
 	successCount := 0
 
@@ -93,9 +129,27 @@ func (app *App) OCRFromPDF(pdfPath string) string {
 
 	// Process each image with OCR
 	for i, doc := range imageDocuments {
+		if ctx.Err() != nil {
+			app.log.Info("OCR processing was cancelled by user")
+			_ = loader.CleanupOutputDirectory()
+			return "Operation cancelled by user"
+		}
+
 		app.log.Info(fmt.Sprintf("Processing OCR for page %d/%d: %s", i+1, len(imageDocuments), doc.ImagePath))
 
-		extractedText, err := t.TextFromImageFile(doc.ImagePath)
+		release, err := workScheduler.Acquire(ctx, ResourceOCR)
+		if err != nil {
+			app.log.Info("OCR processing was cancelled by user")
+			_ = loader.CleanupOutputDirectory()
+			return "Operation cancelled by user"
+		}
+		extractedText, err := app.extractTextFromImage(ctx, doc.ImagePath)
+		release()
+		if ctx.Err() != nil {
+			app.log.Info("OCR processing was cancelled by user")
+			_ = loader.CleanupOutputDirectory()
+			return "Operation cancelled by user"
+		}
 		if err != nil {
 			app.log.Error(fmt.Sprintf("Failed to extract text from page %d: %s", i+1, err.Error()))
 			allText += fmt.Sprintf("\n--- Page %d: OCR Failed ---\n", i+1)
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// OutputFormat names how a prompt type's model marks up its reply
+type OutputFormat string
+
+const (
+	OutputPlain     OutputFormat = ""        // the reply is the answer
+	OutputThinkTags OutputFormat = "think"   // reasoning is wrapped in <think>...</think>
+	OutputHarmony   OutputFormat = "harmony" // gpt-oss harmony channels (analysis, commentary, final)
+	OutputAuto      OutputFormat = "auto"    // detected from the reply; used with model chat templates
+)
+
+// OutputChannel is one harmony message segment
+type OutputChannel struct {
+	Channel string `json:"channel"`
+	Content string `json:"content"`
+}
+
+// ParsedOutput is a model reply split into its reasoning and its final answer
+type ParsedOutput struct {
+	Answer    string          `json:"answer"`
+	Reasoning string          `json:"reasoning,omitempty"`
+	Channels  []OutputChannel `json:"channels,omitempty"`
+}
+
+var (
+	// controlTokenPattern matches the special tokens of the built-in prompt templates
+	controlTokenPattern = regexp.MustCompile(`<\|[a-z_]+\|>|<｜[^｜]*｜>|</?s>|\[/?INST\]|<(?:start|end)_of_turn>`)
+
+	thinkBlockPattern      = regexp.MustCompile(`(?s)<think>(.*?)</think>`)
+	harmonyMessagePattern  = regexp.MustCompile(`(?s)<\|channel\|>\s*([A-Za-z]+)[^<]*(?:<\|constrain\|>[^<]*)?<\|message\|>(.*?)(?:<\|end\|>|<\|return\|>|<\|call\|>|<\|start\|>|$)`)
+	endOfTextMarkerPattern = regexp.MustCompile(`\s*\[end of text\]\s*$`)
+
+	// plainHarmonyPattern matches a harmony reply printed without its special tokens, where the role and
+	// channel names run together ("analysis...assistantfinal..."). The answer follows "final" directly,
+	// so only the joined marker is accepted; prose such as "the assistant finally" is not a channel.
+	plainHarmonyPattern = regexp.MustCompile(`(?s)^\s*analysis(.*?)assistantfinal(.*)$`)
+)
+
+// ParseModelOutput removes the prompt llama-cli echoes before the reply, then splits the reply into
+// reasoning and answer according to the output format of promptType
+func ParseModelOutput(promptType, prompt, output string) ParsedOutput {
+	reply := endOfTextMarkerPattern.ReplaceAllString(stripEchoedPrompt(output, prompt), "")
+
+	format := OutputPlain
+	if config, exists := promptRegistry.GetConfig(resolvePromptType(promptType)); exists {
+		format = config.Output
+	}
+	if format == OutputAuto {
+		format = detectOutputFormat(reply)
+	}
+
+	var parsed ParsedOutput
+	switch format {
+	case OutputThinkTags:
+		parsed = parseThinkTags(reply)
+	case OutputHarmony:
+		parsed = parseHarmonyChannels(reply)
+	default:
+		parsed = ParsedOutput{Answer: reply}
+	}
+	parsed.Answer = strings.TrimSpace(parsed.Answer)
+	parsed.Reasoning = strings.TrimSpace(parsed.Reasoning)
+	return parsed
+}
+
+// detectOutputFormat recognises harmony channels and <think> blocks in a reply. The plain harmony form
+// is too easily confused with ordinary text, so it is only parsed for harmony prompt types.
+func detectOutputFormat(reply string) OutputFormat {
+	switch {
+	case strings.Contains(reply, "<|channel|>"):
+		return OutputHarmony
+	case strings.Contains(reply, "<think>") || strings.Contains(reply, "</think>"):
+		return OutputThinkTags
+	}
+	return OutputPlain
+}
+
+// stripEchoedPrompt removes the prompt that llama-cli prints before the generated text. llama-cli
+// does not print special tokens, so the prompt is also compared with its control tokens removed.
+func stripEchoedPrompt(output, prompt string) string {
+	if prompt == "" {
+		return output
+	}
+	if strings.HasPrefix(output, prompt) {
+		return output[len(prompt):]
+	}
+	visiblePrompt := controlTokenPattern.ReplaceAllString(prompt, "")
+	trimmedOutput := strings.TrimLeft(output, " \t\r\n")
+	trimmedPrompt := strings.TrimLeft(visiblePrompt, " \t\r\n")
+	if trimmedPrompt != "" && strings.HasPrefix(trimmedOutput, trimmedPrompt) {
+		return trimmedOutput[len(trimmedPrompt):]
+	}
+	return output
+}
+
+// parseThinkTags collects every <think> block as reasoning. A reply that only closes the block
+// started by the prompt's generation prefix is handled too.
+func parseThinkTags(reply string) ParsedOutput {
+	var reasoning []string
+	if closeIndex := strings.Index(reply, "</think>"); closeIndex >= 0 && !strings.Contains(reply[:closeIndex], "<think>") {
+		reasoning = append(reasoning, reply[:closeIndex])
+		reply = reply[closeIndex+len("</think>"):]
+	}
+	for _, match := range thinkBlockPattern.FindAllStringSubmatch(reply, -1) {
+		if strings.TrimSpace(match[1]) != "" {
+			reasoning = append(reasoning, strings.TrimSpace(match[1]))
+		}
+	}
+	answer := thinkBlockPattern.ReplaceAllString(reply, "")
+
+	// A reply cut off inside its reasoning has no answer yet
+	if openIndex := strings.LastIndex(answer, "<think>"); openIndex >= 0 {
+		reasoning = append(reasoning, answer[openIndex+len("<think>"):])
+		answer = answer[:openIndex]
+	}
+	return ParsedOutput{Answer: answer, Reasoning: strings.Join(reasoning, "\n\n")}
+}
+
+// parseHarmonyChannels splits a harmony reply into its channel messages. The analysis channel is the
+// reasoning and the last final message the answer. Without special tokens in the output only the
+// plain "analysis...assistantfinal..." form can be recognised.
+func parseHarmonyChannels(reply string) ParsedOutput {
+	var parsed ParsedOutput
+	matches := harmonyMessagePattern.FindAllStringSubmatch(reply, -1)
+	if len(matches) == 0 {
+		if plain := plainHarmonyPattern.FindStringSubmatch(reply); plain != nil {
+			return ParsedOutput{
+				Reasoning: plain[1],
+				Answer:    plain[2],
+				Channels:  []OutputChannel{{Channel: "analysis", Content: strings.TrimSpace(plain[1])}, {Channel: "final", Content: strings.TrimSpace(plain[2])}},
+			}
+		}
+		return ParsedOutput{Answer: reply}
+	}
+
+	var reasoning []string
+	for _, match := range matches {
+		channel := OutputChannel{Channel: strings.ToLower(match[1]), Content: strings.TrimSpace(match[2])}
+		parsed.Channels = append(parsed.Channels, channel)
+		switch channel.Channel {
+		case "analysis":
+			reasoning = append(reasoning, channel.Content)
+		case "final":
+			parsed.Answer = channel.Content
+		}
+	}
+	parsed.Reasoning = strings.Join(reasoning, "\n\n")
+	return parsed
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseModelOutput(t *testing.T) {
+	tests := []struct {
+		name       string
+		promptType string
+		prompt     string
+		output     string
+		want       ParsedOutput
+	}{
+		{
+			name:       "plain reply",
+			promptType: "Mistral",
+			output:     "The answer is 42. [end of text]\n",
+			want:       ParsedOutput{Answer: "The answer is 42."},
+		},
+		{
+			name:       "echoed prompt",
+			promptType: "Mistral",
+			prompt:     "<s>[INST]What is the answer?[/INST]",
+			output:     "What is the answer? The answer is 42.",
+			want:       ParsedOutput{Answer: "The answer is 42."},
+		},
+		{
+			name:       "think tags",
+			promptType: "Qwen3",
+			output:     "<think>\nThe user asks for a number.\n</think>\n\nThe answer is 42.",
+			want:       ParsedOutput{Answer: "The answer is 42.", Reasoning: "The user asks for a number."},
+		},
+		{
+			name:       "think block opened by the prompt",
+			promptType: "Qwen3",
+			output:     "The user asks for a number.</think>The answer is 42.",
+			want:       ParsedOutput{Answer: "The answer is 42.", Reasoning: "The user asks for a number."},
+		},
+		{
+			name:       "cut off while thinking",
+			promptType: "Qwen3",
+			output:     "<think>The user asks for",
+			want:       ParsedOutput{Reasoning: "The user asks for"},
+		},
+		{
+			name:       "harmony with tokens",
+			promptType: "GPTOSS",
+			output:     "<|channel|>analysis<|message|>The user asks for a number.<|end|><|start|>assistant<|channel|>final<|message|>The answer is 42.<|return|>",
+			want: ParsedOutput{
+				Answer:    "The answer is 42.",
+				Reasoning: "The user asks for a number.",
+				Channels:  []OutputChannel{{Channel: "analysis", Content: "The user asks for a number."}, {Channel: "final", Content: "The answer is 42."}},
+			},
+		},
+		{
+			name:       "plain harmony",
+			promptType: "GPTOSS",
+			output:     "analysisThe user asks for a number.assistantfinalThe answer is 42.",
+			want: ParsedOutput{
+				Answer:    "The answer is 42.",
+				Reasoning: "The user asks for a number.",
+				Channels:  []OutputChannel{{Channel: "analysis", Content: "The user asks for a number."}, {Channel: "final", Content: "The answer is 42."}},
+			},
+		},
+		{
+			name:       "harmony prose without the joined marker",
+			promptType: "GPTOSS",
+			output:     "analysis of the logs shows the assistant finally answered.",
+			want:       ParsedOutput{Answer: "analysis of the logs shows the assistant finally answered."},
+		},
+		{
+			name:       "plain harmony is not detected for other prompt types",
+			promptType: AutoPromptType,
+			output:     "analysis first, then the assistantfinal report.",
+			want:       ParsedOutput{Answer: "analysis first, then the assistantfinal report."},
+		},
+		{
+			name:       "auto detects harmony tokens",
+			promptType: "",
+			output:     "<|channel|>final<|message|>The answer is 42.",
+			want: ParsedOutput{
+				Answer:   "The answer is 42.",
+				Channels: []OutputChannel{{Channel: "final", Content: "The answer is 42."}},
+			},
+		},
+		{
+			name:       "auto detects think tags",
+			promptType: AutoPromptType,
+			output:     "<think>Easy.</think>42",
+			want:       ParsedOutput{Answer: "42", Reasoning: "Easy."},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := ParseModelOutput(test.promptType, test.prompt, test.output)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestStripEchoedPrompt(t *testing.T) {
+	tests := []struct {
+		name   string
+		prompt string
+		output string
+		want   string
+	}{
+		{name: "no prompt", output: "Hello", want: "Hello"},
+		{name: "exact echo", prompt: "Say hi.", output: "Say hi. Hello", want: " Hello"},
+		{name: "echo without control tokens", prompt: "<|im_start|>user\nSay hi.<|im_end|>\n<|im_start|>assistant\n", output: "user\nSay hi.\nassistant\nHello", want: "Hello"},
+		{name: "echo after leading whitespace", prompt: "<s>[INST]Say hi.[/INST]", output: "\n Say hi. Hello", want: " Hello"},
+		{name: "not echoed", prompt: "Say hi.", output: "Hello", want: "Hello"},
+		{name: "prompt of control tokens only", prompt: "<|start|>assistant", output: "Hello", want: "Hello"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := stripEchoedPrompt(test.output, test.prompt); got != test.want {
+				t.Fatalf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -4,11 +4,9 @@ import (
 	"context"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"runtime"
 	"strings"
-	"syscall"
+	"time"
 )
 
 var (
@@ -23,6 +21,7 @@ type PDFImagesLoader struct {
 	format        string // jpg, png, ppm, etc.
 	firstPage     int
 	lastPage      int
+	maxRuntime    time.Duration
 }
 
 type ImageDocument struct {
@@ -64,6 +63,11 @@ func (p *PDFImagesLoader) WithFormat(format string) *PDFImagesLoader {
 	return p
 }
 
+func (p *PDFImagesLoader) WithMaxRuntime(maxRuntime time.Duration) *PDFImagesLoader {
+	p.maxRuntime = maxRuntime
+	return p
+}
+
 func (p *PDFImagesLoader) WithPageRange(firstPage, lastPage int) *PDFImagesLoader {
 	p.firstPage = firstPage
 	p.lastPage = lastPage
@@ -140,16 +144,12 @@ func (p *PDFImagesLoader) loadFile(ctx context.Context) ([]ImageDocument, error)
 	args = append(args, p.path, outputPrefix)
 
 	// Execute pdf images command
-	cmd := exec.CommandContext(ctx, p.pdfImagesPath, args...)
-
-	// Hide the window on Windows
-	if runtime.GOOS == "windows" {
-		cmd.SysProcAttr = &syscall.SysProcAttr{
-			HideWindow: true,
-		}
-	}
-
-	err := cmd.Run()
+	_, err := RunProcess(ctx, ProcessSpec{
+		Name:       "pdfimages",
+		Path:       p.pdfImagesPath,
+		Args:       args,
+		MaxRuntime: p.maxRuntime,
+	})
 	if err != nil {
 		return nil, fmt.Errorf("pdfimages execution failed: %w", err)
 	}
@@ -185,7 +185,8 @@ func (p *PDFImagesLoader) loadDir(ctx context.Context) ([]ImageDocument, error)
 			loader := NewPDFImagesLoader(path).
 				WithPDFImagesPath(p.pdfImagesPath).
 				WithFormat(p.format).
-				WithOutputDir(p.outputDir)
+				WithOutputDir(p.outputDir).
+				WithMaxRuntime(p.maxRuntime)
 
 			if p.firstPage > 0 || p.lastPage > 0 {
 				loader = loader.WithPageRange(p.firstPage, p.lastPage)
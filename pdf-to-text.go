@@ -4,11 +4,9 @@ import (
 	"context"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"runtime"
 	"strings"
-	"syscall"
+	"time"
 
 	"github.com/wailsapp/wails/v2/pkg/logger"
 )
@@ -36,6 +34,11 @@ func (p *PDFLoader) WithPDFToTextPath(pdfToTextPath string) *PDFLoader {
 	return p
 }
 
+func (p *PDFLoader) WithMaxRuntime(maxRuntime time.Duration) *PDFLoader {
+	p.maxRuntime = maxRuntime
+	return p
+}
+
 func (p *PDFLoader) WithTextSplitter(textSplitter TextSplitterLoader) *PDFLoader {
 	p.loader.textSplitter = textSplitter
 	return p
@@ -49,9 +52,9 @@ func (p *PDFLoader) Load(ctx context.Context, logger logger.Logger, appArgs Defa
 
 	var documents []Document
 	if fileInfo.IsDir() {
-		documents, err = p.loadDir(ctx)
+		documents, err = p.loadDir(ctx, logger)
 	} else {
-		documents, err = p.loadFile(ctx)
+		documents, err = p.loadFile(ctx, logger)
 	}
 	if err != nil {
 		return nil, err
@@ -69,19 +72,17 @@ func (p *PDFLoader) LoadFromSource(ctx context.Context, source string, logger lo
 	return p.Load(ctx, logger, appArgs, enableStopWordRemoval)
 }
 
-func (p *PDFLoader) loadFile(ctx context.Context) ([]Document, error) {
+func (p *PDFLoader) loadFile(ctx context.Context, logger logger.Logger) ([]Document, error) {
 	//nolint:gosec
-	cmd := exec.CommandContext(ctx, p.pdfToTextPath, "-enc", "UTF-8", p.path, "-")
-	// Hide the window on Windows
-	if runtime.GOOS == "windows" {
-		cmd.SysProcAttr = &syscall.SysProcAttr{
-			HideWindow: true,
-		}
-	}
-	out, err := cmd.Output()
+	out, err := RunProcess(ctx, ProcessSpec{
+		Name:       "pdftotext",
+		Path:       p.pdfToTextPath,
+		Args:       []string{"-enc", "UTF-8", p.path, "-"},
+		MaxRuntime: p.maxRuntime,
+	})
 	if err != nil {
-		fmt.Println(p.path)
-		return nil, nil
+		logger.Error("Failed to extract text from " + p.path + ": " + err.Error())
+		return nil, fmt.Errorf("failed to extract text from %s: %w", p.path, err)
 	}
 	metadata := make(Meta)
 	metadata[SourceMetadataKey] = p.path
@@ -93,12 +94,12 @@ func (p *PDFLoader) loadFile(ctx context.Context) ([]Document, error) {
 	}, nil
 }
 
-func (p *PDFLoader) loadDir(ctx context.Context) ([]Document, error) {
+func (p *PDFLoader) loadDir(ctx context.Context, logger logger.Logger) ([]Document, error) {
 	docs := []Document{}
 
 	err := filepath.Walk(p.path, func(path string, info os.FileInfo, err error) error {
 		if err == nil && strings.HasSuffix(info.Name(), ".pdf") {
-			d, errLoad := NewPDFToTextLoader(path).WithPDFToTextPath(p.pdfToTextPath).loadFile(ctx)
+			d, errLoad := NewPDFToTextLoader(path).WithPDFToTextPath(p.pdfToTextPath).WithMaxRuntime(p.maxRuntime).loadFile(ctx, logger)
 			if errLoad != nil {
 				return errLoad
 			}
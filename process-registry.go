@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wailsapp/wails/v2/pkg/logger"
+)
+
+// processFilePrefix names the files that list each app instance's running child processes
+const processFilePrefix = "byte-vision-processes-"
+
+// trackedProcess is a child process recorded in the process file
+type trackedProcess struct {
+	PID       int       `json:"pid"`
+	Path      string    `json:"path"`
+	StartedAt time.Time `json:"startedAt"`
+}
+
+// ProcessRegistry keeps a file of the child processes this instance is running, so that processes
+// left behind by a crash can be stopped the next time the app starts
+type ProcessRegistry struct {
+	mu        sync.Mutex
+	directory string
+	running   map[int]trackedProcess
+}
+
+// childProcesses records every process started through RunProcess and the llama-server manager
+var childProcesses = &ProcessRegistry{running: make(map[int]trackedProcess)}
+
+// Configure sets the directory the process file is written to; processes are not recorded until it is set
+func (r *ProcessRegistry) Configure(directory string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.directory = directory
+}
+
+// Add records a started process
+func (r *ProcessRegistry) Add(pid int, path string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.running[pid] = trackedProcess{PID: pid, Path: path, StartedAt: time.Now()}
+	r.saveLocked()
+}
+
+// Remove forgets a process that has exited
+func (r *ProcessRegistry) Remove(pid int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.running, pid)
+	r.saveLocked()
+}
+
+// saveLocked rewrites this instance's process file, or removes it when nothing is running
+func (r *ProcessRegistry) saveLocked() {
+	if r.directory == "" {
+		return
+	}
+	path := processFilePath(r.directory, os.Getpid())
+	if len(r.running) == 0 {
+		_ = os.Remove(path)
+		return
+	}
+
+	processes := make([]trackedProcess, 0, len(r.running))
+	for _, process := range r.running {
+		processes = append(processes, process)
+	}
+	data, err := json.Marshal(processes)
+	if err != nil {
+		return
+	}
+	temporaryPath := path + ".tmp"
+	if os.WriteFile(temporaryPath, data, 0644) == nil {
+		_ = os.Rename(temporaryPath, path)
+	}
+}
+
+// CleanupOrphans stops the processes recorded by instances that are no longer running and removes
+// their process files. A recorded process is only stopped while it still runs the recorded executable.
+func (r *ProcessRegistry) CleanupOrphans(log logger.Logger) error {
+	r.mu.Lock()
+	directory := r.directory
+	r.mu.Unlock()
+	if directory == "" {
+		return nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(directory, processFilePrefix+"*.json"))
+	if err != nil {
+		return fmt.Errorf("failed to list process files: %w", err)
+	}
+	for _, file := range files {
+		ownerPID, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(filepath.Base(file), processFilePrefix), ".json"))
+		if err != nil || ownerPID == os.Getpid() || processAlive(ownerPID) {
+			continue
+		}
+
+		data, err := os.ReadFile(file)
+		if err != nil {
+			log.Error("Failed to read process file: " + err.Error())
+			continue
+		}
+		var processes []trackedProcess
+		if err := json.Unmarshal(data, &processes); err != nil {
+			log.Error("Failed to parse process file " + file + ": " + err.Error())
+		}
+		for _, process := range processes {
+			if !processAlive(process.PID) || !processMatches(process.PID, process.Path) {
+				continue
+			}
+			log.Info(fmt.Sprintf("Stopping orphaned process %d (%s) started %s", process.PID, filepath.Base(process.Path), process.StartedAt.Format(time.RFC3339)))
+			if err := killProcessTree(process.PID); err != nil {
+				log.Error(fmt.Sprintf("Failed to stop orphaned process %d: %s", process.PID, err.Error()))
+			}
+		}
+		if err := os.Remove(file); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Error("Failed to remove process file: " + err.Error())
+		}
+	}
+	return nil
+}
+
+func processFilePath(directory string, ownerPID int) string {
+	return filepath.Join(directory, fmt.Sprintf("%s%d.json", processFilePrefix, ownerPID))
+}
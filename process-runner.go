@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// processGracePeriod is how long a process may take to exit after it was asked to stop
+	processGracePeriod = 5 * time.Second
+	// processStderrTailSize is how much of the end of stderr is kept for diagnostics
+	processStderrTailSize = 8 * 1024
+)
+
+// Failure classes of a child process, matched with errors.Is on a *ProcessError
+var (
+	ErrModelNotFound   = errors.New("model not found")
+	ErrOutOfMemory     = errors.New("out of memory")
+	ErrContextOverflow = errors.New("context size exceeded")
+	ErrBadFlag         = errors.New("invalid command-line flag")
+	ErrProcessTimeout  = errors.New("maximum runtime exceeded")
+)
+
+// processFailurePatterns recognise the failure classes in the stderr of llama.cpp and the document tools
+var processFailurePatterns = []struct {
+	cause   error
+	pattern *regexp.Regexp
+}{
+	{ErrOutOfMemory, regexp.MustCompile(`(?i)out of memory|failed to allocate|unable to allocate|bad_alloc|cudaMalloc failed|ErrorOutOfDeviceMemory|insufficient memory`)},
+	{ErrModelNotFound, regexp.MustCompile(`(?i)failed to load model|unable to load model|error loading model|failed to open gguf file|model file not found`)},
+	{ErrContextOverflow, regexp.MustCompile(`(?i)prompt is too long|exceeds? the (?:available )?context size|context size exceeded|input is too large to process|exceeds batch size|failed to find (?:a memory|kv cache) slot`)},
+	{ErrBadFlag, regexp.MustCompile(`(?i)invalid argument|unknown argument|unrecognized option|unknown option|error while handling argument|invalid value for`)},
+}
+
+// ProcessSpec describes one run of an external tool
+type ProcessSpec struct {
+	Name       string        // tool name used in errors and logs, e.g. "llama-cli"
+	Path       string        // executable to run
+	Args       []string      // command-line arguments
+	Stdin      io.Reader     // optional standard input
+	Stdout     io.Writer     // receives stdout; when nil, stdout is returned by RunProcess
+	Stderr     io.Writer     // optionally receives stderr; its tail is kept for diagnostics either way
+	MaxRuntime time.Duration // wall-clock limit; zero means no limit
+}
+
+// ProcessError is returned when a child process fails. Cause is one of the failure classes above, or
+// nil when stderr did not identify the failure.
+type ProcessError struct {
+	Name       string `json:"name"`
+	ExitCode   int    `json:"exitCode"`
+	Cause      error  `json:"-"`
+	StderrTail string `json:"stderrTail,omitempty"`
+	Err        error  `json:"-"`
+}
+
+func (e *ProcessError) Error() string {
+	message := e.Name + " failed"
+	if e.Cause != nil {
+		message += ": " + e.Cause.Error()
+	}
+	if e.Err != nil {
+		message += " (" + e.Err.Error() + ")"
+	}
+	if detail := e.detail(); detail != "" {
+		message += ": " + detail
+	}
+	return message
+}
+
+func (e *ProcessError) Unwrap() []error {
+	var errs []error
+	if e.Cause != nil {
+		errs = append(errs, e.Cause)
+	}
+	if e.Err != nil {
+		errs = append(errs, e.Err)
+	}
+	return errs
+}
+
+// detail returns the stderr line that identified the failure, or else the last line of stderr
+func (e *ProcessError) detail() string {
+	lines := strings.Split(strings.TrimSpace(e.StderrTail), "\n")
+	if e.Cause != nil {
+		for _, failure := range processFailurePatterns {
+			if failure.cause != e.Cause {
+				continue
+			}
+			for i := len(lines) - 1; i >= 0; i-- {
+				if failure.pattern.MatchString(lines[i]) {
+					return strings.TrimSpace(lines[i])
+				}
+			}
+		}
+	}
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+// classifyProcessFailure returns the failure class named in stderr, or nil
+func classifyProcessFailure(stderr string) error {
+	for _, failure := range processFailurePatterns {
+		if failure.pattern.MatchString(stderr) {
+			return failure.cause
+		}
+	}
+	return nil
+}
+
+// RunProcess runs a tool in its own process group and waits for it to exit. When ctx is canceled or
+// MaxRuntime passes, the whole group is asked to stop and is killed if it is still running after
+// processGracePeriod. Cancellation returns ctx.Err(); any other failure returns a *ProcessError.
+func RunProcess(ctx context.Context, spec ProcessSpec) ([]byte, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	//nolint:gosec
+	cmd := exec.Command(spec.Path, spec.Args...)
+	setProcessAttributes(cmd)
+	cmd.Stdin = spec.Stdin
+
+	var stdout bytes.Buffer
+	cmd.Stdout = spec.Stdout
+	if spec.Stdout == nil {
+		cmd.Stdout = &stdout
+	}
+	stderrTail := &tailBuffer{limit: processStderrTailSize}
+	cmd.Stderr = stderrTail
+	if spec.Stderr != nil {
+		cmd.Stderr = io.MultiWriter(stderrTail, spec.Stderr)
+	}
+	// Grandchildren that inherited stdout or stderr must not keep Wait blocked once the group is gone
+	cmd.WaitDelay = 2 * processGracePeriod
+
+	if err := cmd.Start(); err != nil {
+		return nil, &ProcessError{Name: spec.Name, ExitCode: -1, Err: err}
+	}
+	pid := cmd.Process.Pid
+	childProcesses.Add(pid, spec.Path)
+	defer childProcesses.Remove(pid)
+
+	exited := make(chan struct{})
+	stopReason := make(chan error, 1)
+	go func() {
+		var timeout <-chan time.Time
+		if spec.MaxRuntime > 0 {
+			timer := time.NewTimer(spec.MaxRuntime)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+
+		var reason error
+		select {
+		case <-exited:
+			stopReason <- nil
+			return
+		case <-ctx.Done():
+			reason = ctx.Err()
+		case <-timeout:
+			reason = ErrProcessTimeout
+		}
+		stopReason <- reason
+
+		_ = terminateProcessTree(pid)
+		select {
+		case <-exited:
+		case <-time.After(processGracePeriod):
+			_ = killProcessTree(pid)
+		}
+	}()
+
+	waitErr := cmd.Wait()
+	close(exited)
+	reapProcessGroup(pid)
+	reason := <-stopReason
+
+	switch {
+	case reason == ErrProcessTimeout:
+		return nil, &ProcessError{Name: spec.Name, ExitCode: exitCode(waitErr), Cause: ErrProcessTimeout, StderrTail: stderrTail.String(),
+			Err: fmt.Errorf("stopped after %s", spec.MaxRuntime)}
+	case reason != nil:
+		return nil, reason
+	case waitErr != nil:
+		tail := stderrTail.String()
+		return nil, &ProcessError{Name: spec.Name, ExitCode: exitCode(waitErr), Cause: classifyProcessFailure(tail), StderrTail: tail, Err: waitErr}
+	}
+	return stdout.Bytes(), nil
+}
+
+// exitCode returns the exit status in err, or -1 when the process did not exit normally
+func exitCode(err error) int {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+// maxProcessRuntime returns the configured wall-clock limit for a tool run
+func maxProcessRuntime(appArgs DefaultAppArgs) time.Duration {
+	return time.Duration(max(appArgs.ProcessMaxRuntimeMinutes, 0)) * time.Minute
+}
+
+// tailBuffer is an io.Writer that keeps the last limit bytes written to it
+type tailBuffer struct {
+	mu    sync.Mutex
+	limit int
+	data  []byte
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.data = append(t.data, p...)
+	if len(t.data) > t.limit {
+		t.data = append(t.data[:0], t.data[len(t.data)-t.limit:]...)
+	}
+	return len(p), nil
+}
+
+func (t *tailBuffer) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return string(t.data)
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunProcessOutput(t *testing.T) {
+	path := fakeProcess(t)
+	out, err := RunProcess(context.Background(), ProcessSpec{
+		Name:  "fake",
+		Path:  path,
+		Args:  []string{"stdin", "stderr", "loading"},
+		Stdin: strings.NewReader("[1, 2, 3]"),
+	})
+	if err != nil || string(out) != "[1, 2, 3]" {
+		t.Fatalf("got %q, %v", out, err)
+	}
+
+	var stdout, stderr strings.Builder
+	out, err = RunProcess(context.Background(), ProcessSpec{
+		Name:   "fake",
+		Path:   path,
+		Args:   []string{"stdin", "stderr", "loading"},
+		Stdin:  strings.NewReader("streamed"),
+		Stdout: &stdout,
+		Stderr: &stderr,
+	})
+	if err != nil || len(out) != 0 {
+		t.Fatalf("got %q, %v, want the output written to Stdout", out, err)
+	}
+	if stdout.String() != "streamed" || strings.TrimSpace(stderr.String()) != "loading" {
+		t.Fatalf("got stdout %q and stderr %q", stdout.String(), stderr.String())
+	}
+}
+
+func TestRunProcessTimeout(t *testing.T) {
+	path := fakeProcess(t)
+	started := time.Now()
+	_, err := RunProcess(context.Background(), ProcessSpec{
+		Name:       "fake",
+		Path:       path,
+		Args:       []string{"stderr", "still working", "sleep", "1m"},
+		MaxRuntime: 200 * time.Millisecond,
+	})
+	if !errors.Is(err, ErrProcessTimeout) {
+		t.Fatalf("got %v, want ErrProcessTimeout", err)
+	}
+	var processErr *ProcessError
+	if !errors.As(err, &processErr) || processErr.StderrTail != "still working\n" {
+		t.Fatalf("got %#v, want a *ProcessError with the stderr tail", err)
+	}
+	if elapsed := time.Since(started); elapsed > processGracePeriod {
+		t.Fatalf("took %s to stop the process", elapsed)
+	}
+}
+
+func TestRunProcessCanceled(t *testing.T) {
+	path := fakeProcess(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(200*time.Millisecond, cancel)
+	_, err := RunProcess(ctx, ProcessSpec{Name: "fake", Path: path, Args: []string{"sleep", "1m"}, MaxRuntime: time.Minute})
+	if err != context.Canceled {
+		t.Fatalf("got %v, want context.Canceled itself", err)
+	}
+
+	// A canceled context does not start the process at all
+	marker := filepath.Join(t.TempDir(), "ticks")
+	if _, err := RunProcess(ctx, ProcessSpec{Name: "fake", Path: path, Args: []string{"tick", marker}}); err != context.Canceled {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Fatal("the process was started with a canceled context")
+	}
+}
+
+func TestRunProcessFailure(t *testing.T) {
+	path := fakeProcess(t)
+	tests := []struct {
+		name       string
+		stderr     []string
+		exitCode   string
+		wantCause  error
+		wantDetail string
+	}{
+		{
+			name: "out of memory",
+			stderr: []string{
+				"ggml_backend_cuda_buffer_type_alloc_buffer: allocating 8192.00 MiB on device 0: cudaMalloc failed: out of memory",
+				"llama_init_from_model: failed to initialize the context",
+				"main: error: unable to create context",
+			},
+			exitCode:   "1",
+			wantCause:  ErrOutOfMemory,
+			wantDetail: "ggml_backend_cuda_buffer_type_alloc_buffer: allocating 8192.00 MiB on device 0: cudaMalloc failed: out of memory",
+		},
+		{
+			name: "model not found",
+			stderr: []string{
+				"gguf_init_from_file: failed to open GGUF file 'missing.gguf'",
+				"llama_model_load: error loading model: llama_model_loader: failed to load model from missing.gguf",
+				"main: exiting due to model loading error",
+			},
+			exitCode:   "1",
+			wantCause:  ErrModelNotFound,
+			wantDetail: "llama_model_load: error loading model: llama_model_loader: failed to load model from missing.gguf",
+		},
+		{
+			name:       "bad flag",
+			stderr:     []string{"error: invalid argument: --bogus", "usage: llama-cli [options]"},
+			exitCode:   "2",
+			wantCause:  ErrBadFlag,
+			wantDetail: "error: invalid argument: --bogus",
+		},
+		{
+			name:       "unclassified",
+			stderr:     []string{"llama_model_loader: loaded meta data", "Segmentation fault"},
+			exitCode:   "139",
+			wantDetail: "Segmentation fault",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var args []string
+			for _, line := range test.stderr {
+				args = append(args, "stderr", line)
+			}
+			args = append(args, "exit", test.exitCode)
+			_, err := RunProcess(context.Background(), ProcessSpec{Name: "llama-cli", Path: path, Args: args})
+
+			var processErr *ProcessError
+			if !errors.As(err, &processErr) {
+				t.Fatalf("got %v, want a *ProcessError", err)
+			}
+			if processErr.Cause != test.wantCause || (test.wantCause != nil && !errors.Is(err, test.wantCause)) {
+				t.Fatalf("got cause %v, want %v", processErr.Cause, test.wantCause)
+			}
+			if processErr.ExitCode == 0 || processErr.ExitCode == -1 {
+				t.Fatalf("got exit code %d", processErr.ExitCode)
+			}
+			if detail := processErr.detail(); detail != test.wantDetail {
+				t.Fatalf("got detail %q, want %q", detail, test.wantDetail)
+			}
+			if !strings.HasSuffix(err.Error(), ": "+test.wantDetail) {
+				t.Fatalf("the error %q does not end with the detail", err.Error())
+			}
+		})
+	}
+}
+
+func TestRunProcessMissingExecutable(t *testing.T) {
+	_, err := RunProcess(context.Background(), ProcessSpec{Name: "llama-cli", Path: filepath.Join(t.TempDir(), "llama-cli")})
+	var processErr *ProcessError
+	if !errors.As(err, &processErr) || processErr.ExitCode != -1 || processErr.Cause != nil {
+		t.Fatalf("got %#v, want a *ProcessError without a cause", err)
+	}
+}
+
+func TestRunProcessKillsProcessGroup(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("taskkill cannot always close console processes before the grace period")
+	}
+	path := fakeProcess(t)
+	ticks := filepath.Join(t.TempDir(), "ticks")
+	_, err := RunProcess(context.Background(), ProcessSpec{
+		Name:       "fake",
+		Path:       path,
+		Args:       []string{"spawn", ticks, "sleep", "1m"},
+		MaxRuntime: 500 * time.Millisecond,
+	})
+	if !errors.Is(err, ErrProcessTimeout) {
+		t.Fatalf("got %v, want ErrProcessTimeout", err)
+	}
+
+	size := func() int64 {
+		info, err := os.Stat(ticks)
+		if err != nil {
+			t.Fatalf("the child process never ran: %v", err)
+		}
+		return info.Size()
+	}
+	// Allow a tick that was in progress to land, then the file must stop growing
+	time.Sleep(100 * time.Millisecond)
+	stopped := size()
+	time.Sleep(200 * time.Millisecond)
+	if grown := size(); grown != stopped {
+		t.Fatalf("the child process is still running after its group was stopped (%d ticks, then %d)", stopped, grown)
+	}
+}
+
+func TestClassifyProcessFailure(t *testing.T) {
+	tests := []struct {
+		stderr string
+		want   error
+	}{
+		{stderr: "ggml_vulkan: Device memory allocation of size 4294967296 failed.\nvk::Device::allocateMemory: ErrorOutOfDeviceMemory", want: ErrOutOfMemory},
+		{stderr: "std::bad_alloc", want: ErrOutOfMemory},
+		{stderr: "llama_model_load: error loading model: tensor 'token_embd.weight' data is not within the file bounds", want: ErrModelNotFound},
+		{stderr: "main: error: prompt is too long (5000 tokens, max 4092)", want: ErrContextOverflow},
+		{stderr: "decode: failed to find a memory slot for batch of size 512", want: ErrContextOverflow},
+		{stderr: "error while handling argument \"--ctx-size\": stoi", want: ErrBadFlag},
+		{stderr: "error: unknown argument: --bogus", want: ErrBadFlag},
+		{stderr: "failed to allocate buffer\nfailed to load model", want: ErrOutOfMemory},
+		{stderr: "Segmentation fault (core dumped)", want: nil},
+		{stderr: "", want: nil},
+	}
+	for _, test := range tests {
+		if got := classifyProcessFailure(test.stderr); got != test.want {
+			t.Errorf("classifyProcessFailure(%q) = %v, want %v", test.stderr, got, test.want)
+		}
+	}
+}
+
+func TestProcessErrorDetail(t *testing.T) {
+	tests := []struct {
+		name string
+		err  ProcessError
+		want string
+	}{
+		{
+			name: "line that names the cause",
+			err:  ProcessError{Cause: ErrBadFlag, StderrTail: "build: 5535\nerror: invalid argument: --bogus\n\nusage:\n  llama-cli [options]\n"},
+			want: "error: invalid argument: --bogus",
+		},
+		{
+			name: "last matching line",
+			err:  ProcessError{Cause: ErrOutOfMemory, StderrTail: "failed to allocate 1 GiB\nretrying\nfailed to allocate 512 MiB\nexiting"},
+			want: "failed to allocate 512 MiB",
+		},
+		{
+			name: "last line without a cause",
+			err:  ProcessError{StderrTail: "loading\n  Segmentation fault  \n"},
+			want: "Segmentation fault",
+		},
+		{
+			name: "timeout without stderr",
+			err:  ProcessError{Cause: ErrProcessTimeout},
+			want: "",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.err.detail(); got != test.want {
+				t.Fatalf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+
+	err := &ProcessError{Name: "llama-cli", Cause: ErrBadFlag, StderrTail: "error: invalid argument: --bogus", Err: errors.New("exit status 1")}
+	if want := "llama-cli failed: invalid command-line flag (exit status 1): error: invalid argument: --bogus"; err.Error() != want {
+		t.Fatalf("got %q, want %q", err.Error(), want)
+	}
+}
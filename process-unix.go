@@ -0,0 +1,57 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// setProcessAttributes starts the process in a new process group so it can be stopped together with
+// any processes it starts
+func setProcessAttributes(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// terminateProcessTree asks every process in the group to exit
+func terminateProcessTree(pid int) error {
+	return ignoreMissingProcess(syscall.Kill(-pid, syscall.SIGTERM))
+}
+
+// killProcessTree kills every process in the group
+func killProcessTree(pid int) error {
+	return ignoreMissingProcess(syscall.Kill(-pid, syscall.SIGKILL))
+}
+
+// reapProcessGroup kills processes the group leader left running when it exited
+func reapProcessGroup(pid int) {
+	_ = killProcessTree(pid)
+}
+
+// processAlive reports whether a process with the given ID exists
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// processMatches reports whether the running process pid is the executable at path, so a recorded
+// process ID that has since been reused is left alone. ps may truncate the command name.
+func processMatches(pid int, path string) bool {
+	out, err := exec.Command("ps", "-p", strconv.Itoa(pid), "-o", "comm=").Output()
+	if err != nil {
+		return false
+	}
+	name := filepath.Base(strings.TrimSpace(string(out)))
+	return name != "" && strings.HasPrefix(filepath.Base(path), name)
+}
+
+func ignoreMissingProcess(err error) error {
+	if errors.Is(err, syscall.ESRCH) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,74 @@
+//go:build windows
+
+package main
+
+import (
+	"encoding/csv"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// stillActive is the exit code GetExitCodeProcess reports for a running process
+const stillActive = 259
+
+// setProcessAttributes hides the console window and starts the process in a new process group
+func setProcessAttributes(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		HideWindow:    true,
+		CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP,
+	}
+}
+
+// terminateProcessTree asks the process and its children to close
+func terminateProcessTree(pid int) error {
+	return runTaskkill("/PID", strconv.Itoa(pid), "/T")
+}
+
+// killProcessTree forcibly ends the process and its children
+func killProcessTree(pid int) error {
+	return runTaskkill("/PID", strconv.Itoa(pid), "/T", "/F")
+}
+
+// reapProcessGroup does nothing on Windows: once the parent has exited its children can no longer be
+// found through it
+func reapProcessGroup(int) {}
+
+// processAlive reports whether a process with the given ID is running
+func processAlive(pid int) bool {
+	handle, err := syscall.OpenProcess(syscall.PROCESS_QUERY_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return false
+	}
+	defer func() { _ = syscall.CloseHandle(handle) }()
+
+	var exitCode uint32
+	if err := syscall.GetExitCodeProcess(handle, &exitCode); err != nil {
+		return false
+	}
+	return exitCode == stillActive
+}
+
+// processMatches reports whether the running process pid is the executable at path, so a recorded
+// process ID that has since been reused is left alone
+func processMatches(pid int, path string) bool {
+	cmd := exec.Command("tasklist", "/FI", "PID eq "+strconv.Itoa(pid), "/FO", "CSV", "/NH")
+	cmd.SysProcAttr = &syscall.SysProcAttr{HideWindow: true}
+	out, err := cmd.Output()
+	if err != nil {
+		return false
+	}
+	record, err := csv.NewReader(strings.NewReader(string(out))).Read()
+	if err != nil || len(record) == 0 {
+		return false
+	}
+	return strings.EqualFold(record[0], filepath.Base(path))
+}
+
+func runTaskkill(args ...string) error {
+	cmd := exec.Command("taskkill", args...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{HideWindow: true}
+	return cmd.Run()
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wailsapp/wails/v2/pkg/logger"
+)
+
+const (
+	promptCacheExtension      = ".promptcache"
+	minPromptCachePrefixChars = 64 // shorter static prefixes are cheaper to evaluate than to load
+)
+
+var promptCacheNameSanitizer = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// PromptCacheEntry describes one llama-cli prompt cache file
+type PromptCacheEntry struct {
+	File      string    `json:"file"`
+	Model     string    `json:"model"`
+	SizeBytes int64     `json:"sizeBytes"`
+	LastUsed  time.Time `json:"lastUsed"`
+	InUse     bool      `json:"inUse"`
+}
+
+// PromptCacheUsage reports the files under PromptCachePath and the configured size limit
+type PromptCacheUsage struct {
+	Path       string             `json:"path"`
+	Enabled    bool               `json:"enabled"`
+	TotalBytes int64              `json:"totalBytes"`
+	MaxBytes   int64              `json:"maxBytes"`
+	Entries    []PromptCacheEntry `json:"entries"`
+}
+
+// PromptCacheManager gives every model and static prompt prefix its own llama-cli prompt cache file,
+// so repeated questions only evaluate the part of the prompt that changed. Files are named
+// <model>.<model path hash>.<model identity hash>.<prefix hash>.promptcache; a file whose model
+// identity no longer matches the model on disk is removed, and the least recently used files are
+// evicted once the directory grows past PromptCacheMaxSizeMB.
+type PromptCacheManager struct {
+	appArgs *DefaultAppArgs
+	log     logger.Logger
+
+	mu    sync.Mutex
+	inUse map[string]bool
+}
+
+func NewPromptCacheManager(appArgs *DefaultAppArgs, log logger.Logger) *PromptCacheManager {
+	return &PromptCacheManager{appArgs: appArgs, log: log, inUse: make(map[string]bool)}
+}
+
+// Enabled reports whether a cache directory is configured
+func (m *PromptCacheManager) Enabled() bool {
+	return m != nil && m.appArgs != nil && m.appArgs.PromptCachePath != ""
+}
+
+// maxBytes returns the configured size limit of the cache directory
+func (m *PromptCacheManager) maxBytes() int64 {
+	return int64(m.appArgs.PromptCacheMaxSizeMB) * 1024 * 1024
+}
+
+// Apply points cliArgs at the cache file for its model and the static prefix of prompt. The returned
+// func must be called once llama-cli has exited; it releases the file and enforces the size limit.
+// When no file can be used the profile's own prompt cache setting is cleared so two runs never share
+// a file.
+func (m *PromptCacheManager) Apply(cliArgs LlamaCliArgs, prompt string, messages []ChatMessage) (LlamaCliArgs, func()) {
+	if !m.Enabled() {
+		return cliArgs, func() {}
+	}
+	cliArgs.PromptCacheVal = ""
+
+	prefix := staticPromptPrefix(prompt, messages)
+	if len(prefix) < minPromptCachePrefixChars || cliArgs.ModelFullPathVal == "" {
+		return cliArgs, func() {}
+	}
+
+	pathHash, identityHash, err := modelIdentity(cliArgs.ModelFullPathVal)
+	if err != nil {
+		m.log.Error("Failed to identify model for prompt cache: " + err.Error())
+		return cliArgs, func() {}
+	}
+	if err := os.MkdirAll(m.appArgs.PromptCachePath, 0755); err != nil {
+		m.log.Error("Failed to create prompt cache directory: " + err.Error())
+		return cliArgs, func() {}
+	}
+	m.removeStaleModelFiles(pathHash, identityHash)
+
+	prefixSum := sha256.Sum256([]byte(prefix))
+	modelName := promptCacheNameSanitizer.ReplaceAllString(strings.TrimSuffix(filepath.Base(cliArgs.ModelFullPathVal), filepath.Ext(cliArgs.ModelFullPathVal)), "_")
+	fileName := strings.Join([]string{modelName, pathHash, identityHash, hex.EncodeToString(prefixSum[:8])}, ".") + promptCacheExtension
+	cacheFile := filepath.Join(m.appArgs.PromptCachePath, fileName)
+
+	m.mu.Lock()
+	if m.inUse[cacheFile] {
+		m.mu.Unlock()
+		return cliArgs, func() {}
+	}
+	m.inUse[cacheFile] = true
+	m.mu.Unlock()
+
+	// Touch the file so the LRU order reflects reads as well as writes
+	now := time.Now()
+	if err := os.Chtimes(cacheFile, now, now); err == nil {
+		m.log.Info("Reusing prompt cache " + fileName)
+	}
+
+	if cliArgs.PromptCacheCmd == "" {
+		cliArgs.PromptCacheCmd = "--prompt-cache"
+	}
+	cliArgs.PromptCacheVal = cacheFile
+
+	var once sync.Once
+	return cliArgs, func() {
+		once.Do(func() {
+			m.mu.Lock()
+			delete(m.inUse, cacheFile)
+			m.mu.Unlock()
+			m.evict()
+		})
+	}
+}
+
+// Usage lists the cache files, most recently used first
+func (m *PromptCacheManager) Usage() (PromptCacheUsage, error) {
+	usage := PromptCacheUsage{Enabled: m.Enabled(), Entries: []PromptCacheEntry{}}
+	if !usage.Enabled {
+		return usage, nil
+	}
+	usage.Path = m.appArgs.PromptCachePath
+	usage.MaxBytes = m.maxBytes()
+
+	entries, err := m.listEntries()
+	if err != nil {
+		return usage, err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].LastUsed.After(entries[j].LastUsed) })
+	for _, entry := range entries {
+		usage.TotalBytes += entry.SizeBytes
+	}
+	usage.Entries = entries
+	return usage, nil
+}
+
+// Clear removes every cache file that is not currently in use
+func (m *PromptCacheManager) Clear() error {
+	if !m.Enabled() {
+		return nil
+	}
+	entries, err := m.listEntries()
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.InUse {
+			m.removeFile(entry.File)
+		}
+	}
+	return nil
+}
+
+// evict deletes the least recently used files until the directory fits the size limit
+func (m *PromptCacheManager) evict() {
+	limit := m.maxBytes()
+	if limit <= 0 {
+		return
+	}
+	entries, err := m.listEntries()
+	if err != nil {
+		m.log.Error("Failed to list prompt cache: " + err.Error())
+		return
+	}
+
+	var total int64
+	for _, entry := range entries {
+		total += entry.SizeBytes
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].LastUsed.Before(entries[j].LastUsed) })
+	for _, entry := range entries {
+		if total <= limit {
+			return
+		}
+		if entry.InUse {
+			continue
+		}
+		if m.removeFile(entry.File) {
+			total -= entry.SizeBytes
+		}
+	}
+}
+
+// removeStaleModelFiles deletes cache files created for an earlier version of the same model file
+func (m *PromptCacheManager) removeStaleModelFiles(pathHash, identityHash string) {
+	entries, err := m.listEntries()
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		parts := strings.Split(strings.TrimSuffix(entry.File, promptCacheExtension), ".")
+		if len(parts) != 4 || entry.InUse {
+			continue
+		}
+		if parts[1] == pathHash && parts[2] != identityHash {
+			m.log.Info("Model changed, removing prompt cache " + entry.File)
+			m.removeFile(entry.File)
+		}
+	}
+}
+
+func (m *PromptCacheManager) listEntries() ([]PromptCacheEntry, error) {
+	dirEntries, err := os.ReadDir(m.appArgs.PromptCachePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read prompt cache directory: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var entries []PromptCacheEntry
+	for _, dirEntry := range dirEntries {
+		if dirEntry.IsDir() || !strings.HasSuffix(dirEntry.Name(), promptCacheExtension) {
+			continue
+		}
+		info, err := dirEntry.Info()
+		if err != nil {
+			continue
+		}
+		entries = append(entries, PromptCacheEntry{
+			File:      dirEntry.Name(),
+			Model:     strings.Split(dirEntry.Name(), ".")[0],
+			SizeBytes: info.Size(),
+			LastUsed:  info.ModTime(),
+			InUse:     m.inUse[filepath.Join(m.appArgs.PromptCachePath, dirEntry.Name())],
+		})
+	}
+	return entries, nil
+}
+
+// removeFile deletes a cache file unless a run has started using it since it was listed
+func (m *PromptCacheManager) removeFile(fileName string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.inUse[filepath.Join(m.appArgs.PromptCachePath, fileName)] {
+		return false
+	}
+	if err := os.Remove(filepath.Join(m.appArgs.PromptCachePath, fileName)); err != nil && !os.IsNotExist(err) {
+		m.log.Error("Failed to remove prompt cache file: " + err.Error())
+		return false
+	}
+	return true
+}
+
+// modelIdentity hashes the model path, and separately its path, size and modification time, so a
+// replaced model file gets a new identity while keeping the same path hash
+func modelIdentity(modelPath string) (string, string, error) {
+	info, err := os.Stat(modelPath)
+	if err != nil {
+		return "", "", err
+	}
+	pathSum := sha256.Sum256([]byte(filepath.Clean(modelPath)))
+	identitySum := sha256.Sum256([]byte(fmt.Sprintf("%s|%d|%d", filepath.Clean(modelPath), info.Size(), info.ModTime().UnixNano())))
+	return hex.EncodeToString(pathSum[:4]), hex.EncodeToString(identitySum[:4]), nil
+}
+
+// staticPromptPrefix returns the part of prompt before the newest user message: the template header,
+// the system instruction and, for chat sessions, the earlier conversation
+func staticPromptPrefix(prompt string, messages []ChatMessage) string {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role != "user" || messages[i].Content == "" {
+			continue
+		}
+		if index := strings.LastIndex(prompt, messages[i].Content); index >= 0 {
+			return prompt[:index]
+		}
+		break
+	}
+	return ""
+}
+
+// GetPromptCacheUsage reports the prompt cache files and how much space they use
+func (app *App) GetPromptCacheUsage() PromptCacheUsage {
+	usage, err := app.promptCache.Usage()
+	if err != nil {
+		app.log.Error("Failed to read prompt cache usage: " + err.Error())
+	}
+	return usage
+}
+
+// ClearPromptCache removes all prompt cache files that are not in use
+func (app *App) ClearPromptCache() string {
+	if err := app.promptCache.Clear(); err != nil {
+		app.log.Error("Failed to clear prompt cache: " + err.Error())
+		return err.Error()
+	}
+	return "Prompt cache cleared"
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Sources of untrusted prompt text
+const (
+	PromptSourceInput   = "input"   // text typed by the user
+	PromptSourceContext = "context" // chunks retrieved from indexed documents
+)
+
+// StrippedControlToken reports how often a control token was removed from untrusted text
+type StrippedControlToken struct {
+	Source string `json:"source"`
+	Token  string `json:"token"`
+	Count  int    `json:"count"`
+}
+
+// ControlTokens returns the control tokens of a prompt type and whether the generic control token
+// pattern applies too. Types without tokens of their own, such as Auto whose template comes from the
+// model, are guarded with the tokens of every built-in type.
+func (pr *PromptRegistry) ControlTokens(promptType string) ([]string, bool) {
+	config, exists := pr.GetConfig(resolvePromptType(promptType))
+	if exists && len(config.ControlTokens) > 0 {
+		return config.ControlTokens, false
+	}
+	return pr.allControlTokens, true
+}
+
+// StripControlTokens removes the control tokens of promptType from untrusted text, so documents and
+// user input cannot close a turn or open a new one. Every removal is logged and reported.
+func (tp *TemplateProcessor) StripControlTokens(promptType string, source string, text string) (string, []StrippedControlToken) {
+	tokens, generic := promptRegistry.ControlTokens(promptType)
+	counts := make(map[string]int)
+
+	// Removing a token can join the text around it into a new one, so repeat until nothing changes
+	for changed := true; changed; {
+		changed = false
+		for _, token := range tokens {
+			if count := strings.Count(text, token); count > 0 {
+				counts[token] += count
+				text = strings.ReplaceAll(text, token, "")
+				changed = true
+			}
+		}
+		if generic {
+			text = controlTokenPattern.ReplaceAllStringFunc(text, func(token string) string {
+				counts[token]++
+				changed = true
+				return ""
+			})
+		}
+	}
+
+	if len(counts) == 0 {
+		return text, nil
+	}
+	stripped := make([]StrippedControlToken, 0, len(counts))
+	for token, count := range counts {
+		stripped = append(stripped, StrippedControlToken{Source: source, Token: token, Count: count})
+		tp.logger.Warning(fmt.Sprintf("Stripped %d occurrence(s) of control token %s from %s", count, token, source))
+	}
+	return text, mergeStrippedTokens(stripped)
+}
+
+// StripControlTokensFromChunks strips the control tokens of promptType from retrieved chunks
+func (tp *TemplateProcessor) StripControlTokensFromChunks(promptType string, chunks []RetrievedChunk) ([]RetrievedChunk, []StrippedControlToken) {
+	var strippedTokens []StrippedControlToken
+	sanitized := make([]RetrievedChunk, len(chunks))
+	for i, chunk := range chunks {
+		var stripped []StrippedControlToken
+		sanitized[i] = chunk
+		sanitized[i].Text, stripped = tp.StripControlTokens(promptType, PromptSourceContext, chunk.Text)
+		strippedTokens = mergeStrippedTokens(strippedTokens, stripped)
+	}
+	return sanitized, strippedTokens
+}
+
+// mergeStrippedTokens combines reports of the same token and source, sorted by source and token
+func mergeStrippedTokens(reports ...[]StrippedControlToken) []StrippedControlToken {
+	counts := make(map[StrippedControlToken]int)
+	for _, report := range reports {
+		for _, entry := range report {
+			counts[StrippedControlToken{Source: entry.Source, Token: entry.Token}] += entry.Count
+		}
+	}
+	if len(counts) == 0 {
+		return nil
+	}
+
+	merged := make([]StrippedControlToken, 0, len(counts))
+	for key, count := range counts {
+		key.Count = count
+		merged = append(merged, key)
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		if merged[i].Source != merged[j].Source {
+			return merged[i].Source < merged[j].Source
+		}
+		return merged[i].Token < merged[j].Token
+	})
+	return merged
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// loadPromptTemplates seeds the built-in prompt templates on first run and loads the stored templates
+// into the prompt registry
+func (app *App) loadPromptTemplates() error {
+	if err := SeedPromptTemplates(app.appArgs, promptRegistry.BuiltInTemplates()); err != nil {
+		return err
+	}
+	return app.refreshPromptTemplates()
+}
+
+// refreshPromptTemplates reloads the stored templates into the prompt registry
+func (app *App) refreshPromptTemplates() error {
+	templates, err := GetPromptTemplates(app.appArgs)
+	if err != nil {
+		return err
+	}
+	promptRegistry.SetStoredTemplates(templates)
+	app.log.Info(fmt.Sprintf("Loaded %d prompt templates", len(templates)))
+	return nil
+}
+
+// afterPromptTemplateChange refreshes the registry so a change applies to the next request
+func (app *App) afterPromptTemplateChange() {
+	if err := app.refreshPromptTemplates(); err != nil {
+		app.log.Error("Failed to refresh prompt templates: " + err.Error())
+	}
+}
+
+// ListPromptTemplates returns all prompt templates without their version history
+func (app *App) ListPromptTemplates() ([]PromptTemplate, error) {
+	templates, err := GetPromptTemplates(app.appArgs)
+	if err != nil {
+		app.log.Error("Failed to list prompt templates: " + err.Error())
+	}
+	return templates, err
+}
+
+// GetPromptTemplate returns a prompt template with its version history
+func (app *App) GetPromptTemplate(name string) (PromptTemplate, error) {
+	promptTemplate, err := GetPromptTemplate(app.appArgs, name)
+	if err != nil {
+		app.log.Error("Failed to get prompt template: " + err.Error())
+	}
+	return promptTemplate, err
+}
+
+// CreatePromptTemplate stores a new user-defined prompt template
+func (app *App) CreatePromptTemplate(promptTemplate PromptTemplate) (PromptTemplate, error) {
+	promptTemplate.Name = strings.TrimSpace(promptTemplate.Name)
+	promptTemplate.BuiltIn = false
+	if err := ValidatePromptTemplate(promptTemplate); err != nil {
+		return PromptTemplate{}, err
+	}
+	if err := CreatePromptTemplate(app.appArgs, promptTemplate); err != nil {
+		app.log.Error("Failed to create prompt template: " + err.Error())
+		return PromptTemplate{}, err
+	}
+	app.afterPromptTemplateChange()
+	return GetPromptTemplate(app.appArgs, promptTemplate.Name)
+}
+
+// UpdatePromptTemplate saves a new version of a prompt template. Version must be the version that
+// was edited, so concurrent edits are not lost.
+func (app *App) UpdatePromptTemplate(promptTemplate PromptTemplate) (PromptTemplate, error) {
+	if err := ValidatePromptTemplate(promptTemplate); err != nil {
+		return PromptTemplate{}, err
+	}
+	if err := UpdatePromptTemplate(app.appArgs, promptTemplate); err != nil {
+		app.log.Error("Failed to update prompt template: " + err.Error())
+		return PromptTemplate{}, err
+	}
+	app.afterPromptTemplateChange()
+	return GetPromptTemplate(app.appArgs, promptTemplate.Name)
+}
+
+// DeletePromptTemplate removes a user-defined prompt template; built-in templates cannot be deleted
+func (app *App) DeletePromptTemplate(name string) error {
+	promptTemplate, err := GetPromptTemplate(app.appArgs, name)
+	if err != nil {
+		app.log.Error("Failed to delete prompt template: " + err.Error())
+		return err
+	}
+	if promptTemplate.BuiltIn {
+		return fmt.Errorf("built-in prompt template %s cannot be deleted", name)
+	}
+	if err := DeletePromptTemplate(app.appArgs, name); err != nil {
+		app.log.Error("Failed to delete prompt template: " + err.Error())
+		return err
+	}
+	app.afterPromptTemplateChange()
+	return nil
+}
+
+// ClonePromptTemplate copies the current version of a prompt template under a new name
+func (app *App) ClonePromptTemplate(name string, newName string) (PromptTemplate, error) {
+	source, err := GetPromptTemplate(app.appArgs, name)
+	if err != nil {
+		app.log.Error("Failed to clone prompt template: " + err.Error())
+		return PromptTemplate{}, err
+	}
+	clone := source
+	clone.Name = newName
+	return app.CreatePromptTemplate(clone)
+}
+
+// RestorePromptTemplateVersion saves an earlier version of a prompt template as its newest version
+func (app *App) RestorePromptTemplateVersion(name string, version int) (PromptTemplate, error) {
+	current, err := GetPromptTemplate(app.appArgs, name)
+	if err != nil {
+		app.log.Error("Failed to restore prompt template: " + err.Error())
+		return PromptTemplate{}, err
+	}
+	for _, previous := range current.History {
+		if previous.Version != version {
+			continue
+		}
+		restored := current
+		restored.Description = previous.Description
+		restored.Template = previous.Template
+		restored.Data = previous.Data
+		restored.Instruction = previous.Instruction
+		return app.UpdatePromptTemplate(restored)
+	}
+	return PromptTemplate{}, fmt.Errorf("prompt template %s has no version %d", name, version)
+}
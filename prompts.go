@@ -3,7 +3,15 @@ package main
 import (
 	"bytes"
 	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
 	"text/template"
+	"time"
 
 	"github.com/wailsapp/wails/v2/pkg/logger"
 )
@@ -37,15 +45,42 @@ func (ip *UserPrompt) SetInput(input string) {
 	ip.Input = input
 }
 
+// ChatFormat describes how a prompt type renders a multi-turn conversation
+type ChatFormat struct {
+	Begin             string // emitted once before the first message
+	SystemPrefix      string
+	SystemSuffix      string
+	UserPrefix        string
+	UserSuffix        string
+	AssistantPrefix   string
+	AssistantSuffix   string
+	GenerationPrompt  string // opens the assistant turn the model should complete
+	SystemInFirstUser bool   // the format has no system role; prepend the system text to the first user turn
+}
+
 // PromptConfig holds template and data for a specific prompt type
 type PromptConfig struct {
 	Template string
 	Data     PromptData
+	Chat     ChatFormat
+	Output   OutputFormat // how replies mark up reasoning; see ParseModelOutput
+
+	Instruction   string   // system message sent to chat backends; empty for none
+	ControlTokens []string // special tokens that must not appear in untrusted text; see StripControlTokens
 }
 
-// PromptRegistry manages all prompt configurations
+// AutoPromptType renders prompts with the chat template embedded in the model file. It is the
+// default when no prompt type is given.
+const AutoPromptType = "Auto"
+
+// PromptRegistry manages all prompt configurations. The built-in configurations can be overridden and
+// extended by templates stored in MongoDB; see SetStoredTemplates.
 type PromptRegistry struct {
+	mu      sync.RWMutex
 	configs map[string]PromptConfig
+	stored  map[string]PromptConfig
+
+	allControlTokens []string // control tokens of every built-in type, longest first
 }
 
 // NewPromptRegistry creates a new registry with all prompt configurations
@@ -76,6 +111,10 @@ func (pr *PromptRegistry) registerPrompts() {
 	instTemplate := "{{.UserStart }}{{.Input }}{{.UserEnd }}"
 
 	pr.configs = map[string]PromptConfig{
+		// Auto has no template of its own; see renderModelChatTemplate
+		AutoPromptType: {
+			Output: OutputAuto,
+		},
 		"Mistral": {
 			Template: systemTemplate,
 			Data: &SystemPrompt{
@@ -83,6 +122,14 @@ func (pr *PromptRegistry) registerPrompts() {
 				UserPrompt:      "<s>[INST]You are a professional research analyst. Please format output as markdown text, don't include the markdown``` avoid excessive formatting that distracts from content.\nPlease follow these instructions:\n\r\n",
 				AssistantPrompt: "[/INST]\r\n",
 			},
+			Chat: ChatFormat{
+				Begin:             "<s>",
+				UserPrefix:        "[INST]",
+				UserSuffix:        "[/INST]",
+				AssistantSuffix:   "</s>",
+				SystemInFirstUser: true,
+			},
+			ControlTokens: []string{"<s>", "</s>", "[INST]", "[/INST]"},
 		},
 		"LLAMA3": {
 			Template: systemTemplate,
@@ -91,6 +138,17 @@ func (pr *PromptRegistry) registerPrompts() {
 				UserPrompt:      "<|start_header_id|>User<|end_header_id|>\r\n",
 				AssistantPrompt: "<|eot_id|><|start_header_id|>Assistant<|end_header_id|>\r\n",
 			},
+			Chat: ChatFormat{
+				Begin:            "<|begin_of_text|>",
+				SystemPrefix:     "<|start_header_id|>system<|end_header_id|>\n\n",
+				SystemSuffix:     "<|eot_id|>",
+				UserPrefix:       "<|start_header_id|>user<|end_header_id|>\n\n",
+				UserSuffix:       "<|eot_id|>",
+				AssistantPrefix:  "<|start_header_id|>assistant<|end_header_id|>\n\n",
+				AssistantSuffix:  "<|eot_id|>",
+				GenerationPrompt: "<|start_header_id|>assistant<|end_header_id|>\n\n",
+			},
+			ControlTokens: []string{"<|begin_of_text|>", "<|start_header_id|>", "<|end_header_id|>", "<|eot_id|>", "<|end_of_text|>"},
 		},
 		"SystemUserAssistant": {
 			Template: systemTemplate,
@@ -99,6 +157,15 @@ func (pr *PromptRegistry) registerPrompts() {
 				UserPrompt:      "User\n",
 				AssistantPrompt: "Assistant\n",
 			},
+			Chat: ChatFormat{
+				SystemPrefix:     "System ",
+				SystemSuffix:     "\n",
+				UserPrefix:       "User\n",
+				UserSuffix:       "\n",
+				AssistantPrefix:  "Assistant\n",
+				AssistantSuffix:  "\n",
+				GenerationPrompt: "Assistant\n",
+			},
 		},
 		"UserAssistantDeepSeek": {
 			Template: instTemplate,
@@ -106,6 +173,15 @@ func (pr *PromptRegistry) registerPrompts() {
 				UserStart: "<｜User｜>",
 				UserEnd:   "<｜Assistant｜>\n<think>\n</think>\n",
 			},
+			Chat: ChatFormat{
+				SystemSuffix:     "\n",
+				UserPrefix:       "<｜User｜>",
+				AssistantPrefix:  "<｜Assistant｜>",
+				AssistantSuffix:  "<｜end▁of▁sentence｜>",
+				GenerationPrompt: "<｜Assistant｜>\n<think>\n</think>\n",
+			},
+			ControlTokens: []string{"<｜begin▁of▁sentence｜>", "<｜end▁of▁sentence｜>", "<｜User｜>", "<｜Assistant｜>", "<think>", "</think>"},
+			Output:        OutputThinkTags,
 		},
 		"Qwen3": {
 			Template: systemTemplate,
@@ -114,6 +190,17 @@ func (pr *PromptRegistry) registerPrompts() {
 				UserPrompt:      "<|im_start|>\nuser You are a professional research analyst. Please format output as markdown text, don't include the markdown``` avoid excessive formatting that distracts from content.\nPlease follow these instructions:\n",
 				AssistantPrompt: "<|im_end|>\n<|im_start|>assistant\n<think>\n\n</think>\n\n",
 			},
+			Chat: ChatFormat{
+				SystemPrefix:     "<|im_start|>system\n",
+				SystemSuffix:     "<|im_end|>\n",
+				UserPrefix:       "<|im_start|>user\n",
+				UserSuffix:       "<|im_end|>\n",
+				AssistantPrefix:  "<|im_start|>assistant\n",
+				AssistantSuffix:  "<|im_end|>\n",
+				GenerationPrompt: "<|im_start|>assistant\n<think>\n\n</think>\n\n",
+			},
+			ControlTokens: []string{"<|im_start|>", "<|im_end|>", "<|endoftext|>", "<think>", "</think>"},
+			Output:        OutputThinkTags,
 		},
 		"Granite": {
 			Template: systemTemplate,
@@ -122,6 +209,16 @@ func (pr *PromptRegistry) registerPrompts() {
 				UserPrompt:      "<|start_of_role|>user<|end_of_role|> \n",
 				AssistantPrompt: "<|end_of_text|>\n<|start_of_role|>assistant<|end_of_role|>\n",
 			},
+			Chat: ChatFormat{
+				SystemPrefix:     "<|start_of_role|>system<|end_of_role|>",
+				SystemSuffix:     "<|end_of_text|>\n",
+				UserPrefix:       "<|start_of_role|>user<|end_of_role|>",
+				UserSuffix:       "<|end_of_text|>\n",
+				AssistantPrefix:  "<|start_of_role|>assistant<|end_of_role|>",
+				AssistantSuffix:  "<|end_of_text|>\n",
+				GenerationPrompt: "<|start_of_role|>assistant<|end_of_role|>",
+			},
+			ControlTokens: []string{"<|start_of_role|>", "<|end_of_role|>", "<|end_of_text|>"},
 		},
 		"Gemma": {
 			Template: instTemplate,
@@ -129,6 +226,15 @@ func (pr *PromptRegistry) registerPrompts() {
 				UserStart: "<start_of_turn>user You are a professional research analyst. Please format output as markdown text, don't include the markdown``` avoid excessive formatting that distracts from content.\nPlease follow these instructions:\n",
 				UserEnd:   "<end_of_turn>\n<start_of_turn>model\n",
 			},
+			Chat: ChatFormat{
+				UserPrefix:        "<start_of_turn>user\n",
+				UserSuffix:        "<end_of_turn>\n",
+				AssistantPrefix:   "<start_of_turn>model\n",
+				AssistantSuffix:   "<end_of_turn>\n",
+				GenerationPrompt:  "<start_of_turn>model\n",
+				SystemInFirstUser: true,
+			},
+			ControlTokens: []string{"<bos>", "<eos>", "<start_of_turn>", "<end_of_turn>"},
 		},
 		//<|start|>system<|message|>You are ChatGPT, a large language model trained by OpenAI.\nKnowledge cutoff: 2024-06\nCurrent date: 2025-08-05\n\nReasoning: medium\n\n# Valid channels: analysis, commentary, final. Channel must be included for every message.<|end|><|start|>user<|message|>Hello<|end|><|start|>assistant<|channel|>final<|message|>Hi there!<|end|><|start|>user<|message|>What is 1+1?<|end|><|start|>assistant
 		"GPTOSS": {
@@ -137,7 +243,19 @@ func (pr *PromptRegistry) registerPrompts() {
 				"You are a professional research analyst. Please format output as markdown text, don't include the markdown``` avoid excessive formatting that distracts from content.",
 				"final",
 			),
+			Chat: ChatFormat{
+				SystemPrefix:     "<|start|>system<|message|>",
+				SystemSuffix:     "<|end|>",
+				UserPrefix:       "<|start|>user<|message|>",
+				UserSuffix:       "<|end|>",
+				AssistantPrefix:  "<|start|>assistant<|channel|>final<|message|>",
+				AssistantSuffix:  "<|end|>",
+				GenerationPrompt: "<|start|>assistant",
+			},
+			ControlTokens: []string{"<|start|>", "<|end|>", "<|message|>", "<|channel|>", "<|constrain|>", "<|return|>", "<|call|>"},
+			Output:        OutputHarmony,
 		},
+		// FreeForm sends the prompt as typed, without a system instruction
 		"FreeForm": {
 			Template: systemTemplate,
 			Data: &SystemPrompt{
@@ -145,19 +263,129 @@ func (pr *PromptRegistry) registerPrompts() {
 				UserPrompt:      "",
 				AssistantPrompt: "\r\n",
 			},
+			Chat: ChatFormat{
+				UserSuffix:        "\n",
+				AssistantSuffix:   "\n",
+				SystemInFirstUser: true,
+			},
 		},
 	}
+
+	for promptType, config := range pr.configs {
+		if promptType != "FreeForm" {
+			config.Instruction = researchAnalystInstruction
+			pr.configs[promptType] = config
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, config := range pr.configs {
+		for _, token := range config.ControlTokens {
+			if !seen[token] {
+				seen[token] = true
+				pr.allControlTokens = append(pr.allControlTokens, token)
+			}
+		}
+	}
+	sort.Slice(pr.allControlTokens, func(i, j int) bool {
+		if len(pr.allControlTokens[i]) != len(pr.allControlTokens[j]) {
+			return len(pr.allControlTokens[i]) > len(pr.allControlTokens[j])
+		}
+		return pr.allControlTokens[i] < pr.allControlTokens[j]
+	})
 }
 
-// GetConfig returns the prompt configuration for a given type
+// GetConfig returns the prompt configuration for a given type, preferring a stored template
 func (pr *PromptRegistry) GetConfig(promptType string) (PromptConfig, bool) {
+	pr.mu.RLock()
+	defer pr.mu.RUnlock()
+
+	if config, exists := pr.stored[promptType]; exists {
+		return config, true
+	}
 	config, exists := pr.configs[promptType]
 	return config, exists
 }
 
+// SetStoredTemplates replaces the stored templates the registry resolves prompt types from. A
+// template takes its chat and output formats from the built-in configuration named by its BaseType.
+func (pr *PromptRegistry) SetStoredTemplates(templates []PromptTemplate) {
+	stored := make(map[string]PromptConfig, len(templates))
+	for _, storedTemplate := range templates {
+		if storedTemplate.Name == AutoPromptType {
+			continue
+		}
+		base, exists := pr.configs[storedTemplate.BaseType]
+		if !exists || storedTemplate.BaseType == AutoPromptType {
+			base = pr.GetDefaultConfig()
+		}
+		data := storedTemplate.Data
+		stored[storedTemplate.Name] = PromptConfig{
+			Template:      storedTemplate.Template,
+			Data:          &data,
+			Chat:          base.Chat,
+			Output:        base.Output,
+			Instruction:   storedTemplate.Instruction,
+			ControlTokens: base.ControlTokens,
+		}
+	}
+
+	pr.mu.Lock()
+	pr.stored = stored
+	pr.mu.Unlock()
+}
+
+// BuiltInTemplates returns the built-in configurations as templates for seeding the database
+func (pr *PromptRegistry) BuiltInTemplates() []PromptTemplate {
+	templates := make([]PromptTemplate, 0, len(pr.configs))
+	for promptType, config := range pr.configs {
+		if promptType == AutoPromptType {
+			continue
+		}
+		builtIn := PromptTemplate{
+			Name:        promptType,
+			Description: fmt.Sprintf("Built-in %s prompt format", promptType),
+			BaseType:    promptType,
+			Template:    config.Template,
+			Instruction: config.Instruction,
+			BuiltIn:     true,
+		}
+		switch data := config.Data.(type) {
+		case *SystemPrompt:
+			builtIn.Data = PromptTemplateData{SystemPrompt: data.SystemPrompt, UserPrompt: data.UserPrompt, AssistantPrompt: data.AssistantPrompt}
+		case *UserPrompt:
+			builtIn.Data = PromptTemplateData{UserStart: data.UserStart, UserEnd: data.UserEnd}
+		}
+		templates = append(templates, builtIn)
+	}
+	sort.Slice(templates, func(i, j int) bool { return templates[i].Name < templates[j].Name })
+	return templates
+}
+
+// ValidatePromptTemplate checks a template before it is stored
+func ValidatePromptTemplate(promptTemplate PromptTemplate) error {
+	if strings.TrimSpace(promptTemplate.Name) == "" {
+		return fmt.Errorf("prompt template name is required")
+	}
+	if strings.EqualFold(promptTemplate.Name, AutoPromptType) {
+		return fmt.Errorf("prompt template name %s is reserved", AutoPromptType)
+	}
+	if _, exists := promptRegistry.configs[promptTemplate.BaseType]; !exists || promptTemplate.BaseType == AutoPromptType {
+		return fmt.Errorf("unknown base prompt type '%s'", promptTemplate.BaseType)
+	}
+	if _, err := template.New("promptTemplate").Parse(promptTemplate.Template); err != nil {
+		return fmt.Errorf("invalid prompt template: %w", err)
+	}
+	return nil
+}
+
 // GetDefaultConfig returns the default prompt configuration
 func (pr *PromptRegistry) GetDefaultConfig() PromptConfig {
-	return pr.configs["SystemUserAssistant"]
+	config, exists := pr.GetConfig("SystemUserAssistant")
+	if !exists {
+		return pr.configs["SystemUserAssistant"]
+	}
+	return config
 }
 
 // Global registry instance
@@ -173,27 +401,74 @@ func NewTemplateProcessor(log logger.Logger) *TemplateProcessor {
 	return &TemplateProcessor{logger: log}
 }
 
-// Process processes a template with the given data and input
-func (tp *TemplateProcessor) Process(templateText string, data PromptData, input string) (string, error) {
+// Process processes a template with the given data and input. The template can also reference the
+// given variables and the default ones such as {{.Date}}; undefined references are an error.
+func (tp *TemplateProcessor) Process(templateText string, data PromptData, input string, variables map[string]string) (string, error) {
 	// Create a copy of the data to avoid modifying the original
 	dataCopy := tp.copyPromptData(data)
 	dataCopy.SetInput(input)
 
-	tmpl, err := template.New("promptTemplate").Parse(templateText)
+	values := promptDataValues(dataCopy)
+	for name, value := range withDefaultVariables(variables) {
+		if _, exists := values[name]; exists {
+			return "", fmt.Errorf("variable %s conflicts with a prompt template field", name)
+		}
+		values[name] = value
+	}
+
+	result, err := executePromptTemplate("promptTemplate", templateText, values)
+	if err != nil {
+		tp.logger.Error(fmt.Sprintf("Template error: %v", err))
+		return "", err
+	}
+	return result, nil
+}
+
+// missingVariablePattern matches the error text/template reports for an undefined map key
+var missingVariablePattern = regexp.MustCompile(`map has no entry for key "([^"]*)"`)
+
+// executePromptTemplate executes templateText with values, rejecting references to undefined values
+func executePromptTemplate(name string, templateText string, values map[string]interface{}) (string, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(templateText)
 	if err != nil {
-		tp.logger.Error(fmt.Sprintf("Template parsing error: %v", err))
 		return "", fmt.Errorf("failed to parse template: %w", err)
 	}
 
 	var buffer bytes.Buffer
-	if err := tmpl.Execute(&buffer, dataCopy); err != nil {
-		tp.logger.Error(fmt.Sprintf("Template execution error: %v", err))
+	if err := tmpl.Execute(&buffer, values); err != nil {
+		if match := missingVariablePattern.FindStringSubmatch(err.Error()); match != nil {
+			return "", fmt.Errorf("template references undefined variable {{.%s}}", match[1])
+		}
 		return "", fmt.Errorf("failed to execute template: %w", err)
 	}
-
 	return buffer.String(), nil
 }
 
+// promptDataValues returns the string fields of prompt data by name
+func promptDataValues(data PromptData) map[string]interface{} {
+	values := make(map[string]interface{})
+	dataValue := reflect.Indirect(reflect.ValueOf(data))
+	if dataValue.Kind() != reflect.Struct {
+		return values
+	}
+	for i := 0; i < dataValue.NumField(); i++ {
+		if field := dataValue.Type().Field(i); field.IsExported() && field.Type.Kind() == reflect.String {
+			values[field.Name] = dataValue.Field(i).String()
+		}
+	}
+	return values
+}
+
+// promptTemplateFieldNames returns the names of the fields prompt templates are filled with, which
+// variables cannot use. PromptTemplateData has the fields of every built-in prompt type.
+func promptTemplateFieldNames() map[string]bool {
+	names := make(map[string]bool)
+	for name := range promptDataValues(&PromptTemplateData{}) {
+		names[name] = true
+	}
+	return names
+}
+
 // copyPromptData creates a copy of PromptData to avoid modifying the original
 func (tp *TemplateProcessor) copyPromptData(data PromptData) PromptData {
 	switch v := data.(type) {
@@ -210,15 +485,102 @@ func (tp *TemplateProcessor) copyPromptData(data PromptData) PromptData {
 			Input:     v.Input,
 			UserEnd:   v.UserEnd,
 		}
+	case *PromptTemplateData:
+		dataCopy := *v
+		return &dataCopy
 	default:
 		return data // Fallback for unknown types
 	}
 }
 
-// HandlePromptType manages prompt creation based on type using the registry approach
-func HandlePromptType(log logger.Logger, promptType string, promptText string) (string, error) {
+// resolvePromptType returns the prompt type to use when none was chosen
+func resolvePromptType(promptType string) string {
+	if promptType == "" {
+		return AutoPromptType
+	}
+	return promptType
+}
+
+// PromptOptions are the per-request additions to a prompt type
+type PromptOptions struct {
+	SystemPrompt string            // replaces the prompt type's system instruction when set
+	Variables    map[string]string // values for {{.Name}} references; nil leaves the prompt text as typed
+
+	defaults map[string]string // request-specific defaults, see WithDefaults
+}
+
+// WithDefaults returns options whose variables fall back to defaults
+func (o PromptOptions) WithDefaults(defaults map[string]string) PromptOptions {
+	merged := make(map[string]string, len(o.defaults)+len(defaults))
+	for name, value := range o.defaults {
+		merged[name] = value
+	}
+	for name, value := range defaults {
+		merged[name] = value
+	}
+	o.defaults = merged
+	return o
+}
+
+// variables returns the request variables over the defaults
+func (o PromptOptions) variables() map[string]string {
+	variables := withDefaultVariables(o.defaults)
+	for name, value := range o.Variables {
+		variables[name] = value
+	}
+	return variables
+}
+
+// Expand substitutes the variables in promptText and in the system prompt override. Text is only
+// treated as a template when the request passed variables, so prompts containing braces keep working.
+func (o PromptOptions) Expand(promptText string) (string, PromptOptions, error) {
+	if o.Variables == nil {
+		return promptText, o, nil
+	}
+
+	values := make(map[string]interface{})
+	for name, value := range o.variables() {
+		values[name] = value
+	}
+	expandedText, err := executePromptTemplate("prompt", promptText, values)
+	if err != nil {
+		return "", o, fmt.Errorf("invalid prompt text: %w", err)
+	}
+	if o.SystemPrompt != "" {
+		if o.SystemPrompt, err = executePromptTemplate("systemPrompt", o.SystemPrompt, values); err != nil {
+			return "", o, fmt.Errorf("invalid system prompt: %w", err)
+		}
+	}
+	return expandedText, o, nil
+}
+
+// withDefaultVariables returns a copy of variables with the variables every prompt can reference
+func withDefaultVariables(variables map[string]string) map[string]string {
+	merged := map[string]string{
+		"Date": time.Now().Format("2006-01-02"),
+	}
+	for name, value := range variables {
+		merged[name] = value
+	}
+	return merged
+}
+
+// HandlePromptType manages prompt creation based on type using the registry approach. The Auto type
+// renders the chat template of the model at modelPath and falls back to the default configuration
+// when the model has none.
+func HandlePromptType(log logger.Logger, promptType string, modelPath string, promptText string, options PromptOptions) (string, error) {
 	processor := NewTemplateProcessor(log)
 
+	promptType = resolvePromptType(promptType)
+	if promptType == AutoPromptType {
+		if prompt, ok := renderModelChatTemplate(log, modelPath, BuildChatMessages(promptType, promptText, options)); ok {
+			return prompt, nil
+		}
+		config := promptRegistry.GetDefaultConfig()
+		data, input := processor.withSystemPrompt(config, options.SystemPrompt, promptText)
+		return processor.Process(config.Template, data, input, options.variables())
+	}
+
 	config, exists := promptRegistry.GetConfig(promptType)
 	if !exists {
 		// Use default configuration for unknown types
@@ -226,7 +588,172 @@ func HandlePromptType(log logger.Logger, promptType string, promptText string) (
 		log.Debug(fmt.Sprintf("Unknown prompt type '%s', using default configuration", promptType))
 	}
 
-	return processor.Process(config.Template, config.Data, promptText)
+	data, input := processor.withSystemPrompt(config, options.SystemPrompt, promptText)
+	return processor.Process(config.Template, data, input, options.variables())
+}
+
+// withSystemPrompt replaces the system instruction in the prompt data of config. Prompt types whose
+// data does not contain their instruction, such as FreeForm, get the system prompt before the input.
+func (tp *TemplateProcessor) withSystemPrompt(config PromptConfig, systemPrompt string, input string) (PromptData, string) {
+	if systemPrompt == "" {
+		return config.Data, input
+	}
+
+	data := tp.copyPromptData(config.Data)
+	replaced := false
+	dataValue := reflect.Indirect(reflect.ValueOf(data))
+	if config.Instruction != "" && dataValue.Kind() == reflect.Struct {
+		for i := 0; i < dataValue.NumField(); i++ {
+			field := dataValue.Field(i)
+			if field.Kind() == reflect.String && field.CanSet() && strings.Contains(field.String(), config.Instruction) {
+				field.SetString(strings.ReplaceAll(field.String(), config.Instruction, systemPrompt))
+				replaced = true
+			}
+		}
+	}
+	if !replaced {
+		input = systemPrompt + "\n\n" + input
+	}
+	return data, input
+}
+
+// RenderChatPrompt renders a multi-turn conversation in the format of the given prompt type and
+// opens the assistant turn for the model to complete
+func RenderChatPrompt(log logger.Logger, promptType string, modelPath string, messages []ChatMessage) string {
+	promptType = resolvePromptType(promptType)
+	if promptType == AutoPromptType {
+		if prompt, ok := renderModelChatTemplate(log, modelPath, messages); ok {
+			return prompt
+		}
+	}
+
+	config, exists := promptRegistry.GetConfig(promptType)
+	if !exists || promptType == AutoPromptType {
+		config = promptRegistry.GetDefaultConfig()
+		log.Debug(fmt.Sprintf("Unknown prompt type '%s', using default chat format", promptType))
+	}
+	format := config.Chat
+
+	var builder strings.Builder
+	builder.WriteString(format.Begin)
+
+	pendingSystem := ""
+	for _, message := range messages {
+		switch message.Role {
+		case "system":
+			if format.SystemInFirstUser {
+				pendingSystem += message.Content + "\n"
+				continue
+			}
+			builder.WriteString(format.SystemPrefix + message.Content + format.SystemSuffix)
+		case "assistant":
+			builder.WriteString(format.AssistantPrefix + message.Content + format.AssistantSuffix)
+		default:
+			builder.WriteString(format.UserPrefix + pendingSystem + message.Content + format.UserSuffix)
+			pendingSystem = ""
+		}
+	}
+
+	builder.WriteString(format.GenerationPrompt)
+	return builder.String()
+}
+
+// renderModelChatTemplate renders messages with the tokenizer.chat_template of the model at
+// modelPath. It reports false when the model has no template or the template cannot be rendered.
+func renderModelChatTemplate(log logger.Logger, modelPath string, messages []ChatMessage) (string, bool) {
+	if modelPath == "" {
+		return "", false
+	}
+	info, err := os.Stat(modelPath)
+	if err != nil {
+		log.Error("Failed to read model for chat template: " + err.Error())
+		return "", false
+	}
+	descriptor := modelCatalog.Describe(filepath.Clean(modelPath), info)
+	if !descriptor.Valid || descriptor.ChatTemplate == "" {
+		log.Debug(fmt.Sprintf("Model %s has no chat template, using the default prompt format", descriptor.FileName))
+		return "", false
+	}
+
+	prompt, err := RenderChatTemplate(descriptor, messages)
+	if err != nil && len(messages) > 1 && messages[0].Role == "system" {
+		// Some templates, such as Gemma's, reject the system role
+		prompt, err = RenderChatTemplate(descriptor, mergeSystemMessage(messages))
+	}
+	if err != nil {
+		log.Error(fmt.Sprintf("Failed to render chat template of %s, using the default prompt format: %s", descriptor.FileName, err.Error()))
+		return "", false
+	}
+	return prompt, true
+}
+
+// RenderChatTemplate applies the chat template of a model to messages and opens the assistant turn
+func RenderChatTemplate(descriptor ModelDescriptor, messages []ChatMessage) (string, error) {
+	chatTemplate, err := ParseJinjaTemplate(descriptor.ChatTemplate)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse chat template: %w", err)
+	}
+
+	templateMessages := make([]interface{}, len(messages))
+	for i, message := range messages {
+		templateMessages[i] = map[string]interface{}{"role": message.Role, "content": message.Content}
+	}
+	prompt, err := chatTemplate.Render(map[string]interface{}{
+		"messages":              templateMessages,
+		"add_generation_prompt": true,
+		"bos_token":             descriptor.BOSToken,
+		"eos_token":             descriptor.EOSToken,
+		"tools":                 nil,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to render chat template: %w", err)
+	}
+
+	// llama.cpp adds the BOS token itself when it tokenizes the prompt
+	if descriptor.AddBOSToken && descriptor.BOSToken != "" {
+		prompt = strings.TrimPrefix(prompt, descriptor.BOSToken)
+	}
+	return prompt, nil
+}
+
+// mergeSystemMessage prepends the leading system message to the first user message
+func mergeSystemMessage(messages []ChatMessage) []ChatMessage {
+	merged := append([]ChatMessage{}, messages[1:]...)
+	for i := range merged {
+		if merged[i].Role == "user" {
+			merged[i].Content = messages[0].Content + "\n\n" + merged[i].Content
+			return merged
+		}
+	}
+	return messages
+}
+
+// researchAnalystInstruction is the system instruction baked into the built-in prompt templates
+const researchAnalystInstruction = "You are a professional research analyst. Please format output as markdown text, don't include the markdown``` avoid excessive formatting that distracts from content."
+
+// SystemInstruction returns the system message of a prompt type; unknown types use the default
+func SystemInstruction(promptType string) string {
+	config, exists := promptRegistry.GetConfig(resolvePromptType(promptType))
+	if !exists {
+		config = promptRegistry.GetDefaultConfig()
+	}
+	return config.Instruction
+}
+
+// BuildChatMessages returns the role-tagged form of a prompt for chat backends, which apply the
+// model's own chat template instead of the prompt type's control tokens
+func BuildChatMessages(promptType string, promptText string, options PromptOptions) []ChatMessage {
+	instruction := SystemInstruction(promptType)
+	if options.SystemPrompt != "" {
+		instruction = options.SystemPrompt
+	}
+	if instruction == "" {
+		return []ChatMessage{{Role: "user", Content: promptText}}
+	}
+	return []ChatMessage{
+		{Role: "system", Content: instruction},
+		{Role: "user", Content: promptText},
+	}
 }
 
 // Template struct for backward compatibility
@@ -237,5 +764,5 @@ type Template struct {
 // CreateTemplate Legacy function for backward compatibility
 func CreateTemplate(log logger.Logger, data PromptData, tmpl Template, input string) (string, error) {
 	processor := NewTemplateProcessor(log)
-	return processor.Process(tmpl.Text, data, input)
+	return processor.Process(tmpl.Text, data, input, nil)
 }
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// RunStats are the performance figures of a single completion, as printed by llama-cli's llama_perf
+// report or returned in llama-server's timings. Times are in milliseconds.
+type RunStats struct {
+	LoadMs                    float64 `bson:"loadMs" json:"loadMs"`
+	PromptTokens              int     `bson:"promptTokens" json:"promptTokens"`
+	PromptMs                  float64 `bson:"promptMs" json:"promptMs"`
+	PromptTokensPerSecond     float64 `bson:"promptTokensPerSecond" json:"promptTokensPerSecond"`
+	GeneratedTokens           int     `bson:"generatedTokens" json:"generatedTokens"`
+	GenerationMs              float64 `bson:"generationMs" json:"generationMs"`
+	GenerationTokensPerSecond float64 `bson:"generationTokensPerSecond" json:"generationTokensPerSecond"`
+	TotalMs                   float64 `bson:"totalMs" json:"totalMs"`
+	ContextUsed               int     `bson:"contextUsed" json:"contextUsed"` // prompt plus generated tokens
+	ContextSize               int     `bson:"contextSize,omitempty" json:"contextSize,omitempty"`
+}
+
+var (
+	// perfTimePattern matches the llama_perf_context_print lines (llama_print_timings in older builds):
+	//   prompt eval time =     456.78 ms /    30 tokens (   15.23 ms per token,    65.68 tokens per second)
+	perfTimePattern    = regexp.MustCompile(`(?:llama_perf_context_print|llama_print_timings):\s*(load|prompt eval|eval|total) time\s*=\s*([\d.]+) ms(?:\s*/\s*(\d+) (?:tokens|runs))?(?:\s*\(\s*[\d.]+ ms per token,\s*([\d.]+) tokens per second\))?`)
+	contextSizePattern = regexp.MustCompile(`\bn_ctx\s*=\s*(\d+)`)
+)
+
+// RunStatsWriter collects RunStats from llama-cli's stderr as it is written. Lines are parsed as they
+// complete, so the log itself is not kept in memory.
+type RunStatsWriter struct {
+	mu      sync.Mutex
+	partial []byte
+	stats   RunStats
+	found   bool
+}
+
+// Write implements io.Writer
+func (w *RunStatsWriter) Write(data []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.partial = append(w.partial, data...)
+	for {
+		newline := bytes.IndexByte(w.partial, '\n')
+		if newline < 0 {
+			break
+		}
+		w.parseLine(string(w.partial[:newline]))
+		w.partial = w.partial[newline+1:]
+	}
+	// A line without a newline this long is not one of the lines being looked for
+	if len(w.partial) > 64*1024 {
+		w.partial = w.partial[:0]
+	}
+	return len(data), nil
+}
+
+// Stats returns the collected figures, or nil when llama-cli printed no performance report
+func (w *RunStatsWriter) Stats() *RunStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.partial) > 0 {
+		w.parseLine(string(w.partial))
+		w.partial = nil
+	}
+	if !w.found {
+		return nil
+	}
+	stats := w.stats
+	stats.ContextUsed = stats.PromptTokens + stats.GeneratedTokens
+	return &stats
+}
+
+func (w *RunStatsWriter) parseLine(line string) {
+	if w.stats.ContextSize == 0 {
+		if match := contextSizePattern.FindStringSubmatch(line); match != nil {
+			w.stats.ContextSize, _ = strconv.Atoi(match[1])
+		}
+	}
+
+	match := perfTimePattern.FindStringSubmatch(line)
+	if match == nil {
+		return
+	}
+	w.found = true
+	milliseconds, _ := strconv.ParseFloat(match[2], 64)
+	count, _ := strconv.Atoi(match[3])
+	perSecond, _ := strconv.ParseFloat(match[4], 64)
+
+	switch strings.TrimSpace(match[1]) {
+	case "load":
+		w.stats.LoadMs = milliseconds
+	case "prompt eval":
+		w.stats.PromptMs = milliseconds
+		w.stats.PromptTokens = count
+		w.stats.PromptTokensPerSecond = perSecond
+	case "eval":
+		w.stats.GenerationMs = milliseconds
+		w.stats.GeneratedTokens = count
+		w.stats.GenerationTokensPerSecond = perSecond
+	case "total":
+		w.stats.TotalMs = milliseconds
+	}
+}
+
+// ParseRunStats extracts RunStats from a complete llama-cli stderr log
+func ParseRunStats(stderr string) *RunStats {
+	var writer RunStatsWriter
+	_, _ = writer.Write([]byte(stderr))
+	return writer.Stats()
+}
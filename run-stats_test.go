@@ -0,0 +1,116 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// llamaPerfLog is the end of a llama-cli b5535 stderr log
+const llamaPerfLog = `print_info: n_ctx_train      = 131072
+print_info: n_embd           = 3072
+llama_context: constructing llama_context
+llama_context: n_seq_max     = 1
+llama_context: n_ctx         = 4096
+llama_context: n_ctx_per_seq = 4096
+llama_context: n_batch       = 2048
+
+llama_perf_sampler_print:    sampling time =      10.37 ms /   158 runs   (    0.07 ms per token, 15231.08 tokens per second)
+llama_perf_context_print:        load time =     866.51 ms
+llama_perf_context_print: prompt eval time =     456.78 ms /    30 tokens (   15.23 ms per token,    65.68 tokens per second)
+llama_perf_context_print:        eval time =    5391.06 ms /   127 runs   (   42.45 ms per token,    23.56 tokens per second)
+llama_perf_context_print:       total time =    6003.61 ms /   157 tokens
+`
+
+// llamaPrintTimingsLog is the end of a stderr log of an older llama.cpp build
+const llamaPrintTimingsLog = `llm_load_print_meta: n_ctx_train      = 4096
+llama_new_context_with_model: n_ctx      = 2048
+llama_new_context_with_model: freq_base  = 10000.0
+
+llama_print_timings:        load time =    1072.71 ms
+llama_print_timings:      sample time =      21.06 ms /    64 runs   (    0.33 ms per token,  3038.26 tokens per second)
+llama_print_timings: prompt eval time =     624.02 ms /    12 tokens (   52.00 ms per token,    19.23 tokens per second)
+llama_print_timings:        eval time =    4718.36 ms /    63 runs   (   74.89 ms per token,    13.35 tokens per second)
+llama_print_timings:       total time =    5447.15 ms`
+
+var llamaPerfStats = RunStats{
+	LoadMs:                    866.51,
+	PromptTokens:              30,
+	PromptMs:                  456.78,
+	PromptTokensPerSecond:     65.68,
+	GeneratedTokens:           127,
+	GenerationMs:              5391.06,
+	GenerationTokensPerSecond: 23.56,
+	TotalMs:                   6003.61,
+	ContextUsed:               157,
+	ContextSize:               4096,
+}
+
+func TestParseRunStats(t *testing.T) {
+	tests := []struct {
+		name   string
+		stderr string
+		want   *RunStats
+	}{
+		{name: "llama_perf_context_print", stderr: llamaPerfLog, want: &llamaPerfStats},
+		{
+			name:   "llama_print_timings",
+			stderr: llamaPrintTimingsLog,
+			want: &RunStats{
+				LoadMs:                    1072.71,
+				PromptTokens:              12,
+				PromptMs:                  624.02,
+				PromptTokensPerSecond:     19.23,
+				GeneratedTokens:           63,
+				GenerationMs:              4718.36,
+				GenerationTokensPerSecond: 13.35,
+				TotalMs:                   5447.15,
+				ContextUsed:               75,
+				ContextSize:               2048,
+			},
+		},
+		{
+			name:   "n_ctx_train is not the context size",
+			stderr: "print_info: n_ctx_train      = 131072\nllama_perf_context_print:       total time =    6003.61 ms /   157 tokens\n",
+			want:   &RunStats{TotalMs: 6003.61},
+		},
+		{
+			name:   "no performance report",
+			stderr: "llama_context: n_ctx         = 4096\nmain: interrupted by user\n",
+		},
+		{
+			name:   "sampler line alone is no report",
+			stderr: "llama_perf_sampler_print:    sampling time =      10.37 ms /   158 runs   (    0.07 ms per token, 15231.08 tokens per second)\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := ParseRunStats(test.stderr); !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRunStatsWriterSplitWrites(t *testing.T) {
+	for _, size := range []int{1, 7, 64, 1000} {
+		var writer RunStatsWriter
+		for start := 0; start < len(llamaPerfLog); start += size {
+			end := min(start+size, len(llamaPerfLog))
+			if n, err := writer.Write([]byte(llamaPerfLog[start:end])); n != end-start || err != nil {
+				t.Fatalf("Write returned %d, %v", n, err)
+			}
+		}
+		if got := writer.Stats(); !reflect.DeepEqual(got, &llamaPerfStats) {
+			t.Fatalf("writes of %d bytes: got %+v, want %+v", size, got, llamaPerfStats)
+		}
+	}
+
+	// The last line is parsed even when the log does not end with a newline
+	var writer RunStatsWriter
+	_, _ = writer.Write([]byte("llama_perf_context_print: prompt eval time =     456.78 ms /    30 tok"))
+	_, _ = writer.Write([]byte("ens (   15.23 ms per token,    65.68 tokens per second)"))
+	got := writer.Stats()
+	if got == nil || got.PromptTokens != 30 || got.PromptMs != 456.78 || got.PromptTokensPerSecond != 65.68 {
+		t.Fatalf("got %+v, want the unterminated prompt eval line", got)
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// Resource types that the scheduler limits independently
+const (
+	ResourceLLM   = "llm"
+	ResourceEmbed = "embedding"
+	ResourceOCR   = "ocr"
+)
+
+// Priorities; interactive work always runs before waiting background work
+const (
+	PriorityInteractive = iota
+	PriorityBackground
+)
+
+// maxInteractiveStreak is how many interactive grants in a row may pass waiting background work
+// before one background request is let through, so a busy chat cannot starve an ingest forever
+const maxInteractiveStreak = 8
+
+// QueuedHandler is told when a job has to wait for a resource and whenever its position changes
+type QueuedHandler func(job *Job, resource string, position int)
+
+// schedulerTicket is one waiting acquisition
+type schedulerTicket struct {
+	job      *Job
+	priority int
+	ready    chan struct{}
+	position int
+}
+
+// resourceQueue holds the slots and waiting tickets of a single resource type
+type resourceQueue struct {
+	limit             int
+	running           int
+	interactive       []*schedulerTicket
+	background        []*schedulerTicket
+	interactiveStreak int
+}
+
+// queuedNotice is a position update waiting to be delivered to the QueuedHandler
+type queuedNotice struct {
+	job      *Job
+	resource string
+	position int
+}
+
+// Scheduler replaces the global llama-cli and embedding mutexes with per-resource queues that
+// have configurable concurrency and serve interactive work ahead of background work
+type Scheduler struct {
+	mu     sync.Mutex
+	queues map[string]*resourceQueue
+
+	noticeMu    sync.Mutex
+	notices     []queuedNotice // undelivered position changes, at most one per job and resource
+	noticeReady chan struct{}  // wakes deliverNotices; a pending signal covers all queued notices
+
+	handlerMu sync.RWMutex
+	onQueued  QueuedHandler
+}
+
+// workScheduler is shared by the package-level llama-cli, embedding and OCR helpers
+var workScheduler = NewScheduler()
+
+func NewScheduler() *Scheduler {
+	s := &Scheduler{
+		queues: map[string]*resourceQueue{
+			ResourceLLM:   {limit: 1},
+			ResourceEmbed: {limit: 1},
+			ResourceOCR:   {limit: 1},
+		},
+		noticeReady: make(chan struct{}, 1),
+	}
+	go s.deliverNotices()
+	return s
+}
+
+// deliverNotices calls the QueuedHandler outside the scheduler lock, in the order positions changed.
+// A job whose position changed again before delivery is only told its latest position.
+func (s *Scheduler) deliverNotices() {
+	for range s.noticeReady {
+		s.noticeMu.Lock()
+		notices := s.notices
+		s.notices = nil
+		s.noticeMu.Unlock()
+
+		s.handlerMu.RLock()
+		handler := s.onQueued
+		s.handlerMu.RUnlock()
+		if handler == nil {
+			continue
+		}
+		for _, notice := range notices {
+			handler(notice.job, notice.resource, notice.position)
+		}
+	}
+}
+
+// queueNotice records a position change for deliverNotices without ever blocking, so a slow
+// QueuedHandler cannot stall the scheduler
+func (s *Scheduler) queueNotice(notice queuedNotice) {
+	s.noticeMu.Lock()
+	coalesced := false
+	for i := range s.notices {
+		if s.notices[i].job == notice.job && s.notices[i].resource == notice.resource {
+			s.notices[i].position = notice.position
+			coalesced = true
+			break
+		}
+	}
+	if !coalesced {
+		s.notices = append(s.notices, notice)
+	}
+	s.noticeMu.Unlock()
+
+	select {
+	case s.noticeReady <- struct{}{}:
+	default:
+	}
+}
+
+// Configure sets the concurrency limit per resource; values below one are ignored
+func (s *Scheduler) Configure(limits map[string]int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for resource, limit := range limits {
+		if limit < 1 {
+			continue
+		}
+		queue := s.queueLocked(resource)
+		queue.limit = limit
+		s.dispatchLocked(resource, queue)
+	}
+}
+
+// SetQueuedHandler registers the callback used to report queue positions
+func (s *Scheduler) SetQueuedHandler(handler QueuedHandler) {
+	s.handlerMu.Lock()
+	defer s.handlerMu.Unlock()
+	s.onQueued = handler
+}
+
+// Acquire blocks until a slot of resource is free or ctx is done. The job and its priority are
+// taken from ctx; work without a job is treated as interactive. The returned release func must be
+// called exactly once when the work is finished.
+func (s *Scheduler) Acquire(ctx context.Context, resource string) (func(), error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	job := JobFromContext(ctx)
+	priority := PriorityInteractive
+	if job != nil && job.Kind() == JobKindIngest {
+		priority = PriorityBackground
+	}
+
+	s.mu.Lock()
+	queue := s.queueLocked(resource)
+	if queue.running < queue.limit && !s.mustWaitLocked(queue, priority) {
+		queue.running++
+		s.noteGrantLocked(queue, priority)
+		s.mu.Unlock()
+		return s.releaseFunc(resource), nil
+	}
+
+	ticket := &schedulerTicket{job: job, priority: priority, ready: make(chan struct{})}
+	if priority == PriorityBackground {
+		queue.background = append(queue.background, ticket)
+	} else {
+		queue.interactive = append(queue.interactive, ticket)
+	}
+	s.notifyPositionsLocked(resource, queue)
+	s.mu.Unlock()
+
+	select {
+	case <-ticket.ready:
+		return s.releaseFunc(resource), nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		select {
+		case <-ticket.ready:
+			// Granted while canceling; hand the slot to the next waiter
+			queue.running--
+			s.dispatchLocked(resource, queue)
+		default:
+			s.removeTicketLocked(queue, ticket)
+			s.notifyPositionsLocked(resource, queue)
+		}
+		return nil, ctx.Err()
+	}
+}
+
+func (s *Scheduler) releaseFunc(resource string) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			queue := s.queueLocked(resource)
+			queue.running--
+			s.dispatchLocked(resource, queue)
+		})
+	}
+}
+
+// mustWaitLocked keeps new arrivals behind tickets that are already waiting for the same slot
+func (s *Scheduler) mustWaitLocked(queue *resourceQueue, priority int) bool {
+	if priority == PriorityInteractive {
+		return len(queue.interactive) > 0
+	}
+	return len(queue.interactive) > 0 || len(queue.background) > 0
+}
+
+func (s *Scheduler) noteGrantLocked(queue *resourceQueue, priority int) {
+	if priority == PriorityInteractive && len(queue.background) > 0 {
+		queue.interactiveStreak++
+	} else {
+		queue.interactiveStreak = 0
+	}
+}
+
+// dispatchLocked grants free slots to waiting tickets in priority order
+func (s *Scheduler) dispatchLocked(resource string, queue *resourceQueue) {
+	granted := false
+	for queue.running < queue.limit {
+		var ticket *schedulerTicket
+		switch {
+		case len(queue.background) > 0 && (len(queue.interactive) == 0 || queue.interactiveStreak >= maxInteractiveStreak):
+			ticket, queue.background = queue.background[0], queue.background[1:]
+		case len(queue.interactive) > 0:
+			ticket, queue.interactive = queue.interactive[0], queue.interactive[1:]
+		default:
+			if granted {
+				s.notifyPositionsLocked(resource, queue)
+			}
+			return
+		}
+		queue.running++
+		s.noteGrantLocked(queue, ticket.priority)
+		close(ticket.ready)
+		granted = true
+	}
+	if granted {
+		s.notifyPositionsLocked(resource, queue)
+	}
+}
+
+// notifyPositionsLocked reports the 1-based position of every waiting ticket whose position changed
+func (s *Scheduler) notifyPositionsLocked(resource string, queue *resourceQueue) {
+	position := 0
+	for _, waiting := range [][]*schedulerTicket{queue.interactive, queue.background} {
+		for _, ticket := range waiting {
+			position++
+			if ticket.position == position || ticket.job == nil {
+				continue
+			}
+			ticket.position = position
+			s.queueNotice(queuedNotice{job: ticket.job, resource: resource, position: position})
+		}
+	}
+}
+
+func (s *Scheduler) removeTicketLocked(queue *resourceQueue, ticket *schedulerTicket) {
+	remove := func(tickets []*schedulerTicket) []*schedulerTicket {
+		for i, candidate := range tickets {
+			if candidate == ticket {
+				return append(tickets[:i], tickets[i+1:]...)
+			}
+		}
+		return tickets
+	}
+	queue.interactive = remove(queue.interactive)
+	queue.background = remove(queue.background)
+}
+
+func (s *Scheduler) queueLocked(resource string) *resourceQueue {
+	queue, exists := s.queues[resource]
+	if !exists {
+		queue = &resourceQueue{limit: 1}
+		s.queues[resource] = queue
+	}
+	return queue
+}
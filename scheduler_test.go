@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSchedulerDoesNotBlockOnSlowQueuedHandler(t *testing.T) {
+	scheduler := NewScheduler()
+	unblock := make(chan struct{})
+	defer close(unblock)
+	scheduler.SetQueuedHandler(func(*Job, string, int) { <-unblock })
+
+	release, err := scheduler.Acquire(context.Background(), ResourceLLM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every grant moves each waiter up one place, far more notices than the handler takes
+	registry := NewJobRegistry()
+	const waiters = 40
+	var wg sync.WaitGroup
+	for i := 0; i < waiters; i++ {
+		job, err := registry.Start(fmt.Sprintf("job-%d", i), JobKindQuery)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			releaseWaiter, err := scheduler.Acquire(job.Context(), ResourceLLM)
+			if err == nil {
+				releaseWaiter()
+			}
+		}()
+	}
+	waitForQueueLength(t, scheduler, ResourceLLM, waiters)
+	release()
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("waiters did not get the resource while the queued handler was blocked")
+	}
+}
+
+func TestSchedulerCoalescesQueuedNotices(t *testing.T) {
+	// Without deliverNotices running the notices stay queued
+	scheduler := &Scheduler{queues: map[string]*resourceQueue{}, noticeReady: make(chan struct{}, 1)}
+	registry := NewJobRegistry()
+	first, _ := registry.Start("first", JobKindQuery)
+	second, _ := registry.Start("second", JobKindQuery)
+
+	scheduler.queueNotice(queuedNotice{job: first, resource: ResourceLLM, position: 2})
+	scheduler.queueNotice(queuedNotice{job: second, resource: ResourceLLM, position: 3})
+	scheduler.queueNotice(queuedNotice{job: first, resource: ResourceLLM, position: 1})
+	scheduler.queueNotice(queuedNotice{job: first, resource: ResourceEmbed, position: 1})
+
+	want := []queuedNotice{
+		{job: first, resource: ResourceLLM, position: 1},
+		{job: second, resource: ResourceLLM, position: 3},
+		{job: first, resource: ResourceEmbed, position: 1},
+	}
+	if len(scheduler.notices) != len(want) {
+		t.Fatalf("got %d notices, want %d", len(scheduler.notices), len(want))
+	}
+	for i, notice := range scheduler.notices {
+		if notice != want[i] {
+			t.Errorf("notice %d is %+v, want %+v", i, notice, want[i])
+		}
+	}
+}
+
+func waitForQueueLength(t *testing.T, scheduler *Scheduler, resource string, length int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		scheduler.mu.Lock()
+		queue := scheduler.queueLocked(resource)
+		waiting := len(queue.interactive) + len(queue.background)
+		scheduler.mu.Unlock()
+		if waiting == length {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("%d requests did not queue for %s", length, resource)
+}
@@ -0,0 +1,579 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	defaultStructuredRetries = 2
+	maxStructuredRetries     = 5
+)
+
+// StructuredOutputRequest switches a completion into structured-output mode. Generation is
+// constrained with Schema and the reply is validated against it, retrying up to MaxRetries times.
+// When Schema is empty the JsonSchemaVal or JsonSchemaFileVal of the settings profile is used.
+type StructuredOutputRequest struct {
+	Schema     json.RawMessage `json:"schema,omitempty"`
+	MaxRetries *int            `json:"maxRetries,omitempty"`
+}
+
+// StructuredOutputResult is the parsed JSON reply and the outcome of validating it
+type StructuredOutputResult struct {
+	Data             json.RawMessage `json:"data,omitempty"`
+	Valid            bool            `json:"valid"`
+	ValidationErrors []string        `json:"validationErrors,omitempty"`
+	Attempts         int             `json:"attempts"`
+}
+
+// retries returns the bounded number of retries after the first attempt
+func (r *StructuredOutputRequest) retries() int {
+	if r.MaxRetries == nil {
+		return defaultStructuredRetries
+	}
+	if *r.MaxRetries < 0 {
+		return 0
+	}
+	if *r.MaxRetries > maxStructuredRetries {
+		return maxStructuredRetries
+	}
+	return *r.MaxRetries
+}
+
+// resolveSchema returns the schema in compact form together with its decoded value
+func (r *StructuredOutputRequest) resolveSchema(cliArgs LlamaCliArgs) (string, interface{}, error) {
+	schemaText := bytes.TrimSpace(r.Schema)
+	if len(schemaText) == 0 || string(schemaText) == "null" {
+		switch {
+		case cliArgs.JsonSchemaVal != "":
+			schemaText = []byte(cliArgs.JsonSchemaVal)
+		case cliArgs.JsonSchemaFileVal != "":
+			fileContent, err := os.ReadFile(cliArgs.JsonSchemaFileVal)
+			if err != nil {
+				return "", nil, fmt.Errorf("failed to read JSON schema file: %w", err)
+			}
+			schemaText = fileContent
+		default:
+			return "", nil, fmt.Errorf("structured output requires a JSON schema")
+		}
+	}
+
+	// The schema may arrive as a JSON string holding the schema document
+	var embedded string
+	if json.Unmarshal(schemaText, &embedded) == nil {
+		schemaText = []byte(embedded)
+	}
+
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, schemaText); err != nil {
+		return "", nil, fmt.Errorf("invalid JSON schema: %w", err)
+	}
+	var schema interface{}
+	if err := json.Unmarshal(compact.Bytes(), &schema); err != nil {
+		return "", nil, fmt.Errorf("invalid JSON schema: %w", err)
+	}
+	if _, ok := schema.(map[string]interface{}); !ok {
+		return "", nil, fmt.Errorf("invalid JSON schema: expected an object")
+	}
+	return compact.String(), schema, nil
+}
+
+// withSchemaConstraint makes the profile constrain generation with schema instead of any grammar
+func withSchemaConstraint(cliArgs LlamaCliArgs, schema string) LlamaCliArgs {
+	if cliArgs.JsonSchemaCmd == "" {
+		cliArgs.JsonSchemaCmd = "--json-schema"
+	}
+	cliArgs.JsonSchemaVal = schema
+	cliArgs.JsonSchemaFileVal = ""
+	cliArgs.GrammarVal = ""
+	cliArgs.GrammarFileVal = ""
+	return cliArgs
+}
+
+// generateCompletionOrStructured runs request as a plain completion, or in structured-output mode
+// when structured is set. onReset is called before a retry so the tokens already streamed from the
+// rejected attempt can be discarded.
+func (app *App) generateCompletionOrStructured(ctx context.Context, request CompletionRequest, structured *StructuredOutputRequest, onToken TokenCallback, onReset func()) (CompletionResult, *StructuredOutputResult, error) {
+	if structured == nil {
+		output, err := app.generateCompletion(ctx, request, onToken)
+		return output, nil, err
+	}
+	return app.generateStructuredCompletion(ctx, request, structured, onToken, onReset)
+}
+
+// generateStructuredCompletion runs request constrained by the schema of structured, then parses and
+// validates the answer of the reply. Invalid replies are retried with a new seed; chat backends are
+// also told what was wrong, and onReset is called before the retry streams its tokens. The output of
+// the last attempt is returned with the result of validating it.
+func (app *App) generateStructuredCompletion(ctx context.Context, request CompletionRequest, structured *StructuredOutputRequest, onToken TokenCallback, onReset func()) (CompletionResult, *StructuredOutputResult, error) {
+	schemaText, schema, err := structured.resolveSchema(request.CliArgs)
+	if err != nil {
+		return CompletionResult{}, nil, err
+	}
+	request.CliArgs = withSchemaConstraint(request.CliArgs, schemaText)
+
+	result := &StructuredOutputResult{}
+	var output CompletionResult
+	answer := ""
+	for attempt := 0; attempt <= structured.retries(); attempt++ {
+		if attempt > 0 {
+			app.log.Info(fmt.Sprintf("Structured output of %s failed validation, retrying (%d/%d): %s",
+				request.RequestID, attempt, structured.retries(), strings.Join(result.ValidationErrors, "; ")))
+			request = retryStructuredRequest(request, answer, result.ValidationErrors)
+			if onReset != nil {
+				onReset()
+			}
+		}
+
+		generated, err := app.generateCompletion(ctx, request, onToken)
+		if err != nil {
+			return output, result, err
+		}
+		output = generated
+		answer = ParseModelOutput(request.PromptType, request.Prompt, output.Text).Answer
+		result.Attempts = attempt + 1
+
+		payload, value, err := extractJSONPayload(answer)
+		if err != nil {
+			result.Data = nil
+			result.ValidationErrors = []string{err.Error()}
+			continue
+		}
+		result.Data = payload
+		result.ValidationErrors = validateJSONSchema(schema, value)
+		if len(result.ValidationErrors) == 0 {
+			result.Valid = true
+			return output, result, nil
+		}
+	}
+	return output, result, nil
+}
+
+// retryStructuredRequest prepares the next attempt after an invalid reply
+func retryStructuredRequest(request CompletionRequest, previousOutput string, validationErrors []string) CompletionRequest {
+	if seed, err := strconv.Atoi(strings.TrimSpace(request.CliArgs.RandomSeedVal)); err == nil && seed >= 0 {
+		request.CliArgs.RandomSeedVal = strconv.Itoa(seed + 1)
+	}
+	if len(request.Messages) > 0 {
+		correction := "Your reply did not match the required JSON schema:\n- " + strings.Join(validationErrors, "\n- ") +
+			"\nReply again with only a JSON value that matches the schema."
+		request.Messages = append(append([]ChatMessage{}, request.Messages...),
+			ChatMessage{Role: "assistant", Content: previousOutput},
+			ChatMessage{Role: "user", Content: correction})
+	}
+	return request
+}
+
+// jsonCodeFencePattern matches a reply that is nothing but a fenced code block
+var jsonCodeFencePattern = regexp.MustCompile("(?s)^```[A-Za-z]*\\s*(.*?)\\s*```$")
+
+// extractJSONPayload returns the JSON value in a reply. A reply that is a single value, bare or in a
+// code fence, is used as it is, so schemas for strings, numbers and booleans can be satisfied too.
+// Otherwise the first complete object or array is taken; surrounding text and anything the model
+// generated after the value are ignored.
+func extractJSONPayload(text string) (json.RawMessage, interface{}, error) {
+	trimmed := strings.TrimSpace(text)
+	if fenced := jsonCodeFencePattern.FindStringSubmatch(trimmed); fenced != nil {
+		trimmed = fenced[1]
+	}
+	if decoded, ok := decodeSingleJSONValue(trimmed); ok {
+		return json.RawMessage(trimmed), decoded, nil
+	}
+
+	for start := 0; start < len(text); {
+		offset := strings.IndexAny(text[start:], "{[")
+		if offset < 0 {
+			break
+		}
+		start += offset
+
+		decoder := json.NewDecoder(strings.NewReader(text[start:]))
+		decoder.UseNumber()
+		var decoded interface{}
+		if err := decoder.Decode(&decoded); err != nil {
+			start++
+			continue
+		}
+		end := start + int(decoder.InputOffset())
+		return json.RawMessage(text[start:end]), decoded, nil
+	}
+	return nil, nil, fmt.Errorf("reply does not contain a JSON value")
+}
+
+// decodeSingleJSONValue decodes text when it holds exactly one JSON value of any type
+func decodeSingleJSONValue(text string) (interface{}, bool) {
+	decoder := json.NewDecoder(strings.NewReader(text))
+	decoder.UseNumber()
+	var decoded interface{}
+	if err := decoder.Decode(&decoded); err != nil {
+		return nil, false
+	}
+	if decoder.InputOffset() != int64(len(text)) {
+		return nil, false
+	}
+	return decoded, true
+}
+
+// validateJSONSchema checks value against the subset of JSON Schema that llama.cpp can turn into a
+// grammar: type, enum, const, properties, required, additionalProperties, items, length, range,
+// pattern, allOf/anyOf/oneOf/not and local $ref. Unknown keywords are ignored.
+func validateJSONSchema(schema interface{}, value interface{}) []string {
+	validator := &schemaValidator{root: schema}
+	validator.validate(schema, value, "$", 0)
+	return validator.errors
+}
+
+type schemaValidator struct {
+	root   interface{}
+	errors []string
+}
+
+// maxSchemaDepth guards against cyclic $ref chains
+const maxSchemaDepth = 64
+
+func (v *schemaValidator) fail(path, format string, args ...interface{}) {
+	v.errors = append(v.errors, path+": "+fmt.Sprintf(format, args...))
+}
+
+func (v *schemaValidator) validate(schemaValue interface{}, value interface{}, path string, depth int) {
+	if depth > maxSchemaDepth {
+		v.fail(path, "schema nesting is too deep")
+		return
+	}
+	if allowed, ok := schemaValue.(bool); ok {
+		if !allowed {
+			v.fail(path, "no value is allowed here")
+		}
+		return
+	}
+	schema, ok := schemaValue.(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	if ref, ok := schema["$ref"].(string); ok {
+		resolved, err := v.resolveRef(ref)
+		if err != nil {
+			v.fail(path, "%s", err.Error())
+			return
+		}
+		v.validate(resolved, value, path, depth+1)
+	}
+
+	if typeValue, exists := schema["type"]; exists && !matchesSchemaType(typeValue, value) {
+		v.fail(path, "expected %s, got %s", describeSchemaType(typeValue), jsonTypeName(value))
+		return
+	}
+	if enumValues, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, candidate := range enumValues {
+			if jsonValuesEqual(candidate, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			v.fail(path, "value is not one of the allowed values")
+		}
+	}
+	if constValue, exists := schema["const"]; exists && !jsonValuesEqual(constValue, value) {
+		v.fail(path, "value does not equal the required constant")
+	}
+
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		v.validateObject(schema, typed, path, depth)
+	case []interface{}:
+		v.validateArray(schema, typed, path, depth)
+	case string:
+		v.validateString(schema, typed, path)
+	case json.Number:
+		v.validateNumber(schema, typed, path)
+	}
+
+	if allOf, ok := schema["allOf"].([]interface{}); ok {
+		for _, subSchema := range allOf {
+			v.validate(subSchema, value, path, depth+1)
+		}
+	}
+	if anyOf, ok := schema["anyOf"].([]interface{}); ok && v.countMatches(anyOf, value, path, depth) == 0 {
+		v.fail(path, "value does not match any of the allowed schemas")
+	}
+	if oneOf, ok := schema["oneOf"].([]interface{}); ok {
+		if matches := v.countMatches(oneOf, value, path, depth); matches != 1 {
+			v.fail(path, "value matches %d schemas, expected exactly one", matches)
+		}
+	}
+	if notSchema, exists := schema["not"]; exists {
+		if len(validateSubSchema(v.root, notSchema, value, path, depth+1)) == 0 {
+			v.fail(path, "value matches a schema it must not match")
+		}
+	}
+}
+
+func (v *schemaValidator) validateObject(schema map[string]interface{}, object map[string]interface{}, path string, depth int) {
+	if required, ok := schema["required"].([]interface{}); ok {
+		for _, name := range required {
+			if key, ok := name.(string); ok {
+				if _, exists := object[key]; !exists {
+					v.fail(path, "missing required property %q", key)
+				}
+			}
+		}
+	}
+
+	properties, _ := schema["properties"].(map[string]interface{})
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		propertyPath := path + "." + key
+		if propertySchema, exists := properties[key]; exists {
+			v.validate(propertySchema, object[key], propertyPath, depth+1)
+			continue
+		}
+		switch additional := schema["additionalProperties"].(type) {
+		case bool:
+			if !additional {
+				v.fail(propertyPath, "property is not allowed")
+			}
+		case map[string]interface{}:
+			v.validate(additional, object[key], propertyPath, depth+1)
+		}
+	}
+
+	if minimum, ok := schemaInt(schema, "minProperties"); ok && len(object) < minimum {
+		v.fail(path, "expected at least %d properties", minimum)
+	}
+	if maximum, ok := schemaInt(schema, "maxProperties"); ok && len(object) > maximum {
+		v.fail(path, "expected at most %d properties", maximum)
+	}
+}
+
+func (v *schemaValidator) validateArray(schema map[string]interface{}, array []interface{}, path string, depth int) {
+	prefixItems, _ := schema["prefixItems"].([]interface{})
+	for index, item := range array {
+		itemPath := path + "[" + strconv.Itoa(index) + "]"
+		if index < len(prefixItems) {
+			v.validate(prefixItems[index], item, itemPath, depth+1)
+			continue
+		}
+		if itemSchema, exists := schema["items"]; exists {
+			v.validate(itemSchema, item, itemPath, depth+1)
+		}
+	}
+
+	if minimum, ok := schemaInt(schema, "minItems"); ok && len(array) < minimum {
+		v.fail(path, "expected at least %d items, got %d", minimum, len(array))
+	}
+	if maximum, ok := schemaInt(schema, "maxItems"); ok && len(array) > maximum {
+		v.fail(path, "expected at most %d items, got %d", maximum, len(array))
+	}
+	if unique, _ := schema["uniqueItems"].(bool); unique {
+		for i := range array {
+			for j := i + 1; j < len(array); j++ {
+				if jsonValuesEqual(array[i], array[j]) {
+					v.fail(path, "items %d and %d are equal", i, j)
+					return
+				}
+			}
+		}
+	}
+}
+
+func (v *schemaValidator) validateString(schema map[string]interface{}, text string, path string) {
+	length := utf8.RuneCountInString(text)
+	if minimum, ok := schemaInt(schema, "minLength"); ok && length < minimum {
+		v.fail(path, "expected at least %d characters, got %d", minimum, length)
+	}
+	if maximum, ok := schemaInt(schema, "maxLength"); ok && length > maximum {
+		v.fail(path, "expected at most %d characters, got %d", maximum, length)
+	}
+	if pattern, ok := schema["pattern"].(string); ok {
+		expression, err := regexp.Compile(pattern)
+		if err != nil {
+			v.fail(path, "schema pattern %q is invalid", pattern)
+		} else if !expression.MatchString(text) {
+			v.fail(path, "value does not match pattern %q", pattern)
+		}
+	}
+}
+
+func (v *schemaValidator) validateNumber(schema map[string]interface{}, number json.Number, path string) {
+	value, err := number.Float64()
+	if err != nil {
+		v.fail(path, "invalid number %s", number.String())
+		return
+	}
+	if minimum, ok := schemaFloat(schema, "minimum"); ok && value < minimum {
+		v.fail(path, "%s is less than the minimum %v", number, minimum)
+	}
+	if maximum, ok := schemaFloat(schema, "maximum"); ok && value > maximum {
+		v.fail(path, "%s is greater than the maximum %v", number, maximum)
+	}
+	if minimum, ok := schemaFloat(schema, "exclusiveMinimum"); ok && value <= minimum {
+		v.fail(path, "%s must be greater than %v", number, minimum)
+	}
+	if maximum, ok := schemaFloat(schema, "exclusiveMaximum"); ok && value >= maximum {
+		v.fail(path, "%s must be less than %v", number, maximum)
+	}
+	if multiple, ok := schemaFloat(schema, "multipleOf"); ok && multiple > 0 {
+		quotient := value / multiple
+		if math.Abs(quotient-math.Round(quotient)) > 1e-9 {
+			v.fail(path, "%s is not a multiple of %v", number, multiple)
+		}
+	}
+}
+
+// countMatches returns how many of schemas value satisfies
+func (v *schemaValidator) countMatches(schemas []interface{}, value interface{}, path string, depth int) int {
+	matches := 0
+	for _, subSchema := range schemas {
+		if len(validateSubSchema(v.root, subSchema, value, path, depth+1)) == 0 {
+			matches++
+		}
+	}
+	return matches
+}
+
+// validateSubSchema validates value without recording errors on the parent validator
+func validateSubSchema(root, schema, value interface{}, path string, depth int) []string {
+	validator := &schemaValidator{root: root}
+	validator.validate(schema, value, path, depth)
+	return validator.errors
+}
+
+// resolveRef resolves a local reference such as #/$defs/address
+func (v *schemaValidator) resolveRef(ref string) (interface{}, error) {
+	if ref == "#" {
+		return v.root, nil
+	}
+	if !strings.HasPrefix(ref, "#/") {
+		return nil, fmt.Errorf("only local $ref values are supported, got %q", ref)
+	}
+	current := v.root
+	for _, token := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("cannot resolve $ref %q", ref)
+		}
+		if current, ok = object[token]; !ok {
+			return nil, fmt.Errorf("cannot resolve $ref %q", ref)
+		}
+	}
+	return current, nil
+}
+
+// matchesSchemaType reports whether value has the type, or one of the types, named by typeValue
+func matchesSchemaType(typeValue interface{}, value interface{}) bool {
+	switch typed := typeValue.(type) {
+	case string:
+		return matchesTypeName(typed, value)
+	case []interface{}:
+		for _, name := range typed {
+			if typeName, ok := name.(string); ok && matchesTypeName(typeName, value) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func matchesTypeName(typeName string, value interface{}) bool {
+	switch typeName {
+	case "integer":
+		number, ok := value.(json.Number)
+		if !ok {
+			return false
+		}
+		floatValue, err := number.Float64()
+		return err == nil && floatValue == math.Trunc(floatValue)
+	case "number":
+		_, ok := value.(json.Number)
+		return ok
+	default:
+		return jsonTypeName(value) == typeName
+	}
+}
+
+func describeSchemaType(typeValue interface{}) string {
+	if names, ok := typeValue.([]interface{}); ok {
+		parts := make([]string, 0, len(names))
+		for _, name := range names {
+			parts = append(parts, fmt.Sprintf("%v", name))
+		}
+		return strings.Join(parts, " or ")
+	}
+	return fmt.Sprintf("%v", typeValue)
+}
+
+func jsonTypeName(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number, float64:
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// jsonValuesEqual compares decoded JSON values, treating numbers by value
+func jsonValuesEqual(a, b interface{}) bool {
+	return reflect.DeepEqual(normalizeJSONNumbers(a), normalizeJSONNumbers(b))
+}
+
+func normalizeJSONNumbers(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case json.Number:
+		floatValue, err := typed.Float64()
+		if err != nil {
+			return typed.String()
+		}
+		return floatValue
+	case []interface{}:
+		normalized := make([]interface{}, len(typed))
+		for i, item := range typed {
+			normalized[i] = normalizeJSONNumbers(item)
+		}
+		return normalized
+	case map[string]interface{}:
+		normalized := make(map[string]interface{}, len(typed))
+		for key, item := range typed {
+			normalized[key] = normalizeJSONNumbers(item)
+		}
+		return normalized
+	}
+	return value
+}
+
+func schemaFloat(schema map[string]interface{}, keyword string) (float64, bool) {
+	value, ok := schema[keyword].(float64)
+	return value, ok
+}
+
+func schemaInt(schema map[string]interface{}, keyword string) (int, bool) {
+	value, ok := schema[keyword].(float64)
+	return int(value), ok
+}
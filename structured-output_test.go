@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// decodeTestSchema decodes a schema the way resolveSchema does
+func decodeTestSchema(t *testing.T, text string) interface{} {
+	t.Helper()
+	var schema interface{}
+	if err := json.Unmarshal([]byte(text), &schema); err != nil {
+		t.Fatalf("invalid test schema %s: %v", text, err)
+	}
+	return schema
+}
+
+// decodeTestJSON decodes text the way replies are decoded, keeping numbers as json.Number
+func decodeTestJSON(t *testing.T, text string) interface{} {
+	t.Helper()
+	decoder := json.NewDecoder(strings.NewReader(text))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		t.Fatalf("invalid test JSON %s: %v", text, err)
+	}
+	return value
+}
+
+func TestValidateJSONSchema(t *testing.T) {
+	person := `{"type":"object","required":["name","age"],"additionalProperties":false,
+		"properties":{"name":{"type":"string","minLength":1},"age":{"type":"integer","minimum":0,"maximum":150}}}`
+	tests := []struct {
+		name   string
+		schema string
+		value  string
+		want   []string // substrings of the expected errors, in order; empty means valid
+	}{
+		{name: "type string", schema: `{"type":"string"}`, value: `"text"`},
+		{name: "type mismatch", schema: `{"type":"string"}`, value: `42`, want: []string{"$: expected string, got number"}},
+		{name: "integer rejects fraction", schema: `{"type":"integer"}`, value: `1.5`, want: []string{"expected integer"}},
+		{name: "type list", schema: `{"type":["string","null"]}`, value: `null`},
+		{name: "valid object", schema: person, value: `{"name":"Ada","age":36}`},
+		{name: "missing required", schema: person, value: `{"name":"Ada"}`, want: []string{`missing required property "age"`}},
+		{name: "additional property", schema: person, value: `{"name":"Ada","age":36,"city":"London"}`, want: []string{"$.city: property is not allowed"}},
+		{name: "additional property schema", schema: `{"type":"object","additionalProperties":{"type":"number"}}`, value: `{"a":1,"b":"2"}`, want: []string{"$.b: expected number"}},
+		{name: "nested bounds", schema: person, value: `{"name":"","age":200}`, want: []string{"$.age: 200 is greater than the maximum 150", "$.name: expected at least 1 characters"}},
+		{name: "enum", schema: `{"enum":["red","green"]}`, value: `"green"`},
+		{name: "enum mismatch", schema: `{"enum":["red","green"]}`, value: `"blue"`, want: []string{"not one of the allowed values"}},
+		{name: "enum compares numbers", schema: `{"enum":[1,2]}`, value: `2.0`},
+		{name: "items", schema: `{"type":"array","items":{"type":"integer"},"minItems":1}`, value: `[1,2,3]`},
+		{name: "items mismatch", schema: `{"type":"array","items":{"type":"integer"}}`, value: `[1,"two"]`, want: []string{"$[1]: expected integer, got string"}},
+		{name: "min items", schema: `{"type":"array","minItems":2}`, value: `[1]`, want: []string{"expected at least 2 items, got 1"}},
+		{name: "unique items", schema: `{"type":"array","uniqueItems":true}`, value: `[1,2,1]`, want: []string{"items 0 and 2 are equal"}},
+		{name: "anyOf", schema: `{"anyOf":[{"type":"string"},{"type":"integer"}]}`, value: `7`},
+		{name: "anyOf mismatch", schema: `{"anyOf":[{"type":"string"},{"type":"integer"}]}`, value: `true`, want: []string{"does not match any of the allowed schemas"}},
+		{name: "oneOf", schema: `{"oneOf":[{"type":"integer"},{"type":"string"}]}`, value: `"x"`},
+		{name: "oneOf ambiguous", schema: `{"oneOf":[{"type":"number"},{"type":"integer"}]}`, value: `3`, want: []string{"matches 2 schemas, expected exactly one"}},
+		{name: "minimum", schema: `{"type":"number","minimum":1}`, value: `0.5`, want: []string{"0.5 is less than the minimum 1"}},
+		{name: "exclusive maximum", schema: `{"type":"number","exclusiveMaximum":10}`, value: `10`, want: []string{"10 must be less than 10"}},
+		{name: "multipleOf", schema: `{"type":"number","multipleOf":0.5}`, value: `2.5`},
+		{name: "multipleOf mismatch", schema: `{"type":"number","multipleOf":3}`, value: `10`, want: []string{"not a multiple of 3"}},
+		{name: "pattern", schema: `{"type":"string","pattern":"^[a-z]+$"}`, value: `"Abc"`, want: []string{"does not match pattern"}},
+		{name: "local ref", schema: `{"$defs":{"id":{"type":"integer"}},"type":"array","items":{"$ref":"#/$defs/id"}}`, value: `[1,"2"]`, want: []string{"$[1]: expected integer"}},
+		{name: "false schema", schema: `{"properties":{"a":false}}`, value: `{"a":1}`, want: []string{"$.a: no value is allowed here"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			errors := validateJSONSchema(decodeTestSchema(t, test.schema), decodeTestJSON(t, test.value))
+			if len(errors) != len(test.want) {
+				t.Fatalf("got errors %q, want %d matching %q", errors, len(test.want), test.want)
+			}
+			for i, want := range test.want {
+				if !strings.Contains(errors[i], want) {
+					t.Errorf("error %d is %q, want it to contain %q", i, errors[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestExtractJSONPayload(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		want    string
+		wantErr bool
+	}{
+		{name: "bare object", text: `{"a":1}`, want: `{"a":1}`},
+		{name: "code fence", text: "Here you go:\n```json\n[1,2]\n```", want: `[1,2]`},
+		{name: "first of several", text: `{"a":1} and then {"b":2}`, want: `{"a":1}`},
+		{name: "skips invalid brackets", text: `[see below] {"a":[1]} trailing`, want: `{"a":[1]}`},
+		{name: "string", text: ` "positive"` + "\n", want: `"positive"`},
+		{name: "number", text: "42", want: "42"},
+		{name: "boolean in a code fence", text: "```json\ntrue\n```", want: "true"},
+		{name: "null", text: "null", want: "null"},
+		{name: "scalar with trailing text", text: "42 is the answer", wantErr: true},
+		{name: "no json", text: "I cannot answer that", wantErr: true},
+		{name: "incomplete", text: `{"a":`, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			payload, _, err := extractJSONPayload(test.text)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("got %s, want an error", payload)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("extractJSONPayload: %v", err)
+			}
+			if string(payload) != test.want {
+				t.Errorf("got %s, want %s", payload, test.want)
+			}
+		})
+	}
+}
+
+func TestExtractJSONPayloadScalarSchema(t *testing.T) {
+	schema := decodeTestSchema(t, `{"type":"string","enum":["positive","negative"]}`)
+	payload, value, err := extractJSONPayload(`"negative"`)
+	if err != nil {
+		t.Fatalf("extractJSONPayload: %v", err)
+	}
+	if errs := validateJSONSchema(schema, value); len(errs) > 0 {
+		t.Fatalf("%s does not validate: %v", payload, errs)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	defaultContextSize    = 4096 // used when the profile has no CtxSizeVal
+	defaultResponseTokens = 512  // tokens kept free for the answer when PredictVal is unlimited
+	estimatedTokenMargin  = 20   // percent added to estimated counts so an estimated prompt errs on the side of fitting
+)
+
+// RetrievalContextUsage describes how the retrieved chunks of a RAG prompt fit the token budget
+type RetrievalContextUsage struct {
+	ChunksUsed      int  `json:"chunksUsed"`
+	ChunksDropped   int  `json:"chunksDropped"`
+	PromptTokens    int  `json:"promptTokens"`
+	TokenBudget     int  `json:"tokenBudget"`
+	ExactTokenCount bool `json:"exactTokenCount"` // false when tokens were estimated from characters
+}
+
+// TokenCounter counts tokens with the model's own tokenizer through llama-tokenize. When the tool
+// is not configured, or fails, it falls back to estimateTokenCount for the rest of its lifetime.
+type TokenCounter struct {
+	app       *App
+	modelPath string
+	exact     bool
+}
+
+// newTokenCounter returns a counter for the model of cliArgs. Remote OpenAI-compatible backends
+// do not use the local model file, so their prompts are always estimated.
+func (app *App) newTokenCounter(cliArgs LlamaCliArgs) *TokenCounter {
+	counter := &TokenCounter{app: app, modelPath: cliArgs.ModelFullPathVal}
+	counter.exact = app.appArgs != nil && app.appArgs.LLamaTokenizePath != "" && counter.modelPath != "" &&
+		cliArgs.BackendVal != BackendOpenAICompatible
+	return counter
+}
+
+// Exact reports whether counts still come from the model's tokenizer
+func (counter *TokenCounter) Exact() bool {
+	return counter.exact
+}
+
+// Count returns the number of tokens in text
+func (counter *TokenCounter) Count(ctx context.Context, text string) int {
+	if counter.exact {
+		count, err := CountTokensWithCancel(ctx, *counter.app.appArgs, counter.modelPath, text)
+		if err == nil {
+			return count
+		}
+		if ctx.Err() == nil {
+			counter.app.log.Error("Failed to count tokens with llama-tokenize, estimating instead: " + err.Error())
+		}
+		counter.exact = false
+	}
+	return estimateTokenCount(text)
+}
+
+// CountTokensWithCancel runs llama-tokenize on text and returns the number of token ids it prints.
+// The text is passed on stdin so large prompts are not limited by the command line length.
+func CountTokensWithCancel(ctx context.Context, appArgs DefaultAppArgs, modelPath, text string) (int, error) {
+	out, err := RunProcess(ctx, ProcessSpec{
+		Name:       "llama-tokenize",
+		Path:       appArgs.LLamaTokenizePath,
+		Args:       []string{"-m", modelPath, "--stdin", "--ids", "--log-disable"},
+		Stdin:      strings.NewReader(text),
+		MaxRuntime: maxProcessRuntime(appArgs),
+	})
+	if err != nil {
+		return 0, err
+	}
+	return parseTokenIDCount(string(out))
+}
+
+// parseTokenIDCount counts the ids in the "[1, 2, 3]" line printed by llama-tokenize --ids
+func parseTokenIDCount(output string) (int, error) {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		line := strings.TrimSpace(lines[i])
+		if !strings.HasPrefix(line, "[") || !strings.HasSuffix(line, "]") {
+			continue
+		}
+		ids := strings.TrimSpace(line[1 : len(line)-1])
+		if ids == "" {
+			return 0, nil
+		}
+		count := 0
+		for _, id := range strings.Split(ids, ",") {
+			if _, err := strconv.Atoi(strings.TrimSpace(id)); err != nil {
+				return 0, fmt.Errorf("unexpected token id %q in llama-tokenize output", id)
+			}
+			count++
+		}
+		return count, nil
+	}
+	return 0, fmt.Errorf("no token ids in llama-tokenize output")
+}
+
+// estimateTokenCount approximates the token count of text without the model's tokenizer. ASCII text
+// averages about four characters per token, while CJK and other non-ASCII text is closer to one token
+// per character, so those runes are counted individually. estimatedTokenMargin is added on top because
+// code and unusual vocabularies still tokenize denser than the average.
+func estimateTokenCount(text string) int {
+	asciiCount, otherCount := 0, 0
+	for _, r := range text {
+		if r < utf8.RuneSelf {
+			asciiCount++
+		} else {
+			otherCount++
+		}
+	}
+	tokens := (asciiCount+3)/4 + otherCount
+	return tokens + (tokens*estimatedTokenMargin+99)/100
+}
+
+// promptTokenBudget returns how many tokens the prompt may use: the context size of the profile
+// minus the tokens it asks the model to generate
+func promptTokenBudget(cliArgs LlamaCliArgs) int {
+	contextSize, err := strconv.Atoi(strings.TrimSpace(cliArgs.CtxSizeVal))
+	if err != nil || contextSize <= 0 {
+		contextSize = defaultContextSize
+	}
+	responseTokens, err := strconv.Atoi(strings.TrimSpace(cliArgs.PredictVal))
+	if err != nil || responseTokens <= 0 || responseTokens >= contextSize {
+		responseTokens = defaultResponseTokens
+	}
+	return contextSize - responseTokens
+}
+
+// fitRetrievedContext keeps as many of the best-ranked chunks as fit the prompt budget of cliArgs,
+// dropping the lowest-ranked ones first. buildPrompt renders the complete prompt for a chunk list so
+// the template and question are counted too. It returns the chunks to use and the resulting usage.
+func (app *App) fitRetrievedContext(ctx context.Context, cliArgs LlamaCliArgs, chunks []RetrievedChunk, buildPrompt func([]RetrievedChunk) (string, error)) ([]RetrievedChunk, RetrievalContextUsage, error) {
+	counter := app.newTokenCounter(cliArgs)
+	usage := RetrievalContextUsage{TokenBudget: promptTokenBudget(cliArgs)}
+
+	countFor := func(chunkCount int) (int, error) {
+		prompt, err := buildPrompt(chunks[:chunkCount])
+		if err != nil {
+			return 0, err
+		}
+		return counter.Count(ctx, prompt), nil
+	}
+
+	// Most queries fit as they are, which needs a single tokenizer run
+	tokens, err := countFor(len(chunks))
+	if err != nil {
+		return nil, usage, err
+	}
+	kept := len(chunks)
+
+	// Otherwise find the longest prefix of the ranking that fits; counts grow with every chunk
+	if tokens > usage.TokenBudget {
+		low, high := 0, len(chunks)-1
+		kept, tokens = 0, -1
+		for low <= high {
+			middle := (low + high) / 2
+			middleTokens, err := countFor(middle)
+			if err != nil {
+				return nil, usage, err
+			}
+			if middleTokens <= usage.TokenBudget {
+				kept, tokens = middle, middleTokens
+				low = middle + 1
+			} else {
+				high = middle - 1
+			}
+		}
+		if tokens < 0 {
+			// Even the question alone is over budget; send it without context and let the backend decide
+			if tokens, err = countFor(0); err != nil {
+				return nil, usage, err
+			}
+		}
+	}
+	if ctx.Err() != nil {
+		return nil, usage, ctx.Err()
+	}
+
+	usage.ChunksUsed = kept
+	usage.ChunksDropped = len(chunks) - kept
+	usage.PromptTokens = tokens
+	usage.ExactTokenCount = counter.Exact()
+	return chunks[:kept], usage, nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseTokenIDCount(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string
+		want    int
+		wantErr bool
+	}{
+		{name: "ids", output: "[1, 15043, 3186]\n", want: 3},
+		{name: "empty prompt", output: "[]", want: 0},
+		{name: "single id", output: "[ 1 ]", want: 1},
+		{name: "log lines before the ids", output: "llama_model_loader: loaded meta data\nsystem_info: n_threads = 8\n[1, 2, 3, 4]\n", want: 4},
+		{name: "last list wins", output: "[9]\n[1, 2]\n", want: 2},
+		{name: "not an id", output: "[1, two, 3]", wantErr: true},
+		{name: "no ids", output: "error: unable to load model\n", wantErr: true},
+		{name: "no output", output: "", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseTokenIDCount(test.output)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("got %d, want an error", got)
+				}
+				return
+			}
+			if err != nil || got != test.want {
+				t.Fatalf("got %d, %v, want %d", got, err, test.want)
+			}
+		})
+	}
+}
+
+func TestEstimateTokenCount(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want int
+	}{
+		{name: "empty", text: "", want: 0},
+		{name: "ascii", text: strings.Repeat("word", 100), want: 120},
+		{name: "cjk counts each character", text: strings.Repeat("語", 100), want: 120},
+		{name: "margin rounds up", text: "a", want: 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := estimateTokenCount(test.text); got != test.want {
+				t.Fatalf("got %d, want %d", got, test.want)
+			}
+		})
+	}
+}
+
+// testRetrievedChunks returns count chunks of equal size, ranked by descending score
+func testRetrievedChunks(count int) []RetrievedChunk {
+	chunks := make([]RetrievedChunk, count)
+	for i := range chunks {
+		chunks[i] = RetrievedChunk{Text: strings.Repeat(string(rune('a'+i)), 400), Score: float64(count - i)}
+	}
+	return chunks
+}
+
+// testPromptBuilder renders chunks under a fixed question and records how often it is called
+func testPromptBuilder(calls *int) func([]RetrievedChunk) (string, error) {
+	return func(chunks []RetrievedChunk) (string, error) {
+		*calls++
+		var prompt strings.Builder
+		prompt.WriteString("Answer the question using the context.\n")
+		for _, chunk := range chunks {
+			prompt.WriteString(chunk.Text + "\n")
+		}
+		return prompt.String(), nil
+	}
+}
+
+func TestFitRetrievedContext(t *testing.T) {
+	app := &App{}
+	chunks := testRetrievedChunks(10)
+	var calls int
+	buildPrompt := testPromptBuilder(&calls)
+	countFor := func(chunkCount int) int {
+		prompt, _ := buildPrompt(chunks[:chunkCount])
+		return estimateTokenCount(prompt)
+	}
+
+	tests := []struct {
+		name        string
+		contextSize string
+		predict     string
+		wantUsed    int
+		maxCalls    int
+	}{
+		{name: "everything fits", contextSize: "4096", predict: "512", wantUsed: 10, maxCalls: 1},
+		{name: "lowest ranked chunks dropped", contextSize: "1024", predict: "512", wantUsed: 4, maxCalls: 5},
+		{name: "only the question fits", contextSize: "100", predict: "80", wantUsed: 0, maxCalls: 5},
+		{name: "question over budget", contextSize: "100", predict: "95", wantUsed: 0, maxCalls: 6},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			calls = 0
+			cliArgs := LlamaCliArgs{CtxSizeVal: test.contextSize, PredictVal: test.predict}
+			got, usage, err := app.fitRetrievedContext(context.Background(), cliArgs, chunks, buildPrompt)
+			usedCalls := calls // countFor below builds prompts too
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != test.wantUsed {
+				t.Fatalf("kept %d chunks, want %d", len(got), test.wantUsed)
+			}
+			for i := range got {
+				if got[i].Text != chunks[i].Text {
+					t.Fatalf("chunk %d is not the chunk ranked %d", i, i)
+				}
+			}
+			if test.wantUsed < len(chunks) && countFor(test.wantUsed+1) <= usage.TokenBudget {
+				t.Fatalf("%d chunks would still fit in %d tokens", test.wantUsed+1, usage.TokenBudget)
+			}
+			want := RetrievalContextUsage{
+				ChunksUsed:    test.wantUsed,
+				ChunksDropped: len(chunks) - test.wantUsed,
+				PromptTokens:  countFor(test.wantUsed),
+				TokenBudget:   promptTokenBudget(cliArgs),
+			}
+			if usage != want {
+				t.Fatalf("got usage %+v, want %+v", usage, want)
+			}
+			if usedCalls > test.maxCalls {
+				t.Fatalf("built the prompt %d times, want at most %d", usedCalls, test.maxCalls)
+			}
+		})
+	}
+}
+
+func TestFitRetrievedContextErrors(t *testing.T) {
+	app := &App{}
+	chunks := testRetrievedChunks(4)
+	cliArgs := LlamaCliArgs{CtxSizeVal: "512", PredictVal: "256"}
+
+	templateErr := errors.New("template failed")
+	_, _, err := app.fitRetrievedContext(context.Background(), cliArgs, chunks, func([]RetrievedChunk) (string, error) {
+		return "", templateErr
+	})
+	if !errors.Is(err, templateErr) {
+		t.Fatalf("got %v, want the template error", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var calls int
+	if _, _, err := app.fitRetrievedContext(ctx, cliArgs, chunks, testPromptBuilder(&calls)); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/wailsapp/wails/v2/pkg/logger"
 )
@@ -279,6 +280,11 @@ func ParseDefaultLlamaCliEnv() LlamaCliArgs {
 		ChatTemplateFileVal:        os.Getenv("ChatTemplateFileVal"),
 		SimpleIoCmd:                os.Getenv("SimpleIoCmd"),
 		SimpleIoCmdEnabled:         getEnvBool(os.Getenv("SimpleIoCmdEnabled"), false),
+
+		// ----- completion backend -----
+		BackendVal:      os.Getenv("BackendVal"),
+		BackendUrlVal:   os.Getenv("BackendUrlVal"),
+		BackendModelVal: os.Getenv("BackendModelVal"),
 	}
 	return out
 }
@@ -529,17 +535,35 @@ func ParseDefaultAppEnv() DefaultAppArgs {
 		PromptTempPath:               os.Getenv("PromptTempPath"),
 		LLamaCliPath:                 os.Getenv("LLamaCliPath"),
 		LLamaEmbedCliPath:            os.Getenv("LLamaEmbedCliPath"),
+		LLamaServerPath:              os.Getenv("LLamaServerPath"),
+		LLamaTokenizePath:            os.Getenv("LLamaTokenizePath"),
+		LLamaServerHost:              os.Getenv("LLamaServerHost"),
+		LLamaServerPort:              os.Getenv("LLamaServerPort"),
+		SchedulerLlmConcurrency:      getEnvInt(os.Getenv("SchedulerLlmConcurrency"), 1),
+		SchedulerEmbedConcurrency:    getEnvInt(os.Getenv("SchedulerEmbedConcurrency"), 1),
+		SchedulerOcrConcurrency:      getEnvInt(os.Getenv("SchedulerOcrConcurrency"), 1),
+		ProcessMaxRuntimeMinutes:     getEnvInt(os.Getenv("ProcessMaxRuntimeMinutes"), 60),
+		EmbeddingBatchSize:           getEnvInt(os.Getenv("EmbeddingBatchSize"), 32),
+		EmbeddingCachePath:           os.Getenv("EmbeddingCachePath"),
+		EmbeddingCacheMaxSizeMB:      getEnvInt(os.Getenv("EmbeddingCacheMaxSizeMB"), 1024),
+		IndexVectorDims:              getEnvInt(os.Getenv("IndexVectorDims"), 0),
+		IndexSimilarity:              os.Getenv("IndexSimilarity"),
+		IndexQuantization:            os.Getenv("IndexQuantization"),
+		IndexHnswM:                   getEnvInt(os.Getenv("IndexHnswM"), 24),
+		IndexHnswEfConstruction:      getEnvInt(os.Getenv("IndexHnswEfConstruction"), 200),
 		PDFToTextPath:                os.Getenv("PDFToTextPath"),
 		ModelLogPath:                 os.Getenv("ModelLogPath"),
 		DocumentPath:                 os.Getenv("DocumentPath"),
 		EmbedModelFileName:           os.Getenv("EmbedModelFileName"),
 		ModelFileName:                os.Getenv("ModelFileName"),
 		PromptCachePath:              os.Getenv("PromptCachePath"),
+		PromptCacheMaxSizeMB:         getEnvInt(os.Getenv("PromptCacheMaxSizeMB"), 2048),
 		MongoURI:                     os.Getenv("MongoURI"),
 		TesseractPath:                os.Getenv("TesseractPath"),
 		PdfToImagesPath:              os.Getenv("PdfToImagesPath"),
 		ElasticsearchServerAddresses: strings.Split(os.Getenv("ElasticsearchServerAddresses"), ","),
 		ElasticsearchAPIKey:          os.Getenv("ElasticsearchAPIKey"),
+		BackendAPIKey:                os.Getenv("BackendAPIKey"),
 	}
 	return out
 }
@@ -553,6 +577,15 @@ func getEnvBool(key string, fallback bool) bool {
 	return result
 }
 
+func getEnvInt(key string, fallback int) int {
+	result, err := strconv.Atoi(strings.TrimSpace(key))
+	if err != nil {
+		return fallback
+	}
+
+	return result
+}
+
 func LlamaCliStructToArgs(args LlamaCliArgs) []string {
 	var result []string
 	// Helper function for command-value pairs
@@ -859,6 +892,8 @@ func DefaultAppStructToArgs(args DefaultAppArgs) []string {
 	addCmdValPair(args.PDFToTextPath)
 	addCmdValPair(args.LLamaCliPath)
 	addCmdValPair(args.LLamaEmbedCliPath)
+	addCmdValPair(args.LLamaServerPath)
+	addCmdValPair(args.LLamaTokenizePath)
 	addCmdValPair(args.ModelPath)
 	addCmdValPair(args.PromptCachePath)
 	addCmdValPair(args.PromptTempPath)
@@ -1148,6 +1183,13 @@ type LlamaCliArgs struct {
 	ChatTemplateFileVal        string `json:"ChatTemplateFileVal"`
 	SimpleIoCmd                string `json:"SimpleIoCmd"`
 	SimpleIoCmdEnabled         bool   `json:"SimpleIoCmdEnabled"`
+
+	// ----- completion backend -----
+	// The bearer token for the server is app configuration (DefaultAppArgs.BackendAPIKey) so it is never
+	// saved with a settings profile or the request history
+	BackendVal      string `json:"BackendVal"`      // llama-cli, llama-server or openai; empty picks the app default
+	BackendUrlVal   string `json:"BackendUrlVal"`   // base URL of an OpenAI-compatible server
+	BackendModelVal string `json:"BackendModelVal"` // model name sent to an OpenAI-compatible server
 }
 
 type LlamaEmbedArgs struct {
@@ -1391,17 +1433,35 @@ type DefaultAppArgs struct {
 	PromptTempPath               string   `json:"PromptTempPath"`
 	LLamaCliPath                 string   `json:"LLamaCliPath"`
 	LLamaEmbedCliPath            string   `json:"LLamaEmbedCliPath"`
+	LLamaServerPath              string   `json:"LLamaServerPath"`
+	LLamaTokenizePath            string   `json:"LLamaTokenizePath"`
+	LLamaServerHost              string   `json:"LLamaServerHost"`
+	LLamaServerPort              string   `json:"LLamaServerPort"`
+	SchedulerLlmConcurrency      int      `json:"SchedulerLlmConcurrency"`
+	SchedulerEmbedConcurrency    int      `json:"SchedulerEmbedConcurrency"`
+	SchedulerOcrConcurrency      int      `json:"SchedulerOcrConcurrency"`
+	ProcessMaxRuntimeMinutes     int      `json:"ProcessMaxRuntimeMinutes"`
+	EmbeddingBatchSize           int      `json:"EmbeddingBatchSize"`
+	EmbeddingCachePath           string   `json:"EmbeddingCachePath"`
+	EmbeddingCacheMaxSizeMB      int      `json:"EmbeddingCacheMaxSizeMB"`
+	IndexVectorDims              int      `json:"IndexVectorDims"`
+	IndexSimilarity              string   `json:"IndexSimilarity"`
+	IndexQuantization            string   `json:"IndexQuantization"`
+	IndexHnswM                   int      `json:"IndexHnswM"`
+	IndexHnswEfConstruction      int      `json:"IndexHnswEfConstruction"`
 	PDFToTextPath                string   `json:"PDFToTextPath"`
 	ModelLogPath                 string   `json:"ModelLogPath"`
 	DocumentPath                 string   `json:"DocumentPath"`
 	EmbedModelFileName           string   `json:"EmbedModelFileName"`
 	ModelFileName                string   `json:"ModelFileName"`
 	PromptCachePath              string   `json:"PromptCachePath"`
+	PromptCacheMaxSizeMB         int      `json:"PromptCacheMaxSizeMB"`
 	MongoURI                     string   `json:"MongoURI"`
 	TesseractPath                string   `json:"TesseractPath"`
 	PdfToImagesPath              string   `json:"PdfToImagesPath"`
 	ElasticsearchAPIKey          string   `json:"ElasticsearchAPIKey"`
 	ElasticsearchServerAddresses []string `json:"ElasticsearchServerAddresses"`
+	BackendAPIKey                string   `json:"-"` // bearer token for OpenAI-compatible completion backends
 }
 type ModelNameFullPath struct {
 	FileName string
@@ -1454,6 +1514,7 @@ type PDFLoader struct {
 
 	pdfToTextPath string
 	path          string
+	maxRuntime    time.Duration
 }
 
 type ElasticDocumentTextChunk struct {